@@ -0,0 +1,184 @@
+// Command rotate-keys re-wraps the data key of every stored file under a new
+// master key. File contents are not re-encrypted: only the header that holds
+// the wrapped data key is rewritten. Files from before envelope encryption
+// have no data key and are re-encrypted once to bring them onto the new key.
+//
+// Usage:
+//
+//	NEW_ENCRYPTION_KEY=<32-byte key> go run ./cmd/rotate-keys -new-key-id 2025-q3
+//
+// The existing ENCRYPTION_KEY, ENCRYPTION_KEY_ID and ENCRYPTION_PREVIOUS_KEYS
+// settings are used to unwrap, and the STORAGE_* settings select the backend.
+// The ciphertext checksums recorded in DATABASE_URL are updated to match the
+// rewritten files so the integrity scrubber keeps verifying them.
+//
+// Stop the server before running the command. Each object is read, rewrapped
+// and written back, so an upload or new version stored in between would be
+// replaced by the old content, and a running server cannot read the files
+// already moved to the new key. Once the command succeeds, make the new key
+// the server's ENCRYPTION_KEY/ENCRYPTION_KEY_ID, list the old one in
+// ENCRYPTION_PREVIOUS_KEYS and start the server again. An interrupted run can
+// simply be repeated; files already on the new key are skipped.
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"LANFileSharingSystem/internal/config"
+	"LANFileSharingSystem/internal/encryption"
+	"LANFileSharingSystem/internal/storage"
+
+	_ "github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	newKeyID := flag.String("new-key-id", "", "identifier recorded in file headers for the new master key")
+	dryRun := flag.Bool("dry-run", false, "report what would be rotated without writing")
+	flag.Parse()
+
+	if *newKeyID == "" {
+		logrus.Fatal("-new-key-id is required")
+	}
+
+	cfg := config.LoadConfig()
+	from, err := cfg.Keyring()
+	if err != nil {
+		logrus.WithError(err).Fatal("Invalid current encryption key configuration")
+	}
+	to, err := encryption.NewKeyring(*newKeyID, []byte(os.Getenv("NEW_ENCRYPTION_KEY")))
+	if err != nil {
+		logrus.WithError(err).Fatal("Invalid NEW_ENCRYPTION_KEY")
+	}
+	// A re-run after the server switched keys finds the new key in "from" already.
+	if newKey, ok := from.Key(*newKeyID); ok && string(newKey) != os.Getenv("NEW_ENCRYPTION_KEY") {
+		logrus.Fatalf("key ID %q is already in use for a different key", *newKeyID)
+	}
+
+	db, err := sql.Open("postgres", cfg.DatabaseURL)
+	if err != nil {
+		logrus.WithError(err).Fatal("Opening database failed")
+	}
+	defer db.Close()
+	if err := db.Ping(); err != nil {
+		logrus.WithError(err).Fatal("Connecting to database failed")
+	}
+
+	ctx := context.Background()
+	backend, err := cfg.Storage(ctx)
+	if err != nil {
+		logrus.WithError(err).Fatal("Opening storage backend failed")
+	}
+	objects, err := backend.List(ctx, "")
+	if err != nil {
+		logrus.WithError(err).Fatal("Listing stored files failed")
+	}
+
+	var rotated, skipped, failed int
+	for _, obj := range objects {
+		info, err := inspect(ctx, backend, obj.Key)
+		if err != nil {
+			logrus.WithField("file", obj.Key).WithError(err).Error("Could not read encryption header")
+			failed++
+			continue
+		}
+		if info.Version == 2 && info.KeyID == *newKeyID {
+			skipped++
+			continue
+		}
+
+		if *dryRun {
+			logrus.WithField("file", obj.Key).
+				WithField("version", info.Version).
+				WithField("keyID", info.KeyID).
+				Info("Would rotate")
+			rotated++
+			continue
+		}
+
+		checksum, err := rewrapObject(ctx, backend, from, to, obj.Key)
+		if err != nil {
+			logrus.WithField("file", obj.Key).WithError(err).Error("Rotation failed")
+			failed++
+			continue
+		}
+		if err := updateChecksum(db, obj.Key, checksum); err != nil {
+			logrus.WithField("file", obj.Key).WithError(err).Error("Updating ciphertext checksum failed")
+			failed++
+			continue
+		}
+		logrus.WithField("file", obj.Key).
+			WithField("fromVersion", info.Version).
+			WithField("fromKeyID", info.KeyID).
+			Debug("Rotated")
+		rotated++
+	}
+
+	logrus.WithField("rotated", rotated).
+		WithField("alreadyCurrent", skipped).
+		WithField("failed", failed).
+		WithField("dryRun", *dryRun).
+		Info("Key rotation finished")
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func inspect(ctx context.Context, backend storage.Backend, key string) (encryption.HeaderInfo, error) {
+	rc, err := backend.Get(ctx, key)
+	if err != nil {
+		return encryption.HeaderInfo{}, err
+	}
+	defer rc.Close()
+	return encryption.Inspect(rc)
+}
+
+// rewrapObject rewrites key under the new master key. The result is spooled
+// to a temporary file first so the object is never read and replaced at the
+// same time; Put itself replaces the object atomically. Nothing else may
+// write key meanwhile, which is why the server must be stopped. It returns
+// the SHA-256 of the new ciphertext.
+func rewrapObject(ctx context.Context, backend storage.Backend, from, to *encryption.Keyring, key string) (string, error) {
+	spool, err := os.CreateTemp("", "rotate-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	in, err := backend.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	err = encryption.Rewrap(from, to, io.MultiWriter(spool, hash), in)
+	in.Close()
+	if err != nil {
+		return "", fmt.Errorf("rewrap: %w", err)
+	}
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	if err := backend.Put(ctx, key, spool); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// updateChecksum records the new ciphertext digest of key, whether it holds
+// the current content of a file or an archived version.
+func updateChecksum(db *sql.DB, key, checksum string) error {
+	if _, err := db.Exec(`UPDATE files SET ciphertext_checksum = $1 WHERE file_path = $2`, checksum, key); err != nil {
+		return err
+	}
+	_, err := db.Exec(`UPDATE file_versions SET ciphertext_checksum = $1 WHERE storage_key = $2`, checksum, key)
+	return err
+}
@@ -10,11 +10,13 @@ import (
 	"path"
 	"runtime"
 	"strings"
+	"time"
 
 	"LANFileSharingSystem/internal/config"
 	"LANFileSharingSystem/internal/controllers"
 	"LANFileSharingSystem/internal/middleware"
 	"LANFileSharingSystem/internal/models"
+	"LANFileSharingSystem/internal/services"
 	"LANFileSharingSystem/internal/ws"
 
 	"github.com/golang-migrate/migrate/v4"
@@ -45,7 +47,8 @@ LOGGING STANDARDS:
    - DB_PING_ERR: Database ping failures.
    - MIG_INIT_ERR: Migration initialization failures.
    - MIG_UP_ERR: Migration execution failures.
-   - FOLDER_CREATE_ERR: File system folder creation errors.
+   - STORAGE_INIT_ERR: Storage backend configuration or connection errors.
+   - KEY_INIT_ERR: Encryption keyring configuration errors.
    - SERVER_ERR: Server startup errors.
 */
 
@@ -210,36 +213,137 @@ func main() {
 	logger.WithField("function", "main").Debug("Creating new application context (App)...")
 	app := models.NewApp(db, store)
 
+	// Load the master keys once; handlers use the shared keyring.
+	logger.WithField("function", "main").Debug("Loading encryption keyring...")
+	keys, err := cfg.Keyring()
+	if err != nil {
+		// KEY_INIT_ERR: Encryption keyring configuration errors.
+		wrappedErr := fmt.Errorf("invalid encryption key configuration: %w", err)
+		logger.WithField("function", "main").
+			WithField("errorCode", "KEY_INIT_ERR").
+			WithError(wrappedErr).
+			Error("Encryption keyring error")
+		logrus.Exit(1)
+	}
+	app.Keys = keys
+	currentKeyID, _ := keys.Current()
+	logger.WithField("function", "main").
+		WithField("keyID", currentKeyID).
+		Info("Encryption keyring loaded")
+
 	// Initialize the notification hub and attach it to your app context.
 	logger.WithField("function", "main").Debug("Initializing WebSocket hub...")
 	hub := ws.NewHub()
 	go hub.Run()
 	app.NotificationHub = hub
 
-	// Ensure the 'uploads' folder exists.
-	// Ensure the 'Cdrrmo' folder and fixed subfolders exist.
-	logger.WithField("function", "main").Debug("Ensuring 'Cdrrmo' base folders exist...")
-	if err := os.MkdirAll("Cdrrmo", 0755); err != nil {
-		wrappedErr := fmt.Errorf("error creating 'Cdrrmo' folder: %w", err)
+	// Open the storage backend that holds the encrypted blobs.
+	logger.WithField("function", "main").Debug("Opening storage backend...")
+	backend, err := cfg.Storage(context.Background())
+	if err != nil {
+		// STORAGE_INIT_ERR: Storage backend configuration or connection errors.
+		wrappedErr := fmt.Errorf("storage backend %q: %w", cfg.StorageBackend, err)
 		logger.WithField("function", "main").
-			WithField("errorCode", "FOLDER_CREATE_ERR").
-			WithField("folder", "Cdrrmo").
+			WithField("errorCode", "STORAGE_INIT_ERR").
+			WithField("backend", cfg.StorageBackend).
 			WithError(wrappedErr).
-			Error("Folder creation error")
+			Error("Storage initialization error")
 		logrus.Exit(1)
 	}
-	for _, folder := range []string{"Operation", "Research", "Training"} {
-		fullPath := path.Join("Cdrrmo", folder)
-		if err := os.MkdirAll(fullPath, 0755); err != nil {
-			wrappedErr := fmt.Errorf("error creating subfolder: %s - %w", fullPath, err)
-			logger.WithField("function", "main").
-				WithField("errorCode", "FOLDER_CREATE_ERR").
-				WithField("folder", fullPath).
-				WithError(wrappedErr).
-				Error("Subfolder creation error")
-			logrus.Exit(1)
-		}
+	app.Storage = backend
+	logger.WithField("function", "main").
+		WithField("backend", cfg.StorageBackend).
+		Info("Storage backend ready")
+
+	// Periodically re-verify stored blobs against their recorded checksums.
+	scrubber := services.NewIntegrityScrubber(app, cfg.IntegrityScrubInterval)
+	scrubber.Start(context.Background())
+	logger.WithField("function", "main").
+		WithField("interval", cfg.IntegrityScrubInterval.String()).
+		Info("Integrity scrubber scheduled")
+
+	// Permanently delete recycle bin items once their retention period ends.
+	services.NewTrashPurger(app, cfg.TrashRetention).Start(context.Background())
+	logger.WithField("function", "main").
+		WithField("retention", cfg.TrashRetention.String()).
+		Info("Recycle bin purger scheduled")
+
+	// Stage resumable uploads on local disk and expire abandoned ones.
+	stager, err := services.NewUploadStager(app, cfg.ResumableUploadDir, cfg.ResumableUploadExpiry)
+	if err != nil {
+		// STORAGE_INIT_ERR: Storage backend configuration or connection errors.
+		wrappedErr := fmt.Errorf("resumable upload directory %q: %w", cfg.ResumableUploadDir, err)
+		logger.WithField("function", "main").
+			WithField("errorCode", "STORAGE_INIT_ERR").
+			WithField("directory", cfg.ResumableUploadDir).
+			WithError(wrappedErr).
+			Error("Storage initialization error")
+		logrus.Exit(1)
+	}
+	stager.Start(context.Background())
+
+	// Scan uploads with clamd. An unreachable daemon is only a warning here,
+	// since the fail mode decides what happens to uploads meanwhile.
+	scanner := services.NewScanner(cfg.ClamdAddress, cfg.ClamdTimeout, cfg.ClamdFailOpen)
+	failMode := "closed"
+	if cfg.ClamdFailOpen {
+		failMode = "open"
 	}
+	if err := scanner.Ping(); err != nil {
+		logger.WithField("function", "main").
+			WithField("address", cfg.ClamdAddress).
+			WithField("failMode", failMode).
+			WithError(err).
+			Warn("Virus scanner is not reachable")
+	} else {
+		logger.WithField("function", "main").
+			WithField("address", cfg.ClamdAddress).
+			WithField("failMode", failMode).
+			Info("Virus scanner ready")
+	}
+
+	// Periodically rescan stored files so newer signatures catch old uploads.
+	rescanner := services.NewRescanner(app, scanner, cfg.RescanInterval, cfg.RescanRateLimit)
+	rescanner.Start(context.Background())
+	logger.WithField("function", "main").
+		WithField("interval", cfg.RescanInterval.String()).
+		WithField("maxBytesPerSec", cfg.RescanRateLimit).
+		Info("Virus rescanner scheduled")
+
+	// Run post-upload processing on a pool of background workers.
+	jobs := services.NewJobQueue(app, cfg.JobWorkers, cfg.JobPollInterval)
+	jobs.Register(services.JobScanFile, services.JobType{Handler: rescanner.ScanFileJob, MaxAttempts: 10, Timeout: 30 * time.Minute})
+	indexer := services.NewTextIndexer(app, jobs, cfg.TextIndexInterval)
+	jobs.Register(services.JobExtractText, services.JobType{Handler: indexer.ExtractTextJob})
+	thumbnailer := services.NewThumbnailer(app, jobs, cfg.PdftoppmPath, cfg.ThumbnailInterval)
+	jobs.Register(services.JobGenerateThumbnails, services.JobType{Handler: thumbnailer.GenerateJob, Timeout: 5 * time.Minute})
+	jobs.Start(context.Background())
+	logger.WithField("function", "main").
+		WithField("workers", cfg.JobWorkers).
+		WithField("types", jobs.Types()).
+		Info("Background job workers started")
+
+	// Keep the full-text search index in step with file contents.
+	indexer.Start(context.Background())
+	logger.WithField("function", "main").
+		WithField("interval", cfg.TextIndexInterval.String()).
+		Info("Text indexer scheduled")
+
+	// Render thumbnails for file listings.
+	thumbnailer.Start(context.Background())
+	logger.WithField("function", "main").
+		WithField("pdftoppm", cfg.PdftoppmPath).
+		WithField("interval", cfg.ThumbnailInterval.String()).
+		Info("Thumbnail renderer scheduled")
+
+	// Convert documents to PDF for preview, caching the results.
+	previews := services.NewPreviewService(app, services.SofficeConverter{Binary: cfg.SofficePath},
+		cfg.PreviewMaxConversions, cfg.PreviewTimeout)
+	logger.WithField("function", "main").
+		WithField("soffice", cfg.SofficePath).
+		WithField("maxConversions", cfg.PreviewMaxConversions).
+		WithField("timeout", cfg.PreviewTimeout.String()).
+		Info("Document preview ready")
 
 	// Create a new router.
 	logger.WithField("function", "main").Debug("Creating new Gorilla mux router...")
@@ -249,11 +353,24 @@ func main() {
 	// Initialize controllers with the application context.
 	logger.WithField("function", "main").Debug("Initializing controllers...")
 	authController := controllers.NewAuthController(app)
-	fileController := controllers.NewFileController(app)
+	fileController := controllers.NewFileController(app, scanner, jobs, indexer, previews, thumbnailer)
 	userController := controllers.NewUserController(app)
 	directoryController := controllers.NewDirectoryController(app)
 	auditLogController := controllers.NewAuditLogController(app)
 	inventoryController := controllers.NewInventoryController(app)
+	integrityController := controllers.NewIntegrityController(app, scrubber)
+	trashController := controllers.NewTrashController(app, cfg.TrashRetention)
+	tusController := controllers.NewTusController(app, fileController, stager)
+	fileTypeController := controllers.NewFileTypeController(app)
+	quarantineController := controllers.NewQuarantineController(app)
+	scanController := controllers.NewScanController(app, rescanner)
+	jobController := controllers.NewJobController(app, jobs)
+	searchController := controllers.NewSearchController(app)
+	metadataSchemaController := controllers.NewMetadataSchemaController(app)
+	tagController := controllers.NewTagController(app)
+	permissionController := controllers.NewPermissionController(app)
+	groupController := controllers.NewGroupController(app)
+	shareController := controllers.NewShareController(app, cfg.ShareLinkMaxLifetime)
 
 	// Define your routes...
 	logger.WithField("function", "main").Debug("Defining application routes...")
@@ -265,9 +382,10 @@ func main() {
 	router.HandleFunc("/bulk-upload", fileController.BulkUpload).Methods("POST")
 	router.HandleFunc("/copy-file", fileController.CopyFile).Methods("POST")
 	router.HandleFunc("/move-file", fileController.MoveFile).Methods("POST")
-	router.HandleFunc("/download", fileController.Download).Methods("GET")
+	router.HandleFunc("/download", fileController.Download).Methods("GET", "HEAD")
 	router.HandleFunc("/files", fileController.ListFiles).Methods("GET")
 	router.HandleFunc("/file/rename", fileController.RenameFile).Methods("PUT")
+	router.HandleFunc("/file/metadata", fileController.UpdateMetadata).Methods("PUT")
 	router.HandleFunc("/users/fetch", userController.FetchUserList).Methods("GET")
 	router.HandleFunc("/users", userController.ListUsers).Methods("GET")
 	router.HandleFunc("/user/add", userController.AddUser).Methods("POST")
@@ -279,13 +397,27 @@ func main() {
 	router.HandleFunc("/user-role", userController.GetUserRole).Methods("GET")
 	router.HandleFunc("/get-user-role", authController.GetUserRole).Methods("GET")
 	router.HandleFunc("/files/all", fileController.ListAllFiles).Methods("GET")
-	router.HandleFunc("/preview", fileController.Preview).Methods("GET")
+	router.HandleFunc("/search", searchController.Search).Methods("GET")
+	router.HandleFunc("/files/query", searchController.QueryFiles).Methods("GET")
+	router.HandleFunc("/preview", fileController.Preview).Methods("GET", "HEAD")
+	router.HandleFunc("/thumbnail", fileController.Thumbnail).Methods("GET", "HEAD")
 	router.HandleFunc("/revoke-admin", userController.RevokeAdmin).Methods("POST")
 	router.HandleFunc("/get-first-admin", userController.GetFirstAdmin).Methods("GET")
 	router.HandleFunc("/file/message", fileController.SendFileMessage).Methods("POST")
 	router.HandleFunc("/file/message/{id}/done", fileController.MarkFileMessageAsDone).Methods("PATCH")
 	router.HandleFunc("/file/messages", fileController.GetFileMessages).Methods("GET")
 	router.HandleFunc("/file/versions", fileController.GetFileVersions).Methods("GET")
+	router.HandleFunc("/file/versions/download", fileController.DownloadVersion).Methods("GET")
+	router.HandleFunc("/file/versions/restore", fileController.RestoreVersion).Methods("POST")
+	router.HandleFunc("/file/versions/diff", fileController.DiffVersions).Methods("GET")
+
+	// Resumable uploads (tus protocol)
+	router.HandleFunc("/tus/uploads", tusController.Options).Methods("OPTIONS")
+	router.HandleFunc("/tus/uploads", tusController.Create).Methods("POST")
+	router.HandleFunc("/tus/uploads/{id}", tusController.Options).Methods("OPTIONS")
+	router.HandleFunc("/tus/uploads/{id}", tusController.Head).Methods("HEAD")
+	router.HandleFunc("/tus/uploads/{id}", tusController.Patch).Methods("PATCH")
+	router.HandleFunc("/tus/uploads/{id}", tusController.Delete).Methods("DELETE")
 
 	// Directory routes
 	router.HandleFunc("/directory/create", directoryController.Create).Methods("POST")
@@ -296,6 +428,12 @@ func main() {
 	router.HandleFunc("/directory/tree", directoryController.Tree).Methods("GET")
 	router.HandleFunc("/directory/move", directoryController.Move).Methods("POST")
 	router.HandleFunc("/download-folder", directoryController.DownloadFolder).Methods("GET")
+	router.HandleFunc("/download/archive", directoryController.DownloadArchive).Methods("POST")
+
+	// Recycle bin routes
+	router.HandleFunc("/trash", trashController.List).Methods("GET")
+	router.HandleFunc("/trash/restore", trashController.Restore).Methods("POST")
+	router.HandleFunc("/trash/delete", trashController.Delete).Methods("DELETE")
 
 	// Inventory routes
 	router.HandleFunc("/inventory", inventoryController.List).Methods("GET")
@@ -307,6 +445,60 @@ func main() {
 	// Audit logs
 	router.HandleFunc("/auditlogs", auditLogController.List).Methods("GET")
 
+	// Content integrity
+	router.HandleFunc("/admin/integrity", integrityController.Report).Methods("GET")
+	router.HandleFunc("/admin/integrity/scrub", integrityController.Scrub).Methods("POST")
+	router.HandleFunc("/admin/quarantine", quarantineController.List).Methods("GET")
+	router.HandleFunc("/admin/scans", scanController.Report).Methods("GET")
+	router.HandleFunc("/admin/scans/rescan", scanController.Rescan).Methods("POST")
+
+	// Background jobs
+	router.HandleFunc("/admin/jobs", jobController.List).Methods("GET")
+	router.HandleFunc("/admin/jobs/{id}/retry", jobController.Retry).Methods("POST")
+	router.HandleFunc("/admin/jobs/{id}/cancel", jobController.Cancel).Methods("POST")
+
+	// Upload file type policy
+	router.HandleFunc("/admin/file-types", fileTypeController.List).Methods("GET")
+	router.HandleFunc("/admin/file-types", fileTypeController.Create).Methods("POST")
+	router.HandleFunc("/admin/file-types/{id}", fileTypeController.Update).Methods("PUT")
+	router.HandleFunc("/admin/file-types/{id}", fileTypeController.Delete).Methods("DELETE")
+
+	// Metadata schemas
+	router.HandleFunc("/metadata-schema", metadataSchemaController.Effective).Methods("GET")
+	router.HandleFunc("/admin/metadata-schemas", metadataSchemaController.List).Methods("GET")
+	router.HandleFunc("/admin/metadata-schemas", metadataSchemaController.Save).Methods("PUT")
+	router.HandleFunc("/admin/metadata-schemas", metadataSchemaController.Delete).Methods("DELETE")
+
+	// Tags
+	router.HandleFunc("/tags", tagController.List).Methods("GET")
+	router.HandleFunc("/tags/files", tagController.Files).Methods("GET")
+	router.HandleFunc("/file/tags", tagController.AddToFile).Methods("POST")
+	router.HandleFunc("/file/tags", tagController.RemoveFromFile).Methods("DELETE")
+	router.HandleFunc("/admin/tags", tagController.Curate).Methods("PUT")
+	router.HandleFunc("/admin/tags", tagController.Delete).Methods("DELETE")
+
+	// Folder permissions
+	router.HandleFunc("/folder-permissions", permissionController.List).Methods("GET")
+	router.HandleFunc("/folder-permissions", permissionController.Grant).Methods("POST")
+	router.HandleFunc("/folder-permissions/effective", permissionController.Effective).Methods("GET")
+	router.HandleFunc("/folder-permissions/{id}", permissionController.Revoke).Methods("DELETE")
+
+	// Groups
+	router.HandleFunc("/groups", groupController.List).Methods("GET")
+	router.HandleFunc("/groups/mine", groupController.Mine).Methods("GET")
+	router.HandleFunc("/groups/{id}/members", groupController.Members).Methods("GET")
+	router.HandleFunc("/admin/groups", groupController.Create).Methods("POST")
+	router.HandleFunc("/admin/groups/{id}", groupController.Update).Methods("PUT")
+	router.HandleFunc("/admin/groups/{id}", groupController.Delete).Methods("DELETE")
+	router.HandleFunc("/admin/groups/{id}/members", groupController.AddMembers).Methods("POST")
+	router.HandleFunc("/admin/groups/{id}/members/{username}", groupController.RemoveMember).Methods("DELETE")
+
+	// Share links; /s/{token} is served without a session.
+	router.HandleFunc("/share-links", shareController.List).Methods("GET")
+	router.HandleFunc("/share-links", shareController.Create).Methods("POST")
+	router.HandleFunc("/share-links/{id}", shareController.Revoke).Methods("DELETE")
+	router.HandleFunc("/s/{token}", shareController.Open).Methods("GET", "POST")
+
 	// WebSocket route
 	router.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		// Attach correlation ID to logs inside the handler, if needed.
@@ -328,8 +520,13 @@ func main() {
 		handlers.AllowedOriginValidator(func(origin string) bool {
 			return strings.HasPrefix(origin, "http://192.168.") || origin == "http://localhost:3000"
 		}),
-		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
-		handlers.AllowedHeaders([]string{"Content-Type", "Authorization"}),
+		handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}),
+		handlers.AllowedHeaders([]string{"Content-Type", "Authorization",
+			"Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata",
+			"Range", "If-Range", "If-None-Match", "If-Modified-Since"}),
+		handlers.ExposedHeaders([]string{"Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size",
+			"Upload-Offset", "Upload-Length", "Upload-Expires", "X-File-Id",
+			"Accept-Ranges", "Content-Range", "Content-Length", "Content-Disposition", "ETag", "Last-Modified"}),
 		handlers.AllowCredentials(),
 	)(router)
 
@@ -1,7 +1,15 @@
 package config
 
 import (
+	"context"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"LANFileSharingSystem/internal/encryption"
+	"LANFileSharingSystem/internal/storage"
 
 	"github.com/joho/godotenv"
 )
@@ -10,6 +18,71 @@ type Config struct {
 	Port        string
 	DatabaseURL string
 	SessionKey  string
+
+	// EncryptionKey is the current master key (32 bytes) that wraps per-file data keys.
+	EncryptionKey   string
+	EncryptionKeyID string
+	// PreviousEncryptionKeys lists retired master keys as "id:key,id:key" so
+	// files wrapped before a rotation stay readable until they are re-wrapped.
+	PreviousEncryptionKeys string
+
+	// StorageBackend selects where file blobs live: "local" (default) or "s3".
+	StorageBackend string
+	// StorageRoot is the directory used by the local backend.
+	StorageRoot string
+	S3          storage.S3Config
+
+	// IntegrityScrubInterval is how often stored blobs are re-verified against
+	// their recorded checksums; zero disables scheduled scrubs.
+	IntegrityScrubInterval time.Duration
+
+	// TrashRetention is how long deleted items stay in the recycle bin before
+	// they are purged for good; zero keeps them until deleted by hand.
+	TrashRetention time.Duration
+
+	// ShareLinkMaxLifetime is the longest a share link may stay valid.
+	ShareLinkMaxLifetime time.Duration
+
+	// ResumableUploadDir holds the partial data of resumable uploads, and
+	// ResumableUploadExpiry is how long an idle upload is kept.
+	ResumableUploadDir    string
+	ResumableUploadExpiry time.Duration
+
+	// ClamdAddress is the clamd that scans uploads ("tcp://host:port" or
+	// "unix:///path"), and ClamdTimeout bounds a single scan. ClamdFailOpen
+	// accepts uploads unscanned while clamd is unavailable instead of
+	// refusing them.
+	ClamdAddress  string
+	ClamdTimeout  time.Duration
+	ClamdFailOpen bool
+
+	// RescanInterval is how often stored files are scanned again with the
+	// latest signatures; zero disables scheduled rescans. RescanRateLimit
+	// caps the bytes per second a rescan reads; zero means no limit.
+	RescanInterval  time.Duration
+	RescanRateLimit int64
+
+	// JobWorkers is the size of the background job worker pool, and
+	// JobPollInterval how often idle workers look for due jobs.
+	JobWorkers      int
+	JobPollInterval time.Duration
+
+	// TextIndexInterval is how often files whose searchable text is missing
+	// or out of date are queued for extraction; zero only checks at startup.
+	TextIndexInterval time.Duration
+
+	// SofficePath is the LibreOffice executable that converts documents to
+	// PDF for preview. At most PreviewMaxConversions conversions run at once,
+	// each bounded by PreviewTimeout.
+	SofficePath           string
+	PreviewMaxConversions int
+	PreviewTimeout        time.Duration
+
+	// PdftoppmPath is the Poppler executable that renders the first page of
+	// PDFs for thumbnails. ThumbnailInterval is how often files without an
+	// up-to-date thumbnail are queued; zero only checks at startup.
+	PdftoppmPath      string
+	ThumbnailInterval time.Duration
 }
 
 func LoadConfig() Config {
@@ -17,9 +90,28 @@ func LoadConfig() Config {
 	_ = godotenv.Load(".env")
 
 	cfg := Config{
-		Port:        os.Getenv("PORT"),
-		DatabaseURL: os.Getenv("DATABASE_URL"),
-		SessionKey:  os.Getenv("SESSION_KEY"),
+		Port:                   os.Getenv("PORT"),
+		DatabaseURL:            os.Getenv("DATABASE_URL"),
+		SessionKey:             os.Getenv("SESSION_KEY"),
+		EncryptionKey:          os.Getenv("ENCRYPTION_KEY"),
+		EncryptionKeyID:        os.Getenv("ENCRYPTION_KEY_ID"),
+		PreviousEncryptionKeys: os.Getenv("ENCRYPTION_PREVIOUS_KEYS"),
+		StorageBackend:         os.Getenv("STORAGE_BACKEND"),
+		StorageRoot:            os.Getenv("STORAGE_ROOT"),
+		ResumableUploadDir:     os.Getenv("RESUMABLE_UPLOAD_DIR"),
+		ClamdAddress:           os.Getenv("CLAMD_ADDRESS"),
+		ClamdFailOpen:          os.Getenv("CLAMD_FAIL_MODE") == "open",
+		SofficePath:            os.Getenv("SOFFICE_PATH"),
+		PdftoppmPath:           os.Getenv("PDFTOPPM_PATH"),
+		S3: storage.S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			UseSSL:    os.Getenv("S3_USE_SSL") == "true",
+			Prefix:    os.Getenv("S3_PREFIX"),
+		},
 	}
 
 	if cfg.Port == "" {
@@ -34,5 +126,156 @@ func LoadConfig() Config {
 		cfg.SessionKey = "your-default-secret-key"
 	}
 
+	if cfg.EncryptionKeyID == "" {
+		cfg.EncryptionKeyID = "primary"
+	}
+
+	if cfg.StorageBackend == "" {
+		cfg.StorageBackend = "local"
+	}
+
+	if cfg.StorageRoot == "" {
+		cfg.StorageRoot = "Cdrrmo"
+	}
+
+	if cfg.SofficePath == "" {
+		cfg.SofficePath = "soffice"
+	}
+
+	if cfg.PdftoppmPath == "" {
+		cfg.PdftoppmPath = "pdftoppm"
+	}
+
+	cfg.IntegrityScrubInterval = 24 * time.Hour
+	if v := os.Getenv("INTEGRITY_SCRUB_INTERVAL"); v != "" {
+		// Durations such as "6h" or "30m"; "0" turns the schedule off.
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.IntegrityScrubInterval = d
+		}
+	}
+
+	if cfg.ResumableUploadDir == "" {
+		cfg.ResumableUploadDir = filepath.Join(os.TempDir(), "lanfs-uploads")
+	}
+
+	cfg.ResumableUploadExpiry = 24 * time.Hour
+	if v := os.Getenv("RESUMABLE_UPLOAD_EXPIRY"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			cfg.ResumableUploadExpiry = d
+		}
+	}
+
+	if cfg.ClamdAddress == "" {
+		cfg.ClamdAddress = "tcp://127.0.0.1:3310"
+	}
+
+	cfg.ClamdTimeout = 2 * time.Minute
+	if v := os.Getenv("CLAMD_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			cfg.ClamdTimeout = d
+		}
+	}
+
+	cfg.RescanInterval = 7 * 24 * time.Hour
+	if v := os.Getenv("RESCAN_INTERVAL"); v != "" {
+		// Durations such as "24h"; "0" turns the schedule off.
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.RescanInterval = d
+		}
+	}
+
+	cfg.RescanRateLimit = 8 << 20
+	if v := os.Getenv("RESCAN_MAX_BYTES_PER_SEC"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+			cfg.RescanRateLimit = n
+		}
+	}
+
+	cfg.JobWorkers = 2
+	if v := os.Getenv("JOB_WORKERS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			cfg.JobWorkers = n
+		}
+	}
+
+	cfg.JobPollInterval = 5 * time.Second
+	if v := os.Getenv("JOB_POLL_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			cfg.JobPollInterval = d
+		}
+	}
+
+	cfg.TextIndexInterval = time.Hour
+	if v := os.Getenv("TEXT_INDEX_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.TextIndexInterval = d
+		}
+	}
+
+	cfg.PreviewMaxConversions = 2
+	if v := os.Getenv("PREVIEW_MAX_CONVERSIONS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			cfg.PreviewMaxConversions = n
+		}
+	}
+
+	cfg.PreviewTimeout = 2 * time.Minute
+	if v := os.Getenv("PREVIEW_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			cfg.PreviewTimeout = d
+		}
+	}
+
+	cfg.ThumbnailInterval = time.Hour
+	if v := os.Getenv("THUMBNAIL_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.ThumbnailInterval = d
+		}
+	}
+
+	cfg.TrashRetention = 30 * 24 * time.Hour
+	if v := os.Getenv("TRASH_RETENTION_DAYS"); v != "" {
+		if days, err := strconv.Atoi(v); err == nil && days >= 0 {
+			cfg.TrashRetention = time.Duration(days) * 24 * time.Hour
+		}
+	}
+
+	cfg.ShareLinkMaxLifetime = 30 * 24 * time.Hour
+	if v := os.Getenv("SHARE_LINK_MAX_DAYS"); v != "" {
+		if days, err := strconv.Atoi(v); err == nil && days > 0 {
+			cfg.ShareLinkMaxLifetime = time.Duration(days) * 24 * time.Hour
+		}
+	}
+
 	return cfg
 }
+
+// Keyring builds the master keyring from the encryption settings.
+func (cfg Config) Keyring() (*encryption.Keyring, error) {
+	kr, err := encryption.NewKeyring(cfg.EncryptionKeyID, []byte(cfg.EncryptionKey))
+	if err != nil {
+		return nil, err
+	}
+	previous, err := encryption.ParseKeyList(cfg.PreviousEncryptionKeys)
+	if err != nil {
+		return nil, err
+	}
+	for id, key := range previous {
+		if err := kr.Add(id, key); err != nil {
+			return nil, err
+		}
+	}
+	return kr, nil
+}
+
+// Storage opens the configured file storage backend.
+func (cfg Config) Storage(ctx context.Context) (storage.Backend, error) {
+	switch cfg.StorageBackend {
+	case "local":
+		return storage.NewLocal(cfg.StorageRoot)
+	case "s3":
+		return storage.NewS3(ctx, cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q (want \"local\" or \"s3\")", cfg.StorageBackend)
+	}
+}
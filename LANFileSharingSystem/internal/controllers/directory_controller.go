@@ -2,17 +2,20 @@ package controllers
 
 import (
 	"archive/zip"
+	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
 
 	"LANFileSharingSystem/internal/models"
+	"LANFileSharingSystem/internal/storage"
 )
 
 // DirectoryController handles endpoints related to directory operations.
@@ -24,16 +27,28 @@ func NewDirectoryController(app *models.App) *DirectoryController {
 	return &DirectoryController{App: app}
 }
 
-// getResourcePath constructs the full path for a directory based on its name and parent.
-func getResourcePath(name, parent string) string {
-	basePath := "Cdrrmo"
-	if parent != "" {
-		return filepath.Join(basePath, parent, name)
+// splitFolderPath splits a full folder path such as "Operation/Reports" into
+// the (name, parent) pair used by the directories table.
+func splitFolderPath(folder string) (string, string) {
+	parent := filepath.Dir(folder)
+	if parent == "." {
+		parent = ""
 	}
-	return filepath.Join(basePath, name)
+	return filepath.Base(folder), parent
 }
 
-// Create handles directory creation in both the filesystem and the database.
+// folderExists reports whether the folder at path dir exists. The department
+// folders always exist, whether or not they have a directory record.
+func folderExists(app *models.App, dir string) (bool, error) {
+	if uploadTopFolders[strings.ToLower(dir)] {
+		return true, nil
+	}
+	name, parent := splitFolderPath(dir)
+	return app.DirectoryExists(name, parent)
+}
+
+// Create handles directory creation. Folders only exist in the database;
+// the storage backend creates key prefixes implicitly when files are written.
 func (dc *DirectoryController) Create(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
@@ -61,15 +76,23 @@ func (dc *DirectoryController) Create(w http.ResponseWriter, r *http.Request) {
 		models.RespondError(w, http.StatusBadRequest, "Directory name cannot be empty")
 		return
 	}
-
-	resourcePath := getResourcePath(req.Name, req.Parent)
-	if _, err := os.Lstat(resourcePath); err == nil {
-		models.RespondError(w, http.StatusConflict, "Directory already exists")
+	// Dot-prefixed names at the root are reserved for internal storage areas
+	// such as archived versions and the recycle bin.
+	if strings.HasPrefix(strings.Split(filepath.Join(req.Parent, req.Name), "/")[0], ".") {
+		models.RespondError(w, http.StatusBadRequest, "Directory name cannot start with '.'")
+		return
+	}
+	if !authorize(dc.App, w, user, req.Parent, models.PermWrite) {
 		return
 	}
 
-	if err := os.MkdirAll(resourcePath, 0755); err != nil {
-		models.RespondError(w, http.StatusInternalServerError, "Error creating directory on disk")
+	exists, err := dc.App.DirectoryExists(req.Name, req.Parent)
+	if err != nil {
+		models.RespondError(w, http.StatusInternalServerError, "Error checking directory existence in database")
+		return
+	}
+	if exists {
+		models.RespondError(w, http.StatusConflict, "Directory already exists")
 		return
 	}
 
@@ -88,8 +111,8 @@ func (dc *DirectoryController) Create(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// Delete handles directory deletion from both the filesystem and the database.
-// It recursively deletes the folder, its subfolders, and files.
+// Delete moves a directory, with its subfolders and files, to the recycle bin.
+// Use the trash endpoints to restore it or delete it permanently.
 func (dc *DirectoryController) Delete(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
 		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
@@ -118,53 +141,39 @@ func (dc *DirectoryController) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 1) Build the absolute path for disk deletion
-	resourcePath := getResourcePath(req.Name, req.Parent)
-
-	// 2) Remove the directory (and its sub-contents) from the filesystem
-	if err := os.RemoveAll(resourcePath); err != nil {
-		models.RespondError(w, http.StatusInternalServerError, "Error deleting directory on disk")
+	exists, err := dc.App.DirectoryExists(req.Name, req.Parent)
+	if err != nil {
+		models.RespondError(w, http.StatusInternalServerError, "Error checking directory existence in database")
 		return
 	}
-
-	// 3) Build the relative path (for database deletion).
-	//    e.g. If parent="Root" and name="FolderA", this becomes "Root/FolderA"
-	relativeFolder := filepath.Join(req.Parent, req.Name)
-
-	// *** Delete file_versions for any files in this folder.
-	if err := dc.App.DeleteFileVersionsInFolder(relativeFolder); err != nil {
-		models.RespondError(w, http.StatusInternalServerError,
-			"Error deleting file version records in the folder")
+	if !exists {
+		models.RespondError(w, http.StatusNotFound, "Directory not found")
 		return
 	}
-
-	// 4) Delete the file records in that folder
-	if err := dc.App.DeleteFilesWithPrefix(relativeFolder); err != nil {
-		models.RespondError(w, http.StatusInternalServerError, "Error deleting file records in the folder")
+	if !authorize(dc.App, w, user, filepath.Join(req.Parent, req.Name), models.PermManage) {
 		return
 	}
 
-	// 5) Delete any subdirectories
-	if err := dc.App.DeleteDirectoriesWithPrefix(relativeFolder); err != nil {
-		models.RespondError(w, http.StatusInternalServerError, "Error deleting directory records from database")
-		return
-	}
-	if err := dc.App.DeleteDirectoryAndSubdirectories(req.Parent, req.Name); err != nil {
-		models.RespondError(w, http.StatusInternalServerError, "Error deleting directory records from database")
+	// Move the folder, its subfolders and files to the recycle bin.
+	item, err := dc.App.MoveFolderToTrash(r.Context(), req.Parent, req.Name, user.Username)
+	if err != nil {
+		log.Printf("Error moving directory '%s' to the recycle bin: %v", filepath.Join(req.Parent, req.Name), err)
+		models.RespondError(w, http.StatusInternalServerError, "Error moving directory to the recycle bin")
 		return
 	}
 
 	dc.App.LogActivity(fmt.Sprintf(
-		"User '%s' deleted directory '%s' (parent: '%s') and all its contents.",
+		"User '%s' moved directory '%s' (parent: '%s') and all its contents to the recycle bin.",
 		user.Username, req.Name, req.Parent))
-	dc.App.LogAudit(user.Username, 0, "DELETE_FOLDER", fmt.Sprintf("User '%s' deleted folder '%s' under parent '%s'.", user.Username, req.Name, req.Parent))
+	dc.App.LogAudit(user.Username, 0, "DELETE_FOLDER", fmt.Sprintf("User '%s' deleted folder '%s' under parent '%s' (recycle bin item %d).", user.Username, req.Name, req.Parent, item.ID))
 
-	models.RespondJSON(w, http.StatusOK, map[string]string{
-		"message": fmt.Sprintf("Directory '%s' and its contents deleted successfully", req.Name),
+	models.RespondJSON(w, http.StatusOK, map[string]interface{}{
+		"message":       fmt.Sprintf("Directory '%s' and its contents moved to the recycle bin", req.Name),
+		"trash_item_id": item.ID,
 	})
 }
 
-// Rename handles renaming of directories in both the filesystem and the database.
+// Rename handles renaming of directories in both the storage backend and the database.
 func (dc *DirectoryController) Rename(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPut {
 		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
@@ -195,6 +204,13 @@ func (dc *DirectoryController) Rename(w http.ResponseWriter, r *http.Request) {
 		models.RespondError(w, http.StatusBadRequest, "Old and new directory names are required")
 		return
 	}
+	if !validEntryName(req.NewName) {
+		models.RespondError(w, http.StatusBadRequest, "Invalid new directory name")
+		return
+	}
+	if !authorize(dc.App, w, user, filepath.Join(req.Parent, req.OldName), models.PermManage) {
+		return
+	}
 
 	// Check in the DB if a directory with the new name already exists under the same parent.
 	exists, err := dc.App.DirectoryExists(req.NewName, req.Parent)
@@ -208,29 +224,35 @@ func (dc *DirectoryController) Rename(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Build the old and new absolute disk paths
-	oldPath := filepath.Join("Cdrrmo", req.Parent, req.OldName)
-	newPath := filepath.Join("Cdrrmo", req.Parent, req.NewName)
+	oldFolderPath := filepath.Join(req.Parent, req.OldName)
+	newFolderPath := filepath.Join(req.Parent, req.NewName)
 
-	// Rename on disk
-	if err := os.Rename(oldPath, newPath); err != nil {
-		models.RespondError(w, http.StatusInternalServerError, "Error renaming directory on disk")
+	// Move the stored objects to the new prefix
+	if err := storage.MovePrefix(r.Context(), dc.App.Storage, oldFolderPath, newFolderPath); err != nil {
+		models.RespondError(w, http.StatusInternalServerError, "Error renaming directory in storage")
 		return
 	}
 
 	// Update the directory record in the database
-	if err := dc.App.UpdateDirectoryRecord(req.OldName, req.NewName); err != nil {
+	if err := dc.App.UpdateDirectoryRecord(req.Parent, req.OldName, req.NewName); err != nil {
 		models.RespondError(w, http.StatusInternalServerError, "Error updating directory record in database")
 		return
 	}
 
 	// Update file paths in 'files' table (if they start with oldFolderPath)
-	oldFolderPath := filepath.Join(req.Parent, req.OldName)
-	newFolderPath := filepath.Join(req.Parent, req.NewName)
 	if err := dc.App.UpdateFilePathsForRenamedFolder(oldFolderPath, newFolderPath); err != nil {
 		models.RespondError(w, http.StatusInternalServerError, "Error updating file paths in database")
 		return
 	}
+	if err := dc.App.MoveMetadataSchemas(oldFolderPath, newFolderPath); err != nil {
+		log.Printf("Error moving metadata schemas from '%s' to '%s': %v", oldFolderPath, newFolderPath, err)
+	}
+	if err := dc.App.MoveFolderPermissions(oldFolderPath, newFolderPath); err != nil {
+		log.Printf("Error moving folder permissions from '%s' to '%s': %v", oldFolderPath, newFolderPath, err)
+	}
+	if err := dc.App.MoveShareLinks(oldFolderPath, newFolderPath); err != nil {
+		log.Printf("Error moving share links from '%s' to '%s': %v", oldFolderPath, newFolderPath, err)
+	}
 
 	dc.App.LogActivity(fmt.Sprintf(
 		"User '%s' renamed directory from '%s' to '%s' (parent: '%s').",
@@ -251,14 +273,22 @@ func (dc *DirectoryController) List(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check authentication if you want only logged-in users to list directories.
-	_, err := dc.App.GetUserFromSession(r)
+	user, err := dc.App.GetUserFromSession(r)
 	if err != nil {
 		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
 		return
 	}
+	access, ok := loadAccess(dc.App, w, user)
+	if !ok {
+		return
+	}
 
 	// Read the parent folder from a query param called "directory".
 	parentParam := strings.TrimSpace(r.URL.Query().Get("directory"))
+	if !access.CanSeeFolder(parentParam) {
+		respondForbidden(w, models.PermRead)
+		return
+	}
 
 	// Retrieve directories/files from the DB.
 	items, err := dc.App.ListDirectory(parentParam)
@@ -267,7 +297,16 @@ func (dc *DirectoryController) List(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	models.RespondJSON(w, http.StatusOK, items)
+	// Only the subfolders the user may see are listed.
+	visible := []map[string]interface{}{}
+	for _, item := range items {
+		name, _ := item["name"].(string)
+		if access.CanSeeFolder(filepath.Join(parentParam, name)) {
+			visible = append(visible, item)
+		}
+	}
+
+	models.RespondJSON(w, http.StatusOK, visible)
 }
 
 // Copy handles copying a folder (directory) along with its files and subdirectories.
@@ -319,48 +358,59 @@ func (dc *DirectoryController) Copy(w http.ResponseWriter, r *http.Request) {
 	sourceRelPath := filepath.Join(req.SourceParent, req.SourceName)
 	destRelPath := filepath.Join(destParent, req.NewName)
 
-	// Build full disk paths
-	srcPath := getResourcePath(req.SourceName, req.SourceParent)
-	dstPath := getResourcePath(req.NewName, destParent)
-
 	// 1) Verify the source folder exists
-	srcInfo, err := os.Stat(srcPath)
+	srcExists, err := dc.App.DirectoryExists(req.SourceName, req.SourceParent)
 	if err != nil {
-		models.RespondError(w, http.StatusNotFound, fmt.Sprintf("Source folder '%s' not found on disk", srcPath))
+		models.RespondError(w, http.StatusInternalServerError, "Error checking directory existence in database")
+		return
+	}
+	if !srcExists {
+		models.RespondError(w, http.StatusNotFound, fmt.Sprintf("Source folder '%s' not found", sourceRelPath))
+		return
+	}
+	if destParent == sourceRelPath || strings.HasPrefix(destParent, sourceRelPath+"/") {
+		models.RespondError(w, http.StatusBadRequest, "Cannot copy a folder into itself")
+		return
+	}
+	access, ok := loadAccess(dc.App, w, user)
+	if !ok {
+		return
+	}
+	if !access.Can(sourceRelPath, models.PermRead) {
+		respondForbidden(w, models.PermRead)
 		return
 	}
-	if !srcInfo.IsDir() {
-		models.RespondError(w, http.StatusBadRequest, "Source path is not a directory")
+	if !access.Can(destParent, models.PermWrite) {
+		respondForbidden(w, models.PermWrite)
 		return
 	}
 
 	// 2) Auto-rename the top-level destination folder if it already exists
-	if _, err := os.Stat(dstPath); err == nil {
-		uniqueName, _ := generateUniqueFolderName(req.NewName, destParent)
-		req.NewName = uniqueName
-		destRelPath = filepath.Join(destParent, req.NewName)
-		dstPath = getResourcePath(req.NewName, destParent)
+	uniqueName, err := dc.generateUniqueFolderName(req.NewName, destParent)
+	if err != nil {
+		models.RespondError(w, http.StatusInternalServerError, "Error checking directory existence in database")
+		return
 	}
+	req.NewName = uniqueName
+	destRelPath = filepath.Join(destParent, req.NewName)
 
-	// 3) Recursively copy the folder on disk, capturing any subfolder renames
-	renames, err := copyDirAndTrackRenames(srcPath, dstPath)
-	if err != nil {
+	// 3) Copy every stored object. The destination is new, so keys map one to one.
+	if err := storage.CopyPrefix(r.Context(), dc.App.Storage, sourceRelPath, destRelPath); err != nil {
+		storage.DeletePrefix(r.Context(), dc.App.Storage, destRelPath)
 		models.RespondError(w, http.StatusInternalServerError, "Error copying folder: "+err.Error())
-		// Optionally remove the partially copied folder on disk
 		return
 	}
 
 	// 4) Create the top-level directory record
 	if err := dc.App.CreateDirectoryRecord(req.NewName, destParent, user.Username); err != nil {
-		os.RemoveAll(dstPath) // rollback if DB fails
+		storage.DeletePrefix(r.Context(), dc.App.Storage, destRelPath) // rollback if DB fails
 		models.RespondError(w, http.StatusInternalServerError, "Error saving folder record to database")
 		return
 	}
 
-	// 5) Recursively duplicate file & directory records, using the rename map
-	if err := duplicateRecordsWithRenames(sourceRelPath, destRelPath, dc, user.Username, renames); err != nil {
+	// 5) Recursively duplicate file & directory records
+	if err := duplicateRecords(sourceRelPath, destRelPath, dc, user.Username); err != nil {
 		log.Println("Warning: error duplicating nested records:", err)
-		// optionally remove the folder or partially inserted records
 	}
 
 	dc.App.LogActivity(fmt.Sprintf("User '%s' copied folder from '%s' to '%s'.",
@@ -372,163 +422,46 @@ func (dc *DirectoryController) Copy(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// copyDirAndTrackRenames recursively copies a directory from src to dst,
-// renaming subfolders if collisions occur. It returns a map so we can
-// track which subfolders ended up renamed on disk.
-func copyDirAndTrackRenames(src, dst string) (map[string]string, error) {
-	renames := make(map[string]string) // key = oldFullDstPath, val = newSubfolderName
-
-	entries, err := os.ReadDir(src)
-	if err != nil {
-		return renames, err
-	}
-
-	// Ensure the destination folder exists
-	if err := os.MkdirAll(dst, 0755); err != nil {
-		return renames, err
-	}
-
-	for _, entry := range entries {
-		srcPath := filepath.Join(src, entry.Name())
-		dstPath := filepath.Join(dst, entry.Name())
-
-		if entry.IsDir() {
-			// If a subfolder with this name already exists, rename it
-			if _, errStat := os.Stat(dstPath); errStat == nil {
-				newName, _ := generateUniqueFolderName(entry.Name(), filepath.Base(dst))
-				// So now "dst/Subfolder" => "dst/Subfolder_copy"
-				dstPath = filepath.Join(dst, newName)
-				// Record that this subfolder was renamed
-				renames[filepath.Join(dst, entry.Name())] = newName
-			}
-
-			subRenames, err := copyDirAndTrackRenames(srcPath, dstPath)
-			if err != nil {
-				return renames, err
-			}
-			// Merge subRenames into renames
-			for k, v := range subRenames {
-				renames[k] = v
-			}
-		} else {
-			// Copy file
-			if err := copyFile(srcPath, dstPath); err != nil {
-				return renames, err
-			}
-		}
-	}
-	return renames, nil
-}
-
-// duplicateRecordsWithRenames is like duplicateRecords, but uses the renames map
-// to ensure DB directory records match the final on-disk folder names.
-func duplicateRecordsWithRenames(
-	srcRelPath, destRelPath string,
-	dc *DirectoryController,
-	username string,
-	renames map[string]string,
-) error {
+// duplicateRecords recreates the file and directory records found below
+// srcRelPath under destRelPath. The stored objects are copied beforehand.
+func duplicateRecords(srcRelPath, destRelPath string, dc *DirectoryController, username string) error {
 	// 1) Duplicate file records for the current directory
 	fileRecords, err := dc.App.ListFilesInDirectory(srcRelPath)
-	if err == nil {
-		for _, f := range fileRecords {
-			newFilePath := filepath.Join(destRelPath, f.FileName)
-			newFR := models.FileRecord{
-				FileName:    f.FileName,
-				FilePath:    newFilePath,
-				Size:        f.Size,
-				ContentType: f.ContentType,
-				Uploader:    username,
-			}
-			// Attempt to create the file record
-			if createErr := dc.App.CreateFileRecord(newFR); createErr != nil {
-				// If it's a duplicate key constraint, rename and retry
-				if strings.Contains(createErr.Error(), "duplicate key value violates unique constraint") {
-					log.Println("Auto-renaming duplicate file:", newFR.FileName)
-					newFR.FileName = generateCopyName(newFR.FileName)
-					newFR.FilePath = filepath.Join(destRelPath, newFR.FileName)
-
-					if retryErr := dc.App.CreateFileRecord(newFR); retryErr != nil {
-						log.Println("Error creating file record even after rename:", retryErr)
-					}
-				} else {
-					log.Println("Error duplicating file record for", f.FileName, ":", createErr)
-				}
-			}
-		}
-	} else {
-		log.Println("Warning: could not list files in", srcRelPath, ":", err)
-	}
-
-	// 2) Look for subdirectories in the source directory
-	srcFullPath := filepath.Join("Cdrrmo", srcRelPath)
-	entries, err := os.ReadDir(srcFullPath)
 	if err != nil {
 		return err
 	}
-
-	for _, entry := range entries {
-		if entry.IsDir() {
-			srcSubRel := filepath.Join(srcRelPath, entry.Name())
-			destSubRel := filepath.Join(destRelPath, entry.Name())
-
-			// Check if we renamed this subfolder on disk
-			// The original full path on disk was: "dst + / + entry.Name()"
-			// But we only have the *relative* "destRelPath/entry.Name()"
-			oldFullDst := filepath.Join("Cdrrmo", destRelPath, entry.Name())
-
-			newSubfolderName := entry.Name() // default
-			if renameVal, found := renames[oldFullDst]; found {
-				newSubfolderName = renameVal
-				destSubRel = filepath.Join(destRelPath, newSubfolderName)
-			}
-
-			// Create a directory record with the final subfolder name
-			if createErr := dc.App.CreateDirectoryRecord(newSubfolderName, destRelPath, username); createErr != nil {
-				if strings.Contains(createErr.Error(), "duplicate key value violates unique constraint") {
-					log.Println("Auto-renaming duplicate directory:", newSubfolderName)
-					renamed := generateCopyName(newSubfolderName)
-					if retryErr := dc.App.CreateDirectoryRecord(renamed, destRelPath, username); retryErr != nil {
-						log.Println("Error creating directory record even after rename:", retryErr)
-						continue
-					}
-					destSubRel = filepath.Join(destRelPath, renamed)
-				} else {
-					log.Println("Error creating directory record for", entry.Name(), ":", createErr)
-				}
-			}
-
-			// Recurse
-			if err := duplicateRecordsWithRenames(srcSubRel, destSubRel, dc, username, renames); err != nil {
-				log.Println("Error duplicating records for subdirectory", entry.Name(), ":", err)
-			}
+	for _, f := range fileRecords {
+		newFR := models.FileRecord{
+			FileName:           f.FileName,
+			Directory:          destRelPath,
+			FilePath:           filepath.Join(destRelPath, f.FileName),
+			Size:               f.Size,
+			ContentType:        f.ContentType,
+			Uploader:           username,
+			Checksum:           f.Checksum,
+			CiphertextChecksum: f.CiphertextChecksum,
+		}
+		if createErr := dc.App.CreateFileRecord(newFR); createErr != nil {
+			log.Println("Error duplicating file record for", f.FileName, ":", createErr)
 		}
 	}
 
-	return nil
-}
-
-// copyFile copies a file from src to dst.
-func copyFile(src, dst string) error {
-	in, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer in.Close()
-
-	out, err := os.Create(dst)
+	// 2) Recurse into subdirectories
+	subdirs, err := dc.App.ListDirectory(srcRelPath)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if cerr := out.Close(); cerr != nil && err == nil {
-			err = cerr
+	for _, d := range subdirs {
+		name, _ := d["name"].(string)
+		if createErr := dc.App.CreateDirectoryRecord(name, destRelPath, username); createErr != nil {
+			log.Println("Error creating directory record for", name, ":", createErr)
+			continue
+		}
+		if err := duplicateRecords(filepath.Join(srcRelPath, name), filepath.Join(destRelPath, name), dc, username); err != nil {
+			log.Println("Error duplicating records for subdirectory", name, ":", err)
 		}
-	}()
-
-	if _, err = io.Copy(out, in); err != nil {
-		return err
 	}
+
 	return nil
 }
 
@@ -539,11 +472,15 @@ func (dc *DirectoryController) Tree(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := dc.App.GetUserFromSession(r)
+	user, err := dc.App.GetUserFromSession(r)
 	if err != nil {
 		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
 		return
 	}
+	access, ok := loadAccess(dc.App, w, user)
+	if !ok {
+		return
+	}
 
 	dirs, err := dc.getAllDirectories()
 	if err != nil {
@@ -551,9 +488,13 @@ func (dc *DirectoryController) Tree(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// A folder the user may see has parents they may see, so the tree
+	// stays connected.
 	parentMap := make(map[string][]string)
 	for _, d := range dirs {
-		parentMap[d.Parent] = append(parentMap[d.Parent], d.Name)
+		if access.CanSeeFolder(filepath.Join(d.Parent, d.Name)) {
+			parentMap[d.Parent] = append(parentMap[d.Parent], d.Name)
+		}
 	}
 	tree := buildTree("", parentMap)
 	models.RespondJSON(w, http.StatusOK, tree)
@@ -584,6 +525,7 @@ func (dc *DirectoryController) getAllDirectories() ([]DirectoryData, error) {
 	rows, err := dc.App.DB.Query(`
         SELECT directory_name, parent_directory
         FROM directories
+        WHERE parent_directory NOT LIKE '.trash/%'
         ORDER BY directory_name
     `)
 	if err != nil {
@@ -673,24 +615,51 @@ func (dc *DirectoryController) Move(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	oldPath := filepath.Join("Cdrrmo", req.OldParent, req.Name)
-	newPath := filepath.Join("Cdrrmo", req.NewParent, req.Name)
+	oldPath := filepath.Join(req.OldParent, req.Name)
+	newPath := filepath.Join(req.NewParent, req.Name)
 
-	if _, err := os.Stat(newPath); err == nil {
+	access, ok := loadAccess(dc.App, w, user)
+	if !ok {
+		return
+	}
+	if !access.Can(oldPath, models.PermManage) {
+		respondForbidden(w, models.PermManage)
+		return
+	}
+	if !access.Can(req.NewParent, models.PermWrite) {
+		respondForbidden(w, models.PermWrite)
+		return
+	}
+
+	conflict, err := dc.App.DirectoryExists(req.Name, req.NewParent)
+	if err != nil {
+		models.RespondError(w, http.StatusInternalServerError, "Error checking destination folder")
+		return
+	}
+	if conflict {
 		models.RespondError(w, http.StatusConflict, "A folder with that name already exists in the destination")
 		return
 	}
 
-	if err := os.Rename(oldPath, newPath); err != nil {
-		models.RespondError(w, http.StatusInternalServerError, "Error moving directory on disk")
+	if err := storage.MovePrefix(r.Context(), dc.App.Storage, oldPath, newPath); err != nil {
+		models.RespondError(w, http.StatusInternalServerError, "Error moving directory in storage")
 		return
 	}
 
 	if err := dc.App.MoveDirectoryRecord(req.Name, req.OldParent, req.NewParent); err != nil {
-		os.Rename(newPath, oldPath) // rollback
+		storage.MovePrefix(r.Context(), dc.App.Storage, newPath, oldPath) // rollback
 		models.RespondError(w, http.StatusInternalServerError, "Error updating directory records")
 		return
 	}
+	if err := dc.App.MoveMetadataSchemas(oldPath, newPath); err != nil {
+		log.Printf("Error moving metadata schemas from '%s' to '%s': %v", oldPath, newPath, err)
+	}
+	if err := dc.App.MoveFolderPermissions(oldPath, newPath); err != nil {
+		log.Printf("Error moving folder permissions from '%s' to '%s': %v", oldPath, newPath, err)
+	}
+	if err := dc.App.MoveShareLinks(oldPath, newPath); err != nil {
+		log.Printf("Error moving share links from '%s' to '%s': %v", oldPath, newPath, err)
+	}
 
 	dc.App.LogActivity(fmt.Sprintf("User '%s' moved directory '%s' from '%s' to '%s'.",
 		user.Username, req.Name, req.OldParent, req.NewParent))
@@ -701,7 +670,7 @@ func (dc *DirectoryController) Move(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// DownloadFolder handles zipping and downloading a folder.
+// DownloadFolder streams a folder and everything below it as a ZIP archive.
 func (dc *DirectoryController) DownloadFolder(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
@@ -720,104 +689,231 @@ func (dc *DirectoryController) DownloadFolder(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	absFolder := filepath.Join("Cdrrmo", folder)
-	info, err := os.Stat(absFolder)
-	if err != nil || !info.IsDir() {
-		models.RespondError(w, http.StatusNotFound, "Folder not found")
+	folder, ok := dc.archiveFolder(w, folder)
+	if !ok {
+		return
+	}
+	if !authorize(dc.App, w, user, folder, models.PermRead) {
+		return
+	}
+	entries, err := dc.folderArchiveEntries(folder, "")
+	if err != nil {
+		models.RespondError(w, http.StatusInternalServerError, "Error listing folder contents")
+		return
+	}
+
+	folderName, _ := splitFolderPath(folder)
+	written := dc.streamArchive(w, r, folderName+".zip", entries)
+	dc.auditArchive(user, written)
+	dc.App.LogAudit(
+		user.Username,
+		0,
+		"DOWNLOAD_FOLDER",
+		fmt.Sprintf("User '%s' downloaded folder '%s'.", user.Username, folder),
+	)
+}
+
+// archiveRequest selects the files and folders of a multi-item download.
+type archiveRequest struct {
+	FileIDs []int    `json:"file_ids"`
+	Folders []string `json:"folders"` // full folder paths, e.g. "Operation/Reports"
+}
+
+// DownloadArchive streams any mix of files and folders as one ZIP archive.
+// Selected files sit at the top of the archive and each folder keeps its own
+// structure under its name.
+func (dc *DirectoryController) DownloadArchive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
 		return
 	}
 
-	zipFile, err := os.CreateTemp("", "folder-*.zip")
+	user, err := dc.App.GetUserFromSession(r)
 	if err != nil {
-		models.RespondError(w, http.StatusInternalServerError, "Could not create temporary file")
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
 		return
 	}
-	defer os.Remove(zipFile.Name())
-	defer zipFile.Close()
 
-	zipWriter := zip.NewWriter(zipFile)
+	var req archiveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		models.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if len(req.FileIDs) == 0 && len(req.Folders) == 0 {
+		models.RespondError(w, http.StatusBadRequest, "Select at least one file or folder")
+		return
+	}
 
-	// Walk and zip all files and subfolders
-	err = filepath.Walk(absFolder, func(filePath string, info os.FileInfo, walkErr error) error {
-		if walkErr != nil {
-			return walkErr
+	access, ok := loadAccess(dc.App, w, user)
+	if !ok {
+		return
+	}
+
+	var entries []archiveEntry
+	for _, id := range req.FileIDs {
+		fr, err := dc.App.GetFileRecordByID(id)
+		if err == sql.ErrNoRows {
+			models.RespondError(w, http.StatusNotFound, fmt.Sprintf("File %d not found", id))
+			return
 		}
-		relPath, err := filepath.Rel(absFolder, filePath)
 		if err != nil {
-			return err
+			models.RespondError(w, http.StatusInternalServerError, "Error retrieving file")
+			return
 		}
-		relPath = filepath.ToSlash(relPath)
-
-		header, err := zip.FileInfoHeader(info)
-		if err != nil {
-			return err
+		if !access.CanFile(fr, models.PermRead) {
+			respondForbidden(w, models.PermRead)
+			return
 		}
-		header.Name = relPath
-		if info.IsDir() {
-			header.Name += "/"
-		} else {
-			header.Method = zip.Deflate
+		entries = append(entries, archiveEntry{File: fr, Name: fr.FileName})
+	}
+	for _, raw := range req.Folders {
+		folder, ok := dc.archiveFolder(w, raw)
+		if !ok {
+			return
 		}
-
-		writer, err := zipWriter.CreateHeader(header)
+		if !access.Can(folder, models.PermRead) {
+			respondForbidden(w, models.PermRead)
+			return
+		}
+		folderName, _ := splitFolderPath(folder)
+		folderEntries, err := dc.folderArchiveEntries(folder, folderName+"/")
 		if err != nil {
-			return err
+			models.RespondError(w, http.StatusInternalServerError, "Error listing folder contents")
+			return
 		}
+		entries = append(entries, folderEntries...)
+	}
 
-		if !info.IsDir() {
-			file, err := os.Open(filePath)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
+	written := dc.streamArchive(w, r, "download.zip", dedupeArchiveEntries(entries))
+	dc.auditArchive(user, written)
+	dc.App.LogActivity(fmt.Sprintf("User '%s' downloaded %d file(s) as an archive.", user.Username, len(written)))
+}
 
-			if _, err := io.Copy(writer, file); err != nil {
-				return err
-			}
-		}
-		return nil
-	})
+// archiveEntry is one file of a ZIP download.
+type archiveEntry struct {
+	File models.FileRecord
+	Name string // slash-separated path inside the archive
+}
+
+// archiveFolder cleans a requested folder path and checks that it exists.
+// It writes the error response itself.
+func (dc *DirectoryController) archiveFolder(w http.ResponseWriter, raw string) (string, bool) {
+	folder := strings.Trim(filepath.ToSlash(filepath.Clean(strings.TrimSpace(raw))), "/")
+	if folder == "" || folder == "." || strings.HasPrefix(folder, ".") || strings.Contains(folder, "/..") {
+		models.RespondError(w, http.StatusBadRequest, "Invalid folder path")
+		return "", false
+	}
+	exists, err := folderExists(dc.App, folder)
+	if err != nil || !exists {
+		models.RespondError(w, http.StatusNotFound, fmt.Sprintf("Folder '%s' not found", folder))
+		return "", false
+	}
+	return folder, true
+}
+
+// folderArchiveEntries lists the files below folder, named by their path
+// relative to it with prefix prepended.
+func (dc *DirectoryController) folderArchiveEntries(folder, prefix string) ([]archiveEntry, error) {
+	files, err := dc.App.ListFilesUnder(folder)
 	if err != nil {
-		models.RespondError(w, http.StatusInternalServerError, "Error creating ZIP archive: "+err.Error())
-		return
+		return nil, err
+	}
+	entries := make([]archiveEntry, 0, len(files))
+	for _, fr := range files {
+		rel := strings.TrimPrefix(filepath.ToSlash(fr.FilePath), folder+"/")
+		entries = append(entries, archiveEntry{File: fr, Name: prefix + rel})
 	}
+	return entries, nil
+}
 
-	if err := zipWriter.Close(); err != nil {
-		models.RespondError(w, http.StatusInternalServerError, "Error finalizing zip archive")
-		return
+// dedupeArchiveEntries drops files that were selected more than once and
+// renames entries whose archive path is already taken, e.g. two selected
+// files with the same name from different folders.
+func dedupeArchiveEntries(entries []archiveEntry) []archiveEntry {
+	seenFiles := make(map[int]bool)
+	seenNames := make(map[string]bool)
+	result := make([]archiveEntry, 0, len(entries))
+	for _, e := range entries {
+		if seenFiles[e.File.ID] {
+			continue
+		}
+		seenFiles[e.File.ID] = true
+
+		name := e.Name
+		ext := path.Ext(name)
+		for n := 2; seenNames[name]; n++ {
+			name = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(e.Name, ext), n, ext)
+		}
+		seenNames[name] = true
+		e.Name = name
+		result = append(result, e)
 	}
+	return result
+}
 
-	dc.App.LogAudit(
-		user.Username,
-		0,
-		"DOWNLOAD_FOLDER",
-		fmt.Sprintf("User '%s' downloaded folder '%s'.", user.Username, folder),
-	)
+// streamArchive writes entries as a ZIP archive straight to the response,
+// decrypting each file on the way. The headers go out before the first file
+// is read, so a failure part way through can only be logged; the client then
+// gets a truncated archive. It returns the entries written in full.
+func (dc *DirectoryController) streamArchive(w http.ResponseWriter, r *http.Request, archiveName string, entries []archiveEntry) []archiveEntry {
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", archiveName))
+
+	zw := zip.NewWriter(w)
+	var written []archiveEntry
+	for _, e := range entries {
+		if err := dc.addFileToZip(r.Context(), zw, e); err != nil {
+			log.Printf("Archive %s aborted at '%s': %v", archiveName, e.File.FilePath, err)
+			return written
+		}
+		written = append(written, e)
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("Error finalizing archive %s: %v", archiveName, err)
+	}
+	return written
+}
 
-	zipFileForRead, err := os.Open(zipFile.Name())
+// addFileToZip decrypts one file into the archive.
+func (dc *DirectoryController) addFileToZip(ctx context.Context, zw *zip.Writer, e archiveEntry) error {
+	blob, err := dc.App.OpenDecrypted(ctx, e.File.FilePath)
 	if err != nil {
-		models.RespondError(w, http.StatusInternalServerError, "Error opening zipped folder")
-		return
+		return err
 	}
-	defer zipFileForRead.Close()
+	defer blob.Close()
 
-	w.Header().Set("Content-Type", "application/zip")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.zip\"", info.Name()))
-	io.Copy(w, zipFileForRead)
+	header := &zip.FileHeader{Name: e.Name, Method: zip.Deflate}
+	if v, err := dc.App.CurrentFileVersion(e.File.ID); err == nil {
+		header.Modified = v.Timestamp
+	}
+	writer, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, blob)
+	return err
 }
 
-// generateUniqueFolderName & generateCopyName remain the same...
-func generateUniqueFolderName(baseName, parent string) (string, error) {
+// auditArchive records a download audit entry for every file in an archive.
+func (dc *DirectoryController) auditArchive(user models.User, written []archiveEntry) {
+	for _, e := range written {
+		dc.App.LogAudit(user.Username, e.File.ID, "DOWNLOAD", fmt.Sprintf("Downloaded '%s' in a ZIP archive", e.File.FilePath))
+	}
+}
+
+// generateUniqueFolderName returns baseName, or the first free copy name for it under parent.
+func (dc *DirectoryController) generateUniqueFolderName(baseName, parent string) (string, error) {
 	uniqueName := baseName
-	folderPath := getResourcePath(uniqueName, parent)
 	for {
-		if _, err := os.Stat(folderPath); os.IsNotExist(err) {
-			break
+		exists, err := dc.App.DirectoryExists(uniqueName, parent)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return uniqueName, nil
 		}
 		uniqueName = generateCopyName(uniqueName)
-		folderPath = getResourcePath(uniqueName, parent)
 	}
-	return uniqueName, nil
 }
 
 func generateCopyName(original string) string {
@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"LANFileSharingSystem/internal/encryption"
+	"LANFileSharingSystem/internal/models"
+	"LANFileSharingSystem/internal/storage"
+)
+
+// fakeDB is a database/sql driver answering the few queries the archive
+// code runs, so it can be tested without PostgreSQL.
+type fakeDB struct {
+	files []models.FileRecord
+}
+
+func (d *fakeDB) Connect(context.Context) (driver.Conn, error) { return d, nil }
+func (d *fakeDB) Driver() driver.Driver                        { return d }
+func (d *fakeDB) Open(string) (driver.Conn, error)             { return d, nil }
+func (d *fakeDB) Close() error                                 { return nil }
+func (d *fakeDB) Begin() (driver.Tx, error)                    { return nil, errors.New("fakeDB: no transactions") }
+func (d *fakeDB) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{db: d, query: query}, nil
+}
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+func (s fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("fakeDB: unexpected statement")
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	switch {
+	case strings.Contains(s.query, "FROM directories"):
+		// No folder has a directory record.
+		return &fakeRows{columns: []string{"count"}, rows: [][]driver.Value{{int64(0)}}}, nil
+	case strings.Contains(s.query, "FROM files") && strings.Contains(s.query, "left(file_path"):
+		dir := args[0].(string)
+		rows := &fakeRows{columns: []string{"id", "file_name", "directory", "file_path", "size",
+			"content_type", "uploader", "checksum", "ciphertext_checksum"}}
+		for _, f := range s.db.files {
+			if strings.HasPrefix(f.FilePath, dir+"/") {
+				rows.rows = append(rows.rows, []driver.Value{int64(f.ID), f.FileName, f.Directory, f.FilePath,
+					f.Size, f.ContentType, f.Uploader, "", ""})
+			}
+		}
+		return rows, nil
+	}
+	return nil, errors.New("fakeDB: unexpected query")
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// testArchiveApp returns an App whose storage holds the given files,
+// encrypted, and whose database lists them.
+func testArchiveApp(t *testing.T, contents map[string]string) *models.App {
+	t.Helper()
+	keys, err := encryption.NewKeyring("test", bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	backend, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocal: %v", err)
+	}
+
+	fake := &fakeDB{}
+	for filePath, content := range contents {
+		var ct bytes.Buffer
+		if err := encryption.EncryptStream(keys, &ct, strings.NewReader(content)); err != nil {
+			t.Fatalf("EncryptStream: %v", err)
+		}
+		if err := backend.Put(context.Background(), filePath, &ct); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		name, dir := splitFolderPath(filePath)
+		fake.files = append(fake.files, models.FileRecord{
+			ID: len(fake.files) + 1, FileName: name, Directory: dir, FilePath: filePath,
+			Size: int64(len(content)), ContentType: "text/plain", Uploader: "alice",
+		})
+	}
+	db := sql.OpenDB(fake)
+	t.Cleanup(func() { db.Close() })
+
+	app := models.NewApp(db, nil)
+	app.Keys = keys
+	app.Storage = backend
+	return app
+}
+
+func TestArchiveDepartmentFolder(t *testing.T) {
+	app := testArchiveApp(t, map[string]string{
+		"Operation/report.txt":      "quarterly report",
+		"Operation/2024/plan.txt":   "the plan",
+		"Operations/other.txt":      "not in the folder",
+		"Research/unrelated.txt":    "research notes",
+		"Operation_x/lookalike.txt": "not in the folder either",
+	})
+	dc := &DirectoryController{App: app}
+
+	// Department folders have no directory record but always exist.
+	rec := httptest.NewRecorder()
+	folder, ok := dc.archiveFolder(rec, "Operation")
+	if !ok {
+		t.Fatalf("archiveFolder(Operation): %d %s", rec.Code, rec.Body)
+	}
+	entries, err := dc.folderArchiveEntries(folder, "")
+	if err != nil {
+		t.Fatalf("folderArchiveEntries: %v", err)
+	}
+
+	rec = httptest.NewRecorder()
+	written := dc.streamArchive(rec, httptest.NewRequest(http.MethodGet, "/download-folder?directory=Operation", nil),
+		"Operation.zip", entries)
+	if len(written) != 2 {
+		t.Fatalf("wrote %d files, want 2", len(written))
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/zip" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatalf("read archive: %v", err)
+	}
+	got := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("open %s: %v", f.Name, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("read %s: %v", f.Name, err)
+		}
+		got[f.Name] = string(data)
+	}
+	want := map[string]string{"report.txt": "quarterly report", "2024/plan.txt": "the plan"}
+	if len(got) != len(want) {
+		t.Fatalf("archive holds %v, want %v", got, want)
+	}
+	for name, content := range want {
+		if got[name] != content {
+			t.Errorf("%s = %q, want %q", name, got[name], content)
+		}
+	}
+}
+
+func TestArchiveFolderNotFound(t *testing.T) {
+	dc := &DirectoryController{App: testArchiveApp(t, nil)}
+	for _, folder := range []string{"Missing", "Operation/Missing"} {
+		rec := httptest.NewRecorder()
+		if _, ok := dc.archiveFolder(rec, folder); ok || rec.Code != http.StatusNotFound {
+			t.Errorf("archiveFolder(%s) = %v with status %d, want 404", folder, ok, rec.Code)
+		}
+	}
+	for _, folder := range []string{"", "..", "../Operation", ".trash"} {
+		rec := httptest.NewRecorder()
+		if _, ok := dc.archiveFolder(rec, folder); ok || rec.Code != http.StatusBadRequest {
+			t.Errorf("archiveFolder(%q) = %v with status %d, want 400", folder, ok, rec.Code)
+		}
+	}
+}
@@ -1,18 +1,20 @@
 package controllers
 
 import (
-	"LANFileSharingSystem/internal/encryption"
+	"LANFileSharingSystem/internal/filetype"
 	"LANFileSharingSystem/internal/models"
+	"LANFileSharingSystem/internal/services"
+	"LANFileSharingSystem/internal/storage"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
-	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -23,10 +25,20 @@ import (
 
 type FileController struct {
 	App *models.App
+	// Scanner checks every upload for malware before it is encrypted.
+	Scanner *services.Scanner
+	// Jobs runs the processing that follows an upload in the background.
+	Jobs *services.JobQueue
+	// Indexer extracts the searchable text of new content.
+	Indexer *services.TextIndexer
+	// Previews converts documents to PDF for preview and caches the results.
+	Previews *services.PreviewService
+	// Thumbnails renders the thumbnails shown in file listings.
+	Thumbnails *services.Thumbnailer
 }
 
-func NewFileController(app *models.App) *FileController {
-	return &FileController{App: app}
+func NewFileController(app *models.App, scanner *services.Scanner, jobs *services.JobQueue, indexer *services.TextIndexer, previews *services.PreviewService, thumbnails *services.Thumbnailer) *FileController {
+	return &FileController{App: app, Scanner: scanner, Jobs: jobs, Indexer: indexer, Previews: previews, Thumbnails: thumbnails}
 }
 
 // Upload handles file uploads.
@@ -54,114 +66,15 @@ func (fc *FileController) Upload(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	allowedExtensions := map[string]bool{
-		".doc":  true,
-		".docx": true,
-		".xls":  true,
-		".xlsx": true,
-		".pdf":  true,
-	}
-
-	allowedMIMETypes := map[string]bool{
-		"application/msword": true,
-		"application/vnd.openxmlformats-officedocument.wordprocessingml.document": true,
-		"application/vnd.ms-excel": true,
-		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet": true,
-		"application/pdf": true,
-	}
-
-	ext := strings.ToLower(filepath.Ext(handler.Filename))
-	mime := handler.Header.Get("Content-Type")
-
-	if !allowedExtensions[ext] || !allowedMIMETypes[mime] {
-		models.RespondError(w, http.StatusBadRequest, "Only Word, Excel, and PDF files with valid MIME types are allowed")
-		return
-	}
-
-	targetDir := r.FormValue("directory")
-	if targetDir != "" {
-		targetDir = filepath.Clean(targetDir)
-		if strings.HasPrefix(targetDir, "..") {
-			models.RespondError(w, http.StatusBadRequest, "Invalid directory path")
-			return
-		}
-		topFolder := strings.ToLower(strings.Split(targetDir, "/")[0])
-		validTopFolders := map[string]bool{
-			"operation": true,
-			"research":  true,
-			"training":  true,
-		}
-		if !validTopFolders[topFolder] {
-			models.RespondError(w, http.StatusBadRequest, "Invalid top-level folder")
-			return
-		}
-	}
-
-	overwrite := r.FormValue("overwrite") == "true"
-
-	uploadBase := "Cdrrmo"
-	rawFileName := handler.Filename
-	relativePath := filepath.Join(targetDir, rawFileName)
-	finalDiskPath := filepath.Join(uploadBase, relativePath)
-
-	existingFR, getErr := fc.App.GetFileRecordByPath(relativePath)
-
-	skip := r.FormValue("skip") == "true"
-	if getErr == nil && skip {
-		models.RespondJSON(w, http.StatusOK, map[string]string{
-			"message": fmt.Sprintf("File '%s' skipped (already exists)", rawFileName),
-		})
-		return
-	}
-
-	if getErr == nil && !overwrite {
-		baseName := strings.TrimSuffix(rawFileName, filepath.Ext(rawFileName))
-		ext := filepath.Ext(rawFileName)
-		counter := 1
-		for {
-			uniqueFileName := fmt.Sprintf("%s_%d%s", baseName, counter, ext)
-			rel := filepath.Join(targetDir, uniqueFileName)
-			if _, err := fc.App.GetFileRecordByPath(rel); err != nil {
-				relativePath = rel
-				finalDiskPath = filepath.Join(uploadBase, rel)
-				break
-			}
-			counter++
-		}
-	}
-
-	if err := os.MkdirAll(filepath.Dir(finalDiskPath), 0755); err != nil {
-		models.RespondError(w, http.StatusInternalServerError, "Error creating target directory")
-		return
-	}
-
-	tempFilePath := finalDiskPath + ".tmp"
-	tempFile, err := os.Create(tempFilePath)
+	targetDir, err := cleanUploadDirectory(r.FormValue("directory"))
 	if err != nil {
-		models.RespondError(w, http.StatusInternalServerError, "Error creating temporary file")
-		return
-	}
-	if _, err := io.Copy(tempFile, file); err != nil {
-		tempFile.Close()
-		os.Remove(tempFilePath)
-		models.RespondError(w, http.StatusInternalServerError, "Error saving temporary file")
-		return
-	}
-	tempFile.Close()
-
-	key := []byte(os.Getenv("ENCRYPTION_KEY"))
-	if len(key) != 32 {
-		os.Remove(tempFilePath)
-		models.RespondError(w, http.StatusInternalServerError, "Invalid encryption key")
+		models.RespondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-
-	if err := encryption.EncryptFile(key, tempFilePath, finalDiskPath); err != nil {
-		os.Remove(tempFilePath)
-		models.RespondError(w, http.StatusInternalServerError, "Error encrypting file")
+	if err := fc.checkUploadAccess(user, targetDir); err != nil {
+		models.RespondError(w, uploadErrorCode(err), uploadErrorMessage(err))
 		return
 	}
-	os.Remove(tempFilePath)
 
 	metaJSON := r.FormValue("metadata")
 	var metaMap map[string]interface{}
@@ -171,71 +84,39 @@ func (fc *FileController) Upload(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-
-	existingFR, getErr = fc.App.GetFileRecordByPath(relativePath)
-	if getErr == nil && overwrite {
-		fileID := existingFR.ID
-		updateErr := fc.App.UpdateFileMetadata(fileID, handler.Size, handler.Header.Get("Content-Type"))
-		if updateErr != nil {
-			models.RespondError(w, http.StatusInternalServerError, "Error updating file record")
-			return
-		}
-
-		latestVer, _ := fc.App.GetLatestVersionNumber(fileID)
-		newVer := latestVer + 1
-		if verr := fc.App.CreateFileVersion(fileID, newVer, relativePath); verr != nil {
-			log.Println("Warning: failed to create file version record:", verr)
-		}
-
-		fc.App.LogActivity(fmt.Sprintf("User '%s' re-uploaded file '%s' (version %d).", user.Username, rawFileName, newVer))
-		fc.App.LogAudit(user.Username, fileID, "REUPLOAD", fmt.Sprintf("File '%s' re-uploaded as version %d", rawFileName, newVer))
-
-		if fc.App.NotificationHub != nil {
-			notification := []byte(fmt.Sprintf(`{"event": "file_uploaded", "file_name": "%s", "version": %d}`, rawFileName, newVer))
-			fc.App.NotificationHub.Broadcast(notification)
-		}
-
-		models.RespondJSON(w, http.StatusOK, map[string]string{
-			"message": fmt.Sprintf("File '%s' updated (version %d) successfully", rawFileName, newVer),
-		})
+	if !fc.checkMetadata(w, targetDir, metaMap) {
 		return
 	}
 
-	fr := models.FileRecord{
-		FileName:    filepath.Base(relativePath),
-		Directory:   targetDir,
-		FilePath:    relativePath,
-		Size:        handler.Size,
-		ContentType: handler.Header.Get("Content-Type"),
-		Uploader:    user.Username,
-		Metadata:    metaMap,
-	}
-
-	if err := fc.App.CreateFileRecord(fr); err != nil {
-		log.Println("Error saving file record:", err)
-		models.RespondError(w, http.StatusInternalServerError, "Error saving file record")
+	res, err := fc.storeUpload(r.Context(), user, uploadRequest{
+		FileName:  handler.Filename,
+		Directory: targetDir,
+		Overwrite: r.FormValue("overwrite") == "true",
+		Skip:      r.FormValue("skip") == "true",
+		Metadata:  metaMap,
+	}, file)
+	if err != nil {
+		log.Printf("Upload of %s failed: %v", handler.Filename, err)
+		models.RespondError(w, uploadErrorCode(err), uploadErrorMessage(err))
 		return
 	}
+	fc.announceUpload(user, res)
 
-	fileID, _ := fc.App.GetFileIDByPath(fr.FilePath)
-	if fileID > 0 {
-		if verr := fc.App.CreateFileVersion(fileID, 1, fr.FilePath); verr != nil {
-			log.Println("Warning: failed to create version record:", verr)
-		}
-	}
-
-	fc.App.LogAudit(user.Username, fileID, "UPLOAD", fmt.Sprintf("File '%s' uploaded (version 1)", rawFileName))
-	fc.App.LogActivity(fmt.Sprintf("User '%s' uploaded new file '%s' (version 1).", user.Username, rawFileName))
-
-	if fc.App.NotificationHub != nil {
-		notification := []byte(fmt.Sprintf(`{"event": "file_uploaded", "file_name": "%s", "version": 1}`, rawFileName))
-		fc.App.NotificationHub.Broadcast(notification)
+	switch res.Outcome {
+	case uploadSkipped:
+		models.RespondJSON(w, http.StatusOK, map[string]string{
+			"message": fmt.Sprintf("File '%s' skipped (already exists)", res.FileName),
+		})
+	case uploadOverwritten:
+		models.RespondJSON(w, http.StatusOK, map[string]string{
+			"message": fmt.Sprintf("File '%s' updated (version %d) successfully", res.FileName, res.Version),
+		})
+	default:
+		models.RespondJSON(w, http.StatusOK, map[string]interface{}{
+			"message": fmt.Sprintf("File '%s' uploaded (version 1) successfully", res.FileName),
+			"file_id": res.FileID,
+		})
 	}
-
-	models.RespondJSON(w, http.StatusOK, map[string]interface{}{
-		"message": fmt.Sprintf("File '%s' uploaded (version 1) successfully", rawFileName),
-		"file_id": fileID,
-	})
 }
 
 // RenameFile renames a file both in local storage and in the database.
@@ -265,6 +146,10 @@ func (fc *FileController) RenameFile(w http.ResponseWriter, r *http.Request) {
 		models.RespondError(w, http.StatusBadRequest, "Old and new filenames are required")
 		return
 	}
+	if !validEntryName(req.NewFilename) {
+		models.RespondError(w, http.StatusBadRequest, "Invalid new filename")
+		return
+	}
 
 	// 1) Get the old file record to see the old path
 	oldFR, err := fc.App.GetFileRecord(req.OldFilename)
@@ -272,14 +157,22 @@ func (fc *FileController) RenameFile(w http.ResponseWriter, r *http.Request) {
 		models.RespondError(w, http.StatusNotFound, "Old file not found in database")
 		return
 	}
+	if !authorizeFile(fc.App, w, user, oldFR, models.PermWrite) {
+		return
+	}
 
 	// 2) Build the new relative path (keep the same folder, just change the file name)
-	oldFullPath := filepath.Join("Cdrrmo", oldFR.FilePath)
 	newRelativePath := filepath.Join(filepath.Dir(oldFR.FilePath), req.NewFilename)
-	newFullPath := filepath.Join("Cdrrmo", newRelativePath)
+	if _, err := fc.App.GetFileIDByPath(newRelativePath); err == nil {
+		models.RespondError(w, http.StatusConflict, fmt.Sprintf("A file named '%s' already exists in this folder", req.NewFilename))
+		return
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		models.RespondError(w, http.StatusInternalServerError, "Error checking for existing file")
+		return
+	}
 
-	// 3) Rename on disk
-	if err := os.Rename(oldFullPath, newFullPath); err != nil {
+	// 3) Rename in storage
+	if err := fc.App.Storage.Move(r.Context(), oldFR.FilePath, newRelativePath); err != nil {
 		models.RespondError(w, http.StatusInternalServerError, "Error renaming file in storage")
 		return
 	}
@@ -290,18 +183,10 @@ func (fc *FileController) RenameFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Retrieve fileID for the new file path
+	// Retrieve fileID for the new file path. A rename does not change the
+	// content, so no new version is recorded.
 	fileID, err := fc.App.GetFileIDByPath(newRelativePath)
 	if err == nil && fileID > 0 {
-		// If we found the file ID, figure out the next version
-		latestVer, _ := fc.App.GetLatestVersionNumber(fileID)
-		newVer := latestVer + 1
-
-		// Insert a version record for the new name/path
-		if verr := fc.App.CreateFileVersion(fileID, newVer, newRelativePath); verr != nil {
-			log.Println("Warning: failed to create file version record:", verr)
-		}
-
 		// ✅ Log the audit event as a RENAME action (not UPLOAD)
 		action := "RENAME"
 		details := fmt.Sprintf("File renamed from '%s' to '%s'", req.OldFilename, req.NewFilename)
@@ -318,7 +203,64 @@ func (fc *FileController) RenameFile(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// DeleteFile handles file deletion requests.
+// UpdateMetadata handles PUT /file/metadata, which replaces a file's
+// metadata. The new metadata must satisfy the schema of the file's folder.
+func (fc *FileController) UpdateMetadata(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, err := fc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	var req struct {
+		FileID   int                    `json:"file_id"`
+		Metadata map[string]interface{} `json:"metadata"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		models.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.FileID <= 0 {
+		models.RespondError(w, http.StatusBadRequest, "A file ID is required")
+		return
+	}
+
+	fr, err := fc.App.GetFileRecordByID(req.FileID)
+	if err == sql.ErrNoRows {
+		models.RespondError(w, http.StatusNotFound, "File not found")
+		return
+	}
+	if err != nil {
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving file record")
+		return
+	}
+	if !authorizeFile(fc.App, w, user, fr, models.PermWrite) {
+		return
+	}
+	if !fc.checkMetadata(w, fr.Directory, req.Metadata) {
+		return
+	}
+
+	if err := fc.App.UpdateFileMetadata(fr.ID, req.Metadata); err != nil {
+		log.Printf("Error updating metadata of %s: %v", fr.FilePath, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error updating metadata")
+		return
+	}
+
+	fc.App.LogAudit(user.Username, fr.ID, "METADATA_UPDATE", fmt.Sprintf("Metadata of '%s' updated", fr.FilePath))
+	fc.App.LogActivity(fmt.Sprintf("User '%s' updated the metadata of '%s'.", user.Username, fr.FilePath))
+	models.RespondJSON(w, http.StatusOK, map[string]string{
+		"message": fmt.Sprintf("Metadata of '%s' updated successfully", fr.FileName),
+	})
+}
+
+// DeleteFile moves a file to the recycle bin; its versions are kept until
+// the item is deleted permanently.
 func (fc *FileController) DeleteFile(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
 		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
@@ -355,35 +297,28 @@ func (fc *FileController) DeleteFile(w http.ResponseWriter, r *http.Request) {
 		models.RespondError(w, http.StatusNotFound, "File not found in database")
 		return
 	}
-
-	// Log the audit before deletion
-	fc.App.LogAudit(user.Username, fr.ID, "DELETE", fmt.Sprintf("File '%s' deleted", fr.FileName))
-
-	fullPath := filepath.Join("Cdrrmo", fr.FilePath)
-	if removeErr := os.Remove(fullPath); removeErr != nil && !os.IsNotExist(removeErr) {
-		models.RespondError(w, http.StatusInternalServerError, "Error deleting file from local storage")
+	if !authorizeFile(fc.App, w, user, fr, models.PermWrite) {
 		return
 	}
 
-	fileID, err := fc.App.DeleteFileRecordByPath(relativePath)
+	item, err := fc.App.MoveFileToTrash(r.Context(), fr, user.Username)
 	if err != nil {
-		models.RespondError(w, http.StatusInternalServerError, "Error deleting file record from database")
+		log.Printf("Error moving file '%s' to the recycle bin: %v", relativePath, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error moving file to the recycle bin")
 		return
 	}
 
-	if delVerErr := fc.App.DeleteFileVersions(fileID); delVerErr != nil {
-		log.Printf("Warning: could not delete file versions for ID %d: %v\n", fileID, delVerErr)
-	}
-
-	fc.App.LogActivity(fmt.Sprintf("User '%s' deleted file '%s'.", user.Username, relativePath))
-	models.RespondJSON(w, http.StatusOK, map[string]string{
-		"message": fmt.Sprintf("File '%s' deleted successfully", relativePath),
+	fc.App.LogAudit(user.Username, fr.ID, "DELETE", fmt.Sprintf("File '%s' moved to the recycle bin", fr.FileName))
+	fc.App.LogActivity(fmt.Sprintf("User '%s' moved file '%s' to the recycle bin.", user.Username, relativePath))
+	models.RespondJSON(w, http.StatusOK, map[string]interface{}{
+		"message":       fmt.Sprintf("File '%s' moved to the recycle bin", relativePath),
+		"trash_item_id": item.ID,
 	})
 }
 
 // Download handles file download requests by decrypting files before sending.
 func (fc *FileController) Download(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
 		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
 		return
 	}
@@ -431,46 +366,30 @@ func (fc *FileController) Download(w http.ResponseWriter, r *http.Request) {
 		models.RespondError(w, http.StatusNotFound, "File not found")
 		return
 	}
-
-	encryptedFilePath := filepath.Join("Cdrrmo", fr.FilePath)
-	tempDecryptedPath := encryptedFilePath + ".dec"
-
-	key := []byte(os.Getenv("ENCRYPTION_KEY"))
-	if len(key) != 32 {
-		models.RespondError(w, http.StatusInternalServerError, "Invalid encryption key")
+	if !authorizeFile(fc.App, w, user, fr, models.PermRead) {
 		return
 	}
 
-	if err := encryption.DecryptFile(key, encryptedFilePath, tempDecryptedPath); err != nil {
-		os.Remove(tempDecryptedPath)
-		log.Printf("Decryption failed for %s: %v", relativePath, err)
-		models.RespondError(w, http.StatusInternalServerError, "Error decrypting file")
+	etag, modified := fc.contentValidators(fr)
+	if notModified(r, etag) {
+		writeNotModified(w, etag)
 		return
 	}
-	defer func() {
-		if err := os.Remove(tempDecryptedPath); err != nil && !os.IsNotExist(err) {
-			log.Printf("Failed to clean up temp file %s: %v", tempDecryptedPath, err)
-		}
-	}()
 
-	f, err := os.Open(tempDecryptedPath)
+	blob, err := fc.App.OpenSeekable(r.Context(), fr.FilePath)
 	if err != nil {
-		log.Printf("Failed to open decrypted file %s: %v", tempDecryptedPath, err)
-		models.RespondError(w, http.StatusInternalServerError, "Error opening decrypted file")
+		log.Printf("Decryption failed for %s: %v", relativePath, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error decrypting file")
 		return
 	}
-	defer f.Close()
+	defer blob.Close()
 
 	w.Header().Set("Content-Type", fr.ContentType)
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", fr.FileName))
 
-	if _, err := io.Copy(w, f); err != nil {
-		log.Printf("Streaming error for %s: %v", relativePath, err)
-		models.RespondError(w, http.StatusInternalServerError, "Error sending file")
-		return
+	if status := serveBlob(w, r, blob, etag, modified); countsAsRead(r, status) {
+		fc.App.LogActivity(fmt.Sprintf("User '%s' downloaded file '%s' (ID: %d)", user.Username, fr.FileName, fr.ID))
 	}
-
-	fc.App.LogActivity(fmt.Sprintf("User '%s' downloaded file '%s' (ID: %d)", user.Username, fr.FileName, fr.ID))
 }
 
 // CopyFile creates a copy of an existing file in the storage and inserts a new record in the database.
@@ -511,8 +430,6 @@ func (fc *FileController) CopyFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	srcPath := filepath.Join("Cdrrmo", oldFR.FilePath)
-
 	finalName := req.NewFileName
 	if finalName == "" {
 		finalName = filepath.Base(req.SourceFile)
@@ -523,6 +440,19 @@ func (fc *FileController) CopyFile(w http.ResponseWriter, r *http.Request) {
 		destFolder = filepath.Dir(oldFR.FilePath)
 	}
 
+	access, ok := loadAccess(fc.App, w, user)
+	if !ok {
+		return
+	}
+	if !access.CanFile(oldFR, models.PermRead) {
+		respondForbidden(w, models.PermRead)
+		return
+	}
+	if !access.Can(destFolder, models.PermWrite) {
+		respondForbidden(w, models.PermWrite)
+		return
+	}
+
 	base := strings.TrimSuffix(finalName, filepath.Ext(finalName))
 	ext := filepath.Ext(finalName)
 	counter := 0
@@ -542,28 +472,12 @@ func (fc *FileController) CopyFile(w http.ResponseWriter, r *http.Request) {
 		counter++
 	}
 
-	dstPath := filepath.Join("Cdrrmo", newRelativePath)
-
-	in, err := os.Open(srcPath)
-	if err != nil {
-		models.RespondError(w, http.StatusInternalServerError, "Failed to open source file")
-		return
-	}
-	defer in.Close()
-
-	if _, err := os.Stat(dstPath); err == nil {
-		models.RespondError(w, http.StatusConflict, "Target file already exists on disk")
+	if exists, _ := storage.Exists(r.Context(), fc.App.Storage, newRelativePath); exists {
+		models.RespondError(w, http.StatusConflict, "Target file already exists in storage")
 		return
 	}
 
-	out, err := os.Create(dstPath)
-	if err != nil {
-		models.RespondError(w, http.StatusInternalServerError, "Failed to create target file")
-		return
-	}
-	defer out.Close()
-
-	if _, err := io.Copy(out, in); err != nil {
+	if err := storage.Copy(r.Context(), fc.App.Storage, oldFR.FilePath, newRelativePath); err != nil {
 		models.RespondError(w, http.StatusInternalServerError, "Failed to copy file content")
 		return
 	}
@@ -575,10 +489,13 @@ func (fc *FileController) CopyFile(w http.ResponseWriter, r *http.Request) {
 		Size:        oldFR.Size,
 		ContentType: oldFR.ContentType,
 		Uploader:    user.Username,
+		// The ciphertext is copied byte for byte, so both digests carry over.
+		Checksum:           oldFR.Checksum,
+		CiphertextChecksum: oldFR.CiphertextChecksum,
 	}
 
 	if err := fc.App.CreateFileRecord(newRecord); err != nil {
-		os.Remove(dstPath)
+		fc.App.Storage.Delete(r.Context(), newRelativePath)
 		log.Printf("DB insert failed: %+v", err)
 		models.RespondError(w, http.StatusInternalServerError, "Failed to save file record")
 		return
@@ -586,8 +503,22 @@ func (fc *FileController) CopyFile(w http.ResponseWriter, r *http.Request) {
 
 	newFileID, err := fc.App.GetFileIDByPath(newRelativePath)
 	if err == nil && newFileID > 0 {
-		_ = fc.App.CreateFileVersion(newFileID, 1, newRelativePath)
+		// The copy starts its own history with the source's current content.
+		_ = fc.App.RecordFileVersion(models.FileVersion{
+			FileID:             newFileID,
+			Version:            1,
+			FilePath:           newRelativePath,
+			Size:               oldFR.Size,
+			ContentType:        oldFR.ContentType,
+			Uploader:           user.Username,
+			Checksum:           oldFR.Checksum,
+			CiphertextChecksum: oldFR.CiphertextChecksum,
+		})
 		fc.App.LogAudit(user.Username, newFileID, "COPY", fmt.Sprintf("File copied from '%s' to '%s'", req.SourceFile, newRelativePath))
+		if fc.Indexer != nil {
+			newRecord.ID = newFileID
+			fc.Indexer.Enqueue(newRecord)
+		}
 	}
 
 	fc.App.LogActivity(fmt.Sprintf("User '%s' copied file from '%s' to '%s'", user.Username, req.SourceFile, newRelativePath))
@@ -604,54 +535,104 @@ func (fc *FileController) ListFiles(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if _, err := fc.App.GetUserFromSession(r); err != nil {
+	user, err := fc.App.GetUserFromSession(r)
+	if err != nil {
 		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
 		return
 	}
 
 	dir := r.URL.Query().Get("directory")
 
-	files, err := fc.App.ListFilesInDirectory(dir)
-	if err != nil {
-		models.RespondError(w, http.StatusInternalServerError, "Error retrieving files")
+	// Folders that only lead to readable subfolders can be opened but show
+	// no files.
+	access, ok := loadAccess(fc.App, w, user)
+	if !ok {
+		return
+	}
+	if !access.CanSeeFolder(dir) {
+		respondForbidden(w, models.PermRead)
 		return
 	}
 
-	var output []map[string]interface{}
-	for _, f := range files {
-		output = append(output, map[string]interface{}{
-			"name":        f.FileName,
-			"type":        "file",
-			"size":        f.Size,
-			"contentType": f.ContentType,
-			"uploader":    f.Uploader,
-			"id":          f.ID,
-		})
+	var files []models.FileRecord
+	if access.Can(dir, models.PermRead) {
+		files, err = fc.App.ListFilesInDirectory(dir)
+		if err != nil {
+			models.RespondError(w, http.StatusInternalServerError, "Error retrieving files")
+			return
+		}
+	}
+
+	output, err := fileListing(fc.App, files)
+	if err != nil {
+		log.Printf("Error building file listing: %v", err)
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving files")
+		return
 	}
 
 	models.RespondJSON(w, http.StatusOK, output)
 }
 
-// ListAllFiles handles retrieving all file records from the database.
+// ListAllFiles handles retrieving all file records the user may read.
 func (fc *FileController) ListAllFiles(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
 		return
 	}
 
-	if _, err := fc.App.GetUserFromSession(r); err != nil {
+	user, err := fc.App.GetUserFromSession(r)
+	if err != nil {
 		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
 		return
 	}
+	access, ok := loadAccess(fc.App, w, user)
+	if !ok {
+		return
+	}
 
 	files, err := fc.App.ListAllFiles()
 	if err != nil {
 		models.RespondError(w, http.StatusInternalServerError, "Error retrieving files")
 		return
 	}
+	files = access.FilterFiles(files)
+
+	output, err := fileListing(fc.App, files)
+	if err != nil {
+		log.Printf("Error building file listing: %v", err)
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving files")
+		return
+	}
+
+	models.RespondJSON(w, http.StatusOK, output)
+}
+
+// fileListing builds the entries file listings return, with each file's tags
+// and, once rendered, a link to its thumbnail.
+func fileListing(app *models.App, files []models.FileRecord) ([]map[string]interface{}, error) {
+	ids := make([]int, len(files))
+	for i, f := range files {
+		ids[i] = f.ID
+	}
+	tags, err := app.FileTags(ids)
+	if err != nil {
+		return nil, err
+	}
+	thumbs, err := app.FileThumbnails(ids)
+	if err != nil {
+		return nil, err
+	}
 
 	var output []map[string]interface{}
 	for _, f := range files {
+		fileTags := tags[f.ID]
+		if fileTags == nil {
+			fileTags = []string{}
+		}
+		var thumbnail interface{}
+		if checksum, ok := thumbs[f.ID]; ok {
+			thumbnail = fmt.Sprintf("/thumbnail?file_id=%d&v=%s", f.ID, thumbnailTag(checksum))
+		}
 		output = append(output, map[string]interface{}{
 			"name":        f.FileName,
 			"type":        "file",
@@ -659,10 +640,11 @@ func (fc *FileController) ListAllFiles(w http.ResponseWriter, r *http.Request) {
 			"contentType": f.ContentType,
 			"uploader":    f.Uploader,
 			"id":          f.ID,
+			"tags":        fileTags,
+			"thumbnail":   thumbnail,
 		})
 	}
-
-	models.RespondJSON(w, http.StatusOK, output)
+	return output, nil
 }
 
 // MoveFile handles moving a file from one folder to another.
@@ -701,25 +683,34 @@ func (fc *FileController) MoveFile(w http.ResponseWriter, r *http.Request) {
 
 	// Build full relative and disk paths
 	oldRelativePath := filepath.Join(req.OldParent, req.Filename)
-	oldFullPath := filepath.Join("Cdrrmo", oldRelativePath)
 
 	fr, err := fc.App.GetFileRecordByPath(oldRelativePath)
 	if err != nil {
 		models.RespondError(w, http.StatusNotFound, "File not found in database")
 		return
 	}
+	access, ok := loadAccess(fc.App, w, user)
+	if !ok {
+		return
+	}
+	if !access.CanFile(fr, models.PermWrite) || !access.Can(req.NewParent, models.PermWrite) {
+		respondForbidden(w, models.PermWrite)
+		return
+	}
 
 	base := strings.TrimSuffix(fr.FileName, filepath.Ext(fr.FileName))
 	ext := filepath.Ext(fr.FileName)
 	finalName := fr.FileName
 	newRelativePath := filepath.Join(req.NewParent, finalName)
-	newFullPath := filepath.Join("Cdrrmo", newRelativePath)
 
 	existingFR, err := fc.App.GetFileRecordByPath(newRelativePath)
 	if err == nil {
 		if req.Overwrite {
-			_ = os.Remove(filepath.Join("Cdrrmo", existingFR.FilePath))
+			_ = fc.App.Storage.Delete(r.Context(), existingFR.FilePath)
 			_ = fc.App.DeleteFileVersions(existingFR.ID)
+			_ = fc.App.DeleteFileVersionBlobs(r.Context(), existingFR.ID)
+			_ = fc.App.DeletePreviewBlobs(r.Context(), existingFR.ID)
+			_ = fc.App.DeleteThumbnailBlobs(r.Context(), existingFR.ID)
 
 			_, deleteErr := fc.App.DeleteFileRecordByPath(existingFR.FilePath)
 			if deleteErr != nil {
@@ -732,11 +723,9 @@ func (fc *FileController) MoveFile(w http.ResponseWriter, r *http.Request) {
 			for {
 				tempName := fmt.Sprintf("%s (%d)%s", base, attempt, ext)
 				tempRelPath := filepath.Join(req.NewParent, tempName)
-				full := filepath.Join("Cdrrmo", tempRelPath)
-				if _, err := os.Stat(full); os.IsNotExist(err) {
+				if exists, err := storage.Exists(r.Context(), fc.App.Storage, tempRelPath); err == nil && !exists {
 					finalName = tempName
 					newRelativePath = tempRelPath
-					newFullPath = full
 					break
 				}
 				attempt++
@@ -745,43 +734,25 @@ func (fc *FileController) MoveFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Safety: check if original file exists
-	if _, err := os.Stat(oldFullPath); os.IsNotExist(err) {
-		models.RespondError(w, http.StatusNotFound, "Source file does not exist on disk")
+	if exists, _ := storage.Exists(r.Context(), fc.App.Storage, oldRelativePath); !exists {
+		models.RespondError(w, http.StatusNotFound, "Source file does not exist in storage")
 		return
 	}
 
-	if err := os.MkdirAll(filepath.Dir(newFullPath), 0755); err != nil {
-		models.RespondError(w, http.StatusInternalServerError, "Failed to create destination folder")
+	if err := fc.App.Storage.Move(r.Context(), oldRelativePath, newRelativePath); err != nil {
+		models.RespondError(w, http.StatusInternalServerError, "Failed to move file in storage")
 		return
 	}
 
-	if err := os.Rename(oldFullPath, newFullPath); err != nil {
-		models.RespondError(w, http.StatusInternalServerError, "Failed to move file on disk")
-		return
-	}
-
-	// Remove the old file record and create a new one
-	_, _ = fc.App.DeleteFileRecordByPath(oldRelativePath)
-
-	newRecord := models.FileRecord{
-		FileName:    finalName,
-		FilePath:    newRelativePath,
-		Directory:   req.NewParent,
-		Size:        fr.Size,
-		ContentType: fr.ContentType,
-		Uploader:    user.Username,
-	}
-
-	if err := fc.App.CreateFileRecord(newRecord); err != nil {
+	// Update the record in place so the file keeps its ID and version history
+	if err := fc.App.MoveFileRecord(fr.ID, finalName, req.NewParent, newRelativePath); err != nil {
 		// Rollback
-		_ = os.Rename(newFullPath, oldFullPath)
+		_ = fc.App.Storage.Move(r.Context(), newRelativePath, oldRelativePath)
 		models.RespondError(w, http.StatusInternalServerError, "Error saving new file record")
 		return
 	}
 
-	newID, _ := fc.App.GetFileIDByPath(newRelativePath)
-	fc.App.CreateFileVersion(newID, 1, newRelativePath)
-	fc.App.LogAudit(user.Username, newID, "MOVE", fmt.Sprintf("Moved file from '%s' to '%s'", oldRelativePath, newRelativePath))
+	fc.App.LogAudit(user.Username, fr.ID, "MOVE", fmt.Sprintf("Moved file from '%s' to '%s'", oldRelativePath, newRelativePath))
 	fc.App.LogActivity(fmt.Sprintf("User '%s' moved file from '%s' to '%s'", user.Username, oldRelativePath, newRelativePath))
 
 	models.RespondJSON(w, http.StatusOK, map[string]string{
@@ -791,8 +762,10 @@ func (fc *FileController) MoveFile(w http.ResponseWriter, r *http.Request) {
 }
 
 // Preview handles file preview requests by decrypting files and sending them with inline disposition.
+// Spreadsheets and Word documents are rendered natively as HTML, or as JSON
+// with format=json; format=pdf converts them to PDF like other documents.
 func (fc *FileController) Preview(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
 		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
 		return
 	}
@@ -840,26 +813,26 @@ func (fc *FileController) Preview(w http.ResponseWriter, r *http.Request) {
 		models.RespondError(w, http.StatusNotFound, "File not found")
 		return
 	}
-
-	encryptedFilePath := filepath.Join("Cdrrmo", fr.FilePath)
-	tempDecryptedPath := encryptedFilePath + ".dec"
-
-	key := []byte(os.Getenv("ENCRYPTION_KEY"))
-	if len(key) != 32 {
-		models.RespondError(w, http.StatusInternalServerError, "Invalid encryption key")
+	if !authorizeFile(fc.App, w, user, fr, models.PermRead) {
 		return
 	}
 
-	if err := encryption.DecryptFile(key, encryptedFilePath, tempDecryptedPath); err != nil {
-		os.Remove(tempDecryptedPath)
-		log.Printf("Decryption failed for %s: %v", relativePath, err)
-		models.RespondError(w, http.StatusInternalServerError, "Error decrypting file")
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "":
+		format = "html"
+	case "html", "json", "pdf":
+	default:
+		models.RespondError(w, http.StatusBadRequest, "Invalid format, expected html, json or pdf")
+		return
+	}
+	if format != "pdf" && services.CanPreviewNatively(fr.FileName) {
+		fc.nativePreview(w, r, user, fr, format)
 		return
 	}
-	defer os.Remove(tempDecryptedPath)
 
 	ext := strings.ToLower(filepath.Ext(fr.FileName))
-	supportedDirectly := []string{".pdf", ".jpg", ".jpeg", ".png", ".gif"}
+	supportedDirectly := []string{".pdf", ".jpg", ".jpeg", ".png", ".gif", ".webp", ".mp4"}
 	needsConversion := true
 	for _, s := range supportedDirectly {
 		if ext == s {
@@ -868,75 +841,158 @@ func (fc *FileController) Preview(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	finalPath := tempDecryptedPath
-	contentType := fr.ContentType
+	// Converted previews are a different representation of the same
+	// version, so they get their own entity tag.
+	etag, modified := fc.contentValidators(fr)
+	if needsConversion && etag != "" {
+		etag = strings.TrimSuffix(etag, `"`) + `-pdf"`
+	}
+	if notModified(r, etag) {
+		writeNotModified(w, etag)
+		return
+	}
 
+	var preview models.SeekableBlob
+	contentType := fr.ContentType
 	if needsConversion {
-		tempDir, err := ioutil.TempDir("", "libreoffice_convert")
-		if err != nil {
-			models.RespondError(w, http.StatusInternalServerError, "Error creating temp dir for conversion")
-			return
-		}
-		defer os.RemoveAll(tempDir)
-
-		cmd := exec.Command("/Applications/LibreOffice.app/Contents/MacOS/soffice",
-			"--headless", "--convert-to", "pdf",
-			"--outdir", tempDir,
-			tempDecryptedPath)
-
-		out, err := cmd.CombinedOutput()
-		log.Printf("LibreOffice conversion command output:\n%s", string(out))
+		preview, err = fc.convertedPreview(r.Context(), fr)
 		if err != nil {
-			log.Printf("LibreOffice conversion failed: %v", err)
-			models.RespondError(w, http.StatusInternalServerError, "Failed to convert file for preview")
+			log.Printf("Preview conversion of %s failed: %v", relativePath, err)
+			switch {
+			case errors.Is(err, context.DeadlineExceeded):
+				models.RespondError(w, http.StatusGatewayTimeout, "Converting the file for preview took too long")
+			case errors.Is(err, services.ErrContentChanged):
+				models.RespondError(w, http.StatusConflict, "The file changed while it was being converted, please try again")
+			default:
+				models.RespondError(w, http.StatusInternalServerError, "Failed to convert file for preview")
+			}
 			return
 		}
-
-		files, err := ioutil.ReadDir(tempDir)
+		contentType = "application/pdf"
+	} else {
+		preview, err = fc.App.OpenSeekable(r.Context(), fr.FilePath)
 		if err != nil {
-			models.RespondError(w, http.StatusInternalServerError, "Could not list converted files")
+			log.Printf("Decryption failed for %s: %v", relativePath, err)
+			models.RespondError(w, http.StatusInternalServerError, "Error decrypting file")
 			return
 		}
-
-		var convertedPDF string
-		for _, f := range files {
-			if !f.IsDir() && strings.HasSuffix(f.Name(), ".pdf") {
-				convertedPDF = filepath.Join(tempDir, f.Name())
-				break
-			}
-		}
-
-		if convertedPDF == "" {
-			log.Printf("No PDF found in conversion output: %v", files)
-			models.RespondError(w, http.StatusInternalServerError, "Converted PDF not found after conversion")
-			return
-		}
-
-		finalPath = convertedPDF
-		contentType = "application/pdf"
-	}
-
-	f, err := os.Open(finalPath)
-	if err != nil {
-		log.Printf("Failed to open preview file: %v", err)
-		models.RespondError(w, http.StatusInternalServerError, "Error opening file for preview")
-		return
 	}
-	defer f.Close()
+	defer preview.Close()
 
 	w.Header().Set("Content-Type", contentType)
 	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", fr.FileName))
 
-	if _, err := io.Copy(w, f); err != nil {
-		log.Printf("Preview streaming error: %v", err)
-		models.RespondError(w, http.StatusInternalServerError, "Error sending preview")
-		return
+	if status := serveBlob(w, r, preview, etag, modified); countsAsRead(r, status) {
+		fc.App.LogActivity(fmt.Sprintf("User '%s' previewed file '%s' (ID: %d)", user.Username, fr.FileName, fr.ID))
 	}
-
-	fc.App.LogActivity(fmt.Sprintf("User '%s' previewed file '%s' (ID: %d)", user.Username, fr.FileName, fr.ID))
 }
 
-// inside SendFileMessage, add filePath before building the notification
+// convertedPreview returns the current version of fr as a PDF.
+func (fc *FileController) convertedPreview(ctx context.Context, fr models.FileRecord) (models.SeekableBlob, error) {
+	if fc.Previews == nil {
+		return nil, errors.New("document preview is not configured")
+	}
+	version, err := fc.App.GetLatestVersionNumber(fr.ID)
+	if err != nil {
+		return nil, err
+	}
+	return fc.Previews.OpenPDF(ctx, fr, version)
+}
+
+// thumbnailCacheControl lets clients keep a thumbnail requested with the
+// version tag from a listing for good, since that URL changes with the
+// content.
+const thumbnailCacheControl = "private, max-age=31536000, immutable"
+
+// Thumbnail handles GET /thumbnail?file_id=&size=, which serves a PNG
+// thumbnail of an image or of the first page of a PDF. size is one of
+// models.ThumbnailSizes and defaults to models.DefaultThumbnailSize.
+// Listings link to thumbnails with a version tag, v, which makes the
+// response cacheable for good.
+func (fc *FileController) Thumbnail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, err := fc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	fileID, err := strconv.Atoi(r.URL.Query().Get("file_id"))
+	if err != nil || fileID <= 0 {
+		models.RespondError(w, http.StatusBadRequest, "A valid file_id is required")
+		return
+	}
+	size := models.DefaultThumbnailSize
+	if v := r.URL.Query().Get("size"); v != "" {
+		size, err = strconv.Atoi(v)
+		if err != nil || !models.IsThumbnailSize(size) {
+			models.RespondError(w, http.StatusBadRequest, fmt.Sprintf("Size must be one of %v", models.ThumbnailSizes))
+			return
+		}
+	}
+
+	fr, err := fc.App.GetFileRecordByID(fileID)
+	if err == sql.ErrNoRows {
+		models.RespondError(w, http.StatusNotFound, "File not found")
+		return
+	} else if err != nil {
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving file record")
+		return
+	}
+	if !authorizeFile(fc.App, w, user, fr, models.PermRead) {
+		return
+	}
+	thumbs, err := fc.App.FileThumbnails([]int{fileID})
+	if err != nil {
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving thumbnail")
+		return
+	}
+	checksum, ok := thumbs[fileID]
+	if !ok {
+		models.RespondError(w, http.StatusNotFound, "No thumbnail available for this file")
+		return
+	}
+
+	tag := thumbnailTag(checksum)
+	etag := fmt.Sprintf(`"%s-%d"`, tag, size)
+	cacheControl := contentCacheControl
+	if r.URL.Query().Get("v") == tag {
+		cacheControl = thumbnailCacheControl
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", cacheControl)
+	if notModified(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	thumb, err := fc.App.OpenSeekable(r.Context(), models.ThumbnailStorageKey(fileID, checksum, size))
+	if err != nil {
+		log.Printf("Opening thumbnail of file %d failed: %v", fileID, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving thumbnail")
+		return
+	}
+	defer thumb.Close()
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", time.Time{}, thumb)
+}
+
+// thumbnailTag identifies the content a thumbnail was rendered from in
+// URLs and entity tags.
+func thumbnailTag(checksum string) string {
+	if len(checksum) > 16 {
+		return checksum[:16]
+	}
+	return checksum
+}
+
+// inside SendFileMessage, add filePath before building the notification
 
 func (fc *FileController) SendFileMessage(w http.ResponseWriter, r *http.Request) {
 	user, err := fc.App.GetUserFromSession(r)
@@ -956,8 +1012,9 @@ func (fc *FileController) SendFileMessage(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	if msg.FileID == 0 || msg.Receiver == "" || msg.Message == "" {
-		models.RespondError(w, http.StatusBadRequest, "Missing file ID, receiver, or message content")
+	// An instruction goes to one receiver or to every member of a group.
+	if msg.FileID == 0 || (msg.Receiver == "") == (msg.Group == "") || msg.Message == "" {
+		models.RespondError(w, http.StatusBadRequest, "Missing file ID, receiver or group, or message content")
 		return
 	}
 
@@ -977,30 +1034,92 @@ func (fc *FileController) SendFileMessage(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	_, err = fc.App.DB.Exec(
-		`INSERT INTO file_messages (file_id, sender, receiver, message) VALUES ($1, $2, $3, $4)`,
-		msg.FileID, msg.Sender, msg.Receiver, msg.Message,
-	)
-	if err != nil {
+	receivers := []string{msg.Receiver}
+	var group models.Group
+	if msg.Group != "" {
+		group, err = fc.App.GetGroupByName(msg.Group)
+		if err != nil {
+			models.RespondError(w, http.StatusNotFound, "Group not found")
+			return
+		}
+		if receivers, err = fc.groupReceivers(group, msg.Sender); err != nil {
+			models.RespondError(w, http.StatusInternalServerError, "Failed to send message")
+			return
+		}
+		if len(receivers) == 0 {
+			models.RespondError(w, http.StatusBadRequest, fmt.Sprintf("Group '%s' has no other members", group.Name))
+			return
+		}
+	}
+
+	if err := fc.sendInstruction(msg.FileID, msg.Sender, receivers, group, msg.Message, filePath); err != nil {
+		log.Printf("Error sending instruction on file %d: %v", msg.FileID, err)
 		models.RespondError(w, http.StatusInternalServerError, "Failed to send message")
 		return
 	}
 
-	if fc.App.NotificationHub != nil {
-		notification := map[string]string{
-			"type":      "new_instruction",
-			"receiver":  msg.Receiver,
-			"message":   msg.Message,
-			"file_id":   fmt.Sprintf("%d", msg.FileID),
-			"sender":    msg.Sender,
-			"file_path": filePath,
+	models.RespondJSON(w, http.StatusOK, map[string]interface{}{
+		"message":   "Instruction sent",
+		"receivers": receivers,
+	})
+}
+
+// groupReceivers returns the members of group an instruction from sender
+// goes to, which leaves out the sender.
+func (fc *FileController) groupReceivers(group models.Group, sender string) ([]string, error) {
+	members, err := fc.App.GroupMemberNames(group.ID)
+	if err != nil {
+		return nil, err
+	}
+	receivers := []string{}
+	for _, m := range members {
+		if !strings.EqualFold(m, sender) {
+			receivers = append(receivers, m)
 		}
+	}
+	return receivers, nil
+}
 
-		notifBytes, _ := json.Marshal(notification)
-		fc.App.NotificationHub.SendToUser(msg.Receiver, notifBytes)
+// sendInstruction stores an instruction on a file for each receiver and
+// notifies them. group is the group the instruction was addressed to, if
+// any.
+func (fc *FileController) sendInstruction(fileID int, sender string, receivers []string, group models.Group, message, filePath string) error {
+	tx, err := fc.App.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, receiver := range receivers {
+		if _, err := tx.Exec(
+			`INSERT INTO file_messages (file_id, sender, receiver, message, group_id) VALUES ($1, $2, $3, $4, NULLIF($5, 0))`,
+			fileID, sender, receiver, message, group.ID,
+		); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
 	}
 
-	models.RespondJSON(w, http.StatusOK, map[string]string{"message": "Instruction sent"})
+	if fc.App.NotificationHub != nil {
+		for _, receiver := range receivers {
+			notification := map[string]string{
+				"type":      "new_instruction",
+				"receiver":  receiver,
+				"message":   message,
+				"file_id":   fmt.Sprintf("%d", fileID),
+				"sender":    sender,
+				"file_path": filePath,
+			}
+			if group.Name != "" {
+				notification["group"] = group.Name
+			}
+
+			notifBytes, _ := json.Marshal(notification)
+			fc.App.NotificationHub.SendToUser(receiver, notifBytes)
+		}
+	}
+	return nil
 }
 
 func (fc *FileController) GetFileMessages(w http.ResponseWriter, r *http.Request) {
@@ -1021,18 +1140,20 @@ func (fc *FileController) GetFileMessages(w http.ResponseWriter, r *http.Request
 	if user.Role == "admin" {
 		// Admin can see all messages for this file
 		rows, err = fc.App.DB.Query(`
-			SELECT id, file_id, sender, receiver, message, is_done, created_at
-			FROM file_messages
-			WHERE file_id = $1
-			ORDER BY created_at DESC
+			SELECT m.id, m.file_id, m.sender, m.receiver, COALESCE(g.name, ''), m.message, m.is_done, m.created_at
+			FROM file_messages m
+			LEFT JOIN user_groups g ON g.id = m.group_id
+			WHERE m.file_id = $1
+			ORDER BY m.created_at DESC
 		`, fileID)
 	} else {
 		// Regular users only see messages addressed to them
 		rows, err = fc.App.DB.Query(`
-			SELECT id, file_id, sender, receiver, message, is_done, created_at
-			FROM file_messages
-			WHERE file_id = $1 AND receiver = $2
-			ORDER BY created_at DESC
+			SELECT m.id, m.file_id, m.sender, m.receiver, COALESCE(g.name, ''), m.message, m.is_done, m.created_at
+			FROM file_messages m
+			LEFT JOIN user_groups g ON g.id = m.group_id
+			WHERE m.file_id = $1 AND m.receiver = $2
+			ORDER BY m.created_at DESC
 		`, fileID, user.Username)
 	}
 
@@ -1045,7 +1166,7 @@ func (fc *FileController) GetFileMessages(w http.ResponseWriter, r *http.Request
 	var messages []models.FileMessage
 	for rows.Next() {
 		var msg models.FileMessage
-		if err := rows.Scan(&msg.ID, &msg.FileID, &msg.Sender, &msg.Receiver, &msg.Message, &msg.IsDone, &msg.CreatedAt); err != nil {
+		if err := rows.Scan(&msg.ID, &msg.FileID, &msg.Sender, &msg.Receiver, &msg.Group, &msg.Message, &msg.IsDone, &msg.CreatedAt); err != nil {
 			continue
 		}
 		messages = append(messages, msg)
@@ -1054,6 +1175,7 @@ func (fc *FileController) GetFileMessages(w http.ResponseWriter, r *http.Request
 	models.RespondJSON(w, http.StatusOK, messages)
 }
 
+// GetFileVersions lists every version of a file with its size, uploader and checksum.
 func (fc *FileController) GetFileVersions(w http.ResponseWriter, r *http.Request) {
 	user, err := fc.App.GetUserFromSession(r)
 	if err != nil {
@@ -1067,39 +1189,303 @@ func (fc *FileController) GetFileVersions(w http.ResponseWriter, r *http.Request
 		models.RespondError(w, http.StatusBadRequest, "Invalid file ID")
 		return
 	}
+	fr, err := fc.App.GetFileRecordByID(fileID)
+	if err != nil {
+		models.RespondError(w, http.StatusNotFound, "File not found")
+		return
+	}
+	if !authorizeFile(fc.App, w, user, fr, models.PermRead) {
+		return
+	}
 
-	rows, err := fc.App.DB.Query(`
-		SELECT version_number, file_path, created_at
-		FROM file_versions
-		WHERE file_id = $1
-		ORDER BY version_number ASC
-	`, fileID)
+	versions, err := fc.App.ListFileVersions(fileID)
 	if err != nil {
 		models.RespondError(w, http.StatusInternalServerError, "Error retrieving file versions")
 		return
 	}
-	defer rows.Close()
 
-	type VersionInfo struct {
-		Version   int       `json:"version"`
-		Path      string    `json:"file_path"`
-		Timestamp time.Time `json:"timestamp"`
+	// Optional: log the access
+	fc.App.LogActivity(fmt.Sprintf("User '%s' viewed version history for file ID %d.", user.Username, fileID))
+
+	models.RespondJSON(w, http.StatusOK, versions)
+}
+
+// DownloadVersion streams the decrypted content of one version of a file.
+func (fc *FileController) DownloadVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
 	}
 
-	var versions []VersionInfo
-	for rows.Next() {
-		var v VersionInfo
-		if err := rows.Scan(&v.Version, &v.Path, &v.Timestamp); err != nil {
-			continue
+	user, err := fc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	fileID, err := strconv.Atoi(r.URL.Query().Get("file_id"))
+	if err != nil || fileID <= 0 {
+		models.RespondError(w, http.StatusBadRequest, "Invalid file ID")
+		return
+	}
+	version, err := strconv.Atoi(r.URL.Query().Get("version"))
+	if err != nil || version <= 0 {
+		models.RespondError(w, http.StatusBadRequest, "Invalid version number")
+		return
+	}
+
+	fr, err := fc.App.GetFileRecordByID(fileID)
+	if err != nil {
+		models.RespondError(w, http.StatusNotFound, "File not found")
+		return
+	}
+	if !authorizeFile(fc.App, w, user, fr, models.PermRead) {
+		return
+	}
+	v, err := fc.App.GetFileVersion(fileID, version)
+	if err != nil {
+		models.RespondError(w, http.StatusNotFound, "Version not found")
+		return
+	}
+
+	blob, err := fc.App.OpenFileVersion(r.Context(), fr, v)
+	if errors.Is(err, models.ErrVersionNotRetained) {
+		models.RespondError(w, http.StatusGone, "The content of this version was not retained")
+		return
+	}
+	if err != nil {
+		log.Printf("Decryption failed for %s version %d: %v", fr.FilePath, version, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error decrypting file")
+		return
+	}
+	defer blob.Close()
+
+	contentType := v.ContentType
+	if contentType == "" {
+		contentType = fr.ContentType
+	}
+	ext := filepath.Ext(fr.FileName)
+	downloadName := fmt.Sprintf("%s (v%d)%s", strings.TrimSuffix(fr.FileName, ext), version, ext)
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", downloadName))
+
+	// Headers are already sent at this point, so a failure can only be logged.
+	if _, err := io.Copy(w, blob); err != nil {
+		log.Printf("Streaming error for %s version %d: %v", fr.FilePath, version, err)
+		return
+	}
+
+	fc.App.LogAudit(user.Username, fr.ID, "DOWNLOAD_VERSION", fmt.Sprintf("Downloaded version %d of '%s'", version, fr.FileName))
+	fc.App.LogActivity(fmt.Sprintf("User '%s' downloaded version %d of file '%s' (ID: %d)", user.Username, version, fr.FileName, fr.ID))
+}
+
+// RestoreVersion makes an older version current again by storing its content
+// as a new version; the history itself is never rewritten.
+func (fc *FileController) RestoreVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, err := fc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	var req struct {
+		FileID  int `json:"file_id"`
+		Version int `json:"version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		models.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.FileID <= 0 || req.Version <= 0 {
+		models.RespondError(w, http.StatusBadRequest, "File ID and version are required")
+		return
+	}
+
+	fr, err := fc.App.GetFileRecordByID(req.FileID)
+	if err != nil {
+		models.RespondError(w, http.StatusNotFound, "File not found")
+		return
+	}
+	if !authorizeFile(fc.App, w, user, fr, models.PermWrite) {
+		return
+	}
+	v, err := fc.App.GetFileVersion(req.FileID, req.Version)
+	if err != nil {
+		models.RespondError(w, http.StatusNotFound, "Version not found")
+		return
+	}
+	if v.IsCurrent {
+		models.RespondError(w, http.StatusBadRequest, "This version is already the current version")
+		return
+	}
+
+	blob, err := fc.App.OpenFileVersion(r.Context(), fr, v)
+	if errors.Is(err, models.ErrVersionNotRetained) {
+		models.RespondError(w, http.StatusGone, "The content of this version was not retained")
+		return
+	}
+	if err != nil {
+		log.Printf("Decryption failed for %s version %d: %v", fr.FilePath, req.Version, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error decrypting file")
+		return
+	}
+	defer blob.Close()
+
+	contentType := v.ContentType
+	if contentType == "" {
+		contentType = fr.ContentType
+	}
+	newVer, err := fc.App.StoreNewVersion(r.Context(), fr, blob, contentType, user.Username)
+	if err != nil {
+		log.Printf("Restoring version %d of %s failed: %v", req.Version, fr.FilePath, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error restoring file version")
+		return
+	}
+
+	if current, err := fc.App.GetFileRecordByID(fr.ID); err == nil {
+		if fc.Indexer != nil {
+			fc.Indexer.Enqueue(current)
 		}
-		versions = append(versions, v)
+		if fc.Thumbnails != nil {
+			fc.Thumbnails.Enqueue(current)
+		}
+	}
+	if fc.Previews != nil {
+		fc.Previews.Invalidate(fr.ID)
 	}
 
-	// Optional: log the access
-	fc.App.LogActivity(fmt.Sprintf("User '%s' viewed version history for file ID %d.", user.Username, fileID))
+	fc.App.LogActivity(fmt.Sprintf("User '%s' restored file '%s' to version %d (now version %d).", user.Username, fr.FileName, req.Version, newVer))
+	fc.App.LogAudit(user.Username, fr.ID, "RESTORE", fmt.Sprintf("File '%s' restored from version %d as version %d", fr.FileName, req.Version, newVer))
 
-	models.RespondJSON(w, http.StatusOK, versions)
+	if fc.App.NotificationHub != nil {
+		notification, _ := json.Marshal(map[string]interface{}{
+			"event":         "file_restored",
+			"file_id":       fr.ID,
+			"file_name":     fr.FileName,
+			"file_path":     fr.FilePath,
+			"version":       newVer,
+			"restored_from": req.Version,
+			"restored_by":   user.Username,
+		})
+		fc.App.NotificationHub.Broadcast(notification)
+	}
+
+	models.RespondJSON(w, http.StatusOK, map[string]interface{}{
+		"message": fmt.Sprintf("File '%s' restored from version %d as version %d", fr.FileName, req.Version, newVer),
+		"version": newVer,
+	})
 }
+
+// DiffVersions extracts the text of two versions of a DOCX, XLSX or PDF file
+// and returns a line-level diff between them.
+func (fc *FileController) DiffVersions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, err := fc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	query := r.URL.Query()
+	fileID, err := strconv.Atoi(query.Get("file_id"))
+	if err != nil || fileID <= 0 {
+		models.RespondError(w, http.StatusBadRequest, "Invalid file ID")
+		return
+	}
+	from, errFrom := strconv.Atoi(query.Get("from"))
+	to, errTo := strconv.Atoi(query.Get("to"))
+	if errFrom != nil || errTo != nil || from <= 0 || to <= 0 {
+		models.RespondError(w, http.StatusBadRequest, "Both 'from' and 'to' version numbers are required")
+		return
+	}
+
+	fr, err := fc.App.GetFileRecordByID(fileID)
+	if err != nil {
+		models.RespondError(w, http.StatusNotFound, "File not found")
+		return
+	}
+	if !authorizeFile(fc.App, w, user, fr, models.PermRead) {
+		return
+	}
+	if !services.CanExtractText(fr.FileName) {
+		models.RespondError(w, http.StatusUnsupportedMediaType, "Only DOCX, XLSX and PDF files can be compared")
+		return
+	}
+
+	oldLines, status, err := fc.versionText(r.Context(), fr, from)
+	if err != nil {
+		models.RespondError(w, status, err.Error())
+		return
+	}
+	newLines, status, err := fc.versionText(r.Context(), fr, to)
+	if err != nil {
+		models.RespondError(w, status, err.Error())
+		return
+	}
+
+	lines, err := services.DiffLines(oldLines, newLines)
+	if err != nil {
+		models.RespondError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	added, removed := 0, 0
+	for _, l := range lines {
+		switch l.Op {
+		case services.DiffInsert:
+			added++
+		case services.DiffDelete:
+			removed++
+		}
+	}
+
+	fc.App.LogActivity(fmt.Sprintf("User '%s' compared versions %d and %d of file '%s' (ID: %d).", user.Username, from, to, fr.FileName, fr.ID))
+
+	models.RespondJSON(w, http.StatusOK, map[string]interface{}{
+		"file_id":   fr.ID,
+		"file_name": fr.FileName,
+		"from":      from,
+		"to":        to,
+		"added":     added,
+		"removed":   removed,
+		"hunks":     services.GroupHunks(lines, 3),
+	})
+}
+
+// versionText extracts the text lines of one version of fr. On failure it
+// also returns the HTTP status to respond with.
+func (fc *FileController) versionText(ctx context.Context, fr models.FileRecord, version int) ([]string, int, error) {
+	v, err := fc.App.GetFileVersion(fr.ID, version)
+	if err != nil {
+		return nil, http.StatusNotFound, fmt.Errorf("Version %d not found", version)
+	}
+	blob, err := fc.App.OpenFileVersion(ctx, fr, v)
+	if errors.Is(err, models.ErrVersionNotRetained) {
+		return nil, http.StatusGone, fmt.Errorf("The content of version %d was not retained", version)
+	}
+	if err != nil {
+		log.Printf("Decryption failed for %s version %d: %v", fr.FilePath, version, err)
+		return nil, http.StatusInternalServerError, errors.New("Error decrypting file")
+	}
+	defer blob.Close()
+
+	lines, err := services.ExtractText(blob, fr.FileName)
+	if err != nil {
+		log.Printf("Text extraction failed for %s version %d: %v", fr.FilePath, version, err)
+		return nil, http.StatusUnprocessableEntity, fmt.Errorf("Could not extract text from version %d", version)
+	}
+	return lines, http.StatusOK, nil
+}
+
 func (fc *FileController) MarkFileMessageAsDone(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPatch {
 		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
@@ -1166,6 +1552,7 @@ func (fc *FileController) BulkUpload(w http.ResponseWriter, r *http.Request) {
 	skip := r.FormValue("skip") == "true"
 	instruction := r.FormValue("message")
 	receiver := r.FormValue("receiver")
+	groupName := r.FormValue("group")
 	metaJSON := r.FormValue("metadata")
 
 	var metaMap map[string]interface{}
@@ -1180,48 +1567,51 @@ func (fc *FileController) BulkUpload(w http.ResponseWriter, r *http.Request) {
 		models.RespondError(w, http.StatusBadRequest, "Directory and container are required")
 		return
 	}
-
-	files := r.MultipartForm.File["files"]
-	if len(files) == 0 {
-		models.RespondError(w, http.StatusBadRequest, "No files provided")
+	if targetDir, err = cleanUploadDirectory(targetDir); err != nil {
+		models.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := fc.checkUploadAccess(user, targetDir); err != nil {
+		models.RespondError(w, uploadErrorCode(err), uploadErrorMessage(err))
+		return
+	}
+	if !fc.checkMetadata(w, targetDir, metaMap) {
 		return
 	}
 
-	allowedExtensions := map[string]bool{
-		".doc":  true,
-		".docx": true,
-		".xls":  true,
-		".xlsx": true,
-		".pdf":  true,
+	// The instruction, if any, goes to the receiver or to every member of
+	// the group.
+	var receivers []string
+	var group models.Group
+	switch {
+	case receiver != "" && groupName != "":
+		models.RespondError(w, http.StatusBadRequest, "Send the instruction to a receiver or a group, not both")
+		return
+	case groupName != "":
+		if group, err = fc.App.GetGroupByName(groupName); err != nil {
+			models.RespondError(w, http.StatusNotFound, "Group not found")
+			return
+		}
+		if receivers, err = fc.groupReceivers(group, user.Username); err != nil {
+			models.RespondError(w, http.StatusInternalServerError, "Error retrieving group members")
+			return
+		}
+	case receiver != "":
+		receivers = []string{receiver}
 	}
 
-	allowedMIMETypes := map[string]bool{
-		"application/msword": true,
-		"application/vnd.openxmlformats-officedocument.wordprocessingml.document": true,
-		"application/vnd.ms-excel": true,
-		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet": true,
-		"application/pdf": true,
+	files := r.MultipartForm.File["files"]
+	if len(files) == 0 {
+		models.RespondError(w, http.StatusBadRequest, "No files provided")
+		return
 	}
 
 	results := []map[string]string{}
 
 	for _, fileHeader := range files {
 		rawFileName := fileHeader.Filename
-		ext := strings.ToLower(filepath.Ext(rawFileName))
-		mime := fileHeader.Header.Get("Content-Type")
-
-		if !allowedExtensions[ext] || !allowedMIMETypes[mime] {
-			results = append(results, map[string]string{
-				"file":   rawFileName,
-				"status": "rejected: invalid file type or MIME",
-			})
-			continue
-		}
-
 		status := "unknown"
 		var fileID int
-		var finalDiskPath string
-		var relativePath string
 
 		func() {
 			file, err := fileHeader.Open()
@@ -1231,108 +1621,30 @@ func (fc *FileController) BulkUpload(w http.ResponseWriter, r *http.Request) {
 			}
 			defer file.Close()
 
-			relativePath = filepath.Join(targetDir, rawFileName)
-			finalDiskPath = filepath.Join("Cdrrmo", relativePath)
-
-			existingFR, getErr := fc.App.GetFileRecordByPath(relativePath)
-
-			if getErr == nil {
-				if skip {
-					status = "skipped"
-					return
-				}
-				if !overwrite {
-					base := strings.TrimSuffix(rawFileName, filepath.Ext(rawFileName))
-					ext := filepath.Ext(rawFileName)
-					counter := 1
-					for {
-						altName := fmt.Sprintf("%s_%d%s", base, counter, ext)
-						altPath := filepath.Join(targetDir, altName)
-						if _, err := fc.App.GetFileRecordByPath(altPath); err != nil {
-							relativePath = altPath
-							finalDiskPath = filepath.Join("Cdrrmo", altPath)
-							break
-						}
-						counter++
-					}
-					status = "renamed"
-				} else {
-					fileID = existingFR.ID
-					updateErr := fc.App.UpdateFileMetadata(fileID, fileHeader.Size, mime)
-					if updateErr == nil {
-						latestVer, _ := fc.App.GetLatestVersionNumber(fileID)
-						_ = fc.App.CreateFileVersion(fileID, latestVer+1, relativePath)
-						status = "overwritten"
-					}
-				}
-			}
-
-			if err := os.MkdirAll(filepath.Dir(finalDiskPath), 0755); err != nil {
-				status = "error: mkdir failed"
-				return
-			}
-
-			tempFilePath := finalDiskPath + ".tmp"
-			tempFile, err := os.Create(tempFilePath)
+			res, err := fc.storeUpload(r.Context(), user, uploadRequest{
+				FileName:  rawFileName,
+				Directory: targetDir,
+				Overwrite: overwrite,
+				Skip:      skip,
+				Metadata:  metaMap,
+			}, file)
 			if err != nil {
-				status = "error: cannot create temp file"
-				return
-			}
-
-			if _, err := io.Copy(tempFile, file); err != nil {
-				tempFile.Close()
-				os.Remove(tempFilePath)
-				status = "error: write failed"
-				return
-			}
-			tempFile.Close()
-
-			key := []byte(os.Getenv("ENCRYPTION_KEY"))
-			if err := encryption.EncryptFile(key, tempFilePath, finalDiskPath); err != nil {
-				os.Remove(tempFilePath)
-				status = "error: encryption failed"
-				return
-			}
-			os.Remove(tempFilePath)
-
-			if getErr != nil || !overwrite {
-				fr := models.FileRecord{
-					FileName:    filepath.Base(relativePath),
-					FilePath:    relativePath,
-					Directory:   targetDir,
-					Size:        fileHeader.Size,
-					ContentType: mime,
-					Uploader:    user.Username,
-					Metadata:    metaMap,
-				}
-				if err := fc.App.CreateFileRecord(fr); err != nil {
-					status = "error: DB insert failed"
-					return
+				status = uploadErrorStatus(err)
+				if uploadErrorCode(err) == http.StatusInternalServerError {
+					status = "error: " + status
 				}
-				fileID, _ = fc.App.GetFileIDByPath(fr.FilePath)
-				_ = fc.App.CreateFileVersion(fileID, 1, fr.FilePath)
-				status = "uploaded"
+				return
 			}
+			fileID = res.FileID
+			status = res.Outcome
 		}()
 
 		if status == "uploaded" || status == "overwritten" {
-			if instruction != "" && receiver != "" && fileID > 0 {
-				_, _ = fc.App.DB.Exec(`INSERT INTO file_messages (file_id, sender, receiver, message) VALUES ($1, $2, $3, $4)`,
-					fileID, user.Username, receiver, instruction)
-
-				if fc.App.NotificationHub != nil {
-					var filePath string
-					_ = fc.App.DB.QueryRow(`SELECT file_path FROM files WHERE id = $1`, fileID).Scan(&filePath)
-					notification := map[string]string{
-						"type":      "new_instruction",
-						"receiver":  receiver,
-						"message":   instruction,
-						"file_id":   fmt.Sprintf("%d", fileID),
-						"sender":    user.Username,
-						"file_path": filePath,
-					}
-					notifBytes, _ := json.Marshal(notification)
-					fc.App.NotificationHub.SendToUser(receiver, notifBytes)
+			if instruction != "" && len(receivers) > 0 && fileID > 0 {
+				var filePath string
+				_ = fc.App.DB.QueryRow(`SELECT file_path FROM files WHERE id = $1`, fileID).Scan(&filePath)
+				if err := fc.sendInstruction(fileID, user.Username, receivers, group, instruction, filePath); err != nil {
+					log.Printf("Error sending instruction on file %d: %v", fileID, err)
 				}
 			}
 		}
@@ -1345,3 +1657,499 @@ func (fc *FileController) BulkUpload(w http.ResponseWriter, r *http.Request) {
 
 	models.RespondJSON(w, http.StatusOK, results)
 }
+
+// uploadTopFolders are the department folders uploads may target besides
+// the root. File type rules can be set for each of them.
+var uploadTopFolders = map[string]bool{
+	"operation": true,
+	"research":  true,
+	"training":  true,
+}
+
+// uploadPolicyFolder returns the top-level folder whose file type rules apply
+// to uploads into dir, or "" when only the defaults apply.
+func uploadPolicyFolder(dir string) string {
+	top := strings.ToLower(strings.Split(filepath.ToSlash(filepath.Clean(dir)), "/")[0])
+	if uploadTopFolders[top] {
+		return top
+	}
+	return ""
+}
+
+// validEntryName reports whether name can name a file or folder: a single
+// path element that does not start with '.', since dot-prefixed names are
+// reserved for internal storage areas such as archived versions and the
+// recycle bin.
+func validEntryName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, `/\`) && name == path.Base(name) &&
+		!strings.HasPrefix(name, ".")
+}
+
+// cleanUploadDirectory validates the folder an upload targets. Uploads go to
+// the root or below one of the fixed top-level folders.
+func cleanUploadDirectory(dir string) (string, error) {
+	if dir == "" {
+		return "", nil
+	}
+	dir = filepath.Clean(dir)
+	if strings.HasPrefix(dir, "..") {
+		return "", errors.New("Invalid directory path")
+	}
+	topFolder := strings.ToLower(strings.Split(dir, "/")[0])
+	if !uploadTopFolders[topFolder] {
+		return "", errors.New("Invalid top-level folder")
+	}
+	return dir, nil
+}
+
+// Outcomes of storeUpload.
+const (
+	uploadCreated     = "uploaded"
+	uploadOverwritten = "overwritten"
+	uploadSkipped     = "skipped"
+)
+
+// uploadRequest describes a file to be stored by storeUpload.
+type uploadRequest struct {
+	FileName  string
+	Directory string
+	Overwrite bool // store as a new version when the name is taken
+	Skip      bool // leave an existing file alone instead
+	Metadata  map[string]interface{}
+}
+
+// uploadSource is the content of an upload. Multipart files and staged
+// resumable uploads both allow random access, which content detection needs.
+type uploadSource interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+}
+
+// uploadResult reports what storeUpload did.
+type uploadResult struct {
+	Outcome  string
+	FileID   int
+	FileName string // final name, which differs from the request when renamed
+	FilePath string
+	Version  int
+}
+
+// uploadError is a failed storeUpload step. Message is the response text for
+// single uploads and Status the short form used in bulk upload results. Code
+// is the HTTP status for single uploads; zero means an internal error.
+type uploadError struct {
+	Message string
+	Status  string
+	Err     error
+	Code    int
+}
+
+func (e *uploadError) Error() string { return e.Message + ": " + e.Err.Error() }
+func (e *uploadError) Unwrap() error { return e.Err }
+
+func uploadErrorMessage(err error) string {
+	var ue *uploadError
+	if errors.As(err, &ue) {
+		return ue.Message
+	}
+	return "Error uploading file"
+}
+
+func uploadErrorStatus(err error) string {
+	var ue *uploadError
+	if errors.As(err, &ue) {
+		return ue.Status
+	}
+	return "upload failed"
+}
+
+func uploadErrorCode(err error) int {
+	var ue *uploadError
+	if errors.As(err, &ue) && ue.Code != 0 {
+		return ue.Code
+	}
+	return http.StatusInternalServerError
+}
+
+// checkUploadPolicy applies the file type rules for the target directory to
+// a file of the given name and size.
+func (fc *FileController) checkUploadPolicy(directory, fileName string, size int64) error {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	rule, err := fc.App.EffectiveFileTypeRule(uploadPolicyFolder(directory), ext)
+	if err == sql.ErrNoRows || (err == nil && !rule.Allowed) {
+		return &uploadError{fmt.Sprintf("Files of type '%s' are not allowed in this folder", ext), "rejected: file type not allowed",
+			fmt.Errorf("no rule allows %q", ext), http.StatusUnsupportedMediaType}
+	}
+	if err != nil {
+		return &uploadError{"Error checking file type policy", "policy lookup failed", err, 0}
+	}
+	if rule.MaxSize > 0 && size > rule.MaxSize {
+		return &uploadError{fmt.Sprintf("File exceeds the %s limit for '%s' files", formatSize(rule.MaxSize), ext), "rejected: file too large",
+			fmt.Errorf("%d bytes over limit %d", size, rule.MaxSize), http.StatusRequestEntityTooLarge}
+	}
+	return nil
+}
+
+// checkUploadAccess checks that user may write to the folder an upload
+// targets.
+func (fc *FileController) checkUploadAccess(user models.User, directory string) error {
+	access, err := fc.App.UserAccess(user)
+	if err != nil {
+		return &uploadError{"Error checking permissions", "permission check failed", err, 0}
+	}
+	if !access.Can(directory, models.PermWrite) {
+		return &uploadError{"You do not have write access to this folder", "rejected: no write access",
+			fmt.Errorf("%s may not write to %q", user.Username, directory), http.StatusForbidden}
+	}
+	return nil
+}
+
+// checkMetadata validates the metadata of files stored in directory against
+// the folder's schema. It writes the error response itself, listing each
+// offending field.
+func (fc *FileController) checkMetadata(w http.ResponseWriter, directory string, metadata map[string]interface{}) bool {
+	err := fc.App.ValidateFileMetadata(directory, metadata)
+	var verr *models.MetadataValidationError
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &verr):
+		models.RespondJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"error":            fmt.Sprintf("Metadata does not match the schema for '%s'", verr.Directory),
+			"schema_directory": verr.Directory,
+			"fields":           verr.Fields,
+		})
+	default:
+		log.Printf("Error validating metadata for %s: %v", directory, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error validating metadata")
+	}
+	return false
+}
+
+// identifyUpload checks src against the upload policy and detects its type
+// from the content. It returns the MIME type to store and leaves src
+// positioned at the start.
+func (fc *FileController) identifyUpload(req uploadRequest, src uploadSource) (string, error) {
+	size, err := src.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = src.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return "", &uploadError{"Error reading uploaded file", "failed to read", err, 0}
+	}
+	if err := fc.checkUploadPolicy(req.Directory, req.FileName, size); err != nil {
+		return "", err
+	}
+
+	contentType, err := filetype.Identify(req.FileName, src, size)
+	switch {
+	case errors.Is(err, filetype.ErrMismatch), errors.Is(err, filetype.ErrUnknownExtension):
+		return "", &uploadError{"File content does not match its extension", "rejected: content does not match extension",
+			err, http.StatusUnsupportedMediaType}
+	case err != nil:
+		return "", &uploadError{"Error reading uploaded file", "failed to read", err, 0}
+	}
+	return contentType, nil
+}
+
+// scanUpload runs src through the virus scanner and leaves it positioned at
+// the start. Infected content is moved to the quarantine area and the admins
+// are alerted. When the scanner is unavailable the upload is refused, unless
+// the scanner is configured to fail open. It reports whether src was found
+// clean, as opposed to let through unscanned.
+func (fc *FileController) scanUpload(ctx context.Context, user models.User, req uploadRequest, src uploadSource) (bool, error) {
+	if fc.Scanner == nil {
+		return false, nil
+	}
+	relativePath := filepath.Join(req.Directory, req.FileName)
+
+	result, scanErr := fc.Scanner.Scan(src)
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return false, &uploadError{"Error reading uploaded file", "failed to read", err, 0}
+	}
+	if scanErr != nil {
+		if !fc.Scanner.FailOpen {
+			return false, &uploadError{"Virus scanning is unavailable, please try again later", "rejected: virus scanner unavailable",
+				scanErr, http.StatusServiceUnavailable}
+		}
+		log.Printf("Warning: storing %s without a virus scan: %v", relativePath, scanErr)
+		fc.App.LogAudit(user.Username, 0, "SCAN_SKIPPED", fmt.Sprintf("'%s' stored without a virus scan: %v", relativePath, scanErr))
+		return false, nil
+	}
+	if result.Clean {
+		return true, nil
+	}
+
+	q, err := fc.App.QuarantineUpload(ctx, models.QuarantinedFile{
+		FileName:  req.FileName,
+		Directory: req.Directory,
+		Signature: result.Description,
+		Uploader:  user.Username,
+	}, src)
+	if err != nil {
+		log.Printf("Error quarantining infected upload %s: %v", relativePath, err)
+	}
+
+	fc.App.LogAudit(user.Username, 0, "QUARANTINE", fmt.Sprintf("Upload '%s' quarantined: %s", relativePath, result.Description))
+	fc.App.LogActivity(fmt.Sprintf("Upload '%s' by user '%s' was quarantined (%s).", relativePath, user.Username, result.Description))
+	if msg, err := json.Marshal(map[string]interface{}{
+		"event":         "malware_detected",
+		"source":        models.QuarantineUploadSource,
+		"file_name":     req.FileName,
+		"directory":     req.Directory,
+		"uploader":      user.Username,
+		"signature":     result.Description,
+		"quarantine_id": q.ID,
+		"message":       fmt.Sprintf("Malware (%s) found in '%s' uploaded by %s", result.Description, relativePath, user.Username),
+	}); err == nil {
+		fc.App.NotifyAdmins(msg)
+	}
+
+	return false, &uploadError{fmt.Sprintf("File rejected: malware detected (%s)", result.Description), "rejected: malware detected",
+		fmt.Errorf("infected: %s", result.Description), http.StatusUnprocessableEntity}
+}
+
+// processUpload follows up on content stored for fr. The clean verdict of
+// the upload scan is recorded, so the rescanner leaves the file alone until
+// its next pass, while an upload let through unscanned is queued for a scan.
+// Text extraction for search and thumbnail rendering are queued as well.
+func (fc *FileController) processUpload(fr models.FileRecord, scanned bool) {
+	if scanned {
+		scan := models.FileScan{FileID: fr.ID, Status: models.ScanClean, Checksum: fr.Checksum}
+		if err := fc.App.RecordFileScan(scan); err != nil {
+			log.Printf("Warning: failed to record scan of file %d: %v", fr.ID, err)
+		}
+	} else if fc.Scanner != nil && fc.Jobs != nil {
+		if _, err := fc.Jobs.Enqueue(services.JobScanFile, services.ScanFilePayload{FileID: fr.ID}); err != nil {
+			log.Printf("Warning: failed to queue a scan of file %d: %v", fr.ID, err)
+		}
+	}
+	if fc.Indexer != nil {
+		fc.Indexer.Enqueue(fr)
+	}
+	if fc.Thumbnails != nil {
+		fc.Thumbnails.Enqueue(fr)
+	}
+	if fc.Previews != nil {
+		fc.Previews.Invalidate(fr.ID)
+	}
+}
+
+// formatSize renders a byte count for messages, e.g. "25 MB".
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<30 && n%(1<<30) == 0:
+		return fmt.Sprintf("%d GB", n>>30)
+	case n >= 1<<20:
+		return fmt.Sprintf("%d MB", n>>20)
+	case n >= 1<<10:
+		return fmt.Sprintf("%d KB", n>>10)
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
+
+// storeUpload encrypts src into storage and records it. When the name is
+// already taken the file is skipped, stored as a new version or stored
+// under the next free "name_N.ext", depending on the request.
+func (fc *FileController) storeUpload(ctx context.Context, user models.User, req uploadRequest, src uploadSource) (uploadResult, error) {
+	relativePath := filepath.Join(req.Directory, req.FileName)
+	res := uploadResult{FileName: req.FileName, FilePath: relativePath}
+
+	if err := fc.checkUploadAccess(user, req.Directory); err != nil {
+		return res, err
+	}
+	contentType, err := fc.identifyUpload(req, src)
+	if err != nil {
+		return res, err
+	}
+	scanned, err := fc.scanUpload(ctx, user, req, src)
+	if err != nil {
+		return res, err
+	}
+
+	existingFR, getErr := fc.App.GetFileRecordByPath(relativePath)
+	if getErr == nil {
+		switch {
+		case req.Skip:
+			res.Outcome = uploadSkipped
+			res.FileID = existingFR.ID
+			return res, nil
+		case req.Overwrite:
+			// The previous content is archived so every version stays downloadable.
+			newVer, err := fc.App.StoreNewVersion(ctx, existingFR, src, contentType, user.Username)
+			if err != nil {
+				return res, &uploadError{"Error storing new file version", "storing new version failed", err, 0}
+			}
+			res.Outcome = uploadOverwritten
+			res.FileID = existingFR.ID
+			res.Version = newVer
+			if current, err := fc.App.GetFileRecordByID(existingFR.ID); err == nil {
+				fc.processUpload(current, scanned)
+			}
+			return res, nil
+		}
+
+		baseName := strings.TrimSuffix(req.FileName, filepath.Ext(req.FileName))
+		ext := filepath.Ext(req.FileName)
+		for counter := 1; ; counter++ {
+			rel := filepath.Join(req.Directory, fmt.Sprintf("%s_%d%s", baseName, counter, ext))
+			if _, err := fc.App.GetFileRecordByPath(rel); err != nil {
+				relativePath = rel
+				break
+			}
+		}
+	}
+
+	blob, err := fc.App.PutEncrypted(ctx, relativePath, src)
+	if err != nil {
+		return res, &uploadError{"Error encrypting file", "encryption failed", err, 0}
+	}
+
+	fr := models.FileRecord{
+		FileName:           filepath.Base(relativePath),
+		Directory:          req.Directory,
+		FilePath:           relativePath,
+		Size:               blob.Size,
+		ContentType:        contentType,
+		Uploader:           user.Username,
+		Metadata:           req.Metadata,
+		Checksum:           blob.SHA256,
+		CiphertextChecksum: blob.CiphertextSHA256,
+	}
+	if err := fc.App.CreateFileRecord(fr); err != nil {
+		fc.App.Storage.Delete(ctx, relativePath)
+		return res, &uploadError{"Error saving file record", "DB insert failed", err, 0}
+	}
+
+	res.Outcome = uploadCreated
+	res.FileName = fr.FileName
+	res.FilePath = fr.FilePath
+	res.Version = 1
+	res.FileID, _ = fc.App.GetFileIDByPath(fr.FilePath)
+	if res.FileID > 0 {
+		if verr := fc.App.RecordFileVersion(firstVersion(res.FileID, fr, blob)); verr != nil {
+			log.Println("Warning: failed to create version record:", verr)
+		}
+		fr.ID = res.FileID
+		fc.processUpload(fr, scanned)
+	}
+	return res, nil
+}
+
+// announceUpload writes the audit and activity entries for a stored upload
+// and tells connected clients about it.
+func (fc *FileController) announceUpload(user models.User, res uploadResult) {
+	var version int
+	switch res.Outcome {
+	case uploadOverwritten:
+		version = res.Version
+		fc.App.LogActivity(fmt.Sprintf("User '%s' re-uploaded file '%s' (version %d).", user.Username, res.FileName, version))
+		fc.App.LogAudit(user.Username, res.FileID, "REUPLOAD", fmt.Sprintf("File '%s' re-uploaded as version %d", res.FileName, version))
+	case uploadCreated:
+		version = 1
+		fc.App.LogAudit(user.Username, res.FileID, "UPLOAD", fmt.Sprintf("File '%s' uploaded (version 1)", res.FileName))
+		fc.App.LogActivity(fmt.Sprintf("User '%s' uploaded new file '%s' (version 1).", user.Username, res.FileName))
+	default:
+		return
+	}
+
+	if fc.App.NotificationHub != nil {
+		notification, _ := json.Marshal(map[string]interface{}{
+			"event":     "file_uploaded",
+			"file_name": res.FileName,
+			"version":   version,
+		})
+		fc.App.NotificationHub.Broadcast(notification)
+	}
+}
+
+// firstVersion describes the version row written for a newly uploaded file.
+func firstVersion(fileID int, fr models.FileRecord, blob models.BlobInfo) models.FileVersion {
+	return models.FileVersion{
+		FileID:             fileID,
+		Version:            1,
+		FilePath:           fr.FilePath,
+		Size:               blob.Size,
+		ContentType:        fr.ContentType,
+		Uploader:           fr.Uploader,
+		Checksum:           blob.SHA256,
+		CiphertextChecksum: blob.CiphertextSHA256,
+	}
+}
+
+// contentCacheControl lets clients keep file content but makes them
+// revalidate it, since a file can change under the same URL.
+const contentCacheControl = "private, no-cache"
+
+// contentValidators returns the entity tag and modification time of the
+// current content of fr. Both follow the file version, so they change on
+// every upload or restore. Files without versions fall back to the checksum.
+func (fc *FileController) contentValidators(fr models.FileRecord) (string, time.Time) {
+	v, err := fc.App.CurrentFileVersion(fr.ID)
+	if err != nil {
+		if fr.Checksum != "" {
+			return `"` + fr.Checksum + `"`, time.Time{}
+		}
+		return "", time.Time{}
+	}
+	return fmt.Sprintf(`"%d-v%d"`, fr.ID, v.Version), v.Timestamp
+}
+
+// notModified reports whether the conditional headers of a GET or HEAD show
+// the client already has etag. It lets handlers answer before decrypting or
+// converting anything; http.ServeContent repeats the full checks later.
+func notModified(r *http.Request, etag string) bool {
+	if etag == "" || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return false
+	}
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func writeNotModified(w http.ResponseWriter, etag string) {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", contentCacheControl)
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// serveBlob answers a GET or HEAD with content using http.ServeContent, which
+// handles Range, If-Range, If-None-Match and If-Modified-Since and sets
+// Content-Length. Content-Type must already be set. It returns the status
+// that was sent.
+func serveBlob(w http.ResponseWriter, r *http.Request, content io.ReadSeeker, etag string, modified time.Time) int {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	w.Header().Set("Cache-Control", contentCacheControl)
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	http.ServeContent(sw, r, "", modified, content)
+	return sw.status
+}
+
+// countsAsRead reports whether a response served the file to the user, as
+// opposed to a revalidation or a follow-up range request from a viewer that
+// is already reading it.
+func countsAsRead(r *http.Request, status int) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	return status == http.StatusOK ||
+		(status == http.StatusPartialContent && strings.HasPrefix(r.Header.Get("Range"), "bytes=0-"))
+}
+
+// statusWriter records the status code written through it.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	sw.status = code
+	sw.ResponseWriter.WriteHeader(code)
+}
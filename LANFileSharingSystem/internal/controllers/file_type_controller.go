@@ -0,0 +1,246 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"LANFileSharingSystem/internal/filetype"
+	"LANFileSharingSystem/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+// FileTypeController manages the upload policy: which file types may be
+// uploaded, how large they may be, and per-department-folder overrides.
+// Every user can read the policy; only admins can change it.
+type FileTypeController struct {
+	App *models.App
+}
+
+// NewFileTypeController creates a new FileTypeController.
+func NewFileTypeController(app *models.App) *FileTypeController {
+	return &FileTypeController{App: app}
+}
+
+// fileTypeRuleRequest is the body of a create or update request. Folder and
+// Extension are only read on create.
+type fileTypeRuleRequest struct {
+	Folder    string `json:"folder"`
+	Extension string `json:"extension"`
+	Allowed   *bool  `json:"allowed"`
+	MaxSize   int64  `json:"max_size"`
+}
+
+// List handles GET /admin/file-types.
+func (fc *FileTypeController) List(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	if _, err := fc.App.GetUserFromSession(r); err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	rules, err := fc.App.ListFileTypeRules()
+	if err != nil {
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving file type rules")
+		return
+	}
+	if rules == nil {
+		rules = []models.FileTypeRule{}
+	}
+	models.RespondJSON(w, http.StatusOK, rules)
+}
+
+// Create handles POST /admin/file-types.
+func (fc *FileTypeController) Create(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, ok := fc.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var req fileTypeRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		models.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	folder := strings.ToLower(strings.TrimSpace(req.Folder))
+	if folder != "" && !uploadTopFolders[folder] {
+		models.RespondError(w, http.StatusBadRequest, "Folder must be empty or one of: operation, research, training")
+		return
+	}
+	ext := strings.ToLower(strings.TrimSpace(req.Extension))
+	if ext != "" && !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	if _, known := filetype.ForExtension(ext); !known {
+		models.RespondError(w, http.StatusBadRequest, fmt.Sprintf("Content detection does not support '%s' files", ext))
+		return
+	}
+	if req.MaxSize < 0 {
+		models.RespondError(w, http.StatusBadRequest, "Maximum size cannot be negative")
+		return
+	}
+
+	rule := models.FileTypeRule{
+		Folder:    folder,
+		Extension: ext,
+		Allowed:   req.Allowed == nil || *req.Allowed,
+		MaxSize:   req.MaxSize,
+		UpdatedBy: user.Username,
+	}
+	id, err := fc.App.CreateFileTypeRule(rule)
+	if errors.Is(err, models.ErrFileTypeRuleExists) {
+		models.RespondError(w, http.StatusConflict, fmt.Sprintf("A rule for '%s' already exists in this folder", ext))
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating file type rule for %s: %v", ext, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error creating file type rule")
+		return
+	}
+
+	fc.App.LogAudit(user.Username, 0, "FILE_POLICY", fmt.Sprintf("Added file type rule %s", describeRule(rule)))
+	fc.App.LogActivity(fmt.Sprintf("Admin '%s' added file type rule %s.", user.Username, describeRule(rule)))
+	models.RespondJSON(w, http.StatusCreated, map[string]interface{}{
+		"message": fmt.Sprintf("Rule for '%s' created successfully", ext),
+		"id":      id,
+	})
+}
+
+// Update handles PUT /admin/file-types/{id}.
+func (fc *FileTypeController) Update(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, ok := fc.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	rule, ok := fc.lookupRule(w, r)
+	if !ok {
+		return
+	}
+
+	var req fileTypeRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		models.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.MaxSize < 0 {
+		models.RespondError(w, http.StatusBadRequest, "Maximum size cannot be negative")
+		return
+	}
+	if req.Allowed != nil {
+		rule.Allowed = *req.Allowed
+	}
+	rule.MaxSize = req.MaxSize
+	rule.UpdatedBy = user.Username
+
+	if err := fc.App.UpdateFileTypeRule(rule); err != nil {
+		log.Printf("Error updating file type rule %d: %v", rule.ID, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error updating file type rule")
+		return
+	}
+
+	fc.App.LogAudit(user.Username, 0, "FILE_POLICY", fmt.Sprintf("Changed file type rule %s", describeRule(rule)))
+	fc.App.LogActivity(fmt.Sprintf("Admin '%s' changed file type rule %s.", user.Username, describeRule(rule)))
+	models.RespondJSON(w, http.StatusOK, map[string]string{
+		"message": fmt.Sprintf("Rule for '%s' updated successfully", rule.Extension),
+	})
+}
+
+// Delete handles DELETE /admin/file-types/{id}. Removing a folder override
+// makes the default apply again; removing a default disallows the type
+// wherever no override allows it.
+func (fc *FileTypeController) Delete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, ok := fc.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	rule, ok := fc.lookupRule(w, r)
+	if !ok {
+		return
+	}
+
+	if err := fc.App.DeleteFileTypeRule(rule.ID); err != nil {
+		log.Printf("Error deleting file type rule %d: %v", rule.ID, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error deleting file type rule")
+		return
+	}
+
+	fc.App.LogAudit(user.Username, 0, "FILE_POLICY", fmt.Sprintf("Removed file type rule %s", describeRule(rule)))
+	fc.App.LogActivity(fmt.Sprintf("Admin '%s' removed file type rule %s.", user.Username, describeRule(rule)))
+	models.RespondJSON(w, http.StatusOK, map[string]string{
+		"message": fmt.Sprintf("Rule for '%s' deleted successfully", rule.Extension),
+	})
+}
+
+// requireAdmin writes the error response itself when the user is not an admin.
+func (fc *FileTypeController) requireAdmin(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	user, err := fc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return models.User{}, false
+	}
+	if user.Role != "admin" {
+		models.RespondError(w, http.StatusForbidden, "Only admins can manage file type rules")
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// lookupRule loads the rule named in the URL. It writes the error response itself.
+func (fc *FileTypeController) lookupRule(w http.ResponseWriter, r *http.Request) (models.FileTypeRule, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		models.RespondError(w, http.StatusBadRequest, "Invalid rule ID")
+		return models.FileTypeRule{}, false
+	}
+	rule, err := fc.App.GetFileTypeRule(id)
+	if err == sql.ErrNoRows {
+		models.RespondError(w, http.StatusNotFound, "File type rule not found")
+		return models.FileTypeRule{}, false
+	}
+	if err != nil {
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving file type rule")
+		return models.FileTypeRule{}, false
+	}
+	return rule, true
+}
+
+// describeRule summarizes a rule for audit and activity entries.
+func describeRule(rule models.FileTypeRule) string {
+	folder := "all folders"
+	if rule.Folder != "" {
+		folder = "'" + rule.Folder + "'"
+	}
+	verdict := "allowed"
+	if !rule.Allowed {
+		verdict = "denied"
+	} else if rule.MaxSize > 0 {
+		verdict = "allowed up to " + formatSize(rule.MaxSize)
+	}
+	return fmt.Sprintf("'%s' in %s: %s", rule.Extension, folder, verdict)
+}
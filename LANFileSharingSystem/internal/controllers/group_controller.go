@@ -0,0 +1,412 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"LANFileSharingSystem/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+// maxMembersPerRequest caps how many users one request may add to a group.
+const maxMembersPerRequest = 100
+
+// GroupController manages user groups. Everyone can see which groups exist
+// and which they belong to; admins create groups and manage membership.
+// Each department has a group of its own, created with the schema.
+type GroupController struct {
+	App *models.App
+}
+
+// NewGroupController creates a new GroupController.
+func NewGroupController(app *models.App) *GroupController {
+	return &GroupController{App: app}
+}
+
+// List handles GET /groups, which returns every group with its number of
+// members.
+func (gc *GroupController) List(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	if _, err := gc.App.GetUserFromSession(r); err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	groups, err := gc.App.ListGroups()
+	if err != nil {
+		log.Printf("Error listing groups: %v", err)
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving groups")
+		return
+	}
+	if groups == nil {
+		groups = []models.Group{}
+	}
+	models.RespondJSON(w, http.StatusOK, groups)
+}
+
+// Mine handles GET /groups/mine, which returns the groups of the current
+// user.
+func (gc *GroupController) Mine(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, err := gc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	groups, err := gc.App.UserGroups(user.Username)
+	if err != nil {
+		log.Printf("Error listing the groups of %s: %v", user.Username, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving groups")
+		return
+	}
+	if groups == nil {
+		groups = []models.Group{}
+	}
+	models.RespondJSON(w, http.StatusOK, groups)
+}
+
+// Members handles GET /groups/{id}/members. Admins can list the members of
+// any group, other users only of the groups they belong to.
+func (gc *GroupController) Members(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, err := gc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+	group, ok := gc.lookupGroup(w, r)
+	if !ok {
+		return
+	}
+
+	members, err := gc.App.ListGroupMembers(group.ID)
+	if err != nil {
+		log.Printf("Error listing the members of group %d: %v", group.ID, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving members")
+		return
+	}
+	if user.Role != "admin" && !hasMember(members, user.Username) {
+		models.RespondError(w, http.StatusForbidden, "You are not a member of this group")
+		return
+	}
+	if members == nil {
+		members = []models.GroupMember{}
+	}
+	models.RespondJSON(w, http.StatusOK, map[string]interface{}{
+		"group":   group,
+		"members": members,
+	})
+}
+
+// Create handles POST /admin/groups:
+//
+//	{"name": "Typhoon Response", "description": "Field teams for typhoon season"}
+func (gc *GroupController) Create(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, ok := gc.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	name, description, ok := gc.readGroup(w, r, 0)
+	if !ok {
+		return
+	}
+
+	group, err := gc.App.CreateGroup(name, description, user.Username)
+	if err != nil {
+		log.Printf("Error creating group %s: %v", name, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error creating group")
+		return
+	}
+
+	gc.App.LogAudit(user.Username, 0, "GROUP_CREATE", fmt.Sprintf("Created group '%s'", group.Name))
+	gc.App.LogActivity(fmt.Sprintf("Admin '%s' created group '%s'.", user.Username, group.Name))
+	models.RespondJSON(w, http.StatusCreated, group)
+}
+
+// Update handles PUT /admin/groups/{id}, which renames a group or changes
+// its description. It takes the same body as Create.
+func (gc *GroupController) Update(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, ok := gc.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	group, ok := gc.lookupGroup(w, r)
+	if !ok {
+		return
+	}
+	name, description, ok := gc.readGroup(w, r, group.ID)
+	if !ok {
+		return
+	}
+	if group.Department != "" && name != group.Name {
+		models.RespondError(w, http.StatusBadRequest, "Department groups cannot be renamed")
+		return
+	}
+
+	updated, err := gc.App.UpdateGroup(group.ID, name, description)
+	if err != nil {
+		log.Printf("Error updating group %d: %v", group.ID, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error updating group")
+		return
+	}
+
+	details := fmt.Sprintf("Updated group '%s'", updated.Name)
+	if updated.Name != group.Name {
+		details = fmt.Sprintf("Renamed group '%s' to '%s'", group.Name, updated.Name)
+	}
+	gc.App.LogAudit(user.Username, 0, "GROUP_UPDATE", details)
+	gc.App.LogActivity(fmt.Sprintf("Admin '%s' updated group '%s'.", user.Username, updated.Name))
+	models.RespondJSON(w, http.StatusOK, updated)
+}
+
+// Delete handles DELETE /admin/groups/{id}. The group's folder permissions
+// go with it; department groups cannot be deleted.
+func (gc *GroupController) Delete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, ok := gc.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	group, ok := gc.lookupGroup(w, r)
+	if !ok {
+		return
+	}
+	if group.Department != "" {
+		models.RespondError(w, http.StatusBadRequest, "Department groups cannot be deleted")
+		return
+	}
+
+	if err := gc.App.DeleteGroup(group.ID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Error deleting group %d: %v", group.ID, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error deleting group")
+		return
+	}
+
+	gc.App.LogAudit(user.Username, 0, "GROUP_DELETE", fmt.Sprintf("Deleted group '%s' with %d member(s)", group.Name, group.MemberCount))
+	gc.App.LogActivity(fmt.Sprintf("Admin '%s' deleted group '%s'.", user.Username, group.Name))
+	models.RespondJSON(w, http.StatusOK, map[string]string{
+		"message": fmt.Sprintf("Group '%s' deleted", group.Name),
+	})
+}
+
+// AddMembers handles POST /admin/groups/{id}/members:
+//
+//	{"usernames": ["alice", "bob"]}
+func (gc *GroupController) AddMembers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, ok := gc.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	group, ok := gc.lookupGroup(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Usernames []string `json:"usernames"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		models.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if len(req.Usernames) == 0 {
+		models.RespondError(w, http.StatusBadRequest, "At least one username is required")
+		return
+	}
+	if len(req.Usernames) > maxMembersPerRequest {
+		models.RespondError(w, http.StatusBadRequest, fmt.Sprintf("At most %d users can be added at once", maxMembersPerRequest))
+		return
+	}
+
+	// Usernames are matched without regard to case, like at login.
+	var usernames []string
+	for _, name := range req.Usernames {
+		member, err := gc.App.GetUserByUsername(strings.TrimSpace(name))
+		if err != nil {
+			models.RespondError(w, http.StatusNotFound, fmt.Sprintf("User '%s' not found", strings.TrimSpace(name)))
+			return
+		}
+		usernames = append(usernames, member.Username)
+	}
+
+	added, err := gc.App.AddGroupMembers(group.ID, usernames, user.Username)
+	if err != nil {
+		log.Printf("Error adding members to group %d: %v", group.ID, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error adding members")
+		return
+	}
+	if added == nil {
+		added = []string{}
+	}
+	if len(added) > 0 {
+		gc.App.LogAudit(user.Username, 0, "GROUP_ADD_MEMBERS", fmt.Sprintf("Added %s to group '%s'", strings.Join(added, ", "), group.Name))
+		gc.App.LogActivity(fmt.Sprintf("Admin '%s' added %s to group '%s'.", user.Username, strings.Join(added, ", "), group.Name))
+	}
+	models.RespondJSON(w, http.StatusOK, map[string]interface{}{
+		"group": group.Name,
+		"added": added,
+	})
+}
+
+// RemoveMember handles DELETE /admin/groups/{id}/members/{username}.
+func (gc *GroupController) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, ok := gc.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	group, ok := gc.lookupGroup(w, r)
+	if !ok {
+		return
+	}
+	member, err := gc.App.GetUserByUsername(mux.Vars(r)["username"])
+	if err != nil {
+		models.RespondError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	err = gc.App.RemoveGroupMember(group.ID, member.Username)
+	if errors.Is(err, sql.ErrNoRows) {
+		models.RespondError(w, http.StatusNotFound, fmt.Sprintf("'%s' is not a member of '%s'", member.Username, group.Name))
+		return
+	}
+	if err != nil {
+		log.Printf("Error removing %s from group %d: %v", member.Username, group.ID, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error removing member")
+		return
+	}
+
+	gc.App.LogAudit(user.Username, 0, "GROUP_REMOVE_MEMBER", fmt.Sprintf("Removed %s from group '%s'", member.Username, group.Name))
+	gc.App.LogActivity(fmt.Sprintf("Admin '%s' removed '%s' from group '%s'.", user.Username, member.Username, group.Name))
+	models.RespondJSON(w, http.StatusOK, map[string]string{
+		"message": fmt.Sprintf("'%s' removed from '%s'", member.Username, group.Name),
+	})
+}
+
+// readGroup reads and validates the name and description of a group being
+// created, or updated when id is not zero. It writes the error response
+// itself.
+func (gc *GroupController) readGroup(w http.ResponseWriter, r *http.Request, id int) (string, string, bool) {
+	var req struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		models.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return "", "", false
+	}
+	name, err := models.CleanGroupName(req.Name)
+	if err != nil {
+		models.RespondError(w, http.StatusBadRequest, groupErrorMessage(err))
+		return "", "", false
+	}
+
+	existing, err := gc.App.GetGroupByName(name)
+	if err == nil && existing.ID != id {
+		models.RespondError(w, http.StatusConflict, fmt.Sprintf("Group '%s' already exists", existing.Name))
+		return "", "", false
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Error looking up group %s: %v", name, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error checking group name")
+		return "", "", false
+	}
+	return name, strings.TrimSpace(req.Description), true
+}
+
+// lookupGroup loads the group named in the URL. It writes the error
+// response itself.
+func (gc *GroupController) lookupGroup(w http.ResponseWriter, r *http.Request) (models.Group, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		models.RespondError(w, http.StatusBadRequest, "Invalid group ID")
+		return models.Group{}, false
+	}
+	group, err := gc.App.GetGroup(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		models.RespondError(w, http.StatusNotFound, "Group not found")
+		return models.Group{}, false
+	}
+	if err != nil {
+		log.Printf("Error loading group %d: %v", id, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving group")
+		return models.Group{}, false
+	}
+	return group, true
+}
+
+// requireAdmin writes the error response itself when the user is not an admin.
+func (gc *GroupController) requireAdmin(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	user, err := gc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return models.User{}, false
+	}
+	if user.Role != "admin" {
+		models.RespondError(w, http.StatusForbidden, "Only admins can manage groups")
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// hasMember reports whether username is among members.
+func hasMember(members []models.GroupMember, username string) bool {
+	for _, m := range members {
+		if m.Username == username {
+			return true
+		}
+	}
+	return false
+}
+
+// groupErrorMessage turns a CleanGroupName error into response text.
+func groupErrorMessage(err error) string {
+	if !errors.Is(err, models.ErrInvalidGroupName) {
+		return "Invalid group name"
+	}
+	msg := strings.TrimPrefix(err.Error(), models.ErrInvalidGroupName.Error()+": ")
+	return strings.ToUpper(msg[:1]) + msg[1:]
+}
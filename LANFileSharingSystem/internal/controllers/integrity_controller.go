@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"LANFileSharingSystem/internal/models"
+	"LANFileSharingSystem/internal/services"
+)
+
+// IntegrityController exposes the content integrity scrubber to admins.
+type IntegrityController struct {
+	App      *models.App
+	Scrubber *services.IntegrityScrubber
+}
+
+// NewIntegrityController creates a new IntegrityController.
+func NewIntegrityController(app *models.App, scrubber *services.IntegrityScrubber) *IntegrityController {
+	return &IntegrityController{App: app, Scrubber: scrubber}
+}
+
+// Report returns the outcome of the last scrub and the open integrity issues.
+// Pass include_resolved=true to also list issues that have since cleared.
+func (ic *IntegrityController) Report(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+	user, err := ic.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+	if user.Role != "admin" {
+		models.RespondError(w, http.StatusForbidden, "Only admins can view integrity reports")
+		return
+	}
+
+	issues, err := ic.App.ListIntegrityIssues(r.URL.Query().Get("include_resolved") == "true")
+	if err != nil {
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving integrity issues")
+		return
+	}
+	if issues == nil {
+		issues = []models.IntegrityIssue{}
+	}
+	running, last := ic.Scrubber.Status()
+	models.RespondJSON(w, http.StatusOK, map[string]interface{}{
+		"running":     running,
+		"interval":    ic.Scrubber.Interval.String(),
+		"last_scrub":  last,
+		"issues":      issues,
+		"issue_count": len(issues),
+	})
+}
+
+// Scrub starts an integrity scrub in the background.
+func (ic *IntegrityController) Scrub(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+	user, err := ic.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+	if user.Role != "admin" {
+		models.RespondError(w, http.StatusForbidden, "Only admins can start an integrity scrub")
+		return
+	}
+
+	if !ic.Scrubber.Trigger() {
+		models.RespondError(w, http.StatusConflict, "An integrity scrub is already running")
+		return
+	}
+	ic.App.LogActivity(fmt.Sprintf("Admin '%s' started an integrity scrub.", user.Username))
+	models.RespondJSON(w, http.StatusAccepted, map[string]string{"message": "Integrity scrub started"})
+}
@@ -0,0 +1,164 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"LANFileSharingSystem/internal/models"
+	"LANFileSharingSystem/internal/services"
+
+	"github.com/gorilla/mux"
+)
+
+// JobController lets admins watch and steer the background job queue.
+type JobController struct {
+	App  *models.App
+	Jobs *services.JobQueue
+}
+
+// NewJobController creates a new JobController.
+func NewJobController(app *models.App, jobs *services.JobQueue) *JobController {
+	return &JobController{App: app, Jobs: jobs}
+}
+
+// List handles GET /admin/jobs. The status and type query parameters filter
+// the jobs; limit caps how many are returned (default 100, at most 500).
+func (jc *JobController) List(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+	if _, ok := jc.requireAdmin(w, r); !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	limit := 100
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			models.RespondError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		if n > 500 {
+			n = 500
+		}
+		limit = n
+	}
+
+	jobs, err := jc.App.ListJobs(query.Get("status"), query.Get("type"), limit)
+	if err != nil {
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving jobs")
+		return
+	}
+	if jobs == nil {
+		jobs = []models.Job{}
+	}
+	counts, err := jc.App.JobCounts()
+	if err != nil {
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving jobs")
+		return
+	}
+	models.RespondJSON(w, http.StatusOK, map[string]interface{}{
+		"workers": jc.Jobs.Workers,
+		"types":   jc.Jobs.Types(),
+		"counts":  counts,
+		"jobs":    jobs,
+	})
+}
+
+// Retry handles POST /admin/jobs/{id}/retry. Only dead and cancelled jobs
+// can be retried; they get a fresh set of attempts.
+func (jc *JobController) Retry(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+	user, ok := jc.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	id, ok := jobID(w, r)
+	if !ok {
+		return
+	}
+
+	job, err := jc.Jobs.Retry(id)
+	if !jc.checkJobChange(w, id, job, err, "retried") {
+		return
+	}
+
+	jc.App.LogAudit(user.Username, 0, "JOB_RETRY", fmt.Sprintf("Retried %s job %d", job.Type, job.ID))
+	jc.App.LogActivity(fmt.Sprintf("Admin '%s' retried %s job %d.", user.Username, job.Type, job.ID))
+	models.RespondJSON(w, http.StatusOK, job)
+}
+
+// Cancel handles POST /admin/jobs/{id}/cancel. Only queued and running jobs
+// can be cancelled.
+func (jc *JobController) Cancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+	user, ok := jc.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	id, ok := jobID(w, r)
+	if !ok {
+		return
+	}
+
+	job, err := jc.Jobs.Cancel(id)
+	if !jc.checkJobChange(w, id, job, err, "cancelled") {
+		return
+	}
+
+	jc.App.LogAudit(user.Username, 0, "JOB_CANCEL", fmt.Sprintf("Cancelled %s job %d", job.Type, job.ID))
+	jc.App.LogActivity(fmt.Sprintf("Admin '%s' cancelled %s job %d.", user.Username, job.Type, job.ID))
+	models.RespondJSON(w, http.StatusOK, job)
+}
+
+// checkJobChange writes the error response for a failed retry or cancel.
+func (jc *JobController) checkJobChange(w http.ResponseWriter, id int64, job models.Job, err error, verb string) bool {
+	switch {
+	case err == nil:
+		return true
+	case err == sql.ErrNoRows:
+		models.RespondError(w, http.StatusNotFound, "Job not found")
+	case errors.Is(err, models.ErrJobState):
+		models.RespondError(w, http.StatusConflict, fmt.Sprintf("A %s job cannot be %s", job.Status, verb))
+	default:
+		log.Printf("Error updating job %d: %v", id, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error updating job")
+	}
+	return false
+}
+
+// requireAdmin writes the error response itself when the user is not an admin.
+func (jc *JobController) requireAdmin(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	user, err := jc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return models.User{}, false
+	}
+	if user.Role != "admin" {
+		models.RespondError(w, http.StatusForbidden, "Only admins can manage background jobs")
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// jobID parses the job ID in the URL. It writes the error response itself.
+func jobID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		models.RespondError(w, http.StatusBadRequest, "Invalid job ID")
+		return 0, false
+	}
+	return id, true
+}
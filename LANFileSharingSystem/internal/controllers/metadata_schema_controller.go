@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"LANFileSharingSystem/internal/models"
+)
+
+// MetadataSchemaController manages the JSON Schemas that file metadata must
+// satisfy. A schema attached to a folder applies to it and to every folder
+// below it that has none of its own. Every user can read the schemas, so
+// clients can render metadata forms from them; only admins can change them.
+type MetadataSchemaController struct {
+	App *models.App
+}
+
+// NewMetadataSchemaController creates a new MetadataSchemaController.
+func NewMetadataSchemaController(app *models.App) *MetadataSchemaController {
+	return &MetadataSchemaController{App: app}
+}
+
+// List handles GET /admin/metadata-schemas.
+func (mc *MetadataSchemaController) List(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	if _, err := mc.App.GetUserFromSession(r); err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	schemas, err := mc.App.ListMetadataSchemas()
+	if err != nil {
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving metadata schemas")
+		return
+	}
+	if schemas == nil {
+		schemas = []models.MetadataSchema{}
+	}
+	models.RespondJSON(w, http.StatusOK, schemas)
+}
+
+// Effective handles GET /metadata-schema?directory=, which returns the
+// schema that applies to files stored in the directory and the folder it is
+// attached to. Both are null when the folder's metadata is unrestricted.
+func (mc *MetadataSchemaController) Effective(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	if _, err := mc.App.GetUserFromSession(r); err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	dir, err := cleanUploadDirectory(strings.Trim(r.URL.Query().Get("directory"), "/"))
+	if err != nil {
+		models.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resp := map[string]interface{}{
+		"directory":        dir,
+		"schema_directory": nil,
+		"schema":           nil,
+	}
+	schema, err := mc.App.EffectiveMetadataSchema(dir)
+	switch {
+	case err == nil:
+		resp["schema_directory"] = schema.Directory
+		resp["schema"] = schema.Schema
+	case err != sql.ErrNoRows:
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving metadata schema")
+		return
+	}
+	models.RespondJSON(w, http.StatusOK, resp)
+}
+
+// Save handles PUT /admin/metadata-schemas. The body names the directory
+// and holds the schema, which replaces any the directory already has:
+//
+//	{"directory": "Operation/Incidents", "schema": {"type": "object", ...}}
+func (mc *MetadataSchemaController) Save(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, ok := mc.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Directory string          `json:"directory"`
+		Schema    json.RawMessage `json:"schema"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		models.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	dir, ok := schemaDirectory(w, req.Directory)
+	if !ok {
+		return
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(req.Schema), []byte("{")) {
+		models.RespondError(w, http.StatusBadRequest, "Schema must be a JSON object")
+		return
+	}
+	if _, err := models.CompileMetadataSchema(req.Schema); err != nil {
+		models.RespondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid schema: %v", err))
+		return
+	}
+
+	saved, err := mc.App.SaveMetadataSchema(models.MetadataSchema{
+		Directory: dir,
+		Schema:    req.Schema,
+		UpdatedBy: user.Username,
+	})
+	if err != nil {
+		log.Printf("Error saving metadata schema for %s: %v", dir, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error saving metadata schema")
+		return
+	}
+
+	mc.App.LogAudit(user.Username, 0, "METADATA_SCHEMA", fmt.Sprintf("Set the metadata schema for '%s'", dir))
+	mc.App.LogActivity(fmt.Sprintf("Admin '%s' set the metadata schema for '%s'.", user.Username, dir))
+	models.RespondJSON(w, http.StatusOK, saved)
+}
+
+// Delete handles DELETE /admin/metadata-schemas?directory=. The folder then
+// falls under its nearest ancestor's schema, if any.
+func (mc *MetadataSchemaController) Delete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, ok := mc.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	dir, ok := schemaDirectory(w, r.URL.Query().Get("directory"))
+	if !ok {
+		return
+	}
+
+	err := mc.App.DeleteMetadataSchema(dir)
+	if err == sql.ErrNoRows {
+		models.RespondError(w, http.StatusNotFound, fmt.Sprintf("'%s' has no metadata schema", dir))
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting metadata schema for %s: %v", dir, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error deleting metadata schema")
+		return
+	}
+
+	mc.App.LogAudit(user.Username, 0, "METADATA_SCHEMA", fmt.Sprintf("Removed the metadata schema for '%s'", dir))
+	mc.App.LogActivity(fmt.Sprintf("Admin '%s' removed the metadata schema for '%s'.", user.Username, dir))
+	models.RespondJSON(w, http.StatusOK, map[string]string{
+		"message": fmt.Sprintf("Metadata schema for '%s' deleted successfully", dir),
+	})
+}
+
+// requireAdmin writes the error response itself when the user is not an admin.
+func (mc *MetadataSchemaController) requireAdmin(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	user, err := mc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return models.User{}, false
+	}
+	if user.Role != "admin" {
+		models.RespondError(w, http.StatusForbidden, "Only admins can manage metadata schemas")
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// schemaDirectory validates the folder a schema is attached to. Schemas go
+// on one of the upload folders or below. It writes the error response itself.
+func schemaDirectory(w http.ResponseWriter, dir string) (string, bool) {
+	dir, err := cleanUploadDirectory(strings.Trim(strings.TrimSpace(dir), "/"))
+	if err != nil {
+		models.RespondError(w, http.StatusBadRequest, err.Error())
+		return "", false
+	}
+	if dir == "" {
+		models.RespondError(w, http.StatusBadRequest, "A directory is required")
+		return "", false
+	}
+	return dir, true
+}
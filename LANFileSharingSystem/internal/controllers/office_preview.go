@@ -0,0 +1,317 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"LANFileSharingSystem/internal/models"
+	"LANFileSharingSystem/internal/office"
+	"LANFileSharingSystem/internal/services"
+)
+
+const (
+	// defaultSheetPageSize is the number of spreadsheet rows on a preview
+	// page when the request names none; maxSheetPageSize caps page_size.
+	defaultSheetPageSize = 100
+	maxSheetPageSize     = 1000
+)
+
+// officePreviewCSP keeps the pages rendered from office files inert: they
+// may only use their own inline styles.
+const officePreviewCSP = "default-src 'none'; style-src 'unsafe-inline'; base-uri 'none'; form-action 'none'"
+
+// previewSheet summarizes one sheet of a previewed workbook.
+type previewSheet struct {
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+	Rows  int    `json:"rows"`
+}
+
+// previewRow is one non-empty spreadsheet row. Cells start at column A.
+type previewRow struct {
+	Number int      `json:"number"`
+	Cells  []string `json:"cells"`
+}
+
+// sheetPreview is one page of rows of one sheet, as sent for format=json.
+type sheetPreview struct {
+	FileID    int            `json:"file_id"`
+	FileName  string         `json:"file_name"`
+	Sheets    []previewSheet `json:"sheets"`
+	Sheet     int            `json:"sheet"`
+	Page      int            `json:"page"`
+	PageSize  int            `json:"page_size"`
+	Pages     int            `json:"pages"`
+	TotalRows int            `json:"total_rows"`
+	Rows      []previewRow   `json:"rows"`
+}
+
+// nativePreview answers a preview request for a spreadsheet or Word
+// document by reading it in-process rather than converting it to PDF.
+// format is "html" or "json". Spreadsheets are paged: sheet is the 0-based
+// index of the sheet to show, page is 1-based and page_size is the number of
+// rows per page. The pages link to one another with the same query, so they
+// can be browsed in a tab of their own.
+func (fc *FileController) nativePreview(w http.ResponseWriter, r *http.Request, user models.User, fr models.FileRecord, format string) {
+	query := r.URL.Query()
+	sheet, page, pageSize := 0, 1, defaultSheetPageSize
+	if v := query.Get("sheet"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			models.RespondError(w, http.StatusBadRequest, "Invalid sheet")
+			return
+		}
+		sheet = n
+	}
+	if v := query.Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			models.RespondError(w, http.StatusBadRequest, "Invalid page")
+			return
+		}
+		page = n
+	}
+	if v := query.Get("page_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSheetPageSize {
+			models.RespondError(w, http.StatusBadRequest, fmt.Sprintf("page_size must be between 1 and %d", maxSheetPageSize))
+			return
+		}
+		pageSize = n
+	}
+
+	// Like converted previews, each rendering gets its own entity tag.
+	etag, modified := fc.contentValidators(fr)
+	if etag != "" {
+		etag = strings.TrimSuffix(etag, `"`) + "-" + format + `"`
+	}
+	if notModified(r, etag) {
+		writeNotModified(w, etag)
+		return
+	}
+
+	blob, err := fc.App.OpenDecrypted(r.Context(), fr.FilePath)
+	if err != nil {
+		log.Printf("Decryption failed for %s: %v", fr.FilePath, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error decrypting file")
+		return
+	}
+	preview, err := services.RenderOfficePreview(blob, fr.FileName)
+	blob.Close()
+	if errors.Is(err, office.ErrTooLarge) {
+		models.RespondError(w, http.StatusUnprocessableEntity, "The workbook is too large to preview")
+		return
+	}
+	if err != nil {
+		log.Printf("Native preview of %s failed: %v", fr.FilePath, err)
+		models.RespondError(w, http.StatusUnprocessableEntity, "Could not read the file for preview")
+		return
+	}
+
+	var body bytes.Buffer
+	if preview.Workbook != nil {
+		wb := preview.Workbook
+		if sheet >= len(wb.Sheets) {
+			models.RespondError(w, http.StatusNotFound, "Sheet not found")
+			return
+		}
+		sp := pageSheet(fr, wb, sheet, page, pageSize)
+		if format == "json" {
+			err = json.NewEncoder(&body).Encode(sp)
+		} else {
+			err = sheetPageTemplate.Execute(&body, newSheetPageView(sp, query))
+		}
+	} else {
+		if format == "json" {
+			err = json.NewEncoder(&body).Encode(map[string]interface{}{
+				"file_id":   fr.ID,
+				"file_name": fr.FileName,
+				"html":      preview.HTML,
+			})
+		} else {
+			err = documentPageTemplate.Execute(&body, documentPageView{
+				FileName: fr.FileName,
+				Body:     template.HTML(preview.HTML), // built from escaped text by office.DocumentHTML
+			})
+		}
+	}
+	if err != nil {
+		log.Printf("Rendering the preview of %s failed: %v", fr.FilePath, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error rendering preview")
+		return
+	}
+
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", officePreviewCSP)
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if status := serveBlob(w, r, bytes.NewReader(body.Bytes()), etag, modified); countsAsRead(r, status) {
+		fc.App.LogActivity(fmt.Sprintf("User '%s' previewed file '%s' (ID: %d)", user.Username, fr.FileName, fr.ID))
+	}
+}
+
+// pageSheet cuts one page of rows out of a sheet. Pages past the last one
+// are empty.
+func pageSheet(fr models.FileRecord, wb *office.Workbook, sheet, page, pageSize int) sheetPreview {
+	sp := sheetPreview{
+		FileID:   fr.ID,
+		FileName: fr.FileName,
+		Sheets:   make([]previewSheet, len(wb.Sheets)),
+		Sheet:    sheet,
+		Page:     page,
+		PageSize: pageSize,
+		Rows:     []previewRow{},
+	}
+	for i, s := range wb.Sheets {
+		sp.Sheets[i] = previewSheet{Index: i, Name: s.Name, Rows: len(s.Rows)}
+	}
+
+	rows := wb.Sheets[sheet].Rows
+	sp.TotalRows = len(rows)
+	sp.Pages = max(1, (len(rows)+pageSize-1)/pageSize)
+	if start := (page - 1) * pageSize; start < len(rows) {
+		for _, row := range rows[start:min(start+pageSize, len(rows))] {
+			cells := row.Cells
+			if cells == nil {
+				cells = []string{}
+			}
+			sp.Rows = append(sp.Rows, previewRow{Number: row.Number, Cells: cells})
+		}
+	}
+	return sp
+}
+
+// columnName returns the spreadsheet name of a 0-based column index, such
+// as "A" for 0 and "AA" for 26.
+func columnName(index int) string {
+	var name []byte
+	for index++; index > 0; index = (index - 1) / 26 {
+		name = append([]byte{byte('A' + (index-1)%26)}, name...)
+	}
+	return string(name)
+}
+
+// sheetTab is a link to one sheet of a workbook preview.
+type sheetTab struct {
+	Name    string
+	URL     string
+	Current bool
+}
+
+type sheetPageView struct {
+	sheetPreview
+	Tabs    []sheetTab
+	Columns []string
+	PrevURL string
+	NextURL string
+}
+
+// newSheetPageView prepares a sheet page for the HTML template. Links keep
+// the rest of the request's query, such as directory and filename.
+func newSheetPageView(sp sheetPreview, query url.Values) sheetPageView {
+	link := func(sheet, page int) string {
+		q := url.Values{}
+		for k, v := range query {
+			q[k] = v
+		}
+		q.Set("sheet", strconv.Itoa(sheet))
+		q.Set("page", strconv.Itoa(page))
+		return "?" + q.Encode()
+	}
+
+	view := sheetPageView{sheetPreview: sp}
+	for _, s := range sp.Sheets {
+		view.Tabs = append(view.Tabs, sheetTab{Name: s.Name, URL: link(s.Index, 1), Current: s.Index == sp.Sheet})
+	}
+	columns := 0
+	for _, row := range sp.Rows {
+		columns = max(columns, len(row.Cells))
+	}
+	for i := 0; i < columns; i++ {
+		view.Columns = append(view.Columns, columnName(i))
+	}
+	if sp.Page > 1 {
+		view.PrevURL = link(sp.Sheet, min(sp.Page-1, sp.Pages))
+	}
+	if sp.Page < sp.Pages {
+		view.NextURL = link(sp.Sheet, sp.Page+1)
+	}
+	return view
+}
+
+type documentPageView struct {
+	FileName string
+	Body     template.HTML
+}
+
+// previewStyle is shared by the spreadsheet and document preview pages.
+const previewStyle = `
+body { font-family: system-ui, sans-serif; margin: 0; padding: 1rem; color: #222; }
+h1.file { font-size: 1.1rem; margin: 0 0 0.75rem; }
+nav.sheets { display: flex; flex-wrap: wrap; gap: 0.25rem; margin-bottom: 0.75rem; }
+nav.sheets a, nav.sheets strong { padding: 0.25rem 0.6rem; border: 1px solid #ccc; border-radius: 3px; text-decoration: none; }
+nav.sheets strong { background: #e8eef7; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ddd; padding: 0.2rem 0.4rem; vertical-align: top; white-space: pre-wrap; }
+thead th, tbody th { background: #f4f4f4; color: #555; font-weight: normal; }
+nav.pages { margin: 0.75rem 0; display: flex; gap: 1rem; align-items: center; }
+article { max-width: 50rem; line-height: 1.5; }
+article p { white-space: pre-wrap; margin: 0 0 0.6rem; }
+article p:empty { min-height: 1em; }
+article .center { text-align: center; }
+article .right { text-align: right; }
+article .justify { text-align: justify; }
+`
+
+var sheetPageTemplate = template.Must(template.New("sheet").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.FileName}}</title>
+<style>` + previewStyle + `</style>
+</head>
+<body>
+<h1 class="file">{{.FileName}}</h1>
+<nav class="sheets">{{range .Tabs}}{{if .Current}}<strong>{{.Name}}</strong>{{else}}<a href="{{.URL}}">{{.Name}}</a>{{end}}{{end}}</nav>
+{{if .Rows}}<table>
+<thead><tr><th></th>{{range .Columns}}<th>{{.}}</th>{{end}}</tr></thead>
+<tbody>
+{{range .Rows}}<tr><th>{{.Number}}</th>{{range .Cells}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</tbody>
+</table>{{else}}<p>No rows on this page.</p>{{end}}
+<nav class="pages">
+{{if .PrevURL}}<a href="{{.PrevURL}}">Previous</a>{{end}}
+<span>Page {{.Page}} of {{.Pages}} ({{.TotalRows}} rows in this sheet)</span>
+{{if .NextURL}}<a href="{{.NextURL}}">Next</a>{{end}}
+</nav>
+</body>
+</html>
+`))
+
+var documentPageTemplate = template.Must(template.New("document").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.FileName}}</title>
+<style>` + previewStyle + `</style>
+</head>
+<body>
+<article>
+{{.Body}}</article>
+</body>
+</html>
+`))
@@ -0,0 +1,277 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"LANFileSharingSystem/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+// PermissionController manages who may do what in which folder. Admins and
+// users holding manage on a folder grant and revoke permissions on it; a
+// grant covers the folder and everything below it.
+type PermissionController struct {
+	App *models.App
+}
+
+// NewPermissionController creates a new PermissionController.
+func NewPermissionController(app *models.App) *PermissionController {
+	return &PermissionController{App: app}
+}
+
+// loadAccess loads the folder permissions of user. It writes the error
+// response itself.
+func loadAccess(app *models.App, w http.ResponseWriter, user models.User) (*models.Access, bool) {
+	access, err := app.UserAccess(user)
+	if err != nil {
+		log.Printf("Error loading the folder permissions of %s: %v", user.Username, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error checking permissions")
+		return nil, false
+	}
+	return access, true
+}
+
+// authorize reports whether user holds perm in dir. It writes the error
+// response itself.
+func authorize(app *models.App, w http.ResponseWriter, user models.User, dir string, perm models.Permission) bool {
+	access, ok := loadAccess(app, w, user)
+	if !ok {
+		return false
+	}
+	if !access.Can(dir, perm) {
+		respondForbidden(w, perm)
+		return false
+	}
+	return true
+}
+
+// authorizeFile reports whether user holds perm in the folder of fr. It
+// writes the error response itself.
+func authorizeFile(app *models.App, w http.ResponseWriter, user models.User, fr models.FileRecord, perm models.Permission) bool {
+	return authorize(app, w, user, models.FileFolder(fr), perm)
+}
+
+// respondForbidden rejects a request made without perm.
+func respondForbidden(w http.ResponseWriter, perm models.Permission) {
+	models.RespondError(w, http.StatusForbidden, fmt.Sprintf("You do not have %s access to this folder", perm))
+}
+
+// List handles GET /folder-permissions?directory=, which returns the grants
+// that apply to a folder, including those inherited from the folders above
+// it.
+func (pc *PermissionController) List(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, err := pc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	dir := models.CleanFolderPath(r.URL.Query().Get("directory"))
+	if !authorize(pc.App, w, user, dir, models.PermManage) {
+		return
+	}
+
+	grants, err := pc.App.ListFolderGrants(dir)
+	if err != nil {
+		log.Printf("Error listing permissions on '%s': %v", dir, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving permissions")
+		return
+	}
+	if grants == nil {
+		grants = []models.FolderGrant{}
+	}
+	models.RespondJSON(w, http.StatusOK, map[string]interface{}{
+		"directory": dir,
+		"grants":    grants,
+	})
+}
+
+// Effective handles GET /folder-permissions/effective?directory=, which
+// returns what the current user may do in a folder.
+func (pc *PermissionController) Effective(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, err := pc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+	access, ok := loadAccess(pc.App, w, user)
+	if !ok {
+		return
+	}
+
+	dir := models.CleanFolderPath(r.URL.Query().Get("directory"))
+	models.RespondJSON(w, http.StatusOK, map[string]interface{}{
+		"directory":   dir,
+		"permissions": access.Permissions(dir).Names(),
+	})
+}
+
+// Grant handles POST /folder-permissions, which gives a user, or every
+// member of a group, a permission on a folder and everything below it:
+//
+//	{"directory": "Operation/Reports", "username": "alice", "permission": "write"}
+//	{"directory": "Operation/Reports", "group": "Auditors", "permission": "read"}
+func (pc *PermissionController) Grant(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, err := pc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	var req struct {
+		Directory  string `json:"directory"`
+		Username   string `json:"username"`
+		Group      string `json:"group"`
+		Permission string `json:"permission"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		models.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	req.Username = strings.TrimSpace(req.Username)
+	req.Group = strings.TrimSpace(req.Group)
+	perm, err := models.ParsePermission(strings.ToLower(strings.TrimSpace(req.Permission)))
+	if err != nil {
+		models.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if (req.Username == "") == (req.Group == "") {
+		models.RespondError(w, http.StatusBadRequest, "Either a username or a group is required")
+		return
+	}
+	dir := models.CleanFolderPath(req.Directory)
+	if !authorize(pc.App, w, user, dir, models.PermManage) {
+		return
+	}
+
+	if dir != "" {
+		exists, err := folderExists(pc.App, dir)
+		if err != nil {
+			log.Printf("Error checking directory '%s': %v", dir, err)
+			models.RespondError(w, http.StatusInternalServerError, "Error checking directory")
+			return
+		}
+		if !exists {
+			models.RespondError(w, http.StatusNotFound, "Directory not found")
+			return
+		}
+	}
+
+	var grant models.FolderGrant
+	var created bool
+	if req.Group != "" {
+		group, err := pc.App.GetGroupByName(req.Group)
+		if err != nil {
+			models.RespondError(w, http.StatusNotFound, "Group not found")
+			return
+		}
+		grant, created, err = pc.App.GrantGroupFolderPermission(dir, group.ID, perm, user.Username)
+		if err != nil {
+			log.Printf("Error granting %s on '%s' to group %s: %v", perm, dir, group.Name, err)
+			models.RespondError(w, http.StatusInternalServerError, "Error granting permission")
+			return
+		}
+	} else {
+		target, err := pc.App.GetUserByUsername(req.Username)
+		if err != nil {
+			models.RespondError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		grant, created, err = pc.App.GrantFolderPermission(dir, target.Username, perm, user.Username)
+		if err != nil {
+			log.Printf("Error granting %s on '%s' to %s: %v", perm, dir, target.Username, err)
+			models.RespondError(w, http.StatusInternalServerError, "Error granting permission")
+			return
+		}
+	}
+	if !created {
+		models.RespondJSON(w, http.StatusOK, grant)
+		return
+	}
+
+	pc.App.LogAudit(user.Username, 0, "PERMISSION_GRANT", fmt.Sprintf("Granted %s on '%s' to %s", perm, displayFolder(dir), grantee(grant)))
+	pc.App.LogActivity(fmt.Sprintf("User '%s' granted %s on '%s' to %s.", user.Username, perm, displayFolder(dir), grantee(grant)))
+	models.RespondJSON(w, http.StatusCreated, grant)
+}
+
+// Revoke handles DELETE /folder-permissions/{id}.
+func (pc *PermissionController) Revoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, err := pc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		models.RespondError(w, http.StatusBadRequest, "Invalid permission ID")
+		return
+	}
+	grant, err := pc.App.GetFolderGrant(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		models.RespondError(w, http.StatusNotFound, "Permission not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading permission %d: %v", id, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving permission")
+		return
+	}
+	if !authorize(pc.App, w, user, grant.Directory, models.PermManage) {
+		return
+	}
+
+	if err := pc.App.RevokeFolderGrant(id); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Error revoking permission %d: %v", id, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error revoking permission")
+		return
+	}
+
+	pc.App.LogAudit(user.Username, 0, "PERMISSION_REVOKE", fmt.Sprintf("Revoked %s on '%s' from %s", grant.Permission, displayFolder(grant.Directory), grantee(grant)))
+	pc.App.LogActivity(fmt.Sprintf("User '%s' revoked %s on '%s' from %s.", user.Username, grant.Permission, displayFolder(grant.Directory), grantee(grant)))
+	models.RespondJSON(w, http.StatusOK, map[string]string{"message": "Permission revoked"})
+}
+
+// grantee names the holder of a grant in log messages.
+func grantee(g models.FolderGrant) string {
+	if g.Group != "" {
+		return fmt.Sprintf("group '%s'", g.Group)
+	}
+	return fmt.Sprintf("'%s'", g.Username)
+}
+
+// displayFolder names a folder in log messages, where the root is "/".
+func displayFolder(dir string) string {
+	if dir == "" {
+		return "/"
+	}
+	return dir
+}
@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"net/http"
+
+	"LANFileSharingSystem/internal/models"
+)
+
+// QuarantineController lets admins review content the virus scanner flagged.
+type QuarantineController struct {
+	App *models.App
+}
+
+// NewQuarantineController creates a new QuarantineController.
+func NewQuarantineController(app *models.App) *QuarantineController {
+	return &QuarantineController{App: app}
+}
+
+// List handles GET /admin/quarantine.
+func (qc *QuarantineController) List(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, err := qc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+	if user.Role != "admin" {
+		models.RespondError(w, http.StatusForbidden, "Only admins can view the quarantine")
+		return
+	}
+
+	files, err := qc.App.ListQuarantinedFiles()
+	if err != nil {
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving quarantined files")
+		return
+	}
+	if files == nil {
+		files = []models.QuarantinedFile{}
+	}
+	models.RespondJSON(w, http.StatusOK, files)
+}
@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"LANFileSharingSystem/internal/models"
+	"LANFileSharingSystem/internal/services"
+)
+
+// ScanController exposes the virus rescans of stored files to admins.
+type ScanController struct {
+	App       *models.App
+	Rescanner *services.Rescanner
+}
+
+// NewScanController creates a new ScanController.
+func NewScanController(app *models.App, rescanner *services.Rescanner) *ScanController {
+	return &ScanController{App: app, Rescanner: rescanner}
+}
+
+// Report returns the outcome of the last rescan, the verdict counts and the
+// files whose latest scan was not clean. Pass status=all to list clean files too.
+func (sc *ScanController) Report(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+	user, err := sc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+	if user.Role != "admin" {
+		models.RespondError(w, http.StatusForbidden, "Only admins can view scan reports")
+		return
+	}
+
+	scans, err := sc.App.ListFileScans(r.URL.Query().Get("status") == "all")
+	if err != nil {
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving scan results")
+		return
+	}
+	if scans == nil {
+		scans = []models.FileScan{}
+	}
+	summary, err := sc.App.FileScanSummary()
+	if err != nil {
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving scan results")
+		return
+	}
+	running, last := sc.Rescanner.Status()
+	models.RespondJSON(w, http.StatusOK, map[string]interface{}{
+		"running":           running,
+		"interval":          sc.Rescanner.Interval.String(),
+		"max_bytes_per_sec": sc.Rescanner.RateLimit,
+		"last_rescan":       last,
+		"summary":           summary,
+		"results":           scans,
+	})
+}
+
+// Rescan starts a rescan of stored files in the background.
+func (sc *ScanController) Rescan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+	user, err := sc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+	if user.Role != "admin" {
+		models.RespondError(w, http.StatusForbidden, "Only admins can start a virus rescan")
+		return
+	}
+
+	if !sc.Rescanner.Trigger() {
+		models.RespondError(w, http.StatusConflict, "A virus rescan is already running")
+		return
+	}
+	sc.App.LogActivity(fmt.Sprintf("Admin '%s' started a virus rescan.", user.Username))
+	models.RespondJSON(w, http.StatusAccepted, map[string]string{"message": "Virus rescan started"})
+}
@@ -0,0 +1,278 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"LANFileSharingSystem/internal/models"
+)
+
+// SearchController finds files by their names, contents and metadata.
+type SearchController struct {
+	App *models.App
+}
+
+// NewSearchController creates a new SearchController.
+func NewSearchController(app *models.App) *SearchController {
+	return &SearchController{App: app}
+}
+
+// Search handles GET /search?q=. The query accepts web search syntax:
+// quoted phrases, "or" and -excluded words. Results can be narrowed with
+// directory (that folder and below), uploader, and from/to upload dates as
+// YYYY-MM-DD, both inclusive. limit (default 20, at most 100) and offset page
+// through the results.
+func (sc *SearchController) Search(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, err := sc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+	access, ok := loadAccess(sc.App, w, user)
+	if !ok {
+		return
+	}
+
+	params := r.URL.Query()
+	q := models.SearchQuery{
+		Text:      strings.TrimSpace(params.Get("q")),
+		Directory: strings.Trim(params.Get("directory"), "/"),
+		Uploader:  strings.TrimSpace(params.Get("uploader")),
+		Folders:   access.ReadableFolders(),
+	}
+	if q.Text == "" {
+		models.RespondError(w, http.StatusBadRequest, "Search query is required")
+		return
+	}
+
+	var msg string
+	if q.From, q.To, msg = parseDateRange(params); msg != "" {
+		models.RespondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if q.Limit, q.Offset, msg = parsePage(params, 20, 100); msg != "" {
+		models.RespondError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	results, total, err := sc.App.SearchFiles(q)
+	if err != nil {
+		log.Printf("Search for %q failed: %v", q.Text, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error searching files")
+		return
+	}
+	if results == nil {
+		results = []models.SearchResult{}
+	}
+	models.RespondJSON(w, http.StatusOK, map[string]interface{}{
+		"query":   q.Text,
+		"total":   total,
+		"limit":   q.Limit,
+		"offset":  q.Offset,
+		"results": results,
+	})
+}
+
+// QueryFiles handles GET /files/query, which filters files on their
+// metadata. Each metadata condition is a parameter named after the key,
+// with nested keys separated by dots and an optional operator in brackets:
+//
+//	meta.typhoon=Odette              equal (the default operator)
+//	meta.barangay[ne]=Poblacion      not equal
+//	meta.date[gte]=2021-12-01        range: gt, gte, lt, lte
+//	meta.casualties[gt]=10           numbers compare numerically
+//	meta.location.barangay[exists]=true
+//	meta.tags[contains]=evacuation   array membership or JSON subset
+//
+// contains takes a JSON object that the metadata must contain. directory,
+// uploader and from/to behave as for /search. sort is created_at (the
+// default), file_name, size or meta.<key>, and order is asc or desc (the
+// default). limit (default 50, at most 200) and offset page through the
+// results.
+func (sc *SearchController) QueryFiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, err := sc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+	access, ok := loadAccess(sc.App, w, user)
+	if !ok {
+		return
+	}
+
+	params := r.URL.Query()
+	q := models.FileQuery{
+		Directory:  strings.Trim(params.Get("directory"), "/"),
+		Uploader:   strings.TrimSpace(params.Get("uploader")),
+		Folders:    access.ReadableFolders(),
+		Sort:       params.Get("sort"),
+		Descending: params.Get("order") != "asc",
+	}
+	var msg string
+	if q.Conditions, msg = parseMetadataConditions(params); msg != "" {
+		models.RespondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if v := params.Get("contains"); v != "" {
+		if err := json.Unmarshal([]byte(v), &q.Contains); err != nil {
+			models.RespondError(w, http.StatusBadRequest, "'contains' must be a JSON object")
+			return
+		}
+	}
+	switch {
+	case q.Sort == "" || q.Sort == "created_at" || q.Sort == "file_name" || q.Sort == "size":
+	case strings.HasPrefix(q.Sort, "meta."):
+		if q.SortPath = metadataPath(strings.TrimPrefix(q.Sort, "meta.")); q.SortPath == nil {
+			models.RespondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid sort key '%s'", q.Sort))
+			return
+		}
+	default:
+		models.RespondError(w, http.StatusBadRequest, "sort must be created_at, file_name, size or meta.<key>")
+		return
+	}
+	if order := params.Get("order"); order != "" && order != "asc" && order != "desc" {
+		models.RespondError(w, http.StatusBadRequest, "order must be asc or desc")
+		return
+	}
+	if q.From, q.To, msg = parseDateRange(params); msg != "" {
+		models.RespondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if q.Limit, q.Offset, msg = parsePage(params, 50, 200); msg != "" {
+		models.RespondError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	files, total, err := sc.App.QueryFiles(q)
+	if errors.Is(err, models.ErrInvalidFileQuery) {
+		models.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Metadata query %q failed: %v", r.URL.RawQuery, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error querying files")
+		return
+	}
+	if files == nil {
+		files = []models.FileMatch{}
+	}
+	models.RespondJSON(w, http.StatusOK, map[string]interface{}{
+		"total":  total,
+		"limit":  q.Limit,
+		"offset": q.Offset,
+		"files":  files,
+	})
+}
+
+// parseMetadataConditions collects the meta.<key>[op] parameters. It
+// returns an error message for a malformed one.
+func parseMetadataConditions(params url.Values) ([]models.MetadataCondition, string) {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		if strings.HasPrefix(key, "meta.") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys) // a stable query for the same parameters
+
+	var conditions []models.MetadataCondition
+	for _, key := range keys {
+		name, op := strings.TrimPrefix(key, "meta."), models.MetaEq
+		if i := strings.IndexByte(name, '['); i >= 0 && strings.HasSuffix(name, "]") {
+			name, op = name[:i], name[i+1:len(name)-1]
+		}
+		path := metadataPath(name)
+		if path == nil {
+			return nil, fmt.Sprintf("Invalid metadata key in '%s'", key)
+		}
+		known := false
+		for _, o := range models.MetadataOperators {
+			known = known || o == op
+		}
+		if !known {
+			return nil, fmt.Sprintf("Unknown operator '%s' in '%s'; use one of: %s", op, key, strings.Join(models.MetadataOperators, ", "))
+		}
+		for _, value := range params[key] {
+			conditions = append(conditions, models.MetadataCondition{Path: path, Op: op, Value: value})
+		}
+	}
+	return conditions, ""
+}
+
+// metadataPath splits a dotted metadata key into its levels. It returns nil
+// for an empty or overly deep key.
+func metadataPath(key string) []string {
+	path := strings.Split(key, ".")
+	if len(path) > 8 {
+		return nil
+	}
+	for _, p := range path {
+		if p == "" {
+			return nil
+		}
+	}
+	return path
+}
+
+// parseDateRange reads the from and to parameters as YYYY-MM-DD dates, both
+// inclusive. It returns an error message for a malformed date.
+func parseDateRange(params url.Values) (from, to *time.Time, msg string) {
+	if v := params.Get("from"); v != "" {
+		t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			return nil, nil, "Invalid 'from' date, expected YYYY-MM-DD"
+		}
+		from = &t
+	}
+	if v := params.Get("to"); v != "" {
+		t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			return nil, nil, "Invalid 'to' date, expected YYYY-MM-DD"
+		}
+		end := t.AddDate(0, 0, 1)
+		to = &end
+	}
+	return from, to, ""
+}
+
+// parsePage reads the limit and offset parameters. It returns an error
+// message for a malformed value.
+func parsePage(params url.Values, defaultLimit, maxLimit int) (limit, offset int, msg string) {
+	limit = defaultLimit
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return 0, 0, "Invalid limit"
+		}
+		if n > maxLimit {
+			n = maxLimit
+		}
+		limit = n
+	}
+	if v := params.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, "Invalid offset"
+		}
+		offset = n
+	}
+	return limit, offset, ""
+}
@@ -0,0 +1,408 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"LANFileSharingSystem/internal/middleware"
+	"LANFileSharingSystem/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+// defaultShareLinkLifetime is how long a link stays valid when its creator
+// does not choose an expiry.
+const defaultShareLinkLifetime = 7 * 24 * time.Hour
+
+// maxShareLinkPassword is the longest share link password, in bytes; bcrypt
+// ignores anything beyond it.
+const maxShareLinkPassword = 72
+
+// ShareController manages share links and serves them at /s/{token}, where
+// anyone holding a link downloads its file, or its folder as a ZIP archive,
+// without an account. Creating a link takes share access to the item; every
+// use of a link is audited.
+type ShareController struct {
+	App *models.App
+	// MaxLifetime is the longest a link may stay valid.
+	MaxLifetime time.Duration
+
+	dirs *DirectoryController
+}
+
+// NewShareController creates a new ShareController.
+func NewShareController(app *models.App, maxLifetime time.Duration) *ShareController {
+	return &ShareController{App: app, MaxLifetime: maxLifetime, dirs: NewDirectoryController(app)}
+}
+
+// Create handles POST /share-links, which creates a link to a file or a
+// folder:
+//
+//	{"file_id": 42, "expires_at": "2025-07-01T17:00:00+08:00", "password": "...", "max_downloads": 3}
+//	{"directory": "Research/Reports"}
+//
+// Only file_id or directory is required. Links last a week unless
+// expires_at says otherwise. The response carries the token, which cannot
+// be retrieved later.
+func (sc *ShareController) Create(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, err := sc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	var req struct {
+		FileID       int        `json:"file_id"`
+		Directory    string     `json:"directory"`
+		ExpiresAt    *time.Time `json:"expires_at"`
+		Password     string     `json:"password"`
+		MaxDownloads int        `json:"max_downloads"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		models.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	dir := models.CleanFolderPath(req.Directory)
+	if (req.FileID == 0) == (dir == "") {
+		models.RespondError(w, http.StatusBadRequest, "Either a file or a folder is required")
+		return
+	}
+	if req.MaxDownloads < 0 {
+		models.RespondError(w, http.StatusBadRequest, "Maximum downloads cannot be negative")
+		return
+	}
+	if len(req.Password) > maxShareLinkPassword {
+		models.RespondError(w, http.StatusBadRequest, fmt.Sprintf("Passwords can be at most %d bytes long", maxShareLinkPassword))
+		return
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(min(defaultShareLinkLifetime, sc.MaxLifetime))
+	if req.ExpiresAt != nil {
+		expiresAt = *req.ExpiresAt
+	}
+	if !expiresAt.After(now) {
+		models.RespondError(w, http.StatusBadRequest, "Expiry must be in the future")
+		return
+	}
+	if expiresAt.After(now.Add(sc.MaxLifetime)) {
+		models.RespondError(w, http.StatusBadRequest,
+			fmt.Sprintf("Share links can last at most %d days", int(sc.MaxLifetime.Hours()/24)))
+		return
+	}
+
+	link := models.ShareLink{
+		FileID:       req.FileID,
+		Directory:    dir,
+		ExpiresAt:    expiresAt,
+		MaxDownloads: req.MaxDownloads,
+		CreatedBy:    user.Username,
+	}
+	target := dir
+	if req.FileID != 0 {
+		fr, err := sc.App.GetFileRecordByID(req.FileID)
+		if err != nil {
+			models.RespondError(w, http.StatusNotFound, "File not found")
+			return
+		}
+		if !authorizeFile(sc.App, w, user, fr, models.PermShare) {
+			return
+		}
+		target = fr.FilePath
+	} else {
+		exists, err := folderExists(sc.App, dir)
+		if err != nil {
+			log.Printf("Error checking directory '%s': %v", dir, err)
+			models.RespondError(w, http.StatusInternalServerError, "Error checking directory")
+			return
+		}
+		if !exists {
+			models.RespondError(w, http.StatusNotFound, "Directory not found")
+			return
+		}
+		if !authorize(sc.App, w, user, dir, models.PermShare) {
+			return
+		}
+	}
+
+	link, err = sc.App.CreateShareLink(link, req.Password)
+	if err != nil {
+		log.Printf("Error creating share link for '%s': %v", target, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error creating share link")
+		return
+	}
+
+	sc.App.LogAudit(user.Username, link.FileID, "SHARE_LINK_CREATE",
+		fmt.Sprintf("Created share link %d to '%s', valid until %s", link.ID, target, link.ExpiresAt.Format(time.RFC3339)))
+	sc.App.LogActivity(fmt.Sprintf("User '%s' created a share link to '%s'.", user.Username, target))
+	models.RespondJSON(w, http.StatusCreated, map[string]interface{}{
+		"share_link": link,
+		"url":        "/s/" + link.Token,
+	})
+}
+
+// List handles GET /share-links, which returns the links the current user
+// created, or every link for admins.
+func (sc *ShareController) List(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, err := sc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	createdBy := user.Username
+	if user.Role == "admin" {
+		createdBy = ""
+	}
+	links, err := sc.App.ListShareLinks(createdBy)
+	if err != nil {
+		log.Printf("Error listing share links: %v", err)
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving share links")
+		return
+	}
+	if links == nil {
+		links = []models.ShareLink{}
+	}
+	models.RespondJSON(w, http.StatusOK, links)
+}
+
+// Revoke handles DELETE /share-links/{id}. A link can be revoked by its
+// creator and by those who manage the folder it points into.
+func (sc *ShareController) Revoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, err := sc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		models.RespondError(w, http.StatusBadRequest, "Invalid share link ID")
+		return
+	}
+	link, err := sc.App.GetShareLink(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		models.RespondError(w, http.StatusNotFound, "Share link not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading share link %d: %v", id, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving share link")
+		return
+	}
+	if link.CreatedBy != user.Username && !authorize(sc.App, w, user, shareLinkFolder(link), models.PermManage) {
+		return
+	}
+
+	err = sc.App.RevokeShareLink(id, user.Username)
+	if errors.Is(err, sql.ErrNoRows) {
+		models.RespondJSON(w, http.StatusOK, map[string]string{"message": "Share link already revoked"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error revoking share link %d: %v", id, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error revoking share link")
+		return
+	}
+
+	sc.App.LogAudit(user.Username, link.FileID, "SHARE_LINK_REVOKE", fmt.Sprintf("Revoked share link %d to '%s'", link.ID, link.Path))
+	sc.App.LogActivity(fmt.Sprintf("User '%s' revoked a share link to '%s'.", user.Username, link.Path))
+	models.RespondJSON(w, http.StatusOK, map[string]string{"message": "Share link revoked"})
+}
+
+// Open handles /s/{token}, the only endpoint that needs no session. A GET
+// downloads the file or folder of a link without a password; links with a
+// password take it as the "password" form field of a POST. Every download
+// counts towards the link's maximum, and every attempt is audited.
+func (sc *ShareController) Open(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	link, err := sc.App.GetShareLinkByToken(mux.Vars(r)["token"])
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Unknown share link requested from %s", middleware.ClientIP(r))
+		models.RespondError(w, http.StatusNotFound, "Link not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading share link: %v", err)
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving link")
+		return
+	}
+
+	switch link.Status {
+	case models.ShareLinkExpired:
+		sc.refuse(w, r, link, http.StatusGone, "This link has expired", "expired")
+		return
+	case models.ShareLinkRevoked:
+		sc.refuse(w, r, link, http.StatusGone, "This link has been revoked", "revoked")
+		return
+	case models.ShareLinkExhausted:
+		sc.refuse(w, r, link, http.StatusGone, "This link has no downloads left", "no downloads left")
+		return
+	}
+	if link.HasPassword {
+		password := ""
+		if r.Method == http.MethodPost {
+			password = r.FormValue("password")
+		}
+		if password == "" {
+			sc.refuse(w, r, link, http.StatusUnauthorized, "This link requires a password", "no password given")
+			return
+		}
+		if !link.CheckPassword(password) {
+			sc.refuse(w, r, link, http.StatusUnauthorized, "Incorrect password", "wrong password")
+			return
+		}
+	}
+
+	// A link only works while its creator may still share the item.
+	if ok, err := sc.creatorCanShare(link); err != nil || !ok {
+		if err != nil {
+			log.Printf("Error checking the creator of share link %d: %v", link.ID, err)
+		}
+		sc.refuse(w, r, link, http.StatusGone, "This link is no longer available", "creator can no longer share it")
+		return
+	}
+
+	if link.FileID != 0 {
+		sc.serveFile(w, r, link)
+	} else {
+		sc.serveFolder(w, r, link)
+	}
+}
+
+// refuse rejects an attempt to use link and audits it.
+func (sc *ShareController) refuse(w http.ResponseWriter, r *http.Request, link models.ShareLink, code int, message, reason string) {
+	sc.App.LogGuestAudit(link.FileID, "SHARE_LINK_DENIED",
+		fmt.Sprintf("Share link %d to '%s' refused for %s: %s", link.ID, link.Path, middleware.ClientIP(r), reason))
+	models.RespondError(w, code, message)
+}
+
+// creatorCanShare reports whether the creator of link still holds share
+// access to its file or folder.
+func (sc *ShareController) creatorCanShare(link models.ShareLink) (bool, error) {
+	creator, err := sc.App.GetUserByUsername(link.CreatedBy)
+	if err != nil {
+		return false, err
+	}
+	access, err := sc.App.UserAccess(creator)
+	if err != nil {
+		return false, err
+	}
+	return access.Can(shareLinkFolder(link), models.PermShare), nil
+}
+
+// shareLinkFolder returns the folder a link points into: its folder, or the
+// folder its file is stored in.
+func shareLinkFolder(link models.ShareLink) string {
+	if link.FileID != 0 {
+		return models.FileFolder(models.FileRecord{FilePath: link.Path})
+	}
+	return link.Directory
+}
+
+// useLink counts a download of link. It writes the error response itself.
+func (sc *ShareController) useLink(w http.ResponseWriter, r *http.Request, link models.ShareLink) bool {
+	ok, err := sc.App.UseShareLink(link.ID)
+	if err != nil {
+		log.Printf("Error counting a download of share link %d: %v", link.ID, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving link")
+		return false
+	}
+	if !ok {
+		// Another download used up the link in the meantime.
+		sc.refuse(w, r, link, http.StatusGone, "This link has no downloads left", "no downloads left")
+		return false
+	}
+	return true
+}
+
+// serveFile decrypts the file of link and sends it in full; range and
+// conditional requests are not honoured, since every request counts as a
+// download.
+func (sc *ShareController) serveFile(w http.ResponseWriter, r *http.Request, link models.ShareLink) {
+	fr, err := sc.App.GetFileRecordByID(link.FileID)
+	if err != nil {
+		sc.refuse(w, r, link, http.StatusNotFound, "File not found", "file no longer exists")
+		return
+	}
+
+	blob, err := sc.App.OpenSeekable(r.Context(), fr.FilePath)
+	if err != nil {
+		log.Printf("Decryption failed for %s: %v", fr.FilePath, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error decrypting file")
+		return
+	}
+	defer blob.Close()
+	if !sc.useLink(w, r, link) {
+		return
+	}
+
+	for _, h := range []string{"Range", "If-Range", "If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since"} {
+		r.Header.Del(h)
+	}
+	w.Header().Set("Content-Type", fr.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", fr.FileName))
+	serveBlob(w, r, blob, "", time.Time{})
+
+	sc.App.LogGuestAudit(fr.ID, "SHARE_LINK_DOWNLOAD",
+		fmt.Sprintf("Downloaded '%s' for %s through share link %d created by %s", fr.FilePath, middleware.ClientIP(r), link.ID, link.CreatedBy))
+	sc.App.LogActivity(fmt.Sprintf("A guest downloaded file '%s' through a share link created by '%s'.", fr.FileName, link.CreatedBy))
+}
+
+// serveFolder streams the folder of link as a ZIP archive.
+func (sc *ShareController) serveFolder(w http.ResponseWriter, r *http.Request, link models.ShareLink) {
+	exists, err := folderExists(sc.App, link.Directory)
+	if err != nil {
+		log.Printf("Error checking directory '%s': %v", link.Directory, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error checking directory")
+		return
+	}
+	if !exists {
+		sc.refuse(w, r, link, http.StatusNotFound, "Folder not found", "folder no longer exists")
+		return
+	}
+	entries, err := sc.dirs.folderArchiveEntries(link.Directory, "")
+	if err != nil {
+		models.RespondError(w, http.StatusInternalServerError, "Error listing folder contents")
+		return
+	}
+	if !sc.useLink(w, r, link) {
+		return
+	}
+
+	folderName, _ := splitFolderPath(link.Directory)
+	written := sc.dirs.streamArchive(w, r, folderName+".zip", entries)
+	for _, e := range written {
+		sc.App.LogGuestAudit(e.File.ID, "DOWNLOAD",
+			fmt.Sprintf("Downloaded '%s' in a ZIP archive through share link %d", e.File.FilePath, link.ID))
+	}
+	sc.App.LogGuestAudit(0, "SHARE_LINK_DOWNLOAD",
+		fmt.Sprintf("Downloaded folder '%s' (%d file(s)) for %s through share link %d created by %s",
+			link.Directory, len(written), middleware.ClientIP(r), link.ID, link.CreatedBy))
+	sc.App.LogActivity(fmt.Sprintf("A guest downloaded folder '%s' through a share link created by '%s'.", link.Directory, link.CreatedBy))
+}
@@ -0,0 +1,345 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"LANFileSharingSystem/internal/models"
+)
+
+// maxTagsPerRequest caps how many tags one request may add or remove.
+const maxTagsPerRequest = 20
+
+// TagController labels files with tags and lists files by tag. Any user
+// can tag files, inventing new tags as they go; admins curate the tags
+// offered to everyone and can delete a tag outright.
+type TagController struct {
+	App *models.App
+}
+
+// NewTagController creates a new TagController.
+func NewTagController(app *models.App) *TagController {
+	return &TagController{App: app}
+}
+
+// fileTagsRequest is the body of a request adding or removing tags.
+type fileTagsRequest struct {
+	FileID int      `json:"file_id"`
+	Tags   []string `json:"tags"`
+}
+
+// List handles GET /tags, which returns the curated tags and every tag in
+// use on a file the user may read, with the number of such files carrying
+// each.
+func (tc *TagController) List(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, err := tc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+	access, ok := loadAccess(tc.App, w, user)
+	if !ok {
+		return
+	}
+
+	tags, err := tc.App.ListTags(access.ReadableFolders())
+	if err != nil {
+		log.Printf("Error listing tags: %v", err)
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving tags")
+		return
+	}
+	if tags == nil {
+		tags = []models.Tag{}
+	}
+	models.RespondJSON(w, http.StatusOK, tags)
+}
+
+// Files handles GET /tags/files?tag=, which lists the files carrying a tag.
+func (tc *TagController) Files(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, err := tc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+	access, ok := loadAccess(tc.App, w, user)
+	if !ok {
+		return
+	}
+
+	tag, err := models.NormalizeTag(r.URL.Query().Get("tag"))
+	if err != nil {
+		models.RespondError(w, http.StatusBadRequest, tagErrorMessage(err))
+		return
+	}
+
+	files, err := tc.App.ListFilesByTag(tag)
+	if err != nil {
+		log.Printf("Error listing files tagged %s: %v", tag, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving files")
+		return
+	}
+	files = access.FilterFiles(files)
+	output, err := fileListing(tc.App, files)
+	if err != nil {
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving tags")
+		return
+	}
+	for i, f := range files {
+		output[i]["directory"] = f.Directory
+		output[i]["path"] = f.FilePath
+	}
+	if output == nil {
+		output = []map[string]interface{}{}
+	}
+	models.RespondJSON(w, http.StatusOK, map[string]interface{}{
+		"tag":   tag,
+		"files": output,
+	})
+}
+
+// AddToFile handles POST /file/tags, which tags a file:
+//
+//	{"file_id": 42, "tags": ["for-signature", "2025-budget"]}
+func (tc *TagController) AddToFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, err := tc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+	fr, tags, ok := tc.readFileTags(w, r)
+	if !ok || !authorizeFile(tc.App, w, user, fr, models.PermWrite) {
+		return
+	}
+
+	added, err := tc.App.AddFileTags(fr.ID, tags, user.Username)
+	if err != nil {
+		log.Printf("Error tagging %s: %v", fr.FilePath, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error adding tags")
+		return
+	}
+	if len(added) > 0 {
+		tc.App.LogAudit(user.Username, fr.ID, "TAG_ADD", fmt.Sprintf("Tagged '%s' with %s", fr.FilePath, strings.Join(added, ", ")))
+		tc.App.LogActivity(fmt.Sprintf("User '%s' tagged '%s' with %s.", user.Username, fr.FilePath, strings.Join(added, ", ")))
+	}
+	tc.respondFileTags(w, fr, "added", added)
+}
+
+// RemoveFromFile handles DELETE /file/tags, which takes the same body as
+// AddToFile.
+func (tc *TagController) RemoveFromFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, err := tc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+	fr, tags, ok := tc.readFileTags(w, r)
+	if !ok || !authorizeFile(tc.App, w, user, fr, models.PermWrite) {
+		return
+	}
+
+	removed, err := tc.App.RemoveFileTags(fr.ID, tags)
+	if err != nil {
+		log.Printf("Error untagging %s: %v", fr.FilePath, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error removing tags")
+		return
+	}
+	if len(removed) > 0 {
+		tc.App.LogAudit(user.Username, fr.ID, "TAG_REMOVE", fmt.Sprintf("Removed %s from '%s'", strings.Join(removed, ", "), fr.FilePath))
+		tc.App.LogActivity(fmt.Sprintf("User '%s' removed tags %s from '%s'.", user.Username, strings.Join(removed, ", "), fr.FilePath))
+	}
+	tc.respondFileTags(w, fr, "removed", removed)
+}
+
+// Curate handles PUT /admin/tags, which creates a curated tag or curates an
+// existing one:
+//
+//	{"name": "confidential", "description": "Not for circulation outside the office"}
+func (tc *TagController) Curate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, ok := tc.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		models.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	name, err := models.NormalizeTag(req.Name)
+	if err != nil {
+		models.RespondError(w, http.StatusBadRequest, tagErrorMessage(err))
+		return
+	}
+
+	tag, err := tc.App.SaveCuratedTag(name, strings.TrimSpace(req.Description), user.Username)
+	if err != nil {
+		log.Printf("Error saving tag %s: %v", name, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error saving tag")
+		return
+	}
+
+	tc.App.LogAudit(user.Username, 0, "TAG_CURATE", fmt.Sprintf("Curated tag '%s'", name))
+	tc.App.LogActivity(fmt.Sprintf("Admin '%s' curated tag '%s'.", user.Username, name))
+	models.RespondJSON(w, http.StatusOK, tag)
+}
+
+// Delete handles DELETE /admin/tags?name=, which removes a tag from every
+// file and deletes it.
+func (tc *TagController) Delete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, ok := tc.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	name, err := models.NormalizeTag(r.URL.Query().Get("name"))
+	if err != nil {
+		models.RespondError(w, http.StatusBadRequest, tagErrorMessage(err))
+		return
+	}
+
+	fileIDs, err := tc.App.DeleteTag(name)
+	if err == sql.ErrNoRows {
+		models.RespondError(w, http.StatusNotFound, fmt.Sprintf("Tag '%s' not found", name))
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting tag %s: %v", name, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error deleting tag")
+		return
+	}
+
+	// Each file's history shows the tag going away.
+	for _, id := range fileIDs {
+		tc.App.LogAudit(user.Username, id, "TAG_REMOVE", fmt.Sprintf("Removed %s (tag deleted)", name))
+	}
+	tc.App.LogAudit(user.Username, 0, "TAG_DELETE", fmt.Sprintf("Deleted tag '%s' from %d files", name, len(fileIDs)))
+	tc.App.LogActivity(fmt.Sprintf("Admin '%s' deleted tag '%s' from %d files.", user.Username, name, len(fileIDs)))
+	models.RespondJSON(w, http.StatusOK, map[string]interface{}{
+		"message": fmt.Sprintf("Tag '%s' deleted successfully", name),
+		"files":   len(fileIDs),
+	})
+}
+
+// readFileTags decodes a fileTagsRequest, loads the file and normalizes the
+// tags. It writes the error response itself.
+func (tc *TagController) readFileTags(w http.ResponseWriter, r *http.Request) (models.FileRecord, []string, bool) {
+	var req fileTagsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		models.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return models.FileRecord{}, nil, false
+	}
+	if req.FileID <= 0 || len(req.Tags) == 0 {
+		models.RespondError(w, http.StatusBadRequest, "A file ID and at least one tag are required")
+		return models.FileRecord{}, nil, false
+	}
+	if len(req.Tags) > maxTagsPerRequest {
+		models.RespondError(w, http.StatusBadRequest, fmt.Sprintf("At most %d tags can be changed at once", maxTagsPerRequest))
+		return models.FileRecord{}, nil, false
+	}
+
+	seen := make(map[string]bool)
+	var tags []string
+	for _, t := range req.Tags {
+		tag, err := models.NormalizeTag(t)
+		if err != nil {
+			models.RespondError(w, http.StatusBadRequest, tagErrorMessage(err))
+			return models.FileRecord{}, nil, false
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+
+	fr, err := tc.App.GetFileRecordByID(req.FileID)
+	if err == sql.ErrNoRows {
+		models.RespondError(w, http.StatusNotFound, "File not found")
+		return models.FileRecord{}, nil, false
+	}
+	if err != nil {
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving file record")
+		return models.FileRecord{}, nil, false
+	}
+	return fr, tags, true
+}
+
+// respondFileTags reports the tags changed and the file's tags afterwards.
+func (tc *TagController) respondFileTags(w http.ResponseWriter, fr models.FileRecord, verb string, changed []string) {
+	all, err := tc.App.FileTags([]int{fr.ID})
+	if err != nil {
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving tags")
+		return
+	}
+	if changed == nil {
+		changed = []string{}
+	}
+	tags := all[fr.ID]
+	if tags == nil {
+		tags = []string{}
+	}
+	models.RespondJSON(w, http.StatusOK, map[string]interface{}{
+		"file_id": fr.ID,
+		verb:      changed,
+		"tags":    tags,
+	})
+}
+
+// requireAdmin writes the error response itself when the user is not an admin.
+func (tc *TagController) requireAdmin(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	user, err := tc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return models.User{}, false
+	}
+	if user.Role != "admin" {
+		models.RespondError(w, http.StatusForbidden, "Only admins can manage tags")
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// tagErrorMessage turns a NormalizeTag error into response text.
+func tagErrorMessage(err error) string {
+	if !errors.Is(err, models.ErrInvalidTag) {
+		return "Invalid tag"
+	}
+	msg := err.Error()
+	return strings.ToUpper(msg[:1]) + msg[1:]
+}
@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"LANFileSharingSystem/internal/models"
+)
+
+// TrashController handles the recycle bin endpoints. Users see and manage the
+// items they deleted; admins see and manage every item.
+type TrashController struct {
+	App *models.App
+	// Retention is how long items are kept before the purger removes them;
+	// zero when they are kept indefinitely.
+	Retention time.Duration
+}
+
+// NewTrashController creates a new TrashController.
+func NewTrashController(app *models.App, retention time.Duration) *TrashController {
+	return &TrashController{App: app, Retention: retention}
+}
+
+// trashItemRequest identifies a recycle bin item.
+type trashItemRequest struct {
+	ID int `json:"id"`
+}
+
+// List returns the recycle bin contents visible to the current user.
+func (tc *TrashController) List(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, err := tc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	deletedBy := user.Username
+	if user.Role == "admin" {
+		deletedBy = ""
+	}
+	items, err := tc.App.ListTrashItems(deletedBy)
+	if err != nil {
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving recycle bin")
+		return
+	}
+	if items == nil {
+		items = []models.TrashItem{}
+	}
+	if tc.Retention > 0 {
+		for i := range items {
+			expires := items[i].DeletedAt.Add(tc.Retention)
+			items[i].ExpiresAt = &expires
+		}
+	}
+	models.RespondJSON(w, http.StatusOK, items)
+}
+
+// Restore moves an item back to the location it was deleted from.
+func (tc *TrashController) Restore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, err := tc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	item, ok := tc.lookupItem(w, r, user)
+	if !ok {
+		return
+	}
+	// Access may have been withdrawn since the item was deleted.
+	if !authorize(tc.App, w, user, item.OriginalParent, models.PermWrite) {
+		return
+	}
+
+	if err := tc.App.RestoreTrashItem(r.Context(), item, user.Username); err != nil {
+		if errors.Is(err, models.ErrRestoreConflict) {
+			models.RespondError(w, http.StatusConflict,
+				fmt.Sprintf("Cannot restore '%s': an item with the same name already exists there", item.OriginalPath))
+			return
+		}
+		log.Printf("Error restoring recycle bin item %d: %v", item.ID, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error restoring item")
+		return
+	}
+
+	fileID := 0
+	if item.Type == models.TrashFile {
+		fileID, _ = tc.App.GetFileIDByPath(item.OriginalPath)
+	}
+	tc.App.LogAudit(user.Username, fileID, "TRASH_RESTORE", fmt.Sprintf("Restored %s '%s' from the recycle bin", item.Type, item.OriginalPath))
+	tc.App.LogActivity(fmt.Sprintf("User '%s' restored %s '%s' from the recycle bin.", user.Username, item.Type, item.OriginalPath))
+
+	models.RespondJSON(w, http.StatusOK, map[string]string{
+		"message": fmt.Sprintf("'%s' restored successfully", item.OriginalPath),
+		"path":    item.OriginalPath,
+	})
+}
+
+// Delete permanently removes an item from the recycle bin.
+func (tc *TrashController) Delete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		models.RespondError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	user, err := tc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	item, ok := tc.lookupItem(w, r, user)
+	if !ok {
+		return
+	}
+
+	if err := tc.App.PurgeTrashItem(r.Context(), item); err != nil {
+		log.Printf("Error permanently deleting recycle bin item %d: %v", item.ID, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error deleting item permanently")
+		return
+	}
+
+	tc.App.LogAudit(user.Username, 0, "PURGE", fmt.Sprintf("Permanently deleted %s '%s' from the recycle bin", item.Type, item.OriginalPath))
+	tc.App.LogActivity(fmt.Sprintf("User '%s' permanently deleted %s '%s'.", user.Username, item.Type, item.OriginalPath))
+
+	models.RespondJSON(w, http.StatusOK, map[string]string{
+		"message": fmt.Sprintf("'%s' deleted permanently", item.OriginalPath),
+	})
+}
+
+// lookupItem decodes the item ID from the request body and loads the item,
+// checking that user may manage it. It writes the error response itself.
+func (tc *TrashController) lookupItem(w http.ResponseWriter, r *http.Request, user models.User) (models.TrashItem, bool) {
+	var req trashItemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID <= 0 {
+		models.RespondError(w, http.StatusBadRequest, "Invalid request body")
+		return models.TrashItem{}, false
+	}
+
+	item, err := tc.App.GetTrashItem(req.ID)
+	if err == sql.ErrNoRows {
+		models.RespondError(w, http.StatusNotFound, "Item not found in the recycle bin")
+		return models.TrashItem{}, false
+	}
+	if err != nil {
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving recycle bin item")
+		return models.TrashItem{}, false
+	}
+	if user.Role != "admin" && item.DeletedBy != user.Username {
+		models.RespondError(w, http.StatusForbidden, "You can only manage items you deleted")
+		return models.TrashItem{}, false
+	}
+	return item, true
+}
@@ -0,0 +1,336 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"LANFileSharingSystem/internal/models"
+	"LANFileSharingSystem/internal/services"
+
+	"github.com/gorilla/mux"
+)
+
+// TusController implements the tus 1.0.0 resumable upload protocol with the
+// creation, termination and expiration extensions. Finished uploads go
+// through the same pipeline as FileController.Upload.
+//
+// The file is described at creation in Upload-Metadata: "filename" is
+// required; "directory", "overwrite" ("true") and "metadata" (a JSON object)
+// are optional and mean the same as the Upload form fields. A "filetype" is
+// kept for reference only, since the stored type is detected from the content.
+type TusController struct {
+	App    *models.App
+	Files  *FileController
+	Stager *services.UploadStager
+}
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,expiration"
+	// maxResumableUploadSize caps the Upload-Length a client may announce.
+	maxResumableUploadSize int64 = 4 << 30
+)
+
+// NewTusController creates a new TusController that stores finished uploads
+// through files.
+func NewTusController(app *models.App, files *FileController, stager *services.UploadStager) *TusController {
+	return &TusController{App: app, Files: files, Stager: stager}
+}
+
+// Options reports the protocol version, extensions and size limit.
+func (tc *TusController) Options(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(maxResumableUploadSize, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Create starts a new upload and returns its URL in the Location header.
+func (tc *TusController) Create(w http.ResponseWriter, r *http.Request) {
+	user, ok := tc.begin(w, r)
+	if !ok {
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		models.RespondError(w, http.StatusBadRequest, "Upload-Length header is required")
+		return
+	}
+	if length > maxResumableUploadSize {
+		models.RespondError(w, http.StatusRequestEntityTooLarge, "Upload exceeds the maximum size")
+		return
+	}
+
+	meta, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		models.RespondError(w, http.StatusBadRequest, "Invalid Upload-Metadata header")
+		return
+	}
+	fileName := meta["filename"]
+	if fileName == "" || fileName != filepath.Base(fileName) || strings.HasPrefix(fileName, ".") {
+		models.RespondError(w, http.StatusBadRequest, "A valid filename is required in Upload-Metadata")
+		return
+	}
+	targetDir, err := cleanUploadDirectory(meta["directory"])
+	if err != nil {
+		models.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Refuse early what the policy would reject once the upload is complete.
+	if err := tc.Files.checkUploadAccess(user, targetDir); err != nil {
+		models.RespondError(w, uploadErrorCode(err), uploadErrorMessage(err))
+		return
+	}
+	if err := tc.Files.checkUploadPolicy(targetDir, fileName, length); err != nil {
+		models.RespondError(w, uploadErrorCode(err), uploadErrorMessage(err))
+		return
+	}
+	var metaMap map[string]interface{}
+	if meta["metadata"] != "" {
+		if err := json.Unmarshal([]byte(meta["metadata"]), &metaMap); err != nil {
+			models.RespondError(w, http.StatusBadRequest, "Invalid metadata JSON")
+			return
+		}
+	}
+	if !tc.Files.checkMetadata(w, targetDir, metaMap) {
+		return
+	}
+
+	sess, err := tc.Stager.Create(models.UploadSession{
+		Username:    user.Username,
+		FileName:    fileName,
+		Directory:   targetDir,
+		ContentType: meta["filetype"],
+		Metadata:    metaMap,
+		Overwrite:   meta["overwrite"] == "true",
+		Length:      length,
+	})
+	if err != nil {
+		log.Printf("Error creating resumable upload for %s: %v", fileName, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error creating upload")
+		return
+	}
+
+	w.Header().Set("Location", "/tus/uploads/"+sess.ID)
+	w.Header().Set("Upload-Expires", sess.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// Head reports how many bytes of an upload the server has.
+func (tc *TusController) Head(w http.ResponseWriter, r *http.Request) {
+	user, ok := tc.begin(w, r)
+	if !ok {
+		return
+	}
+	sess, ok := tc.lookup(w, r, user)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	tc.writeProgress(w, sess)
+	w.WriteHeader(http.StatusOK)
+}
+
+// Patch appends the request body at Upload-Offset. The request that
+// completes the upload also stores the file.
+func (tc *TusController) Patch(w http.ResponseWriter, r *http.Request) {
+	user, ok := tc.begin(w, r)
+	if !ok {
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		models.RespondError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream")
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		models.RespondError(w, http.StatusBadRequest, "Upload-Offset header is required")
+		return
+	}
+
+	sess, unlock, ok := tc.lockSession(w, r, user)
+	if !ok {
+		return
+	}
+	defer unlock()
+	if offset != sess.Offset {
+		models.RespondError(w, http.StatusConflict, fmt.Sprintf("Upload-Offset does not match the current offset %d", sess.Offset))
+		return
+	}
+
+	if sess.CompletedAt == nil && !sess.Complete() {
+		newOffset, err := tc.Stager.Append(sess, r.Body)
+		sess.Offset = newOffset
+		if err != nil {
+			log.Printf("Resumable upload %s interrupted at offset %d: %v", sess.ID, newOffset, err)
+			models.RespondError(w, http.StatusInternalServerError, "Error receiving upload data")
+			return
+		}
+	}
+
+	// A failed finish leaves the data staged; the client retries with an
+	// empty PATCH at the final offset. Rejected content is discarded, since
+	// retrying cannot change the outcome.
+	if sess.CompletedAt == nil && sess.Complete() {
+		fileID, err := tc.finish(r, user, sess)
+		if err != nil {
+			log.Printf("Storing resumable upload %s failed: %v", sess.ID, err)
+			code := uploadErrorCode(err)
+			if code < http.StatusInternalServerError {
+				if derr := tc.Stager.Discard(sess.ID); derr != nil {
+					log.Printf("Error discarding rejected upload %s: %v", sess.ID, derr)
+				}
+			}
+			models.RespondError(w, code, uploadErrorMessage(err))
+			return
+		}
+		now := time.Now()
+		sess.FileID, sess.CompletedAt = fileID, &now
+	}
+
+	tc.writeProgress(w, sess)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Delete abandons an upload and discards the data received so far.
+func (tc *TusController) Delete(w http.ResponseWriter, r *http.Request) {
+	user, ok := tc.begin(w, r)
+	if !ok {
+		return
+	}
+
+	sess, unlock, ok := tc.lockSession(w, r, user)
+	if !ok {
+		return
+	}
+	defer unlock()
+	if err := tc.Stager.Discard(sess.ID); err != nil {
+		log.Printf("Error discarding resumable upload %s: %v", sess.ID, err)
+		models.RespondError(w, http.StatusInternalServerError, "Error discarding upload")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finish stores a complete upload like FileController.Upload and returns the file ID.
+func (tc *TusController) finish(r *http.Request, user models.User, sess models.UploadSession) (int, error) {
+	staged, err := tc.Stager.Open(sess.ID)
+	if err != nil {
+		return 0, err
+	}
+	defer staged.Close()
+
+	res, err := tc.Files.storeUpload(r.Context(), user, uploadRequest{
+		FileName:  sess.FileName,
+		Directory: sess.Directory,
+		Overwrite: sess.Overwrite,
+		Metadata:  sess.Metadata,
+	}, staged)
+	if err != nil {
+		return 0, err
+	}
+	tc.Files.announceUpload(user, res)
+
+	if err := tc.App.CompleteUploadSession(sess.ID, res.FileID); err != nil {
+		log.Printf("Warning: could not mark resumable upload %s complete: %v", sess.ID, err)
+	}
+	if err := tc.Stager.RemoveData(sess.ID); err != nil {
+		log.Printf("Warning: could not remove staged upload %s: %v", sess.ID, err)
+	}
+	return res.FileID, nil
+}
+
+// begin authenticates the request and checks the protocol version. It writes
+// the error response itself.
+func (tc *TusController) begin(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		models.RespondError(w, http.StatusPreconditionFailed, "Unsupported tus protocol version")
+		return models.User{}, false
+	}
+	user, err := tc.App.GetUserFromSession(r)
+	if err != nil {
+		models.RespondError(w, http.StatusUnauthorized, "Not authenticated")
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// lookup loads the upload named in the URL. Uploads of other users are
+// reported as not found.
+func (tc *TusController) lookup(w http.ResponseWriter, r *http.Request, user models.User) (models.UploadSession, bool) {
+	sess, err := tc.App.GetUploadSession(mux.Vars(r)["id"])
+	if err == sql.ErrNoRows || (err == nil && sess.Username != user.Username) {
+		models.RespondError(w, http.StatusNotFound, "Upload not found")
+		return models.UploadSession{}, false
+	}
+	if err != nil {
+		models.RespondError(w, http.StatusInternalServerError, "Error retrieving upload")
+		return models.UploadSession{}, false
+	}
+	if time.Now().After(sess.ExpiresAt) {
+		models.RespondError(w, http.StatusGone, "Upload has expired")
+		return models.UploadSession{}, false
+	}
+	return sess, true
+}
+
+// lockSession looks up the upload of the request and takes its lock; call
+// the returned function to unlock. The upload is looked up before locking,
+// so requests for unknown IDs leave no lock behind, and again once the lock
+// is held, since the request that held it may have changed the upload. It
+// writes the error response itself.
+func (tc *TusController) lockSession(w http.ResponseWriter, r *http.Request, user models.User) (models.UploadSession, func(), bool) {
+	if _, ok := tc.lookup(w, r, user); !ok {
+		return models.UploadSession{}, nil, false
+	}
+	unlock := tc.Stager.Lock(mux.Vars(r)["id"])
+	sess, ok := tc.lookup(w, r, user)
+	if !ok {
+		unlock()
+		return models.UploadSession{}, nil, false
+	}
+	return sess, unlock, true
+}
+
+// writeProgress sets the headers describing an upload's state.
+func (tc *TusController) writeProgress(w http.ResponseWriter, sess models.UploadSession) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(sess.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(sess.Length, 10))
+	if sess.CompletedAt == nil {
+		w.Header().Set("Upload-Expires", sess.ExpiresAt.UTC().Format(http.TimeFormat))
+	} else if sess.FileID > 0 {
+		w.Header().Set("X-File-Id", strconv.Itoa(sess.FileID))
+	}
+}
+
+// parseUploadMetadata decodes a tus Upload-Metadata header: comma-separated
+// "key base64value" pairs, where the value may be omitted.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, err
+		}
+		meta[key] = string(value)
+	}
+	return meta, nil
+}
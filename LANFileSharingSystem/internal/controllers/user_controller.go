@@ -73,6 +73,13 @@ func (uc *UserController) AddUser(w http.ResponseWriter, r *http.Request) {
 		models.RespondError(w, http.StatusBadRequest, msg)
 		return
 	}
+	var department models.Group
+	if req.Department = strings.TrimSpace(req.Department); req.Department != "" {
+		if department, err = uc.App.GetDepartmentGroup(req.Department); err != nil {
+			models.RespondError(w, http.StatusBadRequest, fmt.Sprintf("Unknown department '%s'", req.Department))
+			return
+		}
+	}
 
 	// Use a case-insensitive search to see if the user already exists.
 	_, err = uc.App.GetUserByUsername(req.Username)
@@ -98,8 +105,16 @@ func (uc *UserController) AddUser(w http.ResponseWriter, r *http.Request) {
 		models.RespondError(w, http.StatusInternalServerError, "Error adding user")
 		return
 	}
-
 	uc.App.LogActivity(fmt.Sprintf("Admin '%s' added user '%s'.", user.Username, req.Username))
+	if department.ID != 0 {
+		if _, err := uc.App.AddGroupMembers(department.ID, []string{req.Username}, user.Username); err != nil {
+			log.Printf("Error adding user %s to group %s: %v", req.Username, department.Name, err)
+			models.RespondError(w, http.StatusInternalServerError, fmt.Sprintf("User '%s' was added, but not to the %s group", req.Username, department.Name))
+			return
+		}
+		uc.App.LogActivity(fmt.Sprintf("Admin '%s' added user '%s' to group '%s'.", user.Username, req.Username, department.Name))
+	}
+
 	models.RespondJSON(w, http.StatusOK, map[string]string{
 		"message": fmt.Sprintf("User '%s' has been added successfully", req.Username),
 	})
@@ -1,85 +1,626 @@
 package encryption
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"encoding/binary"
 	"errors"
 	"io"
-	"io/ioutil"
+	"os"
 )
 
-// EncryptFile encrypts the contents of inputFile and writes the output (nonce + ciphertext) to outputFile.
-func EncryptFile(key []byte, inputFile, outputFile string) error {
-	// Read the plaintext file data.
-	plaintext, err := ioutil.ReadFile(inputFile)
-	if err != nil {
-		return err
-	}
+/*
+STREAM FORMAT:
+
+	header v1: magic "LFSE" | version (1 byte) | segment size (uint32, big endian) | nonce prefix (7 bytes)
+	header v2: v1 header | key ID length (1 byte) | key ID | wrapped key length (uint16) | wrapped data key
+	body:      one or more segments, each sealed independently with AES-GCM
+
+Every segment holds up to segmentSize bytes of plaintext followed by its
+16-byte GCM tag. The 12-byte nonce of segment i is built from the random
+nonce prefix, the segment counter i (uint32, big endian) and a final flag
+(1 for the last segment, 0 otherwise). Reordering segments changes the
+counter and cutting the stream off at a segment boundary removes the only
+segment sealed with the final flag, so both are detected as authentication
+failures. The fixed part of the header is passed as additional data to every
+segment.
+
+Version 2 (written by NewEncryptWriter) seals the segments with a random
+per-file data key. The data key is wrapped with the master key named by the
+key ID, so rotating the master key only rewrites the header (see Rewrap).
+Version 1 streams and the original single-blob files (nonce + ciphertext)
+were sealed directly with a master key; they are still readable and are
+upgraded to version 2 when the master key is rotated.
+*/
+
+const (
+	// DefaultSegmentSize is the amount of plaintext sealed per segment.
+	DefaultSegmentSize = 64 * 1024
+
+	magic           = "LFSE"
+	versionDirect   = 1
+	versionEnvelope = 2
+	noncePrefixSize = 7
+	fixedHeaderSize = len(magic) + 1 + 4 + noncePrefixSize
+	maxSegmentSize  = 16 * 1024 * 1024
+	dataKeySize     = 32
+	maxKeyIDLength  = 255
+)
+
+var (
+	// ErrTruncated is returned when a stream ends before its final segment.
+	ErrTruncated = errors.New("encrypted stream is truncated")
+	// ErrAuthentication is returned when a segment fails to authenticate
+	// (wrong key, tampered data, reordered or truncated segments).
+	ErrAuthentication = errors.New("encrypted stream failed authentication")
+	// ErrUnsupportedVersion is returned for headers with an unknown format version.
+	ErrUnsupportedVersion = errors.New("unsupported encryption format version")
+	// ErrUnknownKey is returned when a stream was wrapped with a master key
+	// that is not in the keyring.
+	ErrUnknownKey = errors.New("encrypted stream uses an unknown master key")
+)
 
+func newGCM(key []byte) (cipher.AEAD, error) {
 	// Create a new AES cipher block with the given key.
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
 	// Wrap the block in Galois/Counter Mode (GCM) for authenticated encryption.
-	aesGCM, err := cipher.NewGCM(block)
+	return cipher.NewGCM(block)
+}
+
+// segmentNonce derives the nonce for segment counter from the stream's nonce prefix.
+func segmentNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// -------------------------------------
+//  Headers
+// -------------------------------------
+
+// header is the parsed form of a stream header.
+type header struct {
+	version    byte
+	fixed      []byte // magic .. nonce prefix, authenticated with every segment
+	segSize    uint32
+	keyID      string
+	wrappedKey []byte
+}
+
+func (h *header) prefix() []byte {
+	return h.fixed[fixedHeaderSize-noncePrefixSize:]
+}
+
+func (h *header) bytes() []byte {
+	out := append([]byte{}, h.fixed...)
+	if h.version == versionEnvelope {
+		out = append(out, byte(len(h.keyID)))
+		out = append(out, h.keyID...)
+		out = binary.BigEndian.AppendUint16(out, uint16(len(h.wrappedKey)))
+		out = append(out, h.wrappedKey...)
+	}
+	return out
+}
+
+// isStreamFormat reports whether the buffered source starts with a stream header.
+// Anything else is treated as a legacy single-blob file.
+func isStreamFormat(br *bufio.Reader) (bool, error) {
+	head, err := br.Peek(len(magic))
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	return len(head) == len(magic) && string(head) == magic, nil
+}
+
+// readHeader consumes a v1 or v2 header from br.
+func readHeader(br *bufio.Reader) (*header, error) {
+	fixed := make([]byte, fixedHeaderSize)
+	if _, err := io.ReadFull(br, fixed); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrTruncated
+		}
+		return nil, err
+	}
+
+	h := &header{
+		version: fixed[len(magic)],
+		fixed:   fixed,
+		segSize: binary.BigEndian.Uint32(fixed[len(magic)+1:]),
+	}
+	if h.version != versionDirect && h.version != versionEnvelope {
+		return nil, ErrUnsupportedVersion
+	}
+	if h.segSize == 0 || h.segSize > maxSegmentSize {
+		return nil, errors.New("invalid segment size in encryption header")
+	}
+	if h.version == versionDirect {
+		return h, nil
+	}
+
+	idLen, err := br.ReadByte()
 	if err != nil {
-		return err
+		return nil, ErrTruncated
+	}
+	keyID := make([]byte, idLen)
+	if _, err := io.ReadFull(br, keyID); err != nil {
+		return nil, ErrTruncated
 	}
+	var lenBuf [2]byte
+	if _, err := io.ReadFull(br, lenBuf[:]); err != nil {
+		return nil, ErrTruncated
+	}
+	wrapped := make([]byte, binary.BigEndian.Uint16(lenBuf[:]))
+	if _, err := io.ReadFull(br, wrapped); err != nil {
+		return nil, ErrTruncated
+	}
+	h.keyID = string(keyID)
+	h.wrappedKey = wrapped
+	return h, nil
+}
+
+// HeaderInfo describes how a stored blob is encrypted.
+type HeaderInfo struct {
+	// Version is 0 for legacy single-blob files, otherwise the stream format version.
+	Version int
+	// KeyID names the master key that wraps the data key (version 2 only).
+	KeyID string
+}
 
-	// Generate a random nonce.
-	nonce := make([]byte, aesGCM.NonceSize())
+// Inspect reads just enough of src to describe its encryption header.
+func Inspect(src io.Reader) (HeaderInfo, error) {
+	br := bufio.NewReader(src)
+	stream, err := isStreamFormat(br)
+	if err != nil {
+		return HeaderInfo{}, err
+	}
+	if !stream {
+		return HeaderInfo{Version: 0}, nil
+	}
+	h, err := readHeader(br)
+	if err != nil {
+		return HeaderInfo{}, err
+	}
+	return HeaderInfo{Version: int(h.version), KeyID: h.keyID}, nil
+}
+
+// -------------------------------------
+//  Data Keys
+// -------------------------------------
+
+// wrapKey seals dataKey with the master key, bound to the stream's fixed header.
+func wrapKey(master, dataKey, fixed []byte) ([]byte, error) {
+	aead, err := newGCM(master)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, fixed), nil
+}
+
+// unwrapKey recovers the data key of a version 2 header.
+func unwrapKey(kr *Keyring, h *header) ([]byte, error) {
+	master, ok := kr.Key(h.keyID)
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	aead, err := newGCM(master)
+	if err != nil {
+		return nil, err
+	}
+	if len(h.wrappedKey) < aead.NonceSize() {
+		return nil, ErrAuthentication
+	}
+	nonce, sealed := h.wrappedKey[:aead.NonceSize()], h.wrappedKey[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, sealed, h.fixed)
+	if err != nil {
+		return nil, ErrAuthentication
+	}
+	return dataKey, nil
+}
+
+// newEnvelopeHeader builds a version 2 header around a fresh data key.
+func newEnvelopeHeader(kr *Keyring) (*header, []byte, error) {
+	fixed := make([]byte, fixedHeaderSize)
+	copy(fixed, magic)
+	fixed[len(magic)] = versionEnvelope
+	binary.BigEndian.PutUint32(fixed[len(magic)+1:], DefaultSegmentSize)
+	if _, err := io.ReadFull(rand.Reader, fixed[fixedHeaderSize-noncePrefixSize:]); err != nil {
+		return nil, nil, err
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, nil, err
+	}
+
+	keyID, master := kr.Current()
+	wrapped, err := wrapKey(master, dataKey, fixed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &header{
+		version:    versionEnvelope,
+		fixed:      fixed,
+		segSize:    DefaultSegmentSize,
+		keyID:      keyID,
+		wrappedKey: wrapped,
+	}, dataKey, nil
+}
+
+// -------------------------------------
+//  Encryption
+// -------------------------------------
+
+type encryptWriter struct {
+	aead    cipher.AEAD
+	dst     io.Writer
+	fixed   []byte
+	prefix  []byte
+	buf     []byte
+	counter uint32
+	closed  bool
+	err     error
+}
+
+// NewEncryptWriter returns a writer that encrypts everything written to it
+// into dst using a fresh data key wrapped by the keyring's current master key.
+// Close must be called to seal the final segment; it does not close dst.
+func NewEncryptWriter(kr *Keyring, dst io.Writer) (io.WriteCloser, error) {
+	h, dataKey, err := newEnvelopeHeader(kr)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := dst.Write(h.bytes()); err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{
+		aead:   aead,
+		dst:    dst,
+		fixed:  h.fixed,
+		prefix: h.prefix(),
+		buf:    make([]byte, 0, DefaultSegmentSize),
+	}, nil
+}
+
+func (ew *encryptWriter) Write(p []byte) (int, error) {
+	if ew.closed {
+		return 0, errors.New("write to closed encryption writer")
+	}
+	if ew.err != nil {
+		return 0, ew.err
+	}
+
+	written := 0
+	for len(p) > 0 {
+		// A full buffer is only sealed once we know more data follows,
+		// otherwise it would have to be the final segment.
+		if len(ew.buf) == cap(ew.buf) {
+			if err := ew.flush(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(ew.buf[len(ew.buf):cap(ew.buf)], p)
+		ew.buf = ew.buf[:len(ew.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (ew *encryptWriter) flush(last bool) error {
+	if !last && ew.counter == ^uint32(0) {
+		ew.err = errors.New("encrypted stream exceeds maximum number of segments")
+		return ew.err
+	}
+	nonce := segmentNonce(ew.prefix, ew.counter, last)
+	sealed := ew.aead.Seal(nil, nonce, ew.buf, ew.fixed)
+	if _, err := ew.dst.Write(sealed); err != nil {
+		ew.err = err
 		return err
 	}
+	ew.buf = ew.buf[:0]
+	ew.counter++
+	return nil
+}
+
+// Close seals the remaining buffered plaintext as the final segment.
+func (ew *encryptWriter) Close() error {
+	if ew.closed {
+		return nil
+	}
+	ew.closed = true
+	if ew.err != nil {
+		return ew.err
+	}
+	return ew.flush(true)
+}
 
-	// Encrypt the plaintext.
-	ciphertext := aesGCM.Seal(nil, nonce, plaintext, nil)
+// EncryptStream encrypts everything read from src into dst.
+func EncryptStream(kr *Keyring, dst io.Writer, src io.Reader) error {
+	ew, err := NewEncryptWriter(kr, dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(ew, src); err != nil {
+		return err
+	}
+	return ew.Close()
+}
 
-	// Prepend the nonce to the ciphertext so that it can be used for decryption.
-	finalData := append(nonce, ciphertext...)
+// -------------------------------------
+//  Decryption
+// -------------------------------------
 
-	// Write the encrypted data to the output file.
-	return ioutil.WriteFile(outputFile, finalData, 0644)
+type decryptReader struct {
+	// candidates holds every AEAD that may have sealed the stream. Version 2
+	// streams know their data key up front; version 1 streams try each master
+	// key on the first segment and keep the one that authenticates.
+	candidates []cipher.AEAD
+	src        *bufio.Reader
+	fixed      []byte
+	prefix     []byte
+	cbuf       []byte
+	plain      []byte
+	counter    uint32
+	done       bool
+	err        error
 }
 
-// DecryptFile decrypts the contents of inputFile (expects nonce + ciphertext)
-// and writes the decrypted plaintext to outputFile.
-func DecryptFile(key []byte, inputFile, outputFile string) error {
-	// Read the encrypted file data.
-	data, err := ioutil.ReadFile(inputFile)
+// NewDecryptReader returns a reader that yields the plaintext of src.
+// Streams are decrypted one segment at a time; legacy single-blob files are
+// detected by their missing header and decrypted in one go.
+func NewDecryptReader(kr *Keyring, src io.Reader) (io.Reader, error) {
+	br := bufio.NewReaderSize(src, DefaultSegmentSize+64)
+	stream, err := isStreamFormat(br)
 	if err != nil {
+		return nil, err
+	}
+	if !stream {
+		return decryptLegacy(kr, br)
+	}
+
+	h, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []cipher.AEAD
+	if h.version == versionEnvelope {
+		dataKey, err := unwrapKey(kr, h)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := newGCM(dataKey)
+		if err != nil {
+			return nil, err
+		}
+		candidates = []cipher.AEAD{aead}
+	} else {
+		for _, key := range kr.all() {
+			aead, err := newGCM(key)
+			if err != nil {
+				return nil, err
+			}
+			candidates = append(candidates, aead)
+		}
+	}
+
+	return &decryptReader{
+		candidates: candidates,
+		src:        br,
+		fixed:      h.fixed,
+		prefix:     h.prefix(),
+		cbuf:       make([]byte, int(h.segSize)+candidates[0].Overhead()),
+	}, nil
+}
+
+// decryptLegacy handles the original format: nonce followed by a single sealed blob.
+func decryptLegacy(kr *Keyring, src io.Reader) (io.Reader, error) {
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range kr.all() {
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		nonceSize := aead.NonceSize()
+		if len(data) < nonceSize {
+			return nil, errors.New("ciphertext too short")
+		}
+
+		// Extract the nonce and ciphertext.
+		nonce, ciphertext := data[:nonceSize], data[nonceSize:]
+		if plaintext, err := aead.Open(nil, nonce, ciphertext, nil); err == nil {
+			return bytes.NewReader(plaintext), nil
+		}
+	}
+	return nil, ErrAuthentication
+}
+
+func (dr *decryptReader) Read(p []byte) (int, error) {
+	for len(dr.plain) == 0 {
+		if dr.err != nil {
+			return 0, dr.err
+		}
+		if dr.done {
+			return 0, io.EOF
+		}
+		dr.err = dr.nextSegment()
+	}
+	n := copy(p, dr.plain)
+	dr.plain = dr.plain[n:]
+	return n, nil
+}
+
+func (dr *decryptReader) nextSegment() error {
+	n, err := io.ReadFull(dr.src, dr.cbuf)
+	last := false
+	switch {
+	case err == io.EOF:
+		// The previous segment was not marked final, so data is missing.
+		return ErrTruncated
+	case err == io.ErrUnexpectedEOF:
+		last = true
+	case err != nil:
 		return err
+	default:
+		if _, perr := dr.src.Peek(1); perr == io.EOF {
+			last = true
+		} else if perr != nil {
+			return perr
+		}
+	}
+	if n < dr.candidates[0].Overhead() {
+		return ErrTruncated
 	}
 
-	// Create a new AES cipher block with the given key.
-	block, err := aes.NewCipher(key)
+	nonce := segmentNonce(dr.prefix, dr.counter, last)
+	sealed := dr.cbuf[:n]
+	if len(dr.candidates) > 1 {
+		// A failed Open may clobber its destination, so keep the
+		// ciphertext intact while several keys are still being tried.
+		sealed = append([]byte{}, sealed...)
+	}
+	for _, aead := range dr.candidates {
+		plain, err := aead.Open(dr.cbuf[:0], nonce, sealed, dr.fixed)
+		if err != nil {
+			continue
+		}
+		dr.candidates = []cipher.AEAD{aead}
+		dr.plain = plain
+		dr.counter++
+		dr.done = last
+		return nil
+	}
+	return ErrAuthentication
+}
+
+// DecryptStream decrypts src (any supported format) into dst.
+func DecryptStream(kr *Keyring, dst io.Writer, src io.Reader) error {
+	dr, err := NewDecryptReader(kr, src)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, dr)
+	return err
+}
+
+// -------------------------------------
+//  Master Key Rotation
+// -------------------------------------
+
+// Rewrap copies the encrypted blob src into dst so that it is protected by
+// to's current master key. Version 2 blobs only get a new header: the data key
+// is unwrapped with from and wrapped again, and the sealed segments are copied
+// byte for byte. Version 1 and legacy blobs have no data key and are decrypted
+// with from and re-encrypted with to.
+func Rewrap(from, to *Keyring, dst io.Writer, src io.Reader) error {
+	br := bufio.NewReaderSize(src, DefaultSegmentSize+64)
+	stream, err := isStreamFormat(br)
+	if err != nil {
+		return err
+	}
+	if !stream {
+		return reencrypt(from, to, dst, br)
+	}
+
+	h, err := readHeader(br)
+	if err != nil {
+		return err
+	}
+	if h.version != versionEnvelope {
+		return reencrypt(from, to, dst, io.MultiReader(bytes.NewReader(h.bytes()), br))
+	}
+
+	dataKey, err := unwrapKey(from, h)
+	if err != nil {
+		return err
+	}
+	keyID, master := to.Current()
+	wrapped, err := wrapKey(master, dataKey, h.fixed)
 	if err != nil {
 		return err
 	}
+	h.keyID = keyID
+	h.wrappedKey = wrapped
+
+	if _, err := dst.Write(h.bytes()); err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, br)
+	return err
+}
 
-	// Wrap the block in GCM mode.
-	aesGCM, err := cipher.NewGCM(block)
+func reencrypt(from, to *Keyring, dst io.Writer, src io.Reader) error {
+	dr, err := NewDecryptReader(from, src)
 	if err != nil {
 		return err
 	}
+	return EncryptStream(to, dst, dr)
+}
 
-	nonceSize := aesGCM.NonceSize()
-	if len(data) < nonceSize {
-		return errors.New("ciphertext too short")
+// -------------------------------------
+//  File Helpers
+// -------------------------------------
+
+// EncryptFile encrypts the contents of inputFile and writes the stream format to outputFile.
+func EncryptFile(kr *Keyring, inputFile, outputFile string) error {
+	in, err := os.Open(inputFile)
+	if err != nil {
+		return err
 	}
+	defer in.Close()
 
-	// Extract the nonce and ciphertext.
-	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
+	out, err := os.OpenFile(outputFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if err := EncryptStream(kr, out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
 
-	// Decrypt the ciphertext.
-	plaintext, err := aesGCM.Open(nil, nonce, ciphertext, nil)
+// DecryptFile decrypts the contents of inputFile (any supported format)
+// and writes the decrypted plaintext to outputFile.
+func DecryptFile(kr *Keyring, inputFile, outputFile string) error {
+	in, err := os.Open(inputFile)
 	if err != nil {
 		return err
 	}
+	defer in.Close()
 
-	// Write the decrypted data to the output file.
-	return ioutil.WriteFile(outputFile, plaintext, 0644)
+	out, err := os.OpenFile(outputFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if err := DecryptStream(kr, out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
 }
@@ -0,0 +1,254 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	mrand "math/rand"
+	"testing"
+)
+
+func testKeyring(t *testing.T) *Keyring {
+	t.Helper()
+	kr, err := NewKeyring("test", bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	return kr
+}
+
+func plaintext(n int) []byte {
+	b := make([]byte, n)
+	mrand.New(mrand.NewSource(int64(n))).Read(b)
+	return b
+}
+
+func encrypt(t *testing.T, kr *Keyring, plain []byte) []byte {
+	t.Helper()
+	var ct bytes.Buffer
+	if err := EncryptStream(kr, &ct, bytes.NewReader(plain)); err != nil {
+		t.Fatalf("EncryptStream: %v", err)
+	}
+	return ct.Bytes()
+}
+
+func decrypt(kr *Keyring, ct []byte) ([]byte, error) {
+	var out bytes.Buffer
+	err := DecryptStream(kr, &out, bytes.NewReader(ct))
+	return out.Bytes(), err
+}
+
+// parseStream returns the header of a stream and its sealed segments.
+func parseStream(t *testing.T, ct []byte) (*header, [][]byte) {
+	t.Helper()
+	h, err := readHeader(bufio.NewReader(bytes.NewReader(ct)))
+	if err != nil {
+		t.Fatalf("readHeader: %v", err)
+	}
+	body := ct[len(h.bytes()):]
+	stride := int(h.segSize) + 16
+	var segments [][]byte
+	for len(body) > stride {
+		segments = append(segments, body[:stride])
+		body = body[stride:]
+	}
+	return h, append(segments, body)
+}
+
+func joinStream(h *header, segments [][]byte) []byte {
+	out := h.bytes()
+	for _, s := range segments {
+		out = append(out, s...)
+	}
+	return out
+}
+
+func TestRoundTrip(t *testing.T) {
+	kr := testKeyring(t)
+	for _, n := range []int{
+		0, 1,
+		DefaultSegmentSize - 1, DefaultSegmentSize, DefaultSegmentSize + 1,
+		2*DefaultSegmentSize - 1, 2 * DefaultSegmentSize, 2*DefaultSegmentSize + 1,
+	} {
+		plain := plaintext(n)
+		ct := encrypt(t, kr, plain)
+
+		// The final segment is full for exact multiples of the segment
+		// size and empty only for an empty file.
+		_, segments := parseStream(t, ct)
+		if want := max(1, (n+DefaultSegmentSize-1)/DefaultSegmentSize); len(segments) != want {
+			t.Errorf("%d bytes: %d segments, want %d", n, len(segments), want)
+		}
+
+		got, err := decrypt(kr, ct)
+		if err != nil {
+			t.Errorf("%d bytes: decrypt: %v", n, err)
+			continue
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("%d bytes: plaintext does not round-trip", n)
+		}
+	}
+}
+
+func TestSwappedSegmentsFail(t *testing.T) {
+	kr := testKeyring(t)
+	h, segments := parseStream(t, encrypt(t, kr, plaintext(3*DefaultSegmentSize+100)))
+	segments[0], segments[1] = segments[1], segments[0]
+
+	if _, err := decrypt(kr, joinStream(h, segments)); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("decrypt with swapped segments: got %v, want ErrAuthentication", err)
+	}
+}
+
+func TestDroppedFinalSegmentFails(t *testing.T) {
+	kr := testKeyring(t)
+	for _, n := range []int{2*DefaultSegmentSize + 100, 2 * DefaultSegmentSize} {
+		h, segments := parseStream(t, encrypt(t, kr, plaintext(n)))
+		truncated := joinStream(h, segments[:len(segments)-1])
+
+		_, err := decrypt(kr, truncated)
+		if !errors.Is(err, ErrAuthentication) && !errors.Is(err, ErrTruncated) {
+			t.Errorf("%d bytes: decrypt without the final segment: got %v, want an authentication or truncation error", n, err)
+		}
+	}
+}
+
+func TestFlippedFinalFlagFails(t *testing.T) {
+	kr := testKeyring(t)
+	plain := plaintext(DefaultSegmentSize + 10)
+	h, segments := parseStream(t, encrypt(t, kr, plain))
+	dataKey, err := unwrapKey(kr, h)
+	if err != nil {
+		t.Fatalf("unwrapKey: %v", err)
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		t.Fatalf("newGCM: %v", err)
+	}
+
+	// Reseal the final segment as if more segments followed it.
+	last := len(segments) - 1
+	finalPlain, err := aead.Open(nil, segmentNonce(h.prefix(), uint32(last), true), segments[last], h.fixed)
+	if err != nil {
+		t.Fatalf("open final segment: %v", err)
+	}
+	segments[last] = aead.Seal(nil, segmentNonce(h.prefix(), uint32(last), false), finalPlain, h.fixed)
+
+	if _, err := decrypt(kr, joinStream(h, segments)); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("decrypt with the final flag cleared: got %v, want ErrAuthentication", err)
+	}
+}
+
+func TestLegacyBlob(t *testing.T) {
+	kr := testKeyring(t)
+	_, master := kr.Current()
+	aead, err := newGCM(master)
+	if err != nil {
+		t.Fatalf("newGCM: %v", err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		t.Fatalf("nonce: %v", err)
+	}
+	plain := plaintext(1000)
+	blob := aead.Seal(append([]byte{}, nonce...), nonce, plain, nil)
+
+	got, err := decrypt(kr, blob)
+	if err != nil {
+		t.Fatalf("decrypt legacy blob: %v", err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatal("legacy plaintext does not round-trip")
+	}
+
+	open := func(offset int64) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(blob[offset:])), nil
+	}
+	if _, err := NewSeekableReader(kr, int64(len(blob)), open); !errors.Is(err, ErrNotSeekable) {
+		t.Fatalf("NewSeekableReader on a legacy blob: got %v, want ErrNotSeekable", err)
+	}
+}
+
+func TestSeekableReader(t *testing.T) {
+	kr := testKeyring(t)
+	plain := plaintext(3*DefaultSegmentSize + 123)
+	ct := encrypt(t, kr, plain)
+
+	opens := 0
+	open := func(offset int64) (io.ReadCloser, error) {
+		opens++
+		return io.NopCloser(bytes.NewReader(ct[offset:])), nil
+	}
+	sr, err := NewSeekableReader(kr, int64(len(ct)), open)
+	if err != nil {
+		t.Fatalf("NewSeekableReader: %v", err)
+	}
+	defer sr.Close()
+
+	if sr.Size() != int64(len(plain)) {
+		t.Fatalf("Size = %d, want %d", sr.Size(), len(plain))
+	}
+
+	all, err := io.ReadAll(sr)
+	if err != nil {
+		t.Fatalf("read all: %v", err)
+	}
+	if !bytes.Equal(all, plain) {
+		t.Fatal("sequential read does not match the plaintext")
+	}
+	if opens != 1 {
+		t.Errorf("sequential read opened the ciphertext %d times, want 1", opens)
+	}
+
+	for _, c := range []struct {
+		offset int64
+		length int
+	}{
+		{DefaultSegmentSize - 10, 20},                    // across one boundary
+		{DefaultSegmentSize - 1, DefaultSegmentSize + 2}, // across two boundaries
+		{2*DefaultSegmentSize + 5, 100},
+		{0, 1},
+		{int64(len(plain)) - 5, 5}, // the end of the final segment
+	} {
+		if _, err := sr.Seek(c.offset, io.SeekStart); err != nil {
+			t.Fatalf("Seek(%d): %v", c.offset, err)
+		}
+		got := make([]byte, c.length)
+		if _, err := io.ReadFull(sr, got); err != nil {
+			t.Fatalf("read %d bytes at %d: %v", c.length, c.offset, err)
+		}
+		if want := plain[c.offset : c.offset+int64(c.length)]; !bytes.Equal(got, want) {
+			t.Errorf("read %d bytes at %d: content does not match", c.length, c.offset)
+		}
+	}
+
+	if _, err := sr.Seek(-3, io.SeekEnd); err != nil {
+		t.Fatalf("Seek from end: %v", err)
+	}
+	tail, err := io.ReadAll(sr)
+	if err != nil {
+		t.Fatalf("read tail: %v", err)
+	}
+	if !bytes.Equal(tail, plain[len(plain)-3:]) {
+		t.Error("read from the end does not match")
+	}
+}
+
+func TestParseKeyList(t *testing.T) {
+	keys, err := ParseKeyList(" old:aaaa , older:bbbb,")
+	if err != nil {
+		t.Fatalf("ParseKeyList: %v", err)
+	}
+	if len(keys) != 2 || string(keys["old"]) != "aaaa" || string(keys["older"]) != "bbbb" {
+		t.Fatalf("ParseKeyList = %q", keys)
+	}
+	if _, err := ParseKeyList("old:aaaa,old:bbbb"); err == nil {
+		t.Fatal("ParseKeyList accepted a duplicate key ID")
+	}
+	if _, err := ParseKeyList("nocolon"); err == nil {
+		t.Fatal("ParseKeyList accepted an entry without an ID")
+	}
+}
@@ -0,0 +1,90 @@
+package encryption
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Keyring holds the master keys that wrap per-file data keys.
+// New blobs are always wrapped with the current key; older keys are kept so
+// blobs wrapped before a rotation can still be opened.
+type Keyring struct {
+	currentID string
+	keys      map[string][]byte
+}
+
+// NewKeyring creates a keyring whose current master key is key, identified by id.
+func NewKeyring(id string, key []byte) (*Keyring, error) {
+	kr := &Keyring{keys: make(map[string][]byte)}
+	if err := kr.Add(id, key); err != nil {
+		return nil, err
+	}
+	kr.currentID = id
+	return kr, nil
+}
+
+// Add registers an additional (older) master key.
+func (kr *Keyring) Add(id string, key []byte) error {
+	if id == "" || len(id) > maxKeyIDLength {
+		return fmt.Errorf("invalid key ID %q", id)
+	}
+	if len(key) != 32 {
+		return fmt.Errorf("master key %q must be 32 bytes, got %d", id, len(key))
+	}
+	if _, exists := kr.keys[id]; exists {
+		return fmt.Errorf("duplicate key ID %q", id)
+	}
+	kr.keys[id] = append([]byte{}, key...)
+	return nil
+}
+
+// Current returns the ID and value of the key used for new blobs.
+func (kr *Keyring) Current() (string, []byte) {
+	return kr.currentID, kr.keys[kr.currentID]
+}
+
+// Key looks up a master key by ID.
+func (kr *Keyring) Key(id string) ([]byte, bool) {
+	key, ok := kr.keys[id]
+	return key, ok
+}
+
+// all returns every master key, current first, for blobs that carry no key ID.
+func (kr *Keyring) all() [][]byte {
+	ids := make([]string, 0, len(kr.keys))
+	for id := range kr.keys {
+		if id != kr.currentID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	keys := [][]byte{kr.keys[kr.currentID]}
+	for _, id := range ids {
+		keys = append(keys, kr.keys[id])
+	}
+	return keys
+}
+
+// ParseKeyList parses "id:key,id:key" pairs as used by ENCRYPTION_PREVIOUS_KEYS.
+// Each ID may appear only once.
+func ParseKeyList(list string) (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, key, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("key entry %q is not in id:key form", entry)
+		}
+		id = strings.TrimSpace(id)
+		if _, exists := keys[id]; exists {
+			return nil, fmt.Errorf("duplicate key ID %q", id)
+		}
+		keys[id] = []byte(key)
+	}
+	return keys, nil
+}
@@ -0,0 +1,212 @@
+package encryption
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"errors"
+	"io"
+)
+
+// ErrNotSeekable is returned by NewSeekableReader for legacy single-blob
+// files, which can only be decrypted as a whole.
+var ErrNotSeekable = errors.New("encrypted blob does not support random access")
+
+// OpenAtFunc opens the ciphertext of a blob starting offset bytes in.
+type OpenAtFunc func(offset int64) (io.ReadCloser, error)
+
+// SeekableReader decrypts a version 1 or 2 stream with random access. The
+// segment holding the read position is located from the segment size, so a
+// read at any offset only decrypts the segments it touches. Sequential reads
+// share one open ciphertext stream; a seek elsewhere reopens it at the
+// target segment.
+type SeekableReader struct {
+	open       OpenAtFunc
+	candidates []cipher.AEAD
+	fixed      []byte
+	prefix     []byte
+
+	headerLen int64
+	segSize   int64
+	stride    int64 // ciphertext bytes per full segment
+	segments  int64
+	lastLen   int64 // ciphertext bytes in the final segment
+	size      int64 // plaintext bytes
+
+	pos   int64
+	src   io.ReadCloser
+	br    *bufio.Reader
+	next  int64 // segment src is positioned at
+	cur   int64 // segment held in plain, -1 if none
+	plain []byte
+	cbuf  []byte
+}
+
+// NewSeekableReader reads the header of a blob of ciphertextSize bytes and
+// returns a reader over its plaintext.
+func NewSeekableReader(kr *Keyring, ciphertextSize int64, open OpenAtFunc) (*SeekableReader, error) {
+	src, err := open(0)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReaderSize(src, DefaultSegmentSize+64)
+	sr, err := newSeekableReader(kr, ciphertextSize, open, br)
+	if err != nil {
+		src.Close()
+		return nil, err
+	}
+	sr.src, sr.br = src, br
+	return sr, nil
+}
+
+func newSeekableReader(kr *Keyring, ciphertextSize int64, open OpenAtFunc, br *bufio.Reader) (*SeekableReader, error) {
+	stream, err := isStreamFormat(br)
+	if err != nil {
+		return nil, err
+	}
+	if !stream {
+		return nil, ErrNotSeekable
+	}
+	h, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []cipher.AEAD
+	if h.version == versionEnvelope {
+		dataKey, err := unwrapKey(kr, h)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := newGCM(dataKey)
+		if err != nil {
+			return nil, err
+		}
+		candidates = []cipher.AEAD{aead}
+	} else {
+		for _, key := range kr.all() {
+			aead, err := newGCM(key)
+			if err != nil {
+				return nil, err
+			}
+			candidates = append(candidates, aead)
+		}
+	}
+
+	overhead := int64(candidates[0].Overhead())
+	sr := &SeekableReader{
+		open:       open,
+		candidates: candidates,
+		fixed:      h.fixed,
+		prefix:     h.prefix(),
+		headerLen:  int64(len(h.bytes())),
+		segSize:    int64(h.segSize),
+		stride:     int64(h.segSize) + overhead,
+		cur:        -1,
+	}
+
+	// Every stream ends with a sealed final segment, possibly empty.
+	body := ciphertextSize - sr.headerLen
+	if body < overhead {
+		return nil, ErrTruncated
+	}
+	sr.segments = (body + sr.stride - 1) / sr.stride
+	sr.lastLen = body - (sr.segments-1)*sr.stride
+	if sr.lastLen < overhead {
+		return nil, ErrTruncated
+	}
+	sr.size = (sr.segments-1)*sr.segSize + sr.lastLen - overhead
+	sr.cbuf = make([]byte, sr.stride)
+	return sr, nil
+}
+
+// Size returns the length of the plaintext.
+func (sr *SeekableReader) Size() int64 {
+	return sr.size
+}
+
+func (sr *SeekableReader) Read(p []byte) (int, error) {
+	if sr.pos >= sr.size {
+		return 0, io.EOF
+	}
+	seg := sr.pos / sr.segSize
+	if seg != sr.cur {
+		if err := sr.load(seg); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, sr.plain[sr.pos-seg*sr.segSize:])
+	sr.pos += int64(n)
+	return n, nil
+}
+
+// Seek sets the plaintext offset of the next Read.
+func (sr *SeekableReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += sr.pos
+	case io.SeekEnd:
+		offset += sr.size
+	default:
+		return 0, errors.New("encryption: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("encryption: negative position")
+	}
+	sr.pos = offset
+	return offset, nil
+}
+
+// Close releases the underlying ciphertext stream.
+func (sr *SeekableReader) Close() error {
+	if sr.src == nil {
+		return nil
+	}
+	err := sr.src.Close()
+	sr.src, sr.br = nil, nil
+	return err
+}
+
+// load decrypts segment seg into sr.plain.
+func (sr *SeekableReader) load(seg int64) error {
+	if sr.src == nil || sr.next != seg {
+		sr.Close()
+		src, err := sr.open(sr.headerLen + seg*sr.stride)
+		if err != nil {
+			return err
+		}
+		sr.src, sr.br, sr.next = src, bufio.NewReaderSize(src, DefaultSegmentSize+64), seg
+	}
+
+	last := seg == sr.segments-1
+	n := sr.stride
+	if last {
+		n = sr.lastLen
+	}
+	if _, err := io.ReadFull(sr.br, sr.cbuf[:n]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrTruncated
+		}
+		return err
+	}
+	sr.next++
+
+	nonce := segmentNonce(sr.prefix, uint32(seg), last)
+	sealed := sr.cbuf[:n]
+	if len(sr.candidates) > 1 {
+		// Keep the ciphertext intact while several keys are still being tried.
+		sealed = append([]byte{}, sealed...)
+	}
+	for _, aead := range sr.candidates {
+		plain, err := aead.Open(sr.cbuf[:0], nonce, sealed, sr.fixed)
+		if err != nil {
+			continue
+		}
+		sr.candidates = []cipher.AEAD{aead}
+		sr.plain = plain
+		sr.cur = seg
+		return nil
+	}
+	sr.cur = -1
+	return ErrAuthentication
+}
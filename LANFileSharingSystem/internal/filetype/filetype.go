@@ -0,0 +1,200 @@
+// Package filetype identifies uploaded files from their content rather than
+// from the name or the MIME type a client claims. Office documents are told
+// apart by looking inside their containers: the parts listed in an OOXML
+// package and the streams of an OLE compound file.
+package filetype
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// MIME types of the formats the repository cares about.
+const (
+	PDF  = "application/pdf"
+	Doc  = "application/msword"
+	Docx = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	Xls  = "application/vnd.ms-excel"
+	Xlsx = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	Ppt  = "application/vnd.ms-powerpoint"
+	Pptx = "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+	Zip  = "application/zip"
+	OLE  = "application/x-ole-storage"
+
+	// encryptedOOXML is a password-protected OOXML document, which is stored
+	// in an OLE container that does not reveal whether it holds a document,
+	// workbook or presentation.
+	encryptedOOXML = "application/x-encrypted-ooxml"
+)
+
+var (
+	// ErrMismatch is returned when the content is not what the extension says.
+	ErrMismatch = errors.New("file content does not match its extension")
+	// ErrUnknownExtension is returned for extensions without a known format.
+	ErrUnknownExtension = errors.New("unknown file extension")
+)
+
+// extensionTypes lists the detected types accepted for each extension. The
+// first entry is the type recorded for files with that extension.
+var extensionTypes = map[string][]string{
+	".pdf":  {PDF},
+	".doc":  {Doc},
+	".docx": {Docx, encryptedOOXML},
+	".xls":  {Xls},
+	".xlsx": {Xlsx, encryptedOOXML},
+	".ppt":  {Ppt},
+	".pptx": {Pptx, encryptedOOXML},
+	".jpg":  {"image/jpeg"},
+	".jpeg": {"image/jpeg"},
+	".png":  {"image/png"},
+	".gif":  {"image/gif"},
+	".webp": {"image/webp"},
+	".bmp":  {"image/bmp"},
+	".mp4":  {"video/mp4"},
+	".txt":  {"text/plain"},
+	".csv":  {"text/plain", "text/csv"},
+	".zip":  {Zip},
+}
+
+// ForExtension returns the MIME type recorded for files with extension ext
+// (including the dot), and whether their content can be identified at all.
+func ForExtension(ext string) (string, bool) {
+	types, ok := extensionTypes[strings.ToLower(ext)]
+	if !ok {
+		return "", false
+	}
+	return types[0], true
+}
+
+// sniffLen is how much of the start of a file is examined.
+const sniffLen = 1024
+
+// Identify detects the type of the size bytes in r and checks that it
+// matches the extension of fileName. It returns the MIME type to record for
+// the file.
+func Identify(fileName string, r io.ReaderAt, size int64) (string, error) {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	accepted, ok := extensionTypes[ext]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownExtension, ext)
+	}
+	detected, err := Detect(r, size)
+	if err != nil {
+		return "", err
+	}
+	for _, t := range accepted {
+		if t == detected {
+			return accepted[0], nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s content in a %s file", ErrMismatch, detected, ext)
+}
+
+// Detect returns the MIME type of the size bytes in r, or
+// "application/octet-stream" when the format is not recognized.
+func Detect(r io.ReaderAt, size int64) (string, error) {
+	head := make([]byte, sniffLen)
+	n, err := r.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, oleSignature):
+		return detectOLE(r, size)
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		return detectZip(r, size)
+	case isPDF(head):
+		return PDF, nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		return "application/octet-stream", nil
+	}
+	return mediaType, nil
+}
+
+// isPDF reports whether head starts with a PDF header. Only a byte order
+// mark and whitespace may come before it, so that text which merely
+// mentions the header is not taken for a PDF.
+func isPDF(head []byte) bool {
+	head = bytes.TrimPrefix(head, []byte("\xEF\xBB\xBF"))
+	head = bytes.TrimLeft(head, " \t\r\n\f")
+	return bytes.HasPrefix(head, []byte("%PDF-"))
+}
+
+// ooxmlMainParts maps the content type of an OOXML package's main part to
+// the type of the document.
+var ooxmlMainParts = map[string]string{
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml":   Docx,
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml":         Xlsx,
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation.main+xml": Pptx,
+}
+
+// maxContentTypesSize bounds how much of [Content_Types].xml is read.
+const maxContentTypesSize = 1 << 20
+
+// detectZip tells OOXML documents from other zip archives by the content
+// types their packages declare.
+func detectZip(r io.ReaderAt, size int64) (string, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return "application/octet-stream", nil
+	}
+	for _, f := range zr.File {
+		if f.Name != "[Content_Types].xml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return Zip, nil
+		}
+		defer rc.Close()
+
+		var types struct {
+			Overrides []struct {
+				ContentType string `xml:"ContentType,attr"`
+			} `xml:"Override"`
+		}
+		if err := xml.NewDecoder(io.LimitReader(rc, maxContentTypesSize)).Decode(&types); err != nil {
+			return Zip, nil
+		}
+		for _, o := range types.Overrides {
+			if t, ok := ooxmlMainParts[o.ContentType]; ok {
+				return t, nil
+			}
+		}
+		return Zip, nil
+	}
+	return Zip, nil
+}
+
+// detectOLE tells the legacy Office formats apart by the streams in the
+// compound file.
+func detectOLE(r io.ReaderAt, size int64) (string, error) {
+	names, err := oleStreamNames(r, size)
+	if err != nil {
+		return OLE, nil
+	}
+	switch {
+	case names["WordDocument"]:
+		return Doc, nil
+	case names["Workbook"] || names["Book"]:
+		return Xls, nil
+	case names["PowerPoint Document"]:
+		return Ppt, nil
+	case names["EncryptedPackage"]:
+		return encryptedOOXML, nil
+	}
+	return OLE, nil
+}
@@ -0,0 +1,201 @@
+package filetype
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"unicode/utf16"
+)
+
+// compoundFile builds a minimal OLE compound file with 512-byte sectors:
+// the header, one FAT sector and one directory sector holding the root
+// entry and a stream named stream.
+func compoundFile(t *testing.T, stream string) []byte {
+	t.Helper()
+	const (
+		endOfChain = 0xFFFFFFFE
+		fatSect    = 0xFFFFFFFD
+		freeSect   = 0xFFFFFFFF
+	)
+	b := make([]byte, 3*512)
+	le := binary.LittleEndian
+
+	header := b[:512]
+	copy(header, oleSignature)
+	le.PutUint16(header[0x18:], 0x3E) // minor version
+	le.PutUint16(header[0x1A:], 3)    // major version
+	le.PutUint16(header[0x1C:], 0xFFFE)
+	le.PutUint16(header[0x1E:], 9) // 512-byte sectors
+	le.PutUint16(header[0x20:], 6) // 64-byte mini sectors
+	le.PutUint32(header[0x2C:], 1) // one FAT sector
+	le.PutUint32(header[0x30:], 1) // the directory starts at sector 1
+	le.PutUint32(header[0x38:], 4096)
+	le.PutUint32(header[0x3C:], endOfChain)
+	le.PutUint32(header[0x44:], endOfChain)
+	for i := 0; i < oleHeaderDIFATLen; i++ {
+		le.PutUint32(header[0x4C+4*i:], freeSect)
+	}
+	le.PutUint32(header[0x4C:], 0) // the FAT is sector 0
+
+	fat := b[512:1024]
+	for i := 0; i < 128; i++ {
+		le.PutUint32(fat[4*i:], freeSect)
+	}
+	le.PutUint32(fat[0:], fatSect)
+	le.PutUint32(fat[4:], endOfChain)
+
+	dir := b[1024:]
+	entry := func(e []byte, name string, objectType byte) {
+		units := utf16.Encode([]rune(name))
+		if len(units) > 31 {
+			t.Fatalf("stream name %q is too long", name)
+		}
+		for i, u := range units {
+			le.PutUint16(e[2*i:], u)
+		}
+		le.PutUint16(e[0x40:], uint16(2*len(units)+2))
+		e[0x42] = objectType
+		le.PutUint32(e[0x44:], freeSect) // left sibling
+		le.PutUint32(e[0x48:], freeSect) // right sibling
+		le.PutUint32(e[0x4C:], freeSect) // child
+		le.PutUint32(e[0x74:], endOfChain)
+	}
+	entry(dir[0:128], "Root Entry", 5)
+	le.PutUint32(dir[0x4C:], 1) // the stream is the root's child
+	entry(dir[128:256], stream, oleStreamObject)
+	return b
+}
+
+// ooxmlPackage builds a zip holding a [Content_Types].xml that declares
+// mainPart as the main part, the way Office writes one.
+func ooxmlPackage(t *testing.T, mainPart, partName string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("[Content_Types].xml")
+	if err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	w.Write([]byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/docProps/core.xml" ContentType="application/vnd.openxmlformats-package.core-properties+xml"/>
+<Override PartName="` + partName + `" ContentType="` + mainPart + `"/>
+</Types>`))
+	if w, err = zw.Create(partName[1:]); err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	w.Write([]byte(`<?xml version="1.0"?><root/>`))
+	if err := zw.Close(); err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func plainZip(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("notes.txt")
+	if err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	w.Write([]byte("hello"))
+	if err := zw.Close(); err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestIdentify(t *testing.T) {
+	var (
+		doc       = compoundFile(t, "WordDocument")
+		xls       = compoundFile(t, "Workbook")
+		xls95     = compoundFile(t, "Book")
+		ppt       = compoundFile(t, "PowerPoint Document")
+		encrypted = compoundFile(t, "EncryptedPackage")
+		otherOLE  = compoundFile(t, "Contents")
+		docx      = ooxmlPackage(t, "application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml", "/word/document.xml")
+		xlsx      = ooxmlPackage(t, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml", "/xl/workbook.xml")
+		pptx      = ooxmlPackage(t, "application/vnd.openxmlformats-officedocument.presentationml.presentation.main+xml", "/ppt/presentation.xml")
+		pdf       = []byte("%PDF-1.7\n%\xE2\xE3\xCF\xD3\n1 0 obj\n<<>>\nendobj\n")
+		html      = []byte("<!DOCTYPE html><html><body><p>Files start with %PDF-1.7</p></body></html>")
+		script    = []byte("#!/bin/sh\necho '%PDF-1.4'\n")
+	)
+
+	for _, c := range []struct {
+		name    string
+		content []byte
+		want    string
+		err     error
+	}{
+		{"report.pdf", pdf, PDF, nil},
+		{"REPORT.PDF", pdf, PDF, nil},
+		{"bom.pdf", append([]byte("\xEF\xBB\xBF\r\n "), pdf...), PDF, nil},
+		{"letter.doc", doc, Doc, nil},
+		{"budget.xls", xls, Xls, nil},
+		{"old.xls", xls95, Xls, nil},
+		{"slides.ppt", ppt, Ppt, nil},
+		{"letter.docx", docx, Docx, nil},
+		{"budget.xlsx", xlsx, Xlsx, nil},
+		{"slides.pptx", pptx, Pptx, nil},
+		{"secret.docx", encrypted, Docx, nil},
+		{"secret.xlsx", encrypted, Xlsx, nil},
+		{"archive.zip", plainZip(t), Zip, nil},
+		{"notes.txt", []byte("plain notes\n"), "text/plain", nil},
+
+		// The header has to start the file.
+		{"page.pdf", html, "", ErrMismatch},
+		{"run.pdf", script, "", ErrMismatch},
+		{"late.pdf", append(bytes.Repeat([]byte("x"), 100), pdf...), "", ErrMismatch},
+
+		// Office formats are told apart by what their containers hold.
+		{"letter.xls", doc, "", ErrMismatch},
+		{"budget.doc", xls, "", ErrMismatch},
+		{"slides.doc", ppt, "", ErrMismatch},
+		{"letter.xlsx", docx, "", ErrMismatch},
+		{"budget.pptx", xlsx, "", ErrMismatch},
+		{"slides.docx", pptx, "", ErrMismatch},
+		{"letter.docx", doc, "", ErrMismatch},
+		{"letter.doc", docx, "", ErrMismatch},
+		{"secret.doc", encrypted, "", ErrMismatch},
+		{"other.doc", otherOLE, "", ErrMismatch},
+		{"archive.docx", plainZip(t), "", ErrMismatch},
+		{"letter.zip", docx, "", ErrMismatch},
+		{"report.docx", pdf, "", ErrMismatch},
+		{"page.txt", pdf, "", ErrMismatch},
+
+		{"tool.exe", []byte("MZ"), "", ErrUnknownExtension},
+		{"noextension", pdf, "", ErrUnknownExtension},
+	} {
+		got, err := Identify(c.name, bytes.NewReader(c.content), int64(len(c.content)))
+		if c.err != nil {
+			if !errors.Is(err, c.err) {
+				t.Errorf("Identify(%s) = %q, %v; want error %v", c.name, got, err, c.err)
+			}
+			continue
+		}
+		if err != nil || got != c.want {
+			t.Errorf("Identify(%s) = %q, %v; want %q", c.name, got, err, c.want)
+		}
+	}
+}
+
+func TestDetectMalformedOLE(t *testing.T) {
+	// A compound file whose FAT count exceeds the file must not be trusted.
+	b := compoundFile(t, "WordDocument")
+	binary.LittleEndian.PutUint32(b[0x2C:], 1000)
+	if got, err := Detect(bytes.NewReader(b), int64(len(b))); err != nil || got != OLE {
+		t.Errorf("Detect = %q, %v; want %q", got, err, OLE)
+	}
+
+	// A directory chain that loops back on itself ends the walk.
+	b = compoundFile(t, "WordDocument")
+	binary.LittleEndian.PutUint32(b[512+4:], 1)
+	if got, err := Detect(bytes.NewReader(b), int64(len(b))); err != nil || got != OLE {
+		t.Errorf("Detect with a looping chain = %q, %v; want %q", got, err, OLE)
+	}
+}
@@ -0,0 +1,133 @@
+package filetype
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"unicode/utf16"
+)
+
+// oleSignature starts every OLE compound file (MS-CFB).
+var oleSignature = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+const (
+	oleHeaderSize     = 512
+	oleDirEntrySize   = 128
+	oleHeaderDIFATLen = 109
+	oleMaxRegSect     = 0xFFFFFFFA // larger sector numbers are markers
+	oleStreamObject   = 2
+)
+
+var errBadOLE = errors.New("filetype: malformed compound file")
+
+// oleFile reads the sector chains of a compound file.
+type oleFile struct {
+	r          io.ReaderAt
+	sectorSize int64
+	sectors    int64    // sectors in the file, used to bound every walk
+	fat        []uint32 // sector numbers of the FAT sectors
+}
+
+// oleStreamNames returns the names of the streams in the compound file,
+// read from its directory.
+func oleStreamNames(r io.ReaderAt, size int64) (map[string]bool, error) {
+	header := make([]byte, oleHeaderSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+	shift := binary.LittleEndian.Uint16(header[0x1E:])
+	if shift != 9 && shift != 12 {
+		return nil, errBadOLE
+	}
+	f := &oleFile{r: r, sectorSize: 1 << shift}
+	f.sectors = size/f.sectorSize - 1
+	if f.sectors <= 0 {
+		return nil, errBadOLE
+	}
+
+	if err := f.readFATSectors(header); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	entry := make([]byte, oleDirEntrySize)
+	sector := binary.LittleEndian.Uint32(header[0x30:])
+	for steps := int64(0); sector < oleMaxRegSect; steps++ {
+		if steps > f.sectors {
+			return nil, errBadOLE // a loop in the chain
+		}
+		base := f.offset(sector)
+		for off := int64(0); off < f.sectorSize; off += oleDirEntrySize {
+			if _, err := r.ReadAt(entry, base+off); err != nil {
+				return nil, err
+			}
+			if entry[0x42] != oleStreamObject {
+				continue
+			}
+			nameLen := int(binary.LittleEndian.Uint16(entry[0x40:]))
+			if nameLen < 2 || nameLen > 64 {
+				continue
+			}
+			units := make([]uint16, nameLen/2-1) // drop the terminating NUL
+			for i := range units {
+				units[i] = binary.LittleEndian.Uint16(entry[2*i:])
+			}
+			names[string(utf16.Decode(units))] = true
+		}
+		next, err := f.next(sector)
+		if err != nil {
+			return nil, err
+		}
+		sector = next
+	}
+	return names, nil
+}
+
+// readFATSectors collects the FAT sector numbers from the header and the
+// DIFAT chain.
+func (f *oleFile) readFATSectors(header []byte) error {
+	count := int64(binary.LittleEndian.Uint32(header[0x2C:]))
+	if count > f.sectors {
+		return errBadOLE
+	}
+	add := func(entries []byte) {
+		for i := 0; i+4 <= len(entries) && int64(len(f.fat)) < count; i += 4 {
+			f.fat = append(f.fat, binary.LittleEndian.Uint32(entries[i:]))
+		}
+	}
+	add(header[0x4C : 0x4C+4*oleHeaderDIFATLen])
+
+	buf := make([]byte, f.sectorSize)
+	sector := binary.LittleEndian.Uint32(header[0x44:])
+	for steps := int64(0); int64(len(f.fat)) < count && sector < oleMaxRegSect; steps++ {
+		if steps > f.sectors {
+			return errBadOLE
+		}
+		if _, err := f.r.ReadAt(buf, f.offset(sector)); err != nil {
+			return err
+		}
+		// The last entry of a DIFAT sector points at the next one.
+		add(buf[:len(buf)-4])
+		sector = binary.LittleEndian.Uint32(buf[len(buf)-4:])
+	}
+	return nil
+}
+
+// next returns the sector that follows sector in its chain.
+func (f *oleFile) next(sector uint32) (uint32, error) {
+	perSector := f.sectorSize / 4
+	idx := int64(sector) / perSector
+	if int64(sector) >= f.sectors || idx >= int64(len(f.fat)) {
+		return 0, errBadOLE
+	}
+	var b [4]byte
+	if _, err := f.r.ReadAt(b[:], f.offset(f.fat[idx])+(int64(sector)%perSector)*4); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b[:]), nil
+}
+
+// offset returns the file offset of a sector; sector 0 follows the header.
+func (f *oleFile) offset(sector uint32) int64 {
+	return (int64(sector) + 1) * f.sectorSize
+}
@@ -53,7 +53,7 @@ func RateLimitMiddleware(next http.Handler) http.Handler {
 		}
 
 		// 2. If we do, proceed with the limiter
-		ip := getClientIP(r)
+		ip := ClientIP(r)
 		if ip == "" {
 			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
@@ -128,8 +128,8 @@ func cleanupClients(interval, ttl time.Duration) {
 	}
 }
 
-// getClientIP tries to extract the client's IP from headers or remote address
-func getClientIP(r *http.Request) string {
+// ClientIP tries to extract the client's IP from headers or remote address
+func ClientIP(r *http.Request) string {
 	ip := r.Header.Get("X-Forwarded-For")
 	if ip == "" {
 		ip = r.RemoteAddr
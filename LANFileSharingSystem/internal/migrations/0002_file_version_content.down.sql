@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_file_versions_file;
+
+ALTER TABLE file_versions
+    DROP COLUMN IF EXISTS storage_key,
+    DROP COLUMN IF EXISTS checksum,
+    DROP COLUMN IF EXISTS uploader,
+    DROP COLUMN IF EXISTS content_type,
+    DROP COLUMN IF EXISTS size;
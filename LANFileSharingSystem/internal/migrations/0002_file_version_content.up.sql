@@ -0,0 +1,19 @@
+ALTER TABLE file_versions
+    ADD COLUMN IF NOT EXISTS size BIGINT,
+    ADD COLUMN IF NOT EXISTS content_type VARCHAR(255),
+    ADD COLUMN IF NOT EXISTS uploader VARCHAR(50),
+    ADD COLUMN IF NOT EXISTS checksum VARCHAR(64),
+    ADD COLUMN IF NOT EXISTS storage_key VARCHAR(500);
+
+CREATE INDEX IF NOT EXISTS idx_file_versions_file ON file_versions (file_id, version_number);
+
+-- Only the latest version of existing files still has content; describe it from the file record.
+UPDATE file_versions fv
+SET size = f.size,
+    content_type = f.content_type,
+    uploader = f.uploader
+FROM files f
+WHERE fv.file_id = f.id
+  AND fv.version_number = (
+      SELECT MAX(version_number) FROM file_versions WHERE file_id = fv.file_id
+  );
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS integrity_issues;
+
+ALTER TABLE file_versions
+    DROP COLUMN IF EXISTS ciphertext_checksum;
+
+ALTER TABLE files
+    DROP COLUMN IF EXISTS ciphertext_checksum,
+    DROP COLUMN IF EXISTS checksum;
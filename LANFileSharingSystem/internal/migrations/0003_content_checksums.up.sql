@@ -0,0 +1,23 @@
+-- SHA-256 digests (hex) of the plaintext and of the stored ciphertext.
+ALTER TABLE files
+    ADD COLUMN IF NOT EXISTS checksum VARCHAR(64),
+    ADD COLUMN IF NOT EXISTS ciphertext_checksum VARCHAR(64);
+
+ALTER TABLE file_versions
+    ADD COLUMN IF NOT EXISTS ciphertext_checksum VARCHAR(64);
+
+-- Problems found by the integrity scrubber. An issue stays open until a later
+-- scrub finds the blob intact again.
+CREATE TABLE IF NOT EXISTS integrity_issues (
+    id SERIAL PRIMARY KEY,
+    file_id INT REFERENCES files(id) ON DELETE CASCADE,
+    version_number INT,
+    storage_key VARCHAR(500) NOT NULL,
+    problem VARCHAR(30) NOT NULL,
+    details VARCHAR(500),
+    detected_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    resolved_at TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_integrity_open ON integrity_issues (storage_key, problem) WHERE resolved_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_integrity_file ON integrity_issues (file_id);
@@ -0,0 +1,15 @@
+-- Files and folders moved to the recycle bin. Their rows stay in files and
+-- directories, relocated under the reserved '.trash/<id>' path, so that a
+-- restore brings back the same file IDs and version history.
+CREATE TABLE IF NOT EXISTS trash_items (
+    id SERIAL PRIMARY KEY,
+    item_type VARCHAR(10) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    original_parent VARCHAR(500) NOT NULL DEFAULT '',
+    size BIGINT NOT NULL DEFAULT 0,
+    file_count INT NOT NULL DEFAULT 0,
+    deleted_by VARCHAR(50),
+    deleted_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_trash_deleted_by ON trash_items (deleted_by);
+CREATE INDEX IF NOT EXISTS idx_trash_deleted_at ON trash_items (deleted_at);
@@ -0,0 +1,19 @@
+-- Resumable (tus) uploads in progress. The received bytes are staged on local
+-- disk until upload_offset reaches upload_length; the row is kept until it
+-- expires so that clients can still query a finished upload.
+CREATE TABLE IF NOT EXISTS upload_sessions (
+    id VARCHAR(36) PRIMARY KEY,
+    username VARCHAR(50) NOT NULL,
+    file_name VARCHAR(255) NOT NULL,
+    directory VARCHAR(255) NOT NULL DEFAULT '',
+    content_type VARCHAR(255) NOT NULL,
+    metadata JSONB DEFAULT '{}',
+    overwrite BOOLEAN NOT NULL DEFAULT FALSE,
+    upload_length BIGINT NOT NULL,
+    upload_offset BIGINT NOT NULL DEFAULT 0,
+    file_id INT REFERENCES files(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMPTZ NOT NULL,
+    completed_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_upload_sessions_expires ON upload_sessions (expires_at);
@@ -0,0 +1,31 @@
+-- Upload policy: which file types may be uploaded and how large they may be.
+-- Rules with an empty folder are the defaults; a rule for a top-level folder
+-- (e.g. 'operation') overrides the default for the same extension there.
+-- A max_size of 0 means no limit.
+CREATE TABLE IF NOT EXISTS file_type_rules (
+    id SERIAL PRIMARY KEY,
+    folder VARCHAR(100) NOT NULL DEFAULT '',
+    extension VARCHAR(20) NOT NULL,
+    allowed BOOLEAN NOT NULL DEFAULT TRUE,
+    max_size BIGINT NOT NULL DEFAULT 0,
+    updated_by VARCHAR(50),
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (folder, extension)
+);
+
+-- Word, Excel and PDF everywhere, as before; photos for Operation; slides
+-- and videos for Training.
+INSERT INTO file_type_rules (folder, extension, max_size) VALUES
+    ('', '.doc', 104857600),
+    ('', '.docx', 104857600),
+    ('', '.xls', 104857600),
+    ('', '.xlsx', 104857600),
+    ('', '.pdf', 104857600),
+    ('operation', '.jpg', 26214400),
+    ('operation', '.jpeg', 26214400),
+    ('operation', '.png', 26214400),
+    ('operation', '.gif', 26214400),
+    ('operation', '.webp', 26214400),
+    ('training', '.pptx', 209715200),
+    ('training', '.mp4', 2147483648)
+ON CONFLICT (folder, extension) DO NOTHING;
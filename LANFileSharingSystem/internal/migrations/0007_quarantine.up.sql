@@ -0,0 +1,15 @@
+-- Files held back because the virus scanner flagged them. The content is
+-- kept, encrypted like any other blob, under the reserved '.quarantine/<id>'
+-- prefix so an admin can examine it.
+CREATE TABLE IF NOT EXISTS quarantined_files (
+    id SERIAL PRIMARY KEY,
+    file_name VARCHAR(255) NOT NULL,
+    directory VARCHAR(500) NOT NULL DEFAULT '',
+    storage_key VARCHAR(800) NOT NULL DEFAULT '',
+    size BIGINT NOT NULL DEFAULT 0,
+    checksum VARCHAR(64),
+    signature VARCHAR(255) NOT NULL,
+    uploader VARCHAR(50),
+    detected_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_quarantined_detected_at ON quarantined_files (detected_at);
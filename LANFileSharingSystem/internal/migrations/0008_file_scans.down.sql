@@ -0,0 +1,5 @@
+ALTER TABLE quarantined_files
+    DROP COLUMN IF EXISTS source,
+    DROP COLUMN IF EXISTS file_id;
+
+DROP TABLE IF EXISTS file_scans;
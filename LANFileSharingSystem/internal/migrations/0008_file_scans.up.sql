@@ -0,0 +1,19 @@
+-- The latest virus scan verdict for each stored file. checksum is the
+-- plaintext digest that was scanned, so content replaced since then is due
+-- for a scan again.
+CREATE TABLE IF NOT EXISTS file_scans (
+    file_id INT PRIMARY KEY REFERENCES files(id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL,
+    signature VARCHAR(255),
+    details TEXT,
+    checksum VARCHAR(64),
+    scanned_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_file_scans_scanned_at ON file_scans (scanned_at);
+CREATE INDEX IF NOT EXISTS idx_file_scans_status ON file_scans (status);
+
+-- Stored files found infected by a rescan are quarantined in place: their
+-- row in files moves under '.quarantine/<id>' and is linked from here.
+ALTER TABLE quarantined_files
+    ADD COLUMN IF NOT EXISTS file_id INT REFERENCES files(id) ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS source VARCHAR(20) NOT NULL DEFAULT 'upload';
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_files_created_at;
+DROP INDEX IF EXISTS idx_files_search;
+
+ALTER TABLE files
+    DROP COLUMN IF EXISTS search_vector,
+    DROP COLUMN IF EXISTS text_checksum,
+    DROP COLUMN IF EXISTS content_text;
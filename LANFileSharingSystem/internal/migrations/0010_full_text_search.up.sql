@@ -0,0 +1,16 @@
+-- Searchable text extracted from file contents. content_text is kept in
+-- plaintext, outside the encrypted blobs, so search results can show
+-- highlighted snippets; text_checksum is the plaintext digest it was
+-- extracted from, so replaced content is extracted again.
+ALTER TABLE files
+    ADD COLUMN IF NOT EXISTS content_text TEXT,
+    ADD COLUMN IF NOT EXISTS text_checksum VARCHAR(64);
+
+-- File names weigh more than body text when ranking.
+ALTER TABLE files
+    ADD COLUMN IF NOT EXISTS search_vector TSVECTOR GENERATED ALWAYS AS (
+        setweight(to_tsvector('english', COALESCE(file_name, '')), 'A') ||
+        setweight(to_tsvector('english', COALESCE(content_text, '')), 'B')
+    ) STORED;
+CREATE INDEX IF NOT EXISTS idx_files_search ON files USING gin (search_vector);
+CREATE INDEX IF NOT EXISTS idx_files_created_at ON files (created_at);
@@ -0,0 +1,10 @@
+-- JSON Schemas that uploaded and edited file metadata must satisfy. A
+-- schema applies to its directory and every folder below it, unless a
+-- deeper folder has a schema of its own.
+CREATE TABLE IF NOT EXISTS metadata_schemas (
+    id SERIAL PRIMARY KEY,
+    directory VARCHAR(255) NOT NULL UNIQUE,
+    schema JSONB NOT NULL,
+    updated_by VARCHAR(50),
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,20 @@
+-- Tags label files across folders. Curated tags are kept by admins and
+-- offered to everyone; other tags are created the first time someone uses
+-- them and go away once no file carries them.
+CREATE TABLE IF NOT EXISTS tags (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(50) NOT NULL UNIQUE,
+    curated BOOLEAN NOT NULL DEFAULT FALSE,
+    description TEXT,
+    created_by VARCHAR(50),
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS file_tags (
+    file_id INT NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+    tag_id INT NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    tagged_by VARCHAR(50),
+    tagged_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (file_id, tag_id)
+);
+CREATE INDEX IF NOT EXISTS idx_file_tags_tag ON file_tags (tag_id);
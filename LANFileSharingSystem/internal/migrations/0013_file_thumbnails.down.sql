@@ -0,0 +1,2 @@
+ALTER TABLE files
+    DROP COLUMN IF EXISTS thumbnail_checksum;
@@ -0,0 +1,5 @@
+-- The plaintext digest of the content a file's stored thumbnails were
+-- rendered from, so listings only offer thumbnails of current content and
+-- replaced content is rendered again.
+ALTER TABLE files
+    ADD COLUMN IF NOT EXISTS thumbnail_checksum VARCHAR(64);
@@ -0,0 +1,21 @@
+-- Folder permissions grant a user read, write, share or manage access to a
+-- directory and every folder below it; '' is the root. Admins may do
+-- everything without grants, everyone else only what is granted.
+CREATE TABLE IF NOT EXISTS folder_permissions (
+    id SERIAL PRIMARY KEY,
+    directory VARCHAR(255) NOT NULL,
+    username VARCHAR(50) NOT NULL REFERENCES users (username) ON UPDATE CASCADE ON DELETE CASCADE,
+    permission VARCHAR(10) NOT NULL CHECK (permission IN ('read', 'write', 'share', 'manage')),
+    granted_by VARCHAR(50),
+    granted_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (directory, username, permission)
+);
+CREATE INDEX IF NOT EXISTS idx_folder_permissions_user ON folder_permissions (username);
+
+-- Existing accounts keep the access they had before permissions existed
+-- until an admin narrows it down.
+INSERT INTO folder_permissions (directory, username, permission)
+SELECT '', username, 'write'
+FROM users
+WHERE role <> 'admin'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,11 @@
+ALTER TABLE file_messages DROP COLUMN IF EXISTS group_id;
+
+DELETE FROM folder_permissions WHERE group_id IS NOT NULL;
+DROP INDEX IF EXISTS idx_folder_permissions_group;
+ALTER TABLE folder_permissions DROP CONSTRAINT IF EXISTS folder_permissions_group_unique;
+ALTER TABLE folder_permissions DROP CONSTRAINT IF EXISTS folder_permissions_grantee_check;
+ALTER TABLE folder_permissions DROP COLUMN IF EXISTS group_id;
+ALTER TABLE folder_permissions ALTER COLUMN username SET NOT NULL;
+
+DROP TABLE IF EXISTS user_group_members;
+DROP TABLE IF EXISTS user_groups;
@@ -0,0 +1,46 @@
+-- Groups collect users, such as the staff of one department. Department
+-- groups belong to one of the top-level folders (department holds its
+-- lowercase name); other groups are free-form.
+CREATE TABLE IF NOT EXISTS user_groups (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(50) NOT NULL UNIQUE,
+    description TEXT,
+    department VARCHAR(50) UNIQUE,
+    created_by VARCHAR(50),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS user_group_members (
+    group_id INT NOT NULL REFERENCES user_groups(id) ON DELETE CASCADE,
+    username VARCHAR(50) NOT NULL REFERENCES users (username) ON UPDATE CASCADE ON DELETE CASCADE,
+    added_by VARCHAR(50),
+    added_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (group_id, username)
+);
+CREATE INDEX IF NOT EXISTS idx_user_group_members_user ON user_group_members (username);
+
+-- One group per department, with write access to its folder.
+INSERT INTO user_groups (name, description, department) VALUES
+    ('Operation', 'Staff of the Operation unit', 'operation'),
+    ('Research', 'Staff of the Research unit', 'research'),
+    ('Training', 'Staff of the Training unit', 'training')
+ON CONFLICT DO NOTHING;
+
+-- A folder permission is granted either to a user or to a group.
+ALTER TABLE folder_permissions ALTER COLUMN username DROP NOT NULL;
+ALTER TABLE folder_permissions ADD COLUMN IF NOT EXISTS group_id INT REFERENCES user_groups(id) ON DELETE CASCADE;
+ALTER TABLE folder_permissions ADD CONSTRAINT folder_permissions_grantee_check
+    CHECK ((username IS NULL) <> (group_id IS NULL));
+ALTER TABLE folder_permissions ADD CONSTRAINT folder_permissions_group_unique
+    UNIQUE (directory, group_id, permission);
+CREATE INDEX IF NOT EXISTS idx_folder_permissions_group ON folder_permissions (group_id);
+
+INSERT INTO folder_permissions (directory, group_id, permission)
+SELECT name, id, 'write'
+FROM user_groups
+WHERE department IS NOT NULL
+ON CONFLICT DO NOTHING;
+
+-- Instructions sent to a group reach each member as a message of their own;
+-- group_id records the group they were addressed to.
+ALTER TABLE file_messages ADD COLUMN IF NOT EXISTS group_id INT REFERENCES user_groups(id) ON DELETE SET NULL;
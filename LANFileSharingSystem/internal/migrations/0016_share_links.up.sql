@@ -0,0 +1,22 @@
+-- Share links hand a file or a folder to someone without an account. Only
+-- the SHA-256 of the token is stored, so the link itself is shown once, when
+-- it is created. A link works until it expires, is revoked or has been
+-- downloaded max_downloads times (NULL for no limit).
+CREATE TABLE IF NOT EXISTS share_links (
+    id SERIAL PRIMARY KEY,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    file_id INT REFERENCES files(id) ON DELETE CASCADE,
+    directory VARCHAR(255),
+    password_hash TEXT,
+    expires_at TIMESTAMPTZ NOT NULL,
+    max_downloads INT CHECK (max_downloads > 0),
+    download_count INT NOT NULL DEFAULT 0,
+    created_by VARCHAR(50) NOT NULL REFERENCES users (username) ON UPDATE CASCADE ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_accessed_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    revoked_by VARCHAR(50),
+    CHECK ((file_id IS NULL) <> (directory IS NULL))
+);
+CREATE INDEX IF NOT EXISTS idx_share_links_file ON share_links (file_id);
+CREATE INDEX IF NOT EXISTS idx_share_links_created_by ON share_links (created_by);
@@ -0,0 +1,200 @@
+package models
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+
+	"LANFileSharingSystem/internal/encryption"
+)
+
+// -------------------------------------
+//  Encrypted Blob Helpers
+// -------------------------------------
+
+// BlobInfo describes an encrypted blob: the plaintext size and the
+// hex-encoded SHA-256 digests of the plaintext and of the stored ciphertext.
+type BlobInfo struct {
+	Size             int64
+	SHA256           string
+	CiphertextSHA256 string
+}
+
+// PutEncrypted encrypts src on the fly and stores the ciphertext under key.
+func (app *App) PutEncrypted(ctx context.Context, key string, src io.Reader) (BlobInfo, error) {
+	hash := sha256.New()
+	counter := &countingWriter{}
+	plaintext := io.TeeReader(src, io.MultiWriter(hash, counter))
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pw.CloseWithError(encryption.EncryptStream(app.Keys, pw, plaintext))
+	}()
+
+	cipherHash := sha256.New()
+	err := app.Storage.Put(ctx, key, io.TeeReader(pr, cipherHash))
+	// Unblock the encrypting goroutine if Put gave up early, then wait for it
+	// so src is no longer in use once we return.
+	pr.CloseWithError(err)
+	<-done
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	return BlobInfo{
+		Size:             counter.n,
+		SHA256:           hex.EncodeToString(hash.Sum(nil)),
+		CiphertextSHA256: hex.EncodeToString(cipherHash.Sum(nil)),
+	}, nil
+}
+
+// HashStoredBlob reads the blob stored under key in full, decrypting it on
+// the way, and returns its digests. Any authentication failure of the
+// ciphertext is returned as an error.
+func (app *App) HashStoredBlob(ctx context.Context, key string) (BlobInfo, error) {
+	rc, err := app.Storage.Get(ctx, key)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	defer rc.Close()
+
+	cipherHash := sha256.New()
+	dr, err := encryption.NewDecryptReader(app.Keys, io.TeeReader(rc, cipherHash))
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	hash := sha256.New()
+	n, err := io.Copy(hash, dr)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	// Drain anything the decrypter did not need so the ciphertext digest
+	// covers the whole object.
+	if _, err := io.Copy(cipherHash, rc); err != nil {
+		return BlobInfo{}, err
+	}
+	return BlobInfo{
+		Size:             n,
+		SHA256:           hex.EncodeToString(hash.Sum(nil)),
+		CiphertextSHA256: hex.EncodeToString(cipherHash.Sum(nil)),
+	}, nil
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// decryptedBlob pairs the plaintext reader with the underlying object.
+type decryptedBlob struct {
+	io.Reader
+	io.Closer
+}
+
+// OpenDecrypted opens the blob stored under key and returns its plaintext.
+// The first segment is decrypted eagerly so a wrong key or corrupt header is
+// reported before the caller starts writing a response.
+func (app *App) OpenDecrypted(ctx context.Context, key string) (io.ReadCloser, error) {
+	rc, err := app.Storage.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	dr, err := encryption.NewDecryptReader(app.Keys, rc)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(dr)
+	if _, err := br.Peek(1); err != nil && err != io.EOF {
+		rc.Close()
+		return nil, err
+	}
+	return decryptedBlob{Reader: br, Closer: rc}, nil
+}
+
+// SeekableBlob is the plaintext of a stored blob with random access.
+type SeekableBlob interface {
+	io.ReadSeekCloser
+	// Size returns the length of the plaintext.
+	Size() int64
+}
+
+// OpenSeekable opens the blob stored under key for random access. Stream
+// format blobs are decrypted one segment at a time as they are read; legacy
+// single-blob files are decrypted into a private temp file first. As with
+// OpenDecrypted, the first segment is checked before returning.
+func (app *App) OpenSeekable(ctx context.Context, key string) (SeekableBlob, error) {
+	info, err := app.Storage.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	sr, err := encryption.NewSeekableReader(app.Keys, info.Size, func(offset int64) (io.ReadCloser, error) {
+		return app.Storage.GetFrom(ctx, key, offset)
+	})
+	if errors.Is(err, encryption.ErrNotSeekable) {
+		return app.spoolDecrypted(ctx, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := sr.Read(make([]byte, 1)); err != nil && err != io.EOF {
+		sr.Close()
+		return nil, err
+	}
+	if _, err := sr.Seek(0, io.SeekStart); err != nil {
+		sr.Close()
+		return nil, err
+	}
+	return sr, nil
+}
+
+// spooledBlob is a plaintext copy in a temp file that is removed on Close.
+type spooledBlob struct {
+	*os.File
+	size int64
+}
+
+func (b spooledBlob) Size() int64 {
+	return b.size
+}
+
+func (b spooledBlob) Close() error {
+	err := b.File.Close()
+	os.Remove(b.File.Name())
+	return err
+}
+
+// spoolDecrypted decrypts the blob stored under key into a temp file that
+// only the server can read.
+func (app *App) spoolDecrypted(ctx context.Context, key string) (SeekableBlob, error) {
+	blob, err := app.OpenDecrypted(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+
+	f, err := os.CreateTemp("", "lanfs-plain-*")
+	if err != nil {
+		return nil, err
+	}
+	spooled := spooledBlob{File: f}
+	if spooled.size, err = io.Copy(f, blob); err != nil {
+		spooled.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		spooled.Close()
+		return nil, err
+	}
+	return spooled, nil
+}
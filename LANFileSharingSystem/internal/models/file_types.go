@@ -0,0 +1,131 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"LANFileSharingSystem/internal/filetype"
+)
+
+// -------------------------------------
+//  File Type Policy
+// -------------------------------------
+
+// ErrFileTypeRuleExists is returned when a folder already has a rule for an extension.
+var ErrFileTypeRuleExists = errors.New("a rule for this extension already exists")
+
+// FileTypeRule allows or denies one file extension, with an optional size
+// limit. Rules with an empty Folder are the defaults; a rule for a top-level
+// folder overrides the default for the same extension there.
+type FileTypeRule struct {
+	ID        int       `json:"id"`
+	Folder    string    `json:"folder"`
+	Extension string    `json:"extension"`
+	MIMEType  string    `json:"mime_type"` // type recorded for matching files
+	Allowed   bool      `json:"allowed"`
+	MaxSize   int64     `json:"max_size"` // bytes; 0 means no limit
+	UpdatedBy string    `json:"updated_by"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+const fileTypeRuleColumns = `id, folder, extension, allowed, max_size, COALESCE(updated_by, ''), updated_at`
+
+func scanFileTypeRule(row interface{ Scan(...interface{}) error }) (FileTypeRule, error) {
+	var rule FileTypeRule
+	err := row.Scan(&rule.ID, &rule.Folder, &rule.Extension, &rule.Allowed, &rule.MaxSize, &rule.UpdatedBy, &rule.UpdatedAt)
+	if err != nil {
+		return FileTypeRule{}, err
+	}
+	rule.MIMEType, _ = filetype.ForExtension(rule.Extension)
+	return rule, nil
+}
+
+// ListFileTypeRules returns every rule, defaults first.
+func (app *App) ListFileTypeRules() ([]FileTypeRule, error) {
+	rows, err := app.DB.Query(`
+        SELECT ` + fileTypeRuleColumns + `
+        FROM file_type_rules
+        ORDER BY folder, extension
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []FileTypeRule
+	for rows.Next() {
+		rule, err := scanFileTypeRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// GetFileTypeRule returns a rule by ID.
+func (app *App) GetFileTypeRule(id int) (FileTypeRule, error) {
+	return scanFileTypeRule(app.DB.QueryRow(`
+        SELECT `+fileTypeRuleColumns+`
+        FROM file_type_rules
+        WHERE id = $1
+    `, id))
+}
+
+// EffectiveFileTypeRule returns the rule that applies to extension ext in the
+// top-level folder: the folder's own rule if it has one, else the default.
+// It returns sql.ErrNoRows when neither exists.
+func (app *App) EffectiveFileTypeRule(folder, ext string) (FileTypeRule, error) {
+	return scanFileTypeRule(app.DB.QueryRow(`
+        SELECT `+fileTypeRuleColumns+`
+        FROM file_type_rules
+        WHERE extension = $1
+          AND folder IN ('', $2)
+        ORDER BY folder DESC
+        LIMIT 1
+    `, ext, folder))
+}
+
+// CreateFileTypeRule stores a new rule and returns its ID.
+func (app *App) CreateFileTypeRule(rule FileTypeRule) (int, error) {
+	var id int
+	err := app.DB.QueryRow(`
+        INSERT INTO file_type_rules (folder, extension, allowed, max_size, updated_by)
+        VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (folder, extension) DO NOTHING
+        RETURNING id
+    `, rule.Folder, rule.Extension, rule.Allowed, rule.MaxSize, rule.UpdatedBy).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, ErrFileTypeRuleExists
+	}
+	return id, err
+}
+
+// UpdateFileTypeRule changes whether a rule allows its extension and its size limit.
+func (app *App) UpdateFileTypeRule(rule FileTypeRule) error {
+	res, err := app.DB.Exec(`
+        UPDATE file_type_rules
+        SET allowed = $1, max_size = $2, updated_by = $3, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $4
+    `, rule.Allowed, rule.MaxSize, rule.UpdatedBy, rule.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteFileTypeRule removes a rule.
+func (app *App) DeleteFileTypeRule(id int) error {
+	res, err := app.DB.Exec(`DELETE FROM file_type_rules WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
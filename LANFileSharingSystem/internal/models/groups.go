@@ -0,0 +1,263 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// -------------------------------------
+//  User Groups
+// -------------------------------------
+
+// ErrInvalidGroupName is wrapped by the errors returned for a malformed
+// group name.
+var ErrInvalidGroupName = errors.New("invalid group name")
+
+// maxGroupNameLength is the longest group name, in characters.
+const maxGroupNameLength = 50
+
+// Group collects users. Department groups, such as Operation, belong to
+// one of the top-level folders and cannot be deleted; Department holds the
+// folder's lowercase name and is empty for other groups.
+type Group struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Department  string    `json:"department,omitempty"`
+	MemberCount int       `json:"member_count"`
+	CreatedBy   string    `json:"created_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// GroupMember is one user's membership of a group.
+type GroupMember struct {
+	Username string    `json:"username"`
+	Role     string    `json:"role"`
+	AddedBy  string    `json:"added_by"`
+	AddedAt  time.Time `json:"added_at"`
+}
+
+// CleanGroupName trims a group name and checks its length.
+func CleanGroupName(name string) (string, error) {
+	name = strings.Join(strings.Fields(name), " ")
+	if name == "" {
+		return "", fmt.Errorf("%w: group names cannot be empty", ErrInvalidGroupName)
+	}
+	if len([]rune(name)) > maxGroupNameLength {
+		return "", fmt.Errorf("%w: '%s' is longer than %d characters", ErrInvalidGroupName, name, maxGroupNameLength)
+	}
+	return name, nil
+}
+
+// groupColumns are the columns scanGroup reads, from user_groups g.
+const groupColumns = `g.id, g.name, COALESCE(g.description, ''), COALESCE(g.department, ''),
+               (SELECT COUNT(*) FROM user_group_members m WHERE m.group_id = g.id),
+               COALESCE(g.created_by, ''), g.created_at`
+
+func scanGroup(row interface{ Scan(...interface{}) error }) (Group, error) {
+	var g Group
+	err := row.Scan(&g.ID, &g.Name, &g.Description, &g.Department, &g.MemberCount, &g.CreatedBy, &g.CreatedAt)
+	return g, err
+}
+
+// ListGroups returns every group, department groups first, then by name.
+func (app *App) ListGroups() ([]Group, error) {
+	return app.queryGroups(`
+        SELECT ` + groupColumns + `
+        FROM user_groups g
+        ORDER BY g.department IS NULL, g.name
+    `)
+}
+
+// UserGroups returns the groups username belongs to.
+func (app *App) UserGroups(username string) ([]Group, error) {
+	return app.queryGroups(`
+        SELECT `+groupColumns+`
+        FROM user_groups g
+        JOIN user_group_members um ON um.group_id = g.id
+        WHERE um.username = $1
+        ORDER BY g.department IS NULL, g.name
+    `, username)
+}
+
+func (app *App) queryGroups(query string, args ...interface{}) ([]Group, error) {
+	rows, err := app.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []Group
+	for rows.Next() {
+		g, err := scanGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+// GetGroup returns one group by ID.
+func (app *App) GetGroup(id int) (Group, error) {
+	return scanGroup(app.DB.QueryRow(`
+        SELECT `+groupColumns+`
+        FROM user_groups g
+        WHERE g.id = $1
+    `, id))
+}
+
+// GetGroupByName returns one group by name, ignoring case.
+func (app *App) GetGroupByName(name string) (Group, error) {
+	return scanGroup(app.DB.QueryRow(`
+        SELECT `+groupColumns+`
+        FROM user_groups g
+        WHERE LOWER(g.name) = LOWER($1)
+    `, name))
+}
+
+// GetDepartmentGroup returns the group of a department, given the name of
+// its top-level folder in any case.
+func (app *App) GetDepartmentGroup(department string) (Group, error) {
+	return scanGroup(app.DB.QueryRow(`
+        SELECT `+groupColumns+`
+        FROM user_groups g
+        WHERE g.department = LOWER($1)
+    `, department))
+}
+
+// CreateGroup creates a group that belongs to no department.
+func (app *App) CreateGroup(name, description, createdBy string) (Group, error) {
+	var id int
+	err := app.DB.QueryRow(`
+        INSERT INTO user_groups (name, description, created_by)
+        VALUES ($1, NULLIF($2, ''), $3)
+        RETURNING id
+    `, name, description, createdBy).Scan(&id)
+	if err != nil {
+		return Group{}, err
+	}
+	return app.GetGroup(id)
+}
+
+// UpdateGroup renames a group and sets its description.
+func (app *App) UpdateGroup(id int, name, description string) (Group, error) {
+	res, err := app.DB.Exec(`
+        UPDATE user_groups
+        SET name = $2, description = NULLIF($3, '')
+        WHERE id = $1
+    `, id, name, description)
+	if err != nil {
+		return Group{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return Group{}, sql.ErrNoRows
+	}
+	return app.GetGroup(id)
+}
+
+// DeleteGroup deletes a group along with its memberships and folder
+// permissions. Messages sent to the group stay with their receivers.
+func (app *App) DeleteGroup(id int) error {
+	res, err := app.DB.Exec(`DELETE FROM user_groups WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ListGroupMembers returns the members of a group, ordered by username.
+func (app *App) ListGroupMembers(groupID int) ([]GroupMember, error) {
+	rows, err := app.DB.Query(`
+        SELECT m.username, u.role, COALESCE(m.added_by, ''), m.added_at
+        FROM user_group_members m
+        JOIN users u ON u.username = m.username
+        WHERE m.group_id = $1
+        ORDER BY m.username
+    `, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []GroupMember
+	for rows.Next() {
+		var m GroupMember
+		if err := rows.Scan(&m.Username, &m.Role, &m.AddedBy, &m.AddedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// GroupMemberNames returns the usernames of the members of a group.
+func (app *App) GroupMemberNames(groupID int) ([]string, error) {
+	rows, err := app.DB.Query(`
+        SELECT username FROM user_group_members WHERE group_id = $1 ORDER BY username
+    `, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// AddGroupMembers adds users to a group and returns those who were not
+// members yet. Usernames must belong to existing users.
+func (app *App) AddGroupMembers(groupID int, usernames []string, addedBy string) ([]string, error) {
+	rows, err := app.DB.Query(`
+        INSERT INTO user_group_members (group_id, username, added_by)
+        SELECT $1, u.username, $3
+        FROM users u
+        WHERE u.username = ANY($2)
+        ON CONFLICT DO NOTHING
+        RETURNING username
+    `, groupID, pq.Array(usernames), addedBy)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var added []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		added = append(added, name)
+	}
+	return added, rows.Err()
+}
+
+// RemoveGroupMember takes a user out of a group. It returns sql.ErrNoRows
+// if the user is not a member.
+func (app *App) RemoveGroupMember(groupID int, username string) error {
+	res, err := app.DB.Exec(`
+        DELETE FROM user_group_members WHERE group_id = $1 AND username = $2
+    `, groupID, username)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
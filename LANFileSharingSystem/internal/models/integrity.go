@@ -0,0 +1,213 @@
+package models
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// -------------------------------------
+//  Content Integrity
+// -------------------------------------
+
+// Problems the integrity scrubber can report for a stored blob.
+const (
+	IntegrityMissing          = "missing"
+	IntegrityChecksumMismatch = "checksum_mismatch"
+	IntegrityUnreadable       = "unreadable"
+)
+
+// StoredBlob is one encrypted blob the database expects to find in storage:
+// either the current content of a file (Version 0) or an archived version.
+type StoredBlob struct {
+	FileID             int
+	Version            int
+	FileName           string
+	Key                string
+	Checksum           string
+	CiphertextChecksum string
+}
+
+// IntegrityIssue is a problem found with a stored blob.
+type IntegrityIssue struct {
+	ID         int        `json:"id"`
+	FileID     *int       `json:"file_id"`
+	Version    *int       `json:"version_number"`
+	StorageKey string     `json:"storage_key"`
+	Problem    string     `json:"problem"`
+	Details    string     `json:"details"`
+	DetectedAt time.Time  `json:"detected_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+}
+
+// ListStoredBlobs returns every blob referenced by the files and file_versions tables.
+func (app *App) ListStoredBlobs() ([]StoredBlob, error) {
+	rows, err := app.DB.Query(`
+        SELECT id, 0, file_name, file_path, COALESCE(checksum, ''), COALESCE(ciphertext_checksum, '')
+        FROM files
+        UNION ALL
+        SELECT fv.file_id, fv.version_number, f.file_name, fv.storage_key,
+               COALESCE(fv.checksum, ''), COALESCE(fv.ciphertext_checksum, '')
+        FROM file_versions fv
+        JOIN files f ON f.id = fv.file_id
+        WHERE fv.storage_key IS NOT NULL
+        ORDER BY 1, 2
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blobs []StoredBlob
+	for rows.Next() {
+		var b StoredBlob
+		if err := rows.Scan(&b.FileID, &b.Version, &b.FileName, &b.Key, &b.Checksum, &b.CiphertextChecksum); err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, b)
+	}
+	return blobs, rows.Err()
+}
+
+// BackfillChecksums records digests for a blob that was stored before
+// checksums existed. Digests that are already recorded are left untouched.
+func (app *App) BackfillChecksums(b StoredBlob, info BlobInfo) error {
+	var err error
+	if b.Version == 0 {
+		_, err = app.DB.Exec(`
+            UPDATE files
+            SET checksum = COALESCE(checksum, $1),
+                ciphertext_checksum = COALESCE(ciphertext_checksum, $2)
+            WHERE id = $3
+        `, info.SHA256, info.CiphertextSHA256, b.FileID)
+	} else {
+		_, err = app.DB.Exec(`
+            UPDATE file_versions
+            SET checksum = COALESCE(checksum, $1),
+                ciphertext_checksum = COALESCE(ciphertext_checksum, $2)
+            WHERE file_id = $3 AND version_number = $4
+        `, info.SHA256, info.CiphertextSHA256, b.FileID, b.Version)
+	}
+	return err
+}
+
+// RecordIntegrityIssue stores a problem found with a blob. It reports whether
+// the problem is new; a problem that is still open is only marked as seen again.
+func (app *App) RecordIntegrityIssue(b StoredBlob, problem, details string) (bool, error) {
+	res, err := app.DB.Exec(`
+        UPDATE integrity_issues
+        SET last_seen_at = CURRENT_TIMESTAMP, details = $3
+        WHERE storage_key = $1 AND problem = $2 AND resolved_at IS NULL
+    `, b.Key, problem, details)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return false, nil
+	}
+
+	var version sql.NullInt64
+	if b.Version > 0 {
+		version = sql.NullInt64{Int64: int64(b.Version), Valid: true}
+	}
+	_, err = app.DB.Exec(`
+        INSERT INTO integrity_issues (file_id, version_number, storage_key, problem, details)
+        VALUES ($1, $2, $3, $4, $5)
+    `, b.FileID, version, b.Key, problem, details)
+	return err == nil, err
+}
+
+// ResolveIntegrityIssues closes the open issues of a blob that verified correctly.
+func (app *App) ResolveIntegrityIssues(key string) error {
+	_, err := app.DB.Exec(`
+        UPDATE integrity_issues
+        SET resolved_at = CURRENT_TIMESTAMP
+        WHERE storage_key = $1 AND resolved_at IS NULL
+    `, key)
+	return err
+}
+
+// ListIntegrityIssues returns open issues, newest first, optionally
+// followed by resolved ones.
+func (app *App) ListIntegrityIssues(includeResolved bool) ([]IntegrityIssue, error) {
+	rows, err := app.DB.Query(`
+        SELECT id, file_id, version_number, storage_key, problem, COALESCE(details, ''),
+               detected_at, last_seen_at, resolved_at
+        FROM integrity_issues
+        WHERE $1 OR resolved_at IS NULL
+        ORDER BY resolved_at IS NOT NULL, detected_at DESC
+    `, includeResolved)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var issues []IntegrityIssue
+	for rows.Next() {
+		var (
+			issue      IntegrityIssue
+			fileID     sql.NullInt64
+			version    sql.NullInt64
+			resolvedAt sql.NullTime
+		)
+		if err := rows.Scan(&issue.ID, &fileID, &version, &issue.StorageKey, &issue.Problem, &issue.Details,
+			&issue.DetectedAt, &issue.LastSeenAt, &resolvedAt); err != nil {
+			return nil, err
+		}
+		if fileID.Valid {
+			val := int(fileID.Int64)
+			issue.FileID = &val
+		}
+		if version.Valid {
+			val := int(version.Int64)
+			issue.Version = &val
+		}
+		if resolvedAt.Valid {
+			issue.ResolvedAt = &resolvedAt.Time
+		}
+		issues = append(issues, issue)
+	}
+	return issues, rows.Err()
+}
+
+// NotifyAdmins sends a WebSocket message to every connected admin.
+func (app *App) NotifyAdmins(message []byte) {
+	if app.NotificationHub == nil {
+		return
+	}
+	users, err := app.ListUsers()
+	if err != nil {
+		log.Printf("NotifyAdmins: could not list users: %v", err)
+		return
+	}
+	for _, u := range users {
+		if u.Role == "admin" {
+			app.NotificationHub.SendToUser(u.Username, message)
+		}
+	}
+}
+
+// RefreshStoredBlob re-reads the row behind b. It reports false when the blob
+// is no longer referenced under the same key, which happens when a file is
+// overwritten, moved or deleted while it is being verified.
+func (app *App) RefreshStoredBlob(b StoredBlob) (StoredBlob, bool, error) {
+	var err error
+	if b.Version == 0 {
+		err = app.DB.QueryRow(`
+            SELECT COALESCE(checksum, ''), COALESCE(ciphertext_checksum, '')
+            FROM files
+            WHERE id = $1 AND file_path = $2
+        `, b.FileID, b.Key).Scan(&b.Checksum, &b.CiphertextChecksum)
+	} else {
+		err = app.DB.QueryRow(`
+            SELECT COALESCE(checksum, ''), COALESCE(ciphertext_checksum, '')
+            FROM file_versions
+            WHERE file_id = $1 AND version_number = $2 AND storage_key = $3
+        `, b.FileID, b.Version, b.Key).Scan(&b.Checksum, &b.CiphertextChecksum)
+	}
+	if err == sql.ErrNoRows {
+		return b, false, nil
+	}
+	return b, err == nil, err
+}
@@ -0,0 +1,258 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// -------------------------------------
+//  Background Jobs
+// -------------------------------------
+
+// Job states. A job waiting for a retry is queued with a later run_at.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobDone      = "done"
+	JobDead      = "dead"
+	JobCancelled = "cancelled"
+)
+
+// ErrJobState is returned when a job cannot be retried or cancelled in its
+// current state.
+var ErrJobState = errors.New("the job cannot be changed in its current state")
+
+// Job is a unit of background work.
+type Job struct {
+	ID          int64           `json:"id"`
+	Type        string          `json:"type"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LockedBy    string          `json:"locked_by,omitempty"`
+	LockedUntil *time.Time      `json:"locked_until,omitempty"`
+	LastError   string          `json:"last_error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	FinishedAt  *time.Time      `json:"finished_at,omitempty"`
+}
+
+// Decode unmarshals the job's payload into v.
+func (j Job) Decode(v interface{}) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+const jobColumns = `id, job_type, payload, status, attempts, run_at, COALESCE(locked_by, ''),
+               locked_until, COALESCE(last_error, ''), created_at, updated_at, finished_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanJob(row rowScanner) (Job, error) {
+	var (
+		j           Job
+		payload     []byte
+		lockedUntil sql.NullTime
+		finishedAt  sql.NullTime
+	)
+	if err := row.Scan(&j.ID, &j.Type, &payload, &j.Status, &j.Attempts, &j.RunAt, &j.LockedBy,
+		&lockedUntil, &j.LastError, &j.CreatedAt, &j.UpdatedAt, &finishedAt); err != nil {
+		return Job{}, err
+	}
+	j.Payload = json.RawMessage(payload)
+	if lockedUntil.Valid {
+		j.LockedUntil = &lockedUntil.Time
+	}
+	if finishedAt.Valid {
+		j.FinishedAt = &finishedAt.Time
+	}
+	return j, nil
+}
+
+// EnqueueJob queues a job of the given type to run as soon as a worker is free.
+func (app *App) EnqueueJob(jobType string, payload interface{}) (Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Job{}, err
+	}
+	return scanJob(app.DB.QueryRow(`
+        INSERT INTO jobs (job_type, payload)
+        VALUES ($1, $2)
+        RETURNING `+jobColumns, jobType, data))
+}
+
+// ClaimJob locks the next due job of one of the given types for workerID
+// until the lease runs out, counting the attempt. It reports false when no
+// job is due.
+func (app *App) ClaimJob(workerID string, types []string, lease time.Duration) (Job, bool, error) {
+	job, err := scanJob(app.DB.QueryRow(`
+        UPDATE jobs
+        SET status = 'running',
+            attempts = attempts + 1,
+            locked_by = $2,
+            locked_until = CURRENT_TIMESTAMP + $3 * INTERVAL '1 second',
+            updated_at = CURRENT_TIMESTAMP
+        WHERE id = (
+            SELECT id
+            FROM jobs
+            WHERE status = 'queued'
+              AND run_at <= CURRENT_TIMESTAMP
+              AND job_type = ANY($1)
+            ORDER BY run_at, id
+            LIMIT 1
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING `+jobColumns, pq.Array(types), workerID, lease.Seconds()))
+	if err == sql.ErrNoRows {
+		return Job{}, false, nil
+	}
+	return job, err == nil, err
+}
+
+// CompleteJob marks a job claimed by workerID as done. A job that was
+// cancelled or reclaimed meanwhile is left alone.
+func (app *App) CompleteJob(id int64, workerID string) error {
+	_, err := app.DB.Exec(`
+        UPDATE jobs
+        SET status = 'done', locked_by = NULL, locked_until = NULL, last_error = NULL,
+            updated_at = CURRENT_TIMESTAMP, finished_at = CURRENT_TIMESTAMP
+        WHERE id = $1 AND status = 'running' AND locked_by = $2
+    `, id, workerID)
+	return err
+}
+
+// RescheduleJob puts a failed job claimed by workerID back in the queue to
+// run again at runAt.
+func (app *App) RescheduleJob(id int64, workerID, lastError string, runAt time.Time) error {
+	_, err := app.DB.Exec(`
+        UPDATE jobs
+        SET status = 'queued', run_at = $3, last_error = $4, locked_by = NULL, locked_until = NULL,
+            updated_at = CURRENT_TIMESTAMP
+        WHERE id = $1 AND status = 'running' AND locked_by = $2
+    `, id, workerID, runAt, lastError)
+	return err
+}
+
+// DeadLetterJob gives up on a job claimed by workerID. It stays in the table
+// as dead until an admin retries it.
+func (app *App) DeadLetterJob(id int64, workerID, lastError string) error {
+	_, err := app.DB.Exec(`
+        UPDATE jobs
+        SET status = 'dead', last_error = $3, locked_by = NULL, locked_until = NULL,
+            updated_at = CURRENT_TIMESTAMP, finished_at = CURRENT_TIMESTAMP
+        WHERE id = $1 AND status = 'running' AND locked_by = $2
+    `, id, workerID, lastError)
+	return err
+}
+
+// RequeueExpiredJobs puts running jobs whose lease ran out, because their
+// worker died or hung, back in the queue. It returns how many were requeued.
+func (app *App) RequeueExpiredJobs() (int64, error) {
+	res, err := app.DB.Exec(`
+        UPDATE jobs
+        SET status = 'queued', run_at = CURRENT_TIMESTAMP, last_error = 'worker lease expired',
+            locked_by = NULL, locked_until = NULL, updated_at = CURRENT_TIMESTAMP
+        WHERE status = 'running' AND locked_until < CURRENT_TIMESTAMP
+    `)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// GetJob returns the job with the given ID.
+func (app *App) GetJob(id int64) (Job, error) {
+	return scanJob(app.DB.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE id = $1`, id))
+}
+
+// ListJobs returns up to limit jobs, newest first, optionally filtered by
+// status and type.
+func (app *App) ListJobs(status, jobType string, limit int) ([]Job, error) {
+	rows, err := app.DB.Query(`
+        SELECT `+jobColumns+`
+        FROM jobs
+        WHERE ($1 = '' OR status = $1)
+          AND ($2 = '' OR job_type = $2)
+        ORDER BY created_at DESC, id DESC
+        LIMIT $3
+    `, status, jobType, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// JobCounts counts the jobs in each state.
+func (app *App) JobCounts() (map[string]int, error) {
+	rows, err := app.DB.Query(`SELECT status, COUNT(*) FROM jobs GROUP BY status`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{JobQueued: 0, JobRunning: 0, JobDone: 0, JobDead: 0, JobCancelled: 0}
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		counts[status] = count
+	}
+	return counts, rows.Err()
+}
+
+// RetryJob queues a dead or cancelled job again with a fresh set of attempts.
+func (app *App) RetryJob(id int64) (Job, error) {
+	job, err := scanJob(app.DB.QueryRow(`
+        UPDATE jobs
+        SET status = 'queued', attempts = 0, run_at = CURRENT_TIMESTAMP,
+            updated_at = CURRENT_TIMESTAMP, finished_at = NULL
+        WHERE id = $1 AND status IN ('dead', 'cancelled')
+        RETURNING `+jobColumns, id))
+	if err == sql.ErrNoRows {
+		return app.jobStateError(id)
+	}
+	return job, err
+}
+
+// CancelJob cancels a queued or running job. A running job's worker finds
+// out when it reports back; its result is discarded.
+func (app *App) CancelJob(id int64) (Job, error) {
+	job, err := scanJob(app.DB.QueryRow(`
+        UPDATE jobs
+        SET status = 'cancelled', locked_by = NULL, locked_until = NULL,
+            updated_at = CURRENT_TIMESTAMP, finished_at = CURRENT_TIMESTAMP
+        WHERE id = $1 AND status IN ('queued', 'running')
+        RETURNING `+jobColumns, id))
+	if err == sql.ErrNoRows {
+		return app.jobStateError(id)
+	}
+	return job, err
+}
+
+// jobStateError tells a missing job (sql.ErrNoRows) from one in the wrong
+// state (ErrJobState).
+func (app *App) jobStateError(id int64) (Job, error) {
+	job, err := app.GetJob(id)
+	if err != nil {
+		return Job{}, err
+	}
+	return job, ErrJobState
+}
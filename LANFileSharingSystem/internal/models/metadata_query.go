@@ -0,0 +1,249 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// -------------------------------------
+//  Metadata Queries
+// -------------------------------------
+
+// Operators a MetadataCondition can apply to a metadata value.
+const (
+	MetaEq       = "eq"
+	MetaNe       = "ne"
+	MetaGt       = "gt"
+	MetaGte      = "gte"
+	MetaLt       = "lt"
+	MetaLte      = "lte"
+	MetaExists   = "exists"
+	MetaContains = "contains"
+)
+
+// ErrInvalidFileQuery is wrapped by the errors QueryFiles returns for a
+// malformed query.
+var ErrInvalidFileQuery = errors.New("invalid file query")
+
+// MetadataOperators lists the supported condition operators.
+var MetadataOperators = []string{MetaEq, MetaNe, MetaGt, MetaGte, MetaLt, MetaLte, MetaExists, MetaContains}
+
+// MetadataCondition filters files on one metadata key. Path holds the key,
+// with one element per level for nested objects.
+//
+// Values are given as text. eq and ne match a string equal to Value, or a
+// number or boolean that Value spells. The range operators compare numbers
+// numerically when Value is a number and strings lexically otherwise, which
+// orders ISO dates correctly. exists takes "true" or "false". contains
+// matches an array holding Value, or a JSON object or array Value is a
+// subset of.
+type MetadataCondition struct {
+	Path  []string
+	Op    string
+	Value string
+}
+
+// FileQuery selects files by metadata and file attributes. Sort is
+// "created_at", "file_name", "size" or a metadata path; Directory matches
+// the folder and everything below it. A non-nil Folders limits the query to
+// those folders and their subfolders.
+type FileQuery struct {
+	Conditions []MetadataCondition
+	Contains   map[string]interface{}
+	Directory  string
+	Uploader   string
+	From       *time.Time
+	To         *time.Time
+	Folders    []string
+	Sort       string
+	SortPath   []string
+	Descending bool
+	Limit      int
+	Offset     int
+}
+
+// FileMatch is a file returned by a metadata query.
+type FileMatch struct {
+	FileRecord
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// queryBuilder collects SQL conditions and their arguments.
+type queryBuilder struct {
+	where []string
+	args  []interface{}
+}
+
+// arg adds a query argument and returns its placeholder.
+func (b *queryBuilder) arg(v interface{}) string {
+	b.args = append(b.args, v)
+	return fmt.Sprintf("$%d", len(b.args))
+}
+
+func (b *queryBuilder) add(format string, v ...interface{}) {
+	b.where = append(b.where, fmt.Sprintf(format, v...))
+}
+
+// nestObject wraps v in one object per path element: ["a","b"] gives {"a":{"b":v}}.
+func nestObject(path []string, v interface{}) string {
+	for i := len(path) - 1; i >= 0; i-- {
+		v = map[string]interface{}{path[i]: v}
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// scalarValue returns the number or boolean a query value spells, if any.
+func scalarValue(s string) (interface{}, bool) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return nil, false
+	}
+	switch v.(type) {
+	case float64, bool:
+		return v, true
+	}
+	return nil, false
+}
+
+// condition appends the SQL for c.
+func (b *queryBuilder) condition(c MetadataCondition) error {
+	path := func() string { return b.arg(pq.Array(c.Path)) }
+	switch c.Op {
+	case MetaEq, MetaNe:
+		// Containment lets the GIN index on metadata answer equality.
+		match := fmt.Sprintf("f.metadata @> %s::jsonb", b.arg(nestObject(c.Path, c.Value)))
+		if v, ok := scalarValue(c.Value); ok {
+			match = fmt.Sprintf("(%s OR f.metadata @> %s::jsonb)", match, b.arg(nestObject(c.Path, v)))
+		}
+		if c.Op == MetaNe {
+			match = "NOT " + match
+		}
+		b.where = append(b.where, match)
+	case MetaGt, MetaGte, MetaLt, MetaLte:
+		op := map[string]string{MetaGt: ">", MetaGte: ">=", MetaLt: "<", MetaLte: "<="}[c.Op]
+		if v, ok := scalarValue(c.Value); ok {
+			if _, isNumber := v.(float64); isNumber {
+				b.add("(CASE WHEN jsonb_typeof(f.metadata #> %[1]s) = 'number' THEN (f.metadata #>> %[1]s)::numeric END) %[2]s %[3]s::numeric",
+					path(), op, b.arg(c.Value))
+				return nil
+			}
+		}
+		b.add("(CASE WHEN jsonb_typeof(f.metadata #> %[1]s) = 'string' THEN f.metadata #>> %[1]s END) %[2]s %[3]s",
+			path(), op, b.arg(c.Value))
+	case MetaExists:
+		switch c.Value {
+		case "true", "":
+			b.add("f.metadata #> %s IS NOT NULL", path())
+		case "false":
+			b.add("f.metadata #> %s IS NULL", path())
+		default:
+			return fmt.Errorf("%w: exists takes true or false, not %q", ErrInvalidFileQuery, c.Value)
+		}
+	case MetaContains:
+		var v interface{}
+		if err := json.Unmarshal([]byte(c.Value), &v); err != nil {
+			v = c.Value
+		}
+		switch v.(type) {
+		case map[string]interface{}, []interface{}:
+		default:
+			v = []interface{}{v}
+		}
+		b.add("f.metadata @> %s::jsonb", b.arg(nestObject(c.Path, v)))
+	default:
+		return fmt.Errorf("%w: unknown operator %q", ErrInvalidFileQuery, c.Op)
+	}
+	return nil
+}
+
+// QueryFiles returns the files matching q and the total number of matches.
+func (app *App) QueryFiles(q FileQuery) ([]FileMatch, int, error) {
+	b := &queryBuilder{}
+	b.add("f.file_path NOT LIKE '.trash/%%'")
+	b.add("f.file_path NOT LIKE '.quarantine/%%'")
+	for _, c := range q.Conditions {
+		if err := b.condition(c); err != nil {
+			return nil, 0, err
+		}
+	}
+	if len(q.Contains) > 0 {
+		data, err := json.Marshal(q.Contains)
+		if err != nil {
+			return nil, 0, err
+		}
+		b.add("f.metadata @> %s::jsonb", b.arg(string(data)))
+	}
+	if q.Directory != "" {
+		dir := b.arg(q.Directory)
+		b.add("(f.directory = %[1]s OR left(f.directory, length(%[1]s) + 1) = %[1]s || '/')", dir)
+	}
+	if q.Uploader != "" {
+		b.add("f.uploader = %s", b.arg(q.Uploader))
+	}
+	if q.Folders != nil {
+		b.add(`EXISTS (SELECT 1 FROM unnest(%s::text[]) AS r(dir)
+              WHERE f.directory = r.dir OR left(f.directory, length(r.dir) + 1) = r.dir || '/')`, b.arg(pq.Array(q.Folders)))
+	}
+	if q.From != nil {
+		b.add("f.created_at >= %s", b.arg(*q.From))
+	}
+	if q.To != nil {
+		b.add("f.created_at < %s", b.arg(*q.To))
+	}
+
+	var order string
+	switch q.Sort {
+	case "file_name":
+		order = "f.file_name"
+	case "size":
+		order = "f.size"
+	case "", "created_at":
+		order = "f.created_at"
+	default:
+		// jsonb orders numbers numerically and strings lexically.
+		order = fmt.Sprintf("f.metadata #> %s", b.arg(pq.Array(q.SortPath)))
+	}
+	if q.Descending {
+		order += " DESC NULLS LAST"
+	} else {
+		order += " ASC NULLS LAST"
+	}
+
+	query := fmt.Sprintf(`
+        SELECT f.id, f.file_name, f.directory, f.file_path, COALESCE(f.size, 0),
+               COALESCE(f.content_type, ''), COALESCE(f.uploader, ''), COALESCE(f.metadata, '{}'),
+               COALESCE(f.checksum, ''), f.created_at, COUNT(*) OVER ()
+        FROM files f
+        WHERE %s
+        ORDER BY %s, f.id
+        LIMIT %s OFFSET %s
+    `, strings.Join(b.where, "\n          AND "), order, b.arg(q.Limit), b.arg(q.Offset))
+
+	rows, err := app.DB.Query(query, b.args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var files []FileMatch
+	total := 0
+	for rows.Next() {
+		var m FileMatch
+		var metadata []byte
+		if err := rows.Scan(&m.ID, &m.FileName, &m.Directory, &m.FilePath, &m.Size, &m.ContentType, &m.Uploader,
+			&metadata, &m.Checksum, &m.CreatedAt, &total); err != nil {
+			return nil, 0, err
+		}
+		if err := json.Unmarshal(metadata, &m.Metadata); err != nil {
+			return nil, 0, err
+		}
+		files = append(files, m)
+	}
+	return files, total, rows.Err()
+}
@@ -0,0 +1,300 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// -------------------------------------
+//  Metadata Schemas
+// -------------------------------------
+
+// MetadataSchema is a JSON Schema that the metadata of files in Directory,
+// and in the folders below it, must satisfy. A deeper folder's own schema
+// takes precedence.
+type MetadataSchema struct {
+	ID        int             `json:"id"`
+	Directory string          `json:"directory"`
+	Schema    json.RawMessage `json:"schema"`
+	UpdatedBy string          `json:"updated_by"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// MetadataFieldError is one way metadata fails its schema. Field is the
+// dotted path of the offending key, or "" for the metadata as a whole.
+type MetadataFieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// MetadataValidationError is returned for metadata that does not satisfy
+// the schema of the folder it is stored in.
+type MetadataValidationError struct {
+	Directory string // the folder the schema is attached to
+	Fields    []MetadataFieldError
+}
+
+func (e *MetadataValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Message
+		if f.Field != "" {
+			msgs[i] = f.Field + ": " + f.Message
+		}
+	}
+	return fmt.Sprintf("metadata does not match the schema for '%s': %s", e.Directory, strings.Join(msgs, "; "))
+}
+
+// CompileMetadataSchema parses a JSON Schema. Formats such as "date" are
+// enforced, and references to other documents are not allowed.
+func CompileMetadataSchema(raw json.RawMessage) (*jsonschema.Schema, error) {
+	const location = "mem://metadata-schema.json"
+	c := jsonschema.NewCompiler()
+	c.AssertFormat = true
+	c.LoadURL = func(s string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("references to other documents are not supported: %s", s)
+	}
+	if err := c.AddResource(location, strings.NewReader(string(raw))); err != nil {
+		return nil, err
+	}
+	return c.Compile(location)
+}
+
+// Validate checks metadata against the schema. It returns a
+// *MetadataValidationError listing every problem found.
+func (s MetadataSchema) Validate(metadata map[string]interface{}) error {
+	schema, err := CompileMetadataSchema(s.Schema)
+	if err != nil {
+		return fmt.Errorf("compile schema for %q: %w", s.Directory, err)
+	}
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	err = schema.Validate(metadata)
+	var ve *jsonschema.ValidationError
+	if !errors.As(err, &ve) {
+		return err
+	}
+	fields := metadataFieldErrors(ve, nil)
+	sort.SliceStable(fields, func(i, j int) bool { return fields[i].Field < fields[j].Field })
+	return &MetadataValidationError{Directory: s.Directory, Fields: fields}
+}
+
+// metadataFieldErrors flattens a validation error into its leaf causes.
+// Missing and unexpected keys are reported against the keys themselves.
+func metadataFieldErrors(ve *jsonschema.ValidationError, fields []MetadataFieldError) []MetadataFieldError {
+	if len(ve.Causes) > 0 {
+		for _, cause := range ve.Causes {
+			fields = metadataFieldErrors(cause, fields)
+		}
+		return fields
+	}
+
+	field := metadataField(ve.InstanceLocation)
+	keyword := ve.KeywordLocation[strings.LastIndexByte(ve.KeywordLocation, '/')+1:]
+	var perKey string
+	switch keyword {
+	case "required":
+		perKey = "is required"
+	case "additionalProperties", "unevaluatedProperties":
+		perKey = "is not allowed"
+	}
+	if names := quotedNames(ve.Message); perKey != "" && len(names) > 0 {
+		for _, name := range names {
+			key := name
+			if field != "" {
+				key = field + "." + name
+			}
+			fields = append(fields, MetadataFieldError{Field: key, Message: perKey})
+		}
+		return fields
+	}
+	return append(fields, MetadataFieldError{Field: field, Message: ve.Message})
+}
+
+// metadataField turns the validator's instance location, a JSON pointer
+// with URL-escaped tokens, into a dotted key path.
+func metadataField(pointer string) string {
+	var parts []string
+	for _, p := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		if p == "" {
+			continue
+		}
+		if unescaped, err := url.PathUnescape(p); err == nil {
+			p = unescaped
+		}
+		parts = append(parts, strings.ReplaceAll(strings.ReplaceAll(p, "~1", "/"), "~0", "~"))
+	}
+	return strings.Join(parts, ".")
+}
+
+// quotedNames returns the property names in a validator message, which
+// quotes them Go-style between single quotes: missing properties: 'a', 'b'.
+func quotedNames(msg string) []string {
+	var names []string
+	for i := 0; i < len(msg); i++ {
+		if msg[i] != '\'' {
+			continue
+		}
+		end := i + 1
+		for end < len(msg) && msg[end] != '\'' {
+			if msg[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(msg) {
+			break
+		}
+		quoted := strings.ReplaceAll(msg[i+1:end], `\'`, `'`)
+		if name, err := strconv.Unquote(`"` + strings.ReplaceAll(quoted, `"`, `\"`) + `"`); err == nil {
+			names = append(names, name)
+		}
+		i = end
+	}
+	return names
+}
+
+const metadataSchemaColumns = `id, directory, schema, COALESCE(updated_by, ''), updated_at`
+
+func scanMetadataSchema(row rowScanner) (MetadataSchema, error) {
+	var s MetadataSchema
+	var schema []byte
+	if err := row.Scan(&s.ID, &s.Directory, &schema, &s.UpdatedBy, &s.UpdatedAt); err != nil {
+		return MetadataSchema{}, err
+	}
+	s.Schema = schema
+	return s, nil
+}
+
+// ListMetadataSchemas returns every schema, ordered by directory.
+func (app *App) ListMetadataSchemas() ([]MetadataSchema, error) {
+	rows, err := app.DB.Query(`
+        SELECT ` + metadataSchemaColumns + `
+        FROM metadata_schemas
+        ORDER BY directory
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schemas []MetadataSchema
+	for rows.Next() {
+		s, err := scanMetadataSchema(rows)
+		if err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, s)
+	}
+	return schemas, rows.Err()
+}
+
+// GetMetadataSchema returns the schema attached to exactly directory.
+func (app *App) GetMetadataSchema(directory string) (MetadataSchema, error) {
+	return scanMetadataSchema(app.DB.QueryRow(`
+        SELECT `+metadataSchemaColumns+`
+        FROM metadata_schemas
+        WHERE directory = $1
+    `, directory))
+}
+
+// EffectiveMetadataSchema returns the schema that applies to files in
+// directory: its own, or else that of its nearest ancestor with one. It
+// returns sql.ErrNoRows when none applies.
+func (app *App) EffectiveMetadataSchema(directory string) (MetadataSchema, error) {
+	var dirs []string
+	for dir := path.Clean(strings.Trim(directory, "/")); dir != "" && dir != "."; dir = path.Dir(dir) {
+		dirs = append(dirs, dir)
+	}
+	if len(dirs) == 0 {
+		return MetadataSchema{}, sql.ErrNoRows
+	}
+	return scanMetadataSchema(app.DB.QueryRow(`
+        SELECT `+metadataSchemaColumns+`
+        FROM metadata_schemas
+        WHERE directory = ANY($1)
+        ORDER BY length(directory) DESC
+        LIMIT 1
+    `, pq.Array(dirs)))
+}
+
+// ValidateFileMetadata checks metadata against the schema that applies to
+// directory, if any. It returns a *MetadataValidationError when the
+// metadata does not match.
+func (app *App) ValidateFileMetadata(directory string, metadata map[string]interface{}) error {
+	schema, err := app.EffectiveMetadataSchema(directory)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return schema.Validate(metadata)
+}
+
+// SaveMetadataSchema attaches a schema to a directory, replacing any it had,
+// and returns the stored row.
+func (app *App) SaveMetadataSchema(s MetadataSchema) (MetadataSchema, error) {
+	return scanMetadataSchema(app.DB.QueryRow(`
+        INSERT INTO metadata_schemas (directory, schema, updated_by)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (directory) DO UPDATE
+        SET schema = EXCLUDED.schema, updated_by = EXCLUDED.updated_by, updated_at = CURRENT_TIMESTAMP
+        RETURNING `+metadataSchemaColumns,
+		s.Directory, []byte(s.Schema), s.UpdatedBy))
+}
+
+// DeleteMetadataSchema removes the schema attached to directory.
+func (app *App) DeleteMetadataSchema(directory string) error {
+	res, err := app.DB.Exec(`DELETE FROM metadata_schemas WHERE directory = $1`, directory)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// MoveMetadataSchemas re-attaches the schemas of a renamed or moved folder,
+// and of the folders below it, to their new paths.
+func (app *App) MoveMetadataSchemas(oldPath, newPath string) error {
+	_, err := app.DB.Exec(`
+        UPDATE metadata_schemas
+        SET directory = $2 || substr(directory, length($1) + 1)
+        WHERE directory = $1 OR left(directory, length($1) + 1) = $1 || '/'
+    `, oldPath, newPath)
+	return err
+}
+
+// UpdateFileMetadata replaces the metadata of a file.
+func (app *App) UpdateFileMetadata(fileID int, metadata map[string]interface{}) error {
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	res, err := app.DB.Exec(`UPDATE files SET metadata = $1 WHERE id = $2`, data, fileID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
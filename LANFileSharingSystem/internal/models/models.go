@@ -13,6 +13,8 @@ import (
 	"os"
 	"time"
 
+	"LANFileSharingSystem/internal/encryption"
+	"LANFileSharingSystem/internal/storage"
 	"LANFileSharingSystem/internal/ws"
 
 	"github.com/gorilla/sessions"
@@ -24,17 +26,17 @@ type App struct {
 	DB              *sql.DB
 	Store           *sessions.CookieStore
 	FileCache       map[string]FileRecord
-	FileShareTokens map[string]string // token -> file name mapping
 	NotificationHub *ws.Hub
+	Keys            *encryption.Keyring
+	Storage         storage.Backend
 }
 
 // NewApp creates a new App instance.
 func NewApp(db *sql.DB, store *sessions.CookieStore) *App {
 	return &App{
-		DB:              db,
-		Store:           store,
-		FileCache:       make(map[string]FileRecord),
-		FileShareTokens: make(map[string]string),
+		DB:        db,
+		Store:     store,
+		FileCache: make(map[string]FileRecord),
 	}
 }
 
@@ -56,6 +58,7 @@ type FileMessage struct {
 	FileID    int       `json:"file_id"`
 	Sender    string    `json:"sender"`
 	Receiver  string    `json:"receiver"`
+	Group     string    `json:"group,omitempty"` // the group the message was sent to, if any
 	Message   string    `json:"message"`
 	IsDone    bool      `json:"is_done"`
 	CreatedAt time.Time `json:"created_at"`
@@ -88,6 +91,10 @@ type FileRecord struct {
 	ContentType string                 `json:"content_type"`
 	Uploader    string                 `json:"uploader"`
 	Metadata    map[string]interface{} `json:"metadata"` // 👈 dynamic field
+
+	// SHA-256 digests (hex) of the plaintext and of the stored ciphertext.
+	Checksum           string `json:"checksum,omitempty"`
+	CiphertextChecksum string `json:"ciphertext_checksum,omitempty"`
 }
 
 // -------------------------------------
@@ -95,8 +102,9 @@ type FileRecord struct {
 // -------------------------------------
 
 type AddUserRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	Department string `json:"department"` // optional; adds the user to the department's group
 }
 
 type UpdateUserRequest struct {
@@ -147,6 +155,7 @@ func CheckPasswordHash(password, hash string) bool {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
 }
 
+// GenerateToken returns a random 128-bit token, hex-encoded.
 func (app *App) GenerateToken() (string, error) {
 	b := make([]byte, 16) // 128-bit token
 	if _, err := rand.Read(b); err != nil {
@@ -364,8 +373,8 @@ func (app *App) ListActivities() ([]map[string]interface{}, error) {
 func (app *App) CreateFileRecord(record FileRecord) error {
 	metadataJSON, _ := json.Marshal(record.Metadata)
 	_, err := app.DB.Exec(`
-		INSERT INTO files (file_name, file_path, directory, size, content_type, uploader, metadata)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO files (file_name, file_path, directory, size, content_type, uploader, metadata, checksum, ciphertext_checksum)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), NULLIF($9, ''))
 	`,
 		record.FileName,
 		record.FilePath,
@@ -374,15 +383,20 @@ func (app *App) CreateFileRecord(record FileRecord) error {
 		record.ContentType,
 		record.Uploader,
 		metadataJSON,
+		record.Checksum,
+		record.CiphertextChecksum,
 	)
 	return err
 }
 
 func (app *App) GetFileRecord(fileName string) (FileRecord, error) {
 	row := app.DB.QueryRow(`
-        SELECT id, file_name, file_path, size, content_type, uploader
+        SELECT id, file_name, file_path, size, content_type, uploader,
+               COALESCE(checksum, ''), COALESCE(ciphertext_checksum, '')
         FROM files
         WHERE file_name = $1
+          AND file_path NOT LIKE '.trash/%'
+          AND file_path NOT LIKE '.quarantine/%'
     `, fileName)
 
 	var fr FileRecord
@@ -393,6 +407,8 @@ func (app *App) GetFileRecord(fileName string) (FileRecord, error) {
 		&fr.Size,
 		&fr.ContentType,
 		&fr.Uploader,
+		&fr.Checksum,
+		&fr.CiphertextChecksum,
 	)
 	return fr, err
 }
@@ -401,6 +417,8 @@ func (app *App) ListFiles() ([]FileRecord, error) {
 	rows, err := app.DB.Query(`
         SELECT file_name, size, content_type, uploader
         FROM files
+        WHERE file_path NOT LIKE '.trash/%'
+          AND file_path NOT LIKE '.quarantine/%'
     `)
 	if err != nil {
 		return nil, err
@@ -434,24 +452,33 @@ func (app *App) DeleteDirectoryRecord(name string) error {
 	return err
 }
 
-func (app *App) UpdateDirectoryRecord(oldName, newName string) error {
-	// 1. Rename the directory record itself
-	_, err := app.DB.Exec(`
-        UPDATE directories 
-        SET directory_name = $1, updated_at = CURRENT_TIMESTAMP 
-        WHERE directory_name = $2
-    `, newName, oldName)
+// UpdateDirectoryRecord renames the folder oldName under parent and points
+// the folders below it at the new path.
+func (app *App) UpdateDirectoryRecord(parent, oldName, newName string) error {
+	oldPath := joinFolder(parent, oldName)
+	newPath := joinFolder(parent, newName)
+
+	tx, err := app.DB.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	// 2. Update any subfolders whose parent_directory = oldName
-	_, err = app.DB.Exec(`
+	if _, err := tx.Exec(`
         UPDATE directories
-        SET parent_directory = $1
-        WHERE parent_directory = $2
-    `, newName, oldName)
-	return err
+        SET directory_name = $3, updated_at = CURRENT_TIMESTAMP
+        WHERE parent_directory = $1 AND directory_name = $2
+    `, parent, oldName, newName); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+        UPDATE directories
+        SET parent_directory = $2 || substr(parent_directory, length($1) + 1)
+        WHERE parent_directory = $1 OR left(parent_directory, length($1) + 1) = $1 || '/'
+    `, oldPath, newPath); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // ListDirectory is a placeholder that can be implemented as needed.
@@ -460,6 +487,7 @@ func (app *App) ListDirectory(parent string) ([]map[string]interface{}, error) {
         SELECT directory_name, parent_directory, created_by, created_at
         FROM directories
         WHERE parent_directory = $1
+          AND parent_directory NOT LIKE '.trash/%'
     `
 	rows, err := app.DB.Query(query, parent)
 	if err != nil {
@@ -492,17 +520,21 @@ func (app *App) ListFilesInDirectory(dir string) ([]FileRecord, error) {
 	if dir == "" {
 		// Root: files with no slash at all
 		rows, err = app.DB.Query(`
-            SELECT id, file_name, file_path, size, content_type, uploader
+            SELECT id, file_name, file_path, size, content_type, uploader,
+                   COALESCE(checksum, ''), COALESCE(ciphertext_checksum, '')
             FROM files
             WHERE file_path NOT LIKE '%/%'
         `)
 	} else {
 		// Only immediate children of dir.
 		rows, err = app.DB.Query(`
-            SELECT id, file_name, file_path, size, content_type, uploader
+            SELECT id, file_name, file_path, size, content_type, uploader,
+                   COALESCE(checksum, ''), COALESCE(ciphertext_checksum, '')
             FROM files
             WHERE file_path LIKE $1 || '/%' 
               AND file_path NOT LIKE $1 || '/%/%'
+              AND file_path NOT LIKE '.trash/%'
+              AND file_path NOT LIKE '.quarantine/%'
         `, dir)
 	}
 
@@ -514,7 +546,8 @@ func (app *App) ListFilesInDirectory(dir string) ([]FileRecord, error) {
 	var results []FileRecord
 	for rows.Next() {
 		var f FileRecord
-		if err := rows.Scan(&f.ID, &f.FileName, &f.FilePath, &f.Size, &f.ContentType, &f.Uploader); err != nil {
+		if err := rows.Scan(&f.ID, &f.FileName, &f.FilePath, &f.Size, &f.ContentType, &f.Uploader,
+			&f.Checksum, &f.CiphertextChecksum); err != nil {
 			return nil, err
 		}
 		results = append(results, f)
@@ -522,6 +555,34 @@ func (app *App) ListFilesInDirectory(dir string) ([]FileRecord, error) {
 	return results, nil
 }
 
+// ListFilesUnder returns every file in dir and its subfolders, ordered by path.
+func (app *App) ListFilesUnder(dir string) ([]FileRecord, error) {
+	rows, err := app.DB.Query(`
+        SELECT id, file_name, directory, file_path, size, content_type, uploader,
+               COALESCE(checksum, ''), COALESCE(ciphertext_checksum, '')
+        FROM files
+        WHERE left(file_path, length($1) + 1) = $1 || '/'
+          AND file_path NOT LIKE '.trash/%'
+          AND file_path NOT LIKE '.quarantine/%'
+        ORDER BY file_path
+    `, dir)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []FileRecord
+	for rows.Next() {
+		var f FileRecord
+		if err := rows.Scan(&f.ID, &f.FileName, &f.Directory, &f.FilePath, &f.Size, &f.ContentType, &f.Uploader,
+			&f.Checksum, &f.CiphertextChecksum); err != nil {
+			return nil, err
+		}
+		results = append(results, f)
+	}
+	return results, rows.Err()
+}
+
 // DirectoryExists checks if a directory with the given name exists under the specified parent.
 func (app *App) DirectoryExists(name, parent string) (bool, error) {
 	var count int
@@ -566,17 +627,18 @@ func (app *App) DeleteFileRecord(fileName string) (int, error) {
 
 // UpdateFilePathsForRenamedFolder updates the file paths of all files
 // whose file_path starts with oldFolderPath by replacing that prefix with newFolderPath.
+// UpdateFilePathsForRenamedFolder points the files below oldFolderPath at
+// newFolderPath.
 func (app *App) UpdateFilePathsForRenamedFolder(oldFolderPath, newFolderPath string) error {
-	query := `
+	_, err := app.DB.Exec(`
         UPDATE files
-        SET file_path = regexp_replace(file_path, $1, $2)
-        WHERE file_path LIKE $3
-    `
-	// Create a pattern that matches the beginning of the file_path.
-	pattern := "^" + oldFolderPath
-	// Build the LIKE pattern (files whose file_path starts with oldFolderPath).
-	likePattern := oldFolderPath + "%"
-	_, err := app.DB.Exec(query, pattern, newFolderPath, likePattern)
+        SET file_path = $2 || substr(file_path, length($1) + 1),
+            directory = CASE
+                WHEN directory = $1 OR left(directory, length($1) + 1) = $1 || '/' THEN $2 || substr(directory, length($1) + 1)
+                ELSE directory
+            END
+        WHERE left(file_path, length($1) + 1) = $1 || '/'
+    `, oldFolderPath, newFolderPath)
 	return err
 }
 
@@ -763,13 +825,6 @@ func (app *App) UpdateUserPassword(username, hashedPassword string) error {
     `, hashedPassword, username)
 	return err
 }
-func (app *App) CreateFileVersion(fileID, versionNum int, path string) error {
-	_, err := app.DB.Exec(`
-        INSERT INTO file_versions (file_id, version_number, file_path)
-        VALUES ($1, $2, $3)
-    `, fileID, versionNum, path)
-	return err
-}
 
 // GetLatestVersionNumber retrieves the highest version_number for a given file_id.
 func (app *App) GetLatestVersionNumber(fileID int) (int, error) {
@@ -804,9 +859,12 @@ func (app *App) DeleteFileVersions(fileID int) error {
 func (app *App) GetFileRecordByPath(filePath string) (FileRecord, error) {
 	var fr FileRecord
 	err := app.DB.QueryRow(`
-        SELECT id, file_name, file_path, size, content_type, uploader
+        SELECT id, file_name, file_path, size, content_type, uploader,
+               COALESCE(checksum, ''), COALESCE(ciphertext_checksum, '')
         FROM files
         WHERE file_path = $1
+          AND file_path NOT LIKE '.trash/%'
+          AND file_path NOT LIKE '.quarantine/%'
     `, filePath).Scan(
 		&fr.ID,
 		&fr.FileName,
@@ -814,23 +872,42 @@ func (app *App) GetFileRecordByPath(filePath string) (FileRecord, error) {
 		&fr.Size,
 		&fr.ContentType,
 		&fr.Uploader,
+		&fr.Checksum,
+		&fr.CiphertextChecksum,
 	)
 	return fr, err
 }
-func (app *App) UpdateFileMetadata(fileID int, newSize int64, newContentType string) error {
+
+// MoveFileRecord points an existing file record at a new name and folder,
+// keeping its ID and therefore its version history.
+func (app *App) MoveFileRecord(fileID int, newName, newDirectory, newFilePath string) error {
+	_, err := app.DB.Exec(`
+        UPDATE files
+        SET file_name = $1,
+            directory = $2,
+            file_path = $3
+        WHERE id = $4
+    `, newName, newDirectory, newFilePath, fileID)
+	return err
+}
+
+// UpdateFileContent records the size, type and checksums of new content
+// written for an existing file.
+func (app *App) UpdateFileContent(fileID int, blob BlobInfo, newContentType string) error {
 	_, err := app.DB.Exec(`
         UPDATE files
         SET size = $1,
-            content_type = $2
-        WHERE id = $3
-    `, newSize, newContentType, fileID)
+            content_type = $2,
+            checksum = $3,
+            ciphertext_checksum = $4
+        WHERE id = $5
+    `, blob.Size, newContentType, blob.SHA256, blob.CiphertextSHA256, fileID)
 	return err
 }
 
-// DeleteFileVersionsInFolder removes file_versions rows for all files whose
-// file_path starts with the given folderPath prefix.
-func (app *App) DeleteFileVersionsInFolder(folderPath string) error {
-	// Step 1: Gather all file IDs in that folder (including subfolders).
+// FileIDsInFolder returns the IDs of all files whose file_path starts with
+// the given folderPath prefix (including subfolders).
+func (app *App) FileIDsInFolder(folderPath string) ([]int, error) {
 	rows, err := app.DB.Query(`
         SELECT id
         FROM files
@@ -838,7 +915,7 @@ func (app *App) DeleteFileVersionsInFolder(folderPath string) error {
            OR file_path LIKE $1 || '/%'
     `, folderPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -846,10 +923,21 @@ func (app *App) DeleteFileVersionsInFolder(folderPath string) error {
 	for rows.Next() {
 		var fid int
 		if err := rows.Scan(&fid); err != nil {
-			return err
+			return nil, err
 		}
 		fileIDs = append(fileIDs, fid)
 	}
+	return fileIDs, rows.Err()
+}
+
+// DeleteFileVersionsInFolder removes file_versions rows for all files whose
+// file_path starts with the given folderPath prefix.
+func (app *App) DeleteFileVersionsInFolder(folderPath string) error {
+	// Step 1: Gather all file IDs in that folder (including subfolders).
+	fileIDs, err := app.FileIDsInFolder(folderPath)
+	if err != nil {
+		return err
+	}
 
 	// Step 2: For each file ID, delete any version rows in file_versions.
 	for _, fid := range fileIDs {
@@ -924,7 +1012,24 @@ func (app *App) ListFileAuditLogs() ([]AuditLog, error) {
 	return logs, nil
 }
 
+// LogAudit records an audit entry. Background tasks pass an empty username,
+// which is stored as a "system" entry not linked to any user.
 func (app *App) LogAudit(username string, fileID int, action, details string) {
+	usernameAtAction := username
+	if usernameAtAction == "" {
+		usernameAtAction = "system"
+	}
+	app.logAudit(username, usernameAtAction, fileID, action, details)
+}
+
+// LogGuestAudit records an audit entry for something done without an
+// account, such as opening a share link. It is stored as a "guest" entry not
+// linked to any user.
+func (app *App) LogGuestAudit(fileID int, action, details string) {
+	app.logAudit("", "guest", fileID, action, details)
+}
+
+func (app *App) logAudit(username, usernameAtAction string, fileID int, action, details string) {
 	var nullableFileID sql.NullInt64
 	if fileID > 0 {
 		nullableFileID = sql.NullInt64{Int64: int64(fileID), Valid: true}
@@ -934,11 +1039,11 @@ func (app *App) LogAudit(username string, fileID int, action, details string) {
 
 	_, err := app.DB.Exec(`
 		INSERT INTO audit_logs (user_username, username_at_action, file_id, action, details)
-		VALUES ($1, $2, $3, $4, $5)
+		VALUES (NULLIF($1, ''), $2, $3, $4, $5)
 	`,
-		username,       // user_username
-		username,       // username_at_action (the snapshot)
-		nullableFileID, // file_id
+		username,         // user_username
+		usernameAtAction, // username_at_action (the snapshot)
+		nullableFileID,   // file_id
 		action,
 		details,
 	)
@@ -951,7 +1056,7 @@ func (app *App) LogAudit(username string, fileID int, action, details string) {
 }
 
 func (app *App) ListAllFiles() ([]FileRecord, error) {
-	rows, err := app.DB.Query("SELECT file_name, size, content_type, uploader FROM files")
+	rows, err := app.DB.Query("SELECT id, file_name, directory, file_path, size, content_type, uploader FROM files WHERE file_path NOT LIKE '.trash/%' AND file_path NOT LIKE '.quarantine/%'")
 	if err != nil {
 		log.Println("Error fetching all files:", err)
 		return nil, err
@@ -961,7 +1066,7 @@ func (app *App) ListAllFiles() ([]FileRecord, error) {
 	var files []FileRecord
 	for rows.Next() {
 		var file FileRecord
-		if err := rows.Scan(&file.FileName, &file.Size, &file.ContentType, &file.Uploader); err != nil {
+		if err := rows.Scan(&file.ID, &file.FileName, &file.Directory, &file.FilePath, &file.Size, &file.ContentType, &file.Uploader); err != nil {
 			log.Println("Error scanning file row:", err)
 			return nil, err
 		}
@@ -972,12 +1077,12 @@ func (app *App) ListAllFiles() ([]FileRecord, error) {
 
 // GetFileRecordByID retrieves a file record by its ID.
 func (app *App) GetFileRecordByID(fileID int) (FileRecord, error) {
-	query := "SELECT id, file_name, directory, file_path, size, content_type, uploader, FROM files WHERE id = $1"
+	query := "SELECT id, file_name, directory, file_path, size, content_type, uploader, COALESCE(checksum, ''), COALESCE(ciphertext_checksum, '') FROM files WHERE id = $1 AND file_path NOT LIKE '.trash/%' AND file_path NOT LIKE '.quarantine/%'"
 	log.Printf("Executing query: %s with fileID: %d", query, fileID)
 	row := app.DB.QueryRow(query, fileID)
 
 	var fr FileRecord
-	err := row.Scan(&fr.ID, &fr.FileName, &fr.Directory, &fr.FilePath, &fr.Size, &fr.ContentType, &fr.Uploader)
+	err := row.Scan(&fr.ID, &fr.FileName, &fr.Directory, &fr.FilePath, &fr.Size, &fr.ContentType, &fr.Uploader, &fr.Checksum, &fr.CiphertextChecksum)
 	if err != nil {
 		log.Printf("Error scanning file record for id %d: %v", fileID, err)
 	} else {
@@ -0,0 +1,360 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// -------------------------------------
+//  Folder Permissions
+// -------------------------------------
+
+// Permission is a set of things a user may do in a folder. Granting one
+// implies the weaker ones: manage includes write and share, and both of
+// those include read.
+type Permission int
+
+const (
+	// PermRead lets a user list, preview, download and search files.
+	PermRead Permission = 1 << iota
+	// PermWrite lets a user upload, edit, move and delete files, and create
+	// folders.
+	PermWrite
+	// PermShare lets a user share files with people outside the system.
+	PermShare
+	// PermManage lets a user rename, move and delete folders and grant
+	// permissions on them.
+	PermManage
+)
+
+// ErrInvalidPermission is returned for an unknown permission name.
+var ErrInvalidPermission = errors.New("permission must be read, write, share or manage")
+
+// permissionNames lists the permissions by name, weakest first.
+var permissionNames = []struct {
+	name string
+	perm Permission
+}{
+	{"read", PermRead},
+	{"write", PermWrite},
+	{"share", PermShare},
+	{"manage", PermManage},
+}
+
+// ParsePermission returns the permission with the given name.
+func ParsePermission(name string) (Permission, error) {
+	for _, p := range permissionNames {
+		if p.name == name {
+			return p.perm, nil
+		}
+	}
+	return 0, ErrInvalidPermission
+}
+
+// String returns the name of a single permission.
+func (p Permission) String() string {
+	for _, n := range permissionNames {
+		if n.perm == p {
+			return n.name
+		}
+	}
+	return strings.Join(p.Names(), ",")
+}
+
+// Names lists the permissions in p, weakest first.
+func (p Permission) Names() []string {
+	names := []string{}
+	for _, n := range permissionNames {
+		if p&n.perm != 0 {
+			names = append(names, n.name)
+		}
+	}
+	return names
+}
+
+// withImplied adds the permissions that those in p imply.
+func (p Permission) withImplied() Permission {
+	if p&PermManage != 0 {
+		p |= PermWrite | PermShare
+	}
+	if p&(PermWrite|PermShare) != 0 {
+		p |= PermRead
+	}
+	return p
+}
+
+// FolderGrant gives a user, or every member of a group, one permission on a
+// directory and everything below it. Either Username or GroupID is set.
+type FolderGrant struct {
+	ID         int       `json:"id"`
+	Directory  string    `json:"directory"`
+	Username   string    `json:"username,omitempty"`
+	GroupID    int       `json:"group_id,omitempty"`
+	Group      string    `json:"group,omitempty"`
+	Permission string    `json:"permission"`
+	GrantedBy  string    `json:"granted_by"`
+	GrantedAt  time.Time `json:"granted_at"`
+}
+
+// folderGrantColumns are the columns scanFolderGrant reads, from
+// folder_permissions p joined with user_groups g.
+const folderGrantColumns = `p.id, p.directory, COALESCE(p.username, ''), COALESCE(p.group_id, 0), COALESCE(g.name, ''),
+               p.permission, COALESCE(p.granted_by, ''), p.granted_at`
+
+func scanFolderGrant(row interface{ Scan(...interface{}) error }) (FolderGrant, error) {
+	var g FolderGrant
+	err := row.Scan(&g.ID, &g.Directory, &g.Username, &g.GroupID, &g.Group, &g.Permission, &g.GrantedBy, &g.GrantedAt)
+	return g, err
+}
+
+// CleanFolderPath returns the canonical form of a folder path, such as
+// "Operation/Reports", with "" for the root. ".." elements cannot climb
+// above the root.
+func CleanFolderPath(dir string) string {
+	return strings.Trim(path.Clean("/"+strings.ReplaceAll(dir, `\`, "/")), "/")
+}
+
+// FileFolder returns the folder a file is stored in.
+func FileFolder(fr FileRecord) string {
+	return CleanFolderPath(path.Dir(strings.ReplaceAll(fr.FilePath, `\`, "/")))
+}
+
+// folderAncestors returns dir and every folder above it, root first.
+func folderAncestors(dir string) []string {
+	dirs := []string{""}
+	if dir == "" {
+		return dirs
+	}
+	parts := strings.Split(dir, "/")
+	for i := range parts {
+		dirs = append(dirs, strings.Join(parts[:i+1], "/"))
+	}
+	return dirs
+}
+
+// Access is what one user may do in the file repository. Admins may do
+// everything; other users hold what their own folder grants and those of
+// their groups give them.
+type Access struct {
+	Username string
+	Admin    bool
+	grants   map[string]Permission // by directory, implied permissions included
+}
+
+// UserAccess loads the folder permissions of user.
+func (app *App) UserAccess(user User) (*Access, error) {
+	access := &Access{Username: user.Username, Admin: user.Role == "admin", grants: make(map[string]Permission)}
+	if access.Admin {
+		return access, nil
+	}
+	rows, err := app.DB.Query(`
+        SELECT directory, permission
+        FROM folder_permissions
+        WHERE username = $1
+           OR group_id IN (SELECT group_id FROM user_group_members WHERE username = $1)
+    `, user.Username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var dir, name string
+		if err := rows.Scan(&dir, &name); err != nil {
+			return nil, err
+		}
+		perm, err := ParsePermission(name)
+		if err != nil {
+			continue
+		}
+		dir = CleanFolderPath(dir)
+		access.grants[dir] = (access.grants[dir] | perm).withImplied()
+	}
+	return access, rows.Err()
+}
+
+// Permissions returns what the user may do in dir, inherited from the
+// folders above it included.
+func (a *Access) Permissions(dir string) Permission {
+	if a.Admin {
+		return PermRead | PermWrite | PermShare | PermManage
+	}
+	var perm Permission
+	for _, d := range folderAncestors(CleanFolderPath(dir)) {
+		perm |= a.grants[d]
+	}
+	return perm
+}
+
+// Can reports whether the user holds perm in dir.
+func (a *Access) Can(dir string, perm Permission) bool {
+	return a.Permissions(dir)&perm == perm
+}
+
+// CanFile reports whether the user holds perm in the folder of fr.
+func (a *Access) CanFile(fr FileRecord, perm Permission) bool {
+	return a.Can(FileFolder(fr), perm)
+}
+
+// CanSeeFolder reports whether dir shows up for the user in folder
+// listings: it can be read, or it leads to a folder that can. The files of
+// a folder that only leads somewhere stay hidden.
+func (a *Access) CanSeeFolder(dir string) bool {
+	dir = CleanFolderPath(dir)
+	if a.Can(dir, PermRead) {
+		return true
+	}
+	for d, perm := range a.grants {
+		if perm&PermRead != 0 && (dir == "" || strings.HasPrefix(d, dir+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadableFolders returns the topmost folders the user may read, for
+// narrowing queries down to them, or nil when the user may read everything.
+func (a *Access) ReadableFolders() []string {
+	if a.Admin || a.grants[""]&PermRead != 0 {
+		return nil
+	}
+	folders := []string{}
+	for d, perm := range a.grants {
+		if perm&PermRead != 0 && !a.readableAbove(d) {
+			folders = append(folders, d)
+		}
+	}
+	sort.Strings(folders)
+	return folders
+}
+
+// readableAbove reports whether a folder above dir is readable.
+func (a *Access) readableAbove(dir string) bool {
+	ancestors := folderAncestors(dir)
+	for _, d := range ancestors[:len(ancestors)-1] {
+		if a.grants[d]&PermRead != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterFiles returns the files in files the user may read.
+func (a *Access) FilterFiles(files []FileRecord) []FileRecord {
+	if a.Admin {
+		return files
+	}
+	var readable []FileRecord
+	for _, fr := range files {
+		if a.CanFile(fr, PermRead) {
+			readable = append(readable, fr)
+		}
+	}
+	return readable
+}
+
+// ListFolderGrants returns the grants that apply to dir: its own and those
+// it inherits from the folders above it, nearest last.
+func (app *App) ListFolderGrants(dir string) ([]FolderGrant, error) {
+	dir = CleanFolderPath(dir)
+	rows, err := app.DB.Query(`
+        SELECT `+folderGrantColumns+`
+        FROM folder_permissions p
+        LEFT JOIN user_groups g ON g.id = p.group_id
+        WHERE p.directory = ANY($1)
+        ORDER BY length(p.directory), g.name NULLS FIRST, p.username, p.permission
+    `, pq.Array(folderAncestors(dir)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var grants []FolderGrant
+	for rows.Next() {
+		g, err := scanFolderGrant(rows)
+		if err != nil {
+			return nil, err
+		}
+		grants = append(grants, g)
+	}
+	return grants, rows.Err()
+}
+
+// GetFolderGrant returns one grant by ID.
+func (app *App) GetFolderGrant(id int) (FolderGrant, error) {
+	return scanFolderGrant(app.DB.QueryRow(`
+        SELECT `+folderGrantColumns+`
+        FROM folder_permissions p
+        LEFT JOIN user_groups g ON g.id = p.group_id
+        WHERE p.id = $1
+    `, id))
+}
+
+// GrantFolderPermission gives username perm on dir. Granting a permission
+// the user already holds there returns the existing grant and false.
+func (app *App) GrantFolderPermission(dir, username string, perm Permission, grantedBy string) (FolderGrant, bool, error) {
+	return app.grantFolderPermission(dir, username, 0, perm, grantedBy)
+}
+
+// GrantGroupFolderPermission gives every member of a group perm on dir, like
+// GrantFolderPermission.
+func (app *App) GrantGroupFolderPermission(dir string, groupID int, perm Permission, grantedBy string) (FolderGrant, bool, error) {
+	return app.grantFolderPermission(dir, "", groupID, perm, grantedBy)
+}
+
+// grantFolderPermission grants perm on dir to username or, when username is
+// empty, to the group.
+func (app *App) grantFolderPermission(dir, username string, groupID int, perm Permission, grantedBy string) (FolderGrant, bool, error) {
+	dir = CleanFolderPath(dir)
+	var id int
+	err := app.DB.QueryRow(`
+        INSERT INTO folder_permissions (directory, username, group_id, permission, granted_by)
+        VALUES ($1, NULLIF($2, ''), NULLIF($3, 0), $4, $5)
+        ON CONFLICT DO NOTHING
+        RETURNING id
+    `, dir, username, groupID, perm.String(), grantedBy).Scan(&id)
+	created := err == nil
+	if err == sql.ErrNoRows {
+		err = app.DB.QueryRow(`
+            SELECT id
+            FROM folder_permissions
+            WHERE directory = $1 AND permission = $4
+              AND username IS NOT DISTINCT FROM NULLIF($2, '')
+              AND group_id IS NOT DISTINCT FROM NULLIF($3, 0)
+        `, dir, username, groupID, perm.String()).Scan(&id)
+	}
+	if err != nil {
+		return FolderGrant{}, false, err
+	}
+	g, err := app.GetFolderGrant(id)
+	return g, created, err
+}
+
+// RevokeFolderGrant removes a grant.
+func (app *App) RevokeFolderGrant(id int) error {
+	res, err := app.DB.Exec(`DELETE FROM folder_permissions WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// MoveFolderPermissions rewrites the grants on a folder and the folders
+// below it after the folder is renamed or moved to newPath.
+func (app *App) MoveFolderPermissions(oldPath, newPath string) error {
+	_, err := app.DB.Exec(`
+        UPDATE folder_permissions
+        SET directory = $2 || substr(directory, length($1) + 1)
+        WHERE directory = $1 OR left(directory, length($1) + 1) = $1 || '/'
+    `, oldPath, newPath)
+	return err
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"context"
+	"fmt"
+
+	"LANFileSharingSystem/internal/storage"
+)
+
+// -------------------------------------
+//  Preview Cache
+// -------------------------------------
+
+// PreviewsPrefix is the storage prefix that holds cached PDF previews of
+// documents, encrypted like any other blob.
+const PreviewsPrefix = ".previews"
+
+// PreviewStorageKey returns the storage key the PDF preview of one version
+// of a file is cached under.
+func PreviewStorageKey(fileID, version int) string {
+	return fmt.Sprintf("%s/%d/v%d.pdf", PreviewsPrefix, fileID, version)
+}
+
+// DeletePreviewBlobs removes every cached preview of a file.
+func (app *App) DeletePreviewBlobs(ctx context.Context, fileID int) error {
+	return storage.DeletePrefix(ctx, app.Storage, fmt.Sprintf("%s/%d", PreviewsPrefix, fileID))
+}
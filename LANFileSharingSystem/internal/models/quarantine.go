@@ -0,0 +1,147 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"time"
+)
+
+// -------------------------------------
+//  Quarantine
+// -------------------------------------
+
+// QuarantinePrefix is the storage prefix that holds quarantined content. Entry
+// n keeps its blob at ".quarantine/<n>/<name>", out of reach of every path
+// lookup.
+const QuarantinePrefix = ".quarantine"
+
+// Where quarantined content was caught.
+const (
+	QuarantineUploadSource = "upload"
+	QuarantineRescanSource = "rescan"
+)
+
+// QuarantinedFile is content the virus scanner flagged. FileID is set for a
+// stored file caught by a rescan, whose file record now lives in the
+// quarantine.
+type QuarantinedFile struct {
+	ID         int       `json:"id"`
+	FileID     *int      `json:"file_id"`
+	Source     string    `json:"source"`
+	FileName   string    `json:"file_name"`
+	Directory  string    `json:"directory"`
+	Size       int64     `json:"size"`
+	Checksum   string    `json:"checksum"`
+	Signature  string    `json:"signature"`
+	Uploader   string    `json:"uploader"`
+	DetectedAt time.Time `json:"detected_at"`
+
+	StorageKey string `json:"-"`
+}
+
+// QuarantinePath returns the folder quarantine entry id lives in.
+func QuarantinePath(id int) string {
+	return fmt.Sprintf("%s/%d", QuarantinePrefix, id)
+}
+
+// QuarantineUpload records q and stores src, encrypted, in the quarantine
+// area. The returned entry has its ID, key, size and checksum filled in.
+func (app *App) QuarantineUpload(ctx context.Context, q QuarantinedFile, src io.Reader) (QuarantinedFile, error) {
+	err := app.DB.QueryRow(`
+        INSERT INTO quarantined_files (file_name, directory, signature, uploader)
+        VALUES ($1, $2, $3, $4)
+        RETURNING id, detected_at
+    `, q.FileName, q.Directory, q.Signature, q.Uploader).Scan(&q.ID, &q.DetectedAt)
+	if err != nil {
+		return QuarantinedFile{}, err
+	}
+
+	q.Source = QuarantineUploadSource
+	q.StorageKey = QuarantinePath(q.ID) + "/" + q.FileName
+	info, err := app.PutEncrypted(ctx, q.StorageKey, src)
+	if err != nil {
+		app.DB.Exec(`DELETE FROM quarantined_files WHERE id = $1`, q.ID)
+		return QuarantinedFile{}, err
+	}
+	q.Size, q.Checksum = info.Size, info.SHA256
+
+	_, err = app.DB.Exec(`
+        UPDATE quarantined_files
+        SET storage_key = $1, size = $2, checksum = $3
+        WHERE id = $4
+    `, q.StorageKey, q.Size, q.Checksum, q.ID)
+	return q, err
+}
+
+// QuarantineStoredFile moves the stored file fr into the quarantine. Like a
+// trashed file, it keeps its file record, relocated under the entry's folder,
+// so its ID and version history survive.
+func (app *App) QuarantineStoredFile(ctx context.Context, fr FileRecord, signature string) (QuarantinedFile, error) {
+	fileID := fr.ID
+	q := QuarantinedFile{
+		FileID:    &fileID,
+		Source:    QuarantineRescanSource,
+		FileName:  fr.FileName,
+		Directory: fr.Directory,
+		Size:      fr.Size,
+		Checksum:  fr.Checksum,
+		Signature: signature,
+		Uploader:  fr.Uploader,
+	}
+	err := app.DB.QueryRow(`
+        INSERT INTO quarantined_files (file_id, source, file_name, directory, size, checksum, signature, uploader)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+        RETURNING id, detected_at
+    `, fr.ID, q.Source, q.FileName, q.Directory, q.Size, q.Checksum, q.Signature, q.Uploader).Scan(&q.ID, &q.DetectedAt)
+	if err != nil {
+		return QuarantinedFile{}, err
+	}
+
+	q.StorageKey = QuarantinePath(q.ID) + "/" + fr.FileName
+	if err := app.Storage.Move(ctx, fr.FilePath, q.StorageKey); err != nil {
+		app.DB.Exec(`DELETE FROM quarantined_files WHERE id = $1`, q.ID)
+		return QuarantinedFile{}, err
+	}
+	if err := app.MoveFileRecord(fr.ID, fr.FileName, QuarantinePath(q.ID), q.StorageKey); err != nil {
+		app.Storage.Move(ctx, q.StorageKey, fr.FilePath)
+		app.DB.Exec(`DELETE FROM quarantined_files WHERE id = $1`, q.ID)
+		return QuarantinedFile{}, err
+	}
+
+	_, err = app.DB.Exec(`UPDATE quarantined_files SET storage_key = $1 WHERE id = $2`, q.StorageKey, q.ID)
+	return q, err
+}
+
+// ListQuarantinedFiles returns the quarantine contents, newest first.
+func (app *App) ListQuarantinedFiles() ([]QuarantinedFile, error) {
+	rows, err := app.DB.Query(`
+        SELECT id, file_id, source, file_name, directory, storage_key, size, COALESCE(checksum, ''),
+               signature, COALESCE(uploader, ''), detected_at
+        FROM quarantined_files
+        ORDER BY detected_at DESC
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []QuarantinedFile
+	for rows.Next() {
+		var (
+			q      QuarantinedFile
+			fileID sql.NullInt64
+		)
+		if err := rows.Scan(&q.ID, &fileID, &q.Source, &q.FileName, &q.Directory, &q.StorageKey, &q.Size, &q.Checksum,
+			&q.Signature, &q.Uploader, &q.DetectedAt); err != nil {
+			return nil, err
+		}
+		if fileID.Valid {
+			val := int(fileID.Int64)
+			q.FileID = &val
+		}
+		files = append(files, q)
+	}
+	return files, rows.Err()
+}
@@ -0,0 +1,131 @@
+package models
+
+import (
+	"time"
+)
+
+// -------------------------------------
+//  Virus Scan Status
+// -------------------------------------
+
+// Verdicts recorded for a stored file.
+const (
+	ScanClean    = "clean"
+	ScanInfected = "infected"
+	ScanError    = "error"
+)
+
+// FileScan is the latest virus scan verdict for a stored file.
+type FileScan struct {
+	FileID    int       `json:"file_id"`
+	FileName  string    `json:"file_name"`
+	FilePath  string    `json:"file_path"`
+	Status    string    `json:"status"`
+	Signature string    `json:"signature,omitempty"`
+	Details   string    `json:"details,omitempty"`
+	Checksum  string    `json:"checksum"`
+	ScannedAt time.Time `json:"scanned_at"`
+}
+
+// RecordFileScan stores s as the latest verdict for its file.
+func (app *App) RecordFileScan(s FileScan) error {
+	_, err := app.DB.Exec(`
+        INSERT INTO file_scans (file_id, status, signature, details, checksum, scanned_at)
+        VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''), CURRENT_TIMESTAMP)
+        ON CONFLICT (file_id) DO UPDATE
+        SET status = EXCLUDED.status,
+            signature = EXCLUDED.signature,
+            details = EXCLUDED.details,
+            checksum = EXCLUDED.checksum,
+            scanned_at = EXCLUDED.scanned_at
+    `, s.FileID, s.Status, s.Signature, s.Details, s.Checksum)
+	return err
+}
+
+// ListFilesDueForScan returns the stored files that have not been scanned
+// since before, or whose content changed after their last scan. Files never
+// scanned come first, then the longest unscanned.
+func (app *App) ListFilesDueForScan(before time.Time) ([]FileRecord, error) {
+	rows, err := app.DB.Query(`
+        SELECT f.id, f.file_name, f.directory, f.file_path, f.size, f.uploader,
+               COALESCE(f.checksum, '')
+        FROM files f
+        LEFT JOIN file_scans s ON s.file_id = f.id
+        WHERE f.file_path NOT LIKE '.trash/%'
+          AND f.file_path NOT LIKE '.quarantine/%'
+          AND (s.file_id IS NULL OR s.scanned_at < $1 OR s.checksum IS DISTINCT FROM f.checksum)
+        ORDER BY s.scanned_at NULLS FIRST, f.id
+    `, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []FileRecord
+	for rows.Next() {
+		var fr FileRecord
+		if err := rows.Scan(&fr.ID, &fr.FileName, &fr.Directory, &fr.FilePath, &fr.Size, &fr.Uploader,
+			&fr.Checksum); err != nil {
+			return nil, err
+		}
+		files = append(files, fr)
+	}
+	return files, rows.Err()
+}
+
+// ListFileScans returns the scan verdicts of files outside the recycle bin,
+// problems first and newest first. Clean verdicts are only included when
+// includeClean is set.
+func (app *App) ListFileScans(includeClean bool) ([]FileScan, error) {
+	rows, err := app.DB.Query(`
+        SELECT s.file_id, f.file_name, f.file_path, s.status, COALESCE(s.signature, ''),
+               COALESCE(s.details, ''), COALESCE(s.checksum, ''), s.scanned_at
+        FROM file_scans s
+        JOIN files f ON f.id = s.file_id
+        WHERE f.file_path NOT LIKE '.trash/%'
+          AND ($1 OR s.status <> 'clean')
+        ORDER BY s.status = 'clean', s.scanned_at DESC
+    `, includeClean)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var scans []FileScan
+	for rows.Next() {
+		var s FileScan
+		if err := rows.Scan(&s.FileID, &s.FileName, &s.FilePath, &s.Status, &s.Signature,
+			&s.Details, &s.Checksum, &s.ScannedAt); err != nil {
+			return nil, err
+		}
+		scans = append(scans, s)
+	}
+	return scans, rows.Err()
+}
+
+// FileScanSummary counts the files outside the recycle bin by their latest
+// verdict. Files that were never scanned are counted as "unscanned".
+func (app *App) FileScanSummary() (map[string]int, error) {
+	rows, err := app.DB.Query(`
+        SELECT COALESCE(s.status, 'unscanned'), COUNT(*)
+        FROM files f
+        LEFT JOIN file_scans s ON s.file_id = f.id
+        WHERE f.file_path NOT LIKE '.trash/%'
+        GROUP BY 1
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summary := map[string]int{ScanClean: 0, ScanInfected: 0, ScanError: 0, "unscanned": 0}
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		summary[status] = count
+	}
+	return summary, rows.Err()
+}
@@ -0,0 +1,172 @@
+package models
+
+import (
+	"html"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// -------------------------------------
+//  Full-Text Search
+// -------------------------------------
+
+// Markers ts_headline puts around matches. They are stripped from stored
+// text, so after HTML-escaping a snippet they can safely become <mark> tags.
+const (
+	snippetStart = "\x02"
+	snippetStop  = "\x03"
+)
+
+// SearchQuery is a full-text search with its filters. Directory matches the
+// folder and everything below it; From and To bound the upload time. A
+// non-nil Folders limits the search to those folders and their subfolders,
+// as given by Access.ReadableFolders.
+type SearchQuery struct {
+	Text      string
+	Directory string
+	Uploader  string
+	From      *time.Time
+	To        *time.Time
+	Folders   []string
+	Limit     int
+	Offset    int
+}
+
+// SearchResult is a file matching a search. Snippet is HTML with the
+// matching words wrapped in <mark>.
+type SearchResult struct {
+	ID          int       `json:"id"`
+	FileName    string    `json:"file_name"`
+	Directory   string    `json:"directory"`
+	FilePath    string    `json:"file_path"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	Uploader    string    `json:"uploader"`
+	CreatedAt   time.Time `json:"created_at"`
+	Rank        float64   `json:"rank"`
+	Snippet     string    `json:"snippet"`
+}
+
+// SearchFiles returns the files matching q, best match first, and the total
+// number of matches.
+func (app *App) SearchFiles(q SearchQuery) ([]SearchResult, int, error) {
+	// Snippets are only built for the page of results being returned.
+	rows, err := app.DB.Query(`
+        SELECT m.id, m.file_name, m.directory, m.file_path, m.size, m.content_type, m.uploader,
+               m.created_at, m.rank, m.total,
+               ts_headline('english', COALESCE(m.content_text, ''), m.query, $8)
+        FROM (
+            SELECT f.id, f.file_name, f.directory, f.file_path, COALESCE(f.size, 0) AS size,
+                   COALESCE(f.content_type, '') AS content_type, COALESCE(f.uploader, '') AS uploader,
+                   f.created_at, f.content_text, q.query,
+                   ts_rank_cd(f.search_vector, q.query) AS rank,
+                   COUNT(*) OVER () AS total
+            FROM files f, websearch_to_tsquery('english', $1) AS q(query)
+            WHERE f.search_vector @@ q.query
+              AND f.file_path NOT LIKE '.trash/%'
+              AND f.file_path NOT LIKE '.quarantine/%'
+              AND ($2 = '' OR f.directory = $2 OR left(f.directory, length($2) + 1) = $2 || '/')
+              AND ($3 = '' OR f.uploader = $3)
+              AND ($4::timestamptz IS NULL OR f.created_at >= $4)
+              AND ($5::timestamptz IS NULL OR f.created_at < $5)
+              AND ($9::text[] IS NULL OR EXISTS (
+                  SELECT 1 FROM unnest($9::text[]) AS r(dir)
+                  WHERE f.directory = r.dir OR left(f.directory, length(r.dir) + 1) = r.dir || '/'))
+            ORDER BY rank DESC, f.id DESC
+            LIMIT $6 OFFSET $7
+        ) m
+        ORDER BY m.rank DESC, m.id DESC
+    `, q.Text, q.Directory, q.Uploader, q.From, q.To, q.Limit, q.Offset,
+		"StartSel="+snippetStart+", StopSel="+snippetStop+", MaxWords=35, MinWords=15, MaxFragments=2, FragmentDelimiter=\" … \"",
+		pq.Array(q.Folders))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	total := 0
+	for rows.Next() {
+		var r SearchResult
+		var snippet string
+		if err := rows.Scan(&r.ID, &r.FileName, &r.Directory, &r.FilePath, &r.Size, &r.ContentType, &r.Uploader,
+			&r.CreatedAt, &r.Rank, &total, &snippet); err != nil {
+			return nil, 0, err
+		}
+		r.Snippet = highlightSnippet(snippet)
+		results = append(results, r)
+	}
+	return results, total, rows.Err()
+}
+
+// highlightSnippet escapes a ts_headline snippet for HTML and turns its
+// match markers into <mark> tags.
+func highlightSnippet(snippet string) string {
+	snippet = html.EscapeString(strings.TrimSpace(snippet))
+	snippet = strings.ReplaceAll(snippet, snippetStart, "<mark>")
+	return strings.ReplaceAll(snippet, snippetStop, "</mark>")
+}
+
+// UpdateFileText stores the text extracted from a file's content. checksum
+// is the plaintext digest the text was extracted from; if the content has
+// been replaced since, nothing is stored and false is returned.
+func (app *App) UpdateFileText(fileID int, checksum, text string) (bool, error) {
+	text = strings.Map(func(r rune) rune {
+		switch r {
+		case 0, 0x02, 0x03: // Postgres rejects NUL; the others mark snippets
+			return ' '
+		}
+		return r
+	}, strings.ToValidUTF8(text, ""))
+
+	res, err := app.DB.Exec(`
+        UPDATE files
+        SET content_text = $1, text_checksum = $2
+        WHERE id = $3 AND checksum = $2
+    `, text, checksum, fileID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ListFilesNeedingText returns the files with one of the given extensions
+// whose text has not been extracted from their current content, leaving out
+// those with a pending jobType job, or a dead one for the same content.
+func (app *App) ListFilesNeedingText(jobType string, extensions []string) ([]FileRecord, error) {
+	rows, err := app.DB.Query(`
+        SELECT f.id, f.file_name, f.file_path, f.checksum
+        FROM files f
+        WHERE f.checksum IS NOT NULL
+          AND f.text_checksum IS DISTINCT FROM f.checksum
+          AND LOWER(SUBSTRING(f.file_name FROM '\.[^.]*$')) = ANY($2)
+          AND f.file_path NOT LIKE '.trash/%'
+          AND f.file_path NOT LIKE '.quarantine/%'
+          AND NOT EXISTS (
+              SELECT 1
+              FROM jobs j
+              WHERE j.job_type = $1
+                AND j.payload->>'file_id' = f.id::text
+                AND (j.status IN ('queued', 'running')
+                     OR (j.status = 'dead' AND j.payload->>'checksum' = f.checksum))
+          )
+        ORDER BY f.id
+    `, jobType, pq.Array(extensions))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []FileRecord
+	for rows.Next() {
+		var fr FileRecord
+		if err := rows.Scan(&fr.ID, &fr.FileName, &fr.FilePath, &fr.Checksum); err != nil {
+			return nil, err
+		}
+		files = append(files, fr)
+	}
+	return files, rows.Err()
+}
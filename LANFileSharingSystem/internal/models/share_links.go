@@ -0,0 +1,221 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"time"
+)
+
+// -------------------------------------
+//  Share Links
+// -------------------------------------
+
+// States of a share link.
+const (
+	ShareLinkActive    = "active"
+	ShareLinkExpired   = "expired"
+	ShareLinkRevoked   = "revoked"
+	ShareLinkExhausted = "exhausted" // every allowed download has been used
+)
+
+// ShareLink lets anyone holding its token download a file or a folder
+// without an account. Either FileID or Directory is set.
+type ShareLink struct {
+	ID int `json:"id"`
+	// Token is only known when the link is created; the database keeps a
+	// hash of it.
+	Token          string     `json:"token,omitempty"`
+	FileID         int        `json:"file_id,omitempty"`
+	Directory      string     `json:"directory,omitempty"`
+	Path           string     `json:"path"` // of the file or folder
+	HasPassword    bool       `json:"has_password"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	MaxDownloads   int        `json:"max_downloads,omitempty"` // zero for no limit
+	DownloadCount  int        `json:"download_count"`
+	Status         string     `json:"status"`
+	CreatedBy      string     `json:"created_by"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastAccessedAt *time.Time `json:"last_accessed_at,omitempty"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+	RevokedBy      string     `json:"revoked_by,omitempty"`
+
+	passwordHash string
+}
+
+// CheckPassword reports whether password opens the link. Links without a
+// password accept anything.
+func (l ShareLink) CheckPassword(password string) bool {
+	return l.passwordHash == "" || CheckPasswordHash(password, l.passwordHash)
+}
+
+// state works out the status of the link at now.
+func (l ShareLink) state(now time.Time) string {
+	switch {
+	case l.RevokedAt != nil:
+		return ShareLinkRevoked
+	case !now.Before(l.ExpiresAt):
+		return ShareLinkExpired
+	case l.MaxDownloads > 0 && l.DownloadCount >= l.MaxDownloads:
+		return ShareLinkExhausted
+	}
+	return ShareLinkActive
+}
+
+// hashShareToken returns the hash a share link token is stored as.
+func hashShareToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// shareLinkColumns are the columns scanShareLink reads, from share_links s
+// joined with files f.
+const shareLinkColumns = `s.id, COALESCE(s.file_id, 0), COALESCE(s.directory, ''), COALESCE(f.file_path, s.directory, ''),
+               COALESCE(s.password_hash, ''), s.expires_at, COALESCE(s.max_downloads, 0), s.download_count,
+               s.created_by, s.created_at, s.last_accessed_at, s.revoked_at, COALESCE(s.revoked_by, '')`
+
+func scanShareLink(row interface{ Scan(...interface{}) error }) (ShareLink, error) {
+	var l ShareLink
+	var lastAccessed, revoked sql.NullTime
+	err := row.Scan(&l.ID, &l.FileID, &l.Directory, &l.Path, &l.passwordHash, &l.ExpiresAt, &l.MaxDownloads,
+		&l.DownloadCount, &l.CreatedBy, &l.CreatedAt, &lastAccessed, &revoked, &l.RevokedBy)
+	if err != nil {
+		return ShareLink{}, err
+	}
+	if lastAccessed.Valid {
+		l.LastAccessedAt = &lastAccessed.Time
+	}
+	if revoked.Valid {
+		l.RevokedAt = &revoked.Time
+	}
+	l.HasPassword = l.passwordHash != ""
+	l.Status = l.state(time.Now())
+	return l, nil
+}
+
+// CreateShareLink stores a new link for the file or folder in link, with a
+// freshly generated token. An empty password leaves the link open to anyone
+// holding the token.
+func (app *App) CreateShareLink(link ShareLink, password string) (ShareLink, error) {
+	token, err := app.GenerateToken()
+	if err != nil {
+		return ShareLink{}, err
+	}
+	var passwordHash string
+	if password != "" {
+		if passwordHash, err = HashPassword(password); err != nil {
+			return ShareLink{}, err
+		}
+	}
+
+	var id int
+	err = app.DB.QueryRow(`
+        INSERT INTO share_links (token_hash, file_id, directory, password_hash, expires_at, max_downloads, created_by)
+        VALUES ($1, NULLIF($2, 0), NULLIF($3, ''), NULLIF($4, ''), $5, NULLIF($6, 0), $7)
+        RETURNING id
+    `, hashShareToken(token), link.FileID, link.Directory, passwordHash, link.ExpiresAt, link.MaxDownloads,
+		link.CreatedBy).Scan(&id)
+	if err != nil {
+		return ShareLink{}, err
+	}
+	created, err := app.GetShareLink(id)
+	if err != nil {
+		return ShareLink{}, err
+	}
+	created.Token = token
+	return created, nil
+}
+
+// GetShareLink returns one link by ID.
+func (app *App) GetShareLink(id int) (ShareLink, error) {
+	return scanShareLink(app.DB.QueryRow(`
+        SELECT `+shareLinkColumns+`
+        FROM share_links s
+        LEFT JOIN files f ON f.id = s.file_id
+        WHERE s.id = $1
+    `, id))
+}
+
+// GetShareLinkByToken returns the link a token belongs to.
+func (app *App) GetShareLinkByToken(token string) (ShareLink, error) {
+	return scanShareLink(app.DB.QueryRow(`
+        SELECT `+shareLinkColumns+`
+        FROM share_links s
+        LEFT JOIN files f ON f.id = s.file_id
+        WHERE s.token_hash = $1
+    `, hashShareToken(token)))
+}
+
+// ListShareLinks returns the links created by createdBy, or every link when
+// createdBy is empty, newest first.
+func (app *App) ListShareLinks(createdBy string) ([]ShareLink, error) {
+	rows, err := app.DB.Query(`
+        SELECT `+shareLinkColumns+`
+        FROM share_links s
+        LEFT JOIN files f ON f.id = s.file_id
+        WHERE $1 = '' OR s.created_by = $1
+        ORDER BY s.created_at DESC
+    `, createdBy)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []ShareLink
+	for rows.Next() {
+		l, err := scanShareLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
+
+// UseShareLink counts one download of a link. It reports false, without
+// counting anything, when the link has been revoked, has expired or has no
+// downloads left.
+func (app *App) UseShareLink(id int) (bool, error) {
+	res, err := app.DB.Exec(`
+        UPDATE share_links
+        SET download_count = download_count + 1, last_accessed_at = CURRENT_TIMESTAMP
+        WHERE id = $1
+          AND revoked_at IS NULL
+          AND expires_at > CURRENT_TIMESTAMP
+          AND (max_downloads IS NULL OR download_count < max_downloads)
+    `, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// RevokeShareLink stops a link from working. It returns sql.ErrNoRows if the
+// link does not exist or was already revoked.
+func (app *App) RevokeShareLink(id int, revokedBy string) error {
+	res, err := app.DB.Exec(`
+        UPDATE share_links
+        SET revoked_at = CURRENT_TIMESTAMP, revoked_by = $2
+        WHERE id = $1 AND revoked_at IS NULL
+    `, id, revokedBy)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// MoveShareLinks points the links to a folder, and to the folders below it,
+// at newPath after the folder is renamed or moved. Links to files follow
+// their file on their own.
+func (app *App) MoveShareLinks(oldPath, newPath string) error {
+	_, err := app.DB.Exec(`
+        UPDATE share_links
+        SET directory = $2 || substr(directory, length($1) + 1)
+        WHERE directory = $1 OR left(directory, length($1) + 1) = $1 || '/'
+    `, oldPath, newPath)
+	return err
+}
@@ -0,0 +1,285 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// -------------------------------------
+//  Tags
+// -------------------------------------
+
+// ErrInvalidTag is wrapped by the errors returned for a malformed tag name.
+var ErrInvalidTag = errors.New("invalid tag")
+
+// maxTagLength is the longest tag name, in characters.
+const maxTagLength = 50
+
+// tagPattern is what a normalized tag name looks like: letters and digits,
+// joined by dashes, underscores or dots.
+var tagPattern = regexp.MustCompile(`^[\p{L}\p{N}]([\p{L}\p{N}._-]*[\p{L}\p{N}])?$`)
+
+// Tag labels files across folders. Curated tags are maintained by admins;
+// the others exist while at least one file carries them.
+type Tag struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Curated     bool      `json:"curated"`
+	Description string    `json:"description"`
+	FileCount   int       `json:"file_count"`
+	CreatedBy   string    `json:"created_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// NormalizeTag returns the canonical form of a tag name: lower case, with
+// runs of spaces turned into single dashes, so "2025 Budget" and
+// "2025-budget" are the same tag.
+func NormalizeTag(name string) (string, error) {
+	tag := strings.Join(strings.Fields(strings.ToLower(name)), "-")
+	if tag == "" {
+		return "", fmt.Errorf("%w: tag names cannot be empty", ErrInvalidTag)
+	}
+	if len([]rune(tag)) > maxTagLength {
+		return "", fmt.Errorf("%w: '%s' is longer than %d characters", ErrInvalidTag, tag, maxTagLength)
+	}
+	if !tagPattern.MatchString(tag) {
+		return "", fmt.Errorf("%w: '%s' may only contain letters, digits, dashes, underscores and dots", ErrInvalidTag, tag)
+	}
+	return tag, nil
+}
+
+// ListTags returns the curated tags and every tag in use, with the number
+// of files carrying each, ordered by name. A non-nil folders, as given by
+// Access.ReadableFolders, only counts the files in those folders and their
+// subfolders, and leaves out the uncurated tags no such file carries.
+func (app *App) ListTags(folders []string) ([]Tag, error) {
+	rows, err := app.DB.Query(`
+        SELECT t.id, t.name, t.curated, COALESCE(t.description, ''), COUNT(f.id),
+               COALESCE(t.created_by, ''), t.created_at
+        FROM tags t
+        LEFT JOIN file_tags ft ON ft.tag_id = t.id
+        LEFT JOIN files f ON f.id = ft.file_id
+             AND f.file_path NOT LIKE '.trash/%'
+             AND f.file_path NOT LIKE '.quarantine/%'
+             AND ($1::text[] IS NULL OR EXISTS (
+                 SELECT 1 FROM unnest($1::text[]) AS r(dir)
+                 WHERE f.directory = r.dir OR left(f.directory, length(r.dir) + 1) = r.dir || '/'))
+        GROUP BY t.id
+        HAVING t.curated OR COUNT(f.id) > 0
+        ORDER BY t.name
+    `, pq.Array(folders))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []Tag
+	for rows.Next() {
+		var t Tag
+		if err := rows.Scan(&t.ID, &t.Name, &t.Curated, &t.Description, &t.FileCount, &t.CreatedBy, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+// SaveCuratedTag creates a curated tag, or curates an existing one, and
+// sets its description.
+func (app *App) SaveCuratedTag(name, description, createdBy string) (Tag, error) {
+	var t Tag
+	err := app.DB.QueryRow(`
+        INSERT INTO tags (name, curated, description, created_by)
+        VALUES ($1, TRUE, NULLIF($2, ''), $3)
+        ON CONFLICT (name) DO UPDATE
+        SET curated = TRUE, description = EXCLUDED.description
+        RETURNING id, name, curated, COALESCE(description, ''), COALESCE(created_by, ''), created_at
+    `, name, description, createdBy).Scan(&t.ID, &t.Name, &t.Curated, &t.Description, &t.CreatedBy, &t.CreatedAt)
+	return t, err
+}
+
+// DeleteTag removes a tag from every file and deletes it. It returns the
+// IDs of the files that carried it, or sql.ErrNoRows if there is no such tag.
+func (app *App) DeleteTag(name string) ([]int, error) {
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+        DELETE FROM file_tags
+        WHERE tag_id = (SELECT id FROM tags WHERE name = $1)
+        RETURNING file_id
+    `, name)
+	if err != nil {
+		return nil, err
+	}
+	var fileIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		fileIDs = append(fileIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	res, err := tx.Exec(`DELETE FROM tags WHERE name = $1`, name)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return fileIDs, tx.Commit()
+}
+
+// AddFileTags tags a file, creating tags that do not exist yet. It returns
+// the tags the file did not already have.
+func (app *App) AddFileTags(fileID int, tags []string, username string) ([]string, error) {
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var added []string
+	for _, tag := range tags {
+		if _, err := tx.Exec(`
+            INSERT INTO tags (name, created_by)
+            VALUES ($1, $2)
+            ON CONFLICT (name) DO NOTHING
+        `, tag, username); err != nil {
+			return nil, err
+		}
+		res, err := tx.Exec(`
+            INSERT INTO file_tags (file_id, tag_id, tagged_by)
+            SELECT $1, id, $3 FROM tags WHERE name = $2
+            ON CONFLICT DO NOTHING
+        `, fileID, tag, username)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			added = append(added, tag)
+		}
+	}
+	return added, tx.Commit()
+}
+
+// RemoveFileTags removes tags from a file and returns those it had. Tags
+// that are neither curated nor on any other file are deleted.
+func (app *App) RemoveFileTags(fileID int, tags []string) ([]string, error) {
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+        DELETE FROM file_tags ft
+        USING tags t
+        WHERE ft.tag_id = t.id
+          AND ft.file_id = $1
+          AND t.name = ANY($2)
+        RETURNING t.name
+    `, fileID, pq.Array(tags))
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		removed = append(removed, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`
+        DELETE FROM tags t
+        WHERE t.name = ANY($1)
+          AND NOT t.curated
+          AND NOT EXISTS (SELECT 1 FROM file_tags ft WHERE ft.tag_id = t.id)
+    `, pq.Array(removed)); err != nil {
+		return nil, err
+	}
+	return removed, tx.Commit()
+}
+
+// FileTags returns the tags of the given files, keyed by file ID, each
+// list ordered by name.
+func (app *App) FileTags(fileIDs []int) (map[int][]string, error) {
+	tags := make(map[int][]string)
+	if len(fileIDs) == 0 {
+		return tags, nil
+	}
+	rows, err := app.DB.Query(`
+        SELECT ft.file_id, t.name
+        FROM file_tags ft
+        JOIN tags t ON t.id = ft.tag_id
+        WHERE ft.file_id = ANY($1)
+        ORDER BY t.name
+    `, pq.Array(fileIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		tags[id] = append(tags[id], name)
+	}
+	return tags, rows.Err()
+}
+
+// ListFilesByTag returns the files carrying a tag, ordered by path.
+func (app *App) ListFilesByTag(name string) ([]FileRecord, error) {
+	rows, err := app.DB.Query(`
+        SELECT f.id, f.file_name, f.directory, f.file_path, COALESCE(f.size, 0),
+               COALESCE(f.content_type, ''), COALESCE(f.uploader, ''),
+               COALESCE(f.checksum, ''), COALESCE(f.ciphertext_checksum, '')
+        FROM files f
+        JOIN file_tags ft ON ft.file_id = f.id
+        JOIN tags t ON t.id = ft.tag_id
+        WHERE t.name = $1
+          AND f.file_path NOT LIKE '.trash/%'
+          AND f.file_path NOT LIKE '.quarantine/%'
+        ORDER BY f.file_path
+    `, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []FileRecord
+	for rows.Next() {
+		var f FileRecord
+		if err := rows.Scan(&f.ID, &f.FileName, &f.Directory, &f.FilePath, &f.Size, &f.ContentType, &f.Uploader,
+			&f.Checksum, &f.CiphertextChecksum); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
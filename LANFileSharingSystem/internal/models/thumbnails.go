@@ -0,0 +1,149 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"LANFileSharingSystem/internal/storage"
+
+	"github.com/lib/pq"
+)
+
+// -------------------------------------
+//  Thumbnails
+// -------------------------------------
+
+// ThumbnailsPrefix is the storage prefix that holds rendered thumbnails,
+// encrypted like any other blob.
+const ThumbnailsPrefix = ".thumbnails"
+
+// ThumbnailSizes are the bounding boxes, in pixels, thumbnails are rendered
+// at. DefaultThumbnailSize is served when a request names none.
+var ThumbnailSizes = []int{128, 256, 512}
+
+const DefaultThumbnailSize = 256
+
+// IsThumbnailSize reports whether thumbnails are rendered at size.
+func IsThumbnailSize(size int) bool {
+	for _, s := range ThumbnailSizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+// ThumbnailStorageKey returns the storage key of the thumbnail of a file's
+// content, identified by its plaintext checksum, at one size.
+func ThumbnailStorageKey(fileID int, checksum string, size int) string {
+	return fmt.Sprintf("%s/%d/%s/%d.png", ThumbnailsPrefix, fileID, checksum, size)
+}
+
+// DeleteThumbnailBlobs removes every thumbnail of a file.
+func (app *App) DeleteThumbnailBlobs(ctx context.Context, fileID int) error {
+	return storage.DeletePrefix(ctx, app.Storage, fmt.Sprintf("%s/%d", ThumbnailsPrefix, fileID))
+}
+
+// DeleteStaleThumbnails removes the thumbnails of a file's earlier contents,
+// keeping those rendered from the content with the given checksum.
+func (app *App) DeleteStaleThumbnails(ctx context.Context, fileID int, checksum string) error {
+	base := fmt.Sprintf("%s/%d/", ThumbnailsPrefix, fileID)
+	objects, err := app.Storage.List(ctx, base)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if strings.HasPrefix(obj.Key, base+checksum+"/") {
+			continue
+		}
+		if err := app.Storage.Delete(ctx, obj.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateFileThumbnail records that a file's thumbnails were rendered from
+// the content with the given checksum. If the content has been replaced
+// since, nothing is recorded and false is returned.
+func (app *App) UpdateFileThumbnail(fileID int, checksum string) (bool, error) {
+	res, err := app.DB.Exec(`
+        UPDATE files
+        SET thumbnail_checksum = $1
+        WHERE id = $2 AND checksum = $1
+    `, checksum, fileID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// FileThumbnails returns, keyed by file ID, the checksums the thumbnails of
+// the given files were rendered from. Files without a thumbnail of their
+// current content are left out.
+func (app *App) FileThumbnails(fileIDs []int) (map[int]string, error) {
+	thumbs := make(map[int]string)
+	if len(fileIDs) == 0 {
+		return thumbs, nil
+	}
+	rows, err := app.DB.Query(`
+        SELECT id, thumbnail_checksum
+        FROM files
+        WHERE id = ANY($1)
+          AND thumbnail_checksum = checksum
+    `, pq.Array(fileIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var checksum string
+		if err := rows.Scan(&id, &checksum); err != nil {
+			return nil, err
+		}
+		thumbs[id] = checksum
+	}
+	return thumbs, rows.Err()
+}
+
+// ListFilesNeedingThumbnails returns the files with one of the given
+// extensions that have no thumbnail of their current content, leaving out
+// those with a pending jobType job, or a dead one for the same content.
+func (app *App) ListFilesNeedingThumbnails(jobType string, extensions []string) ([]FileRecord, error) {
+	rows, err := app.DB.Query(`
+        SELECT f.id, f.file_name, f.file_path, f.checksum
+        FROM files f
+        WHERE f.checksum IS NOT NULL
+          AND f.thumbnail_checksum IS DISTINCT FROM f.checksum
+          AND LOWER(SUBSTRING(f.file_name FROM '\.[^.]*$')) = ANY($2)
+          AND f.file_path NOT LIKE '.trash/%'
+          AND f.file_path NOT LIKE '.quarantine/%'
+          AND NOT EXISTS (
+              SELECT 1
+              FROM jobs j
+              WHERE j.job_type = $1
+                AND j.payload->>'file_id' = f.id::text
+                AND (j.status IN ('queued', 'running')
+                     OR (j.status = 'dead' AND j.payload->>'checksum' = f.checksum))
+          )
+        ORDER BY f.id
+    `, jobType, pq.Array(extensions))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []FileRecord
+	for rows.Next() {
+		var fr FileRecord
+		if err := rows.Scan(&fr.ID, &fr.FileName, &fr.FilePath, &fr.Checksum); err != nil {
+			return nil, err
+		}
+		files = append(files, fr)
+	}
+	return files, rows.Err()
+}
@@ -0,0 +1,342 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"LANFileSharingSystem/internal/storage"
+)
+
+// -------------------------------------
+//  Recycle Bin
+// -------------------------------------
+
+// TrashPrefix is the storage and path prefix that holds trashed items. Item n
+// keeps its file or folder at ".trash/<n>/<name>", both in storage and in the
+// file_path/parent_directory columns, so regular path lookups never see it.
+const TrashPrefix = ".trash"
+
+// Kinds of trashed items.
+const (
+	TrashFile   = "file"
+	TrashFolder = "folder"
+)
+
+// ErrRestoreConflict is returned when something already occupies the
+// original location of an item being restored.
+var ErrRestoreConflict = errors.New("an item with the same name already exists at the original location")
+
+// TrashItem is a file or folder in the recycle bin.
+type TrashItem struct {
+	ID             int        `json:"id"`
+	Type           string     `json:"type"`
+	Name           string     `json:"name"`
+	OriginalParent string     `json:"original_parent"`
+	OriginalPath   string     `json:"original_path"`
+	Size           int64      `json:"size"`
+	FileCount      int        `json:"file_count"`
+	DeletedBy      string     `json:"deleted_by"`
+	DeletedAt      time.Time  `json:"deleted_at"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+}
+
+// TrashPath returns the folder a trashed item lives in.
+func TrashPath(id int) string {
+	return fmt.Sprintf("%s/%d", TrashPrefix, id)
+}
+
+// location returns where the item currently lives inside the trash.
+func (t TrashItem) location() string {
+	return TrashPath(t.ID) + "/" + t.Name
+}
+
+func joinFolder(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "/" + name
+}
+
+// MoveFileToTrash moves the file fr to the recycle bin.
+func (app *App) MoveFileToTrash(ctx context.Context, fr FileRecord, deletedBy string) (TrashItem, error) {
+	parent := path.Dir(fr.FilePath)
+	if parent == "." {
+		parent = ""
+	}
+	item := TrashItem{Type: TrashFile, Name: fr.FileName, OriginalParent: parent, Size: fr.Size, FileCount: 1, DeletedBy: deletedBy}
+	if err := app.insertTrashItem(&item); err != nil {
+		return TrashItem{}, err
+	}
+
+	dest := item.location()
+	if err := app.Storage.Move(ctx, fr.FilePath, dest); err != nil {
+		app.deleteTrashRow(item.ID)
+		return TrashItem{}, err
+	}
+	if err := app.MoveFileRecord(fr.ID, fr.FileName, TrashPath(item.ID), dest); err != nil {
+		app.Storage.Move(ctx, dest, fr.FilePath)
+		app.deleteTrashRow(item.ID)
+		return TrashItem{}, err
+	}
+	return item, nil
+}
+
+// MoveFolderToTrash moves the folder parent/name, with its files and
+// subfolders, to the recycle bin.
+func (app *App) MoveFolderToTrash(ctx context.Context, parent, name, deletedBy string) (TrashItem, error) {
+	folder := joinFolder(parent, name)
+	item := TrashItem{Type: TrashFolder, Name: name, OriginalParent: parent, DeletedBy: deletedBy}
+	if err := app.DB.QueryRow(`
+        SELECT COUNT(*), COALESCE(SUM(size), 0)
+        FROM files
+        WHERE left(file_path, length($1) + 1) = $1 || '/'
+    `, folder).Scan(&item.FileCount, &item.Size); err != nil {
+		return TrashItem{}, err
+	}
+	if err := app.insertTrashItem(&item); err != nil {
+		return TrashItem{}, err
+	}
+
+	dest := item.location()
+	if err := storage.MovePrefix(ctx, app.Storage, folder, dest); err != nil {
+		app.deleteTrashRow(item.ID)
+		return TrashItem{}, err
+	}
+	if err := app.relocateFolder(parent, name, TrashPath(item.ID)); err != nil {
+		storage.MovePrefix(ctx, app.Storage, dest, folder)
+		app.deleteTrashRow(item.ID)
+		return TrashItem{}, err
+	}
+	return item, nil
+}
+
+// RestoreTrashItem moves an item back to where it was deleted from. Missing
+// parent folders are recreated; an occupied location is an ErrRestoreConflict.
+func (app *App) RestoreTrashItem(ctx context.Context, item TrashItem, restoredBy string) error {
+	original := joinFolder(item.OriginalParent, item.Name)
+	if err := app.checkRestoreTarget(item, original); err != nil {
+		return err
+	}
+	if err := app.ensureFolderPath(item.OriginalParent, restoredBy); err != nil {
+		return fmt.Errorf("recreate parent folders: %w", err)
+	}
+
+	src := item.location()
+	if item.Type == TrashFile {
+		fileID, err := app.GetTrashedFileID(item.ID)
+		if err != nil {
+			return err
+		}
+		if err := app.Storage.Move(ctx, src, original); err != nil {
+			return err
+		}
+		if err := app.MoveFileRecord(fileID, item.Name, item.OriginalParent, original); err != nil {
+			app.Storage.Move(ctx, original, src)
+			return err
+		}
+	} else {
+		if err := storage.MovePrefix(ctx, app.Storage, src, original); err != nil {
+			return err
+		}
+		if err := app.relocateFolder(TrashPath(item.ID), item.Name, item.OriginalParent); err != nil {
+			storage.MovePrefix(ctx, app.Storage, original, src)
+			return err
+		}
+	}
+	return app.deleteTrashRow(item.ID)
+}
+
+// PurgeTrashItem permanently deletes a trashed item, its file records and
+// every stored version, cached preview and thumbnail of its files.
+func (app *App) PurgeTrashItem(ctx context.Context, item TrashItem) error {
+	base := TrashPath(item.ID)
+	fileIDs, err := app.FileIDsInFolder(base)
+	if err != nil {
+		return err
+	}
+	for _, id := range fileIDs {
+		if err := app.DeleteFileVersionBlobs(ctx, id); err != nil {
+			return fmt.Errorf("delete versions of file %d: %w", id, err)
+		}
+		if err := app.DeletePreviewBlobs(ctx, id); err != nil {
+			return fmt.Errorf("delete previews of file %d: %w", id, err)
+		}
+		if err := app.DeleteThumbnailBlobs(ctx, id); err != nil {
+			return fmt.Errorf("delete thumbnails of file %d: %w", id, err)
+		}
+	}
+	if err := storage.DeletePrefix(ctx, app.Storage, base); err != nil {
+		return err
+	}
+
+	// file_versions rows go with their files (ON DELETE CASCADE).
+	if _, err := app.DB.Exec(`DELETE FROM files WHERE left(file_path, length($1) + 1) = $1 || '/'`, base); err != nil {
+		return err
+	}
+	if _, err := app.DB.Exec(`
+        DELETE FROM directories
+        WHERE parent_directory = $1 OR left(parent_directory, length($1) + 1) = $1 || '/'
+    `, base); err != nil {
+		return err
+	}
+	return app.deleteTrashRow(item.ID)
+}
+
+// GetTrashItem returns a single item in the recycle bin.
+func (app *App) GetTrashItem(id int) (TrashItem, error) {
+	items, err := app.queryTrashItems(`WHERE id = $1`, id)
+	if err != nil {
+		return TrashItem{}, err
+	}
+	if len(items) == 0 {
+		return TrashItem{}, sql.ErrNoRows
+	}
+	return items[0], nil
+}
+
+// ListTrashItems returns the recycle bin, newest first. A non-empty
+// deletedBy limits the list to items that user deleted.
+func (app *App) ListTrashItems(deletedBy string) ([]TrashItem, error) {
+	if deletedBy == "" {
+		return app.queryTrashItems(`ORDER BY deleted_at DESC`)
+	}
+	return app.queryTrashItems(`WHERE deleted_by = $1 ORDER BY deleted_at DESC`, deletedBy)
+}
+
+// ListTrashItemsDeletedBefore returns the items trashed before cutoff.
+func (app *App) ListTrashItemsDeletedBefore(cutoff time.Time) ([]TrashItem, error) {
+	return app.queryTrashItems(`WHERE deleted_at < $1 ORDER BY deleted_at`, cutoff)
+}
+
+// GetTrashedFileID returns the ID of the file record held by a trashed file item.
+func (app *App) GetTrashedFileID(itemID int) (int, error) {
+	var id int
+	err := app.DB.QueryRow(`
+        SELECT id
+        FROM files
+        WHERE directory = $1
+    `, TrashPath(itemID)).Scan(&id)
+	return id, err
+}
+
+func (app *App) queryTrashItems(clause string, args ...interface{}) ([]TrashItem, error) {
+	rows, err := app.DB.Query(`
+        SELECT id, item_type, name, original_parent, size, file_count, COALESCE(deleted_by, ''), deleted_at
+        FROM trash_items
+        `+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []TrashItem
+	for rows.Next() {
+		var t TrashItem
+		if err := rows.Scan(&t.ID, &t.Type, &t.Name, &t.OriginalParent, &t.Size, &t.FileCount,
+			&t.DeletedBy, &t.DeletedAt); err != nil {
+			return nil, err
+		}
+		t.OriginalPath = joinFolder(t.OriginalParent, t.Name)
+		items = append(items, t)
+	}
+	return items, rows.Err()
+}
+
+func (app *App) insertTrashItem(item *TrashItem) error {
+	err := app.DB.QueryRow(`
+        INSERT INTO trash_items (item_type, name, original_parent, size, file_count, deleted_by)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING id, deleted_at
+    `, item.Type, item.Name, item.OriginalParent, item.Size, item.FileCount, item.DeletedBy).Scan(&item.ID, &item.DeletedAt)
+	item.OriginalPath = joinFolder(item.OriginalParent, item.Name)
+	return err
+}
+
+func (app *App) deleteTrashRow(id int) error {
+	_, err := app.DB.Exec(`DELETE FROM trash_items WHERE id = $1`, id)
+	return err
+}
+
+// checkRestoreTarget reports ErrRestoreConflict if original is taken.
+func (app *App) checkRestoreTarget(item TrashItem, original string) error {
+	var taken bool
+	err := app.DB.QueryRow(`
+        SELECT EXISTS (SELECT 1 FROM files WHERE file_path = $1)
+            OR EXISTS (SELECT 1 FROM directories WHERE parent_directory = $2 AND directory_name = $3)
+    `, original, item.OriginalParent, item.Name).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrRestoreConflict
+	}
+	return nil
+}
+
+// ensureFolderPath creates the directory records of folder and of each of
+// its ancestors that no longer exist.
+func (app *App) ensureFolderPath(folder, createdBy string) error {
+	if folder == "" {
+		return nil
+	}
+	parent := ""
+	for _, name := range strings.Split(folder, "/") {
+		exists, err := app.DirectoryExists(name, parent)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			if err := app.CreateDirectoryRecord(name, parent, createdBy); err != nil {
+				return err
+			}
+		}
+		parent = joinFolder(parent, name)
+	}
+	return nil
+}
+
+// relocateFolder moves the directory record oldParent/name below newParent
+// and rewrites the paths of its subfolders and files to match, in one
+// transaction.
+func (app *App) relocateFolder(oldParent, name, newParent string) error {
+	oldPath := joinFolder(oldParent, name)
+	newPath := joinFolder(newParent, name)
+
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+        UPDATE directories
+        SET parent_directory = $1, updated_at = CURRENT_TIMESTAMP
+        WHERE parent_directory = $2 AND directory_name = $3
+    `, newParent, oldParent, name); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+        UPDATE directories
+        SET parent_directory = $2 || substr(parent_directory, length($1) + 1)
+        WHERE parent_directory = $1 OR left(parent_directory, length($1) + 1) = $1 || '/'
+    `, oldPath, newPath); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+        UPDATE files
+        SET file_path = $2 || substr(file_path, length($1) + 1),
+            directory = CASE
+                WHEN directory = $1 OR left(directory, length($1) + 1) = $1 || '/' THEN $2 || substr(directory, length($1) + 1)
+                ELSE directory
+            END
+        WHERE left(file_path, length($1) + 1) = $1 || '/'
+    `, oldPath, newPath); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,127 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// -------------------------------------
+//  Resumable Uploads
+// -------------------------------------
+
+// UploadSession is a resumable upload that is in progress or recently finished.
+type UploadSession struct {
+	ID          string
+	Username    string
+	FileName    string
+	Directory   string
+	ContentType string
+	Metadata    map[string]interface{}
+	Overwrite   bool
+	Length      int64
+	Offset      int64
+	FileID      int // set once the upload has been stored
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+	CompletedAt *time.Time
+}
+
+// Complete reports whether every byte of the upload has been received.
+func (s UploadSession) Complete() bool {
+	return s.Offset >= s.Length
+}
+
+// CreateUploadSession stores a new upload session.
+func (app *App) CreateUploadSession(s UploadSession) error {
+	metadataJSON, _ := json.Marshal(s.Metadata)
+	_, err := app.DB.Exec(`
+        INSERT INTO upload_sessions (id, username, file_name, directory, content_type, metadata,
+                                     overwrite, upload_length, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+    `, s.ID, s.Username, s.FileName, s.Directory, s.ContentType, metadataJSON,
+		s.Overwrite, s.Length, s.ExpiresAt)
+	return err
+}
+
+// GetUploadSession returns an upload session by ID.
+func (app *App) GetUploadSession(id string) (UploadSession, error) {
+	var (
+		s           UploadSession
+		metadata    []byte
+		fileID      sql.NullInt64
+		completedAt sql.NullTime
+	)
+	err := app.DB.QueryRow(`
+        SELECT id, username, file_name, directory, content_type, COALESCE(metadata, '{}'),
+               overwrite, upload_length, upload_offset, file_id, created_at, expires_at, completed_at
+        FROM upload_sessions
+        WHERE id = $1
+    `, id).Scan(&s.ID, &s.Username, &s.FileName, &s.Directory, &s.ContentType, &metadata,
+		&s.Overwrite, &s.Length, &s.Offset, &fileID, &s.CreatedAt, &s.ExpiresAt, &completedAt)
+	if err != nil {
+		return UploadSession{}, err
+	}
+	if len(metadata) > 0 {
+		_ = json.Unmarshal(metadata, &s.Metadata)
+	}
+	s.FileID = int(fileID.Int64)
+	if completedAt.Valid {
+		s.CompletedAt = &completedAt.Time
+	}
+	return s, nil
+}
+
+// UpdateUploadOffset records how many bytes have been received and pushes
+// the expiry of the session out.
+func (app *App) UpdateUploadOffset(id string, offset int64, expiresAt time.Time) error {
+	_, err := app.DB.Exec(`
+        UPDATE upload_sessions
+        SET upload_offset = $1, expires_at = $2
+        WHERE id = $3
+    `, offset, expiresAt, id)
+	return err
+}
+
+// CompleteUploadSession marks an upload as stored as the given file.
+func (app *App) CompleteUploadSession(id string, fileID int) error {
+	var nullableFileID sql.NullInt64
+	if fileID > 0 {
+		nullableFileID = sql.NullInt64{Int64: int64(fileID), Valid: true}
+	}
+	_, err := app.DB.Exec(`
+        UPDATE upload_sessions
+        SET file_id = $1, completed_at = CURRENT_TIMESTAMP
+        WHERE id = $2
+    `, nullableFileID, id)
+	return err
+}
+
+// DeleteUploadSession removes an upload session.
+func (app *App) DeleteUploadSession(id string) error {
+	_, err := app.DB.Exec(`DELETE FROM upload_sessions WHERE id = $1`, id)
+	return err
+}
+
+// ListExpiredUploadSessions returns the IDs of sessions that expired before now.
+func (app *App) ListExpiredUploadSessions(now time.Time) ([]string, error) {
+	rows, err := app.DB.Query(`
+        SELECT id
+        FROM upload_sessions
+        WHERE expires_at < $1
+    `, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
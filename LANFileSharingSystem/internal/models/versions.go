@@ -0,0 +1,221 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"LANFileSharingSystem/internal/storage"
+)
+
+// -------------------------------------
+//  File Versions
+// -------------------------------------
+
+// VersionsPrefix is the storage prefix that holds the blobs of past versions.
+// The current version of a file always lives at its file_path.
+const VersionsPrefix = ".versions"
+
+// ErrVersionNotRetained is returned for version rows whose content was never
+// kept, such as those recorded before past versions were archived.
+var ErrVersionNotRetained = errors.New("content of this version was not retained")
+
+// FileVersion is one stored revision of a file.
+type FileVersion struct {
+	FileID             int       `json:"file_id"`
+	Version            int       `json:"version"`
+	FilePath           string    `json:"file_path"` // path of the file when the version was written
+	Size               int64     `json:"size"`
+	ContentType        string    `json:"content_type"`
+	Uploader           string    `json:"uploader"`
+	Checksum           string    `json:"checksum"`            // SHA-256 of the plaintext
+	CiphertextChecksum string    `json:"ciphertext_checksum"` // SHA-256 of the stored blob
+	Timestamp          time.Time `json:"timestamp"`
+	IsCurrent          bool      `json:"is_current"`
+	Available          bool      `json:"available"`
+
+	// StorageKey is where an archived version's blob lives; empty for the current version.
+	StorageKey string `json:"-"`
+}
+
+// VersionStorageKey returns the storage key an archived version is kept under.
+func VersionStorageKey(fileID, version int) string {
+	return fmt.Sprintf("%s/%d/v%d", VersionsPrefix, fileID, version)
+}
+
+// RecordFileVersion inserts a version row.
+func (app *App) RecordFileVersion(v FileVersion) error {
+	_, err := app.DB.Exec(`
+        INSERT INTO file_versions (file_id, version_number, file_path, size, content_type, uploader, checksum, ciphertext_checksum)
+        VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, ''))
+    `, v.FileID, v.Version, v.FilePath, v.Size, v.ContentType, v.Uploader, v.Checksum, v.CiphertextChecksum)
+	return err
+}
+
+// ListFileVersions returns every version of a file, oldest first.
+func (app *App) ListFileVersions(fileID int) ([]FileVersion, error) {
+	rows, err := app.DB.Query(`
+        SELECT file_id, version_number, file_path, COALESCE(size, 0), COALESCE(content_type, ''),
+               COALESCE(uploader, ''), COALESCE(checksum, ''), COALESCE(ciphertext_checksum, ''),
+               COALESCE(storage_key, ''), created_at
+        FROM file_versions
+        WHERE file_id = $1
+        ORDER BY version_number ASC
+    `, fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []FileVersion
+	for rows.Next() {
+		var v FileVersion
+		if err := rows.Scan(&v.FileID, &v.Version, &v.FilePath, &v.Size, &v.ContentType,
+			&v.Uploader, &v.Checksum, &v.CiphertextChecksum, &v.StorageKey, &v.Timestamp); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if n := len(versions); n > 0 {
+		versions[n-1].IsCurrent = true
+	}
+	for i := range versions {
+		versions[i].Available = versions[i].IsCurrent || versions[i].StorageKey != ""
+	}
+	return versions, nil
+}
+
+// GetFileVersion returns a single version of a file.
+func (app *App) GetFileVersion(fileID, version int) (FileVersion, error) {
+	versions, err := app.ListFileVersions(fileID)
+	if err != nil {
+		return FileVersion{}, err
+	}
+	for _, v := range versions {
+		if v.Version == version {
+			return v, nil
+		}
+	}
+	return FileVersion{}, sql.ErrNoRows
+}
+
+// CurrentFileVersion returns the latest version of a file. Files stored
+// before versioning have no versions and get sql.ErrNoRows.
+func (app *App) CurrentFileVersion(fileID int) (FileVersion, error) {
+	versions, err := app.ListFileVersions(fileID)
+	if err != nil {
+		return FileVersion{}, err
+	}
+	if len(versions) == 0 {
+		return FileVersion{}, sql.ErrNoRows
+	}
+	return versions[len(versions)-1], nil
+}
+
+// OpenFileVersion returns the plaintext of version v of the file fr.
+func (app *App) OpenFileVersion(ctx context.Context, fr FileRecord, v FileVersion) (io.ReadCloser, error) {
+	switch {
+	case v.IsCurrent:
+		return app.OpenDecrypted(ctx, fr.FilePath)
+	case v.StorageKey != "":
+		return app.OpenDecrypted(ctx, v.StorageKey)
+	default:
+		return nil, ErrVersionNotRetained
+	}
+}
+
+// StoreNewVersion keeps the current content of fr as an archived version and
+// writes src as the next version. It returns the new version number.
+func (app *App) StoreNewVersion(ctx context.Context, fr FileRecord, src io.Reader, contentType, uploader string) (int, error) {
+	latest, err := app.archiveCurrentVersion(ctx, fr)
+	if err != nil {
+		return 0, fmt.Errorf("archive version: %w", err)
+	}
+
+	info, err := app.PutEncrypted(ctx, fr.FilePath, src)
+	if err != nil {
+		app.unarchiveVersion(ctx, fr, latest)
+		return 0, err
+	}
+
+	if err := app.UpdateFileContent(fr.ID, info, contentType); err != nil {
+		return 0, err
+	}
+	newVer := latest + 1
+	err = app.RecordFileVersion(FileVersion{
+		FileID:             fr.ID,
+		Version:            newVer,
+		FilePath:           fr.FilePath,
+		Size:               info.Size,
+		ContentType:        contentType,
+		Uploader:           uploader,
+		Checksum:           info.SHA256,
+		CiphertextChecksum: info.CiphertextSHA256,
+	})
+	return newVer, err
+}
+
+// archiveCurrentVersion moves the current blob of fr to its version key and
+// returns the version number it was archived as. Files without any version
+// rows get one describing their current content first. The blob's ciphertext
+// digest is taken from fr, which key rotation keeps up to date.
+func (app *App) archiveCurrentVersion(ctx context.Context, fr FileRecord) (int, error) {
+	latest, err := app.GetLatestVersionNumber(fr.ID)
+	if err != nil {
+		return 0, err
+	}
+	if latest == 0 {
+		latest = 1
+		if err := app.RecordFileVersion(FileVersion{
+			FileID:             fr.ID,
+			Version:            latest,
+			FilePath:           fr.FilePath,
+			Size:               fr.Size,
+			ContentType:        fr.ContentType,
+			Uploader:           fr.Uploader,
+			Checksum:           fr.Checksum,
+			CiphertextChecksum: fr.CiphertextChecksum,
+		}); err != nil {
+			return 0, err
+		}
+	}
+
+	key := VersionStorageKey(fr.ID, latest)
+	if err := app.Storage.Move(ctx, fr.FilePath, key); err != nil {
+		return 0, err
+	}
+	if _, err := app.DB.Exec(`
+        UPDATE file_versions
+        SET storage_key = $1,
+            ciphertext_checksum = COALESCE(NULLIF($4, ''), ciphertext_checksum)
+        WHERE file_id = $2 AND version_number = $3
+    `, key, fr.ID, latest, fr.CiphertextChecksum); err != nil {
+		app.Storage.Move(ctx, key, fr.FilePath)
+		return 0, err
+	}
+	return latest, nil
+}
+
+// unarchiveVersion undoes archiveCurrentVersion after a failed upload.
+func (app *App) unarchiveVersion(ctx context.Context, fr FileRecord, version int) {
+	if err := app.Storage.Move(ctx, VersionStorageKey(fr.ID, version), fr.FilePath); err != nil {
+		return
+	}
+	app.DB.Exec(`
+        UPDATE file_versions
+        SET storage_key = NULL
+        WHERE file_id = $1 AND version_number = $2
+    `, fr.ID, version)
+}
+
+// DeleteFileVersionBlobs removes the archived blobs of every past version of a file.
+func (app *App) DeleteFileVersionBlobs(ctx context.Context, fileID int) error {
+	return storage.DeletePrefix(ctx, app.Storage, fmt.Sprintf("%s/%d", VersionsPrefix, fileID))
+}
@@ -0,0 +1,94 @@
+package office
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// wordNamespace is the main WordprocessingML namespace.
+const wordNamespace = "http://schemas.openxmlformats.org/wordprocessingml/2006/main"
+
+// DocumentParagraphs returns the text of every paragraph in the body of a
+// DOCX archive, in document order. Table cells contribute one paragraph each.
+func DocumentParagraphs(r io.ReaderAt, size int64) ([]string, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotOOXML, err)
+	}
+	var doc *zip.File
+	for _, f := range zr.File {
+		if f.Name == "word/document.xml" {
+			doc = f
+			break
+		}
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("%w: missing word/document.xml", ErrNotOOXML)
+	}
+
+	rc, err := doc.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var paragraphs []string
+	var current strings.Builder
+	inParagraph, inRun, inText := false, false, false
+	dec := xml.NewDecoder(io.LimitReader(rc, maxPartSize))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return paragraphs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Space != wordNamespace {
+				continue
+			}
+			switch t.Name.Local {
+			case "p":
+				inParagraph = true
+				current.Reset()
+			case "r":
+				inRun = true
+			case "t":
+				inText = true
+			case "tab":
+				// Tab stops in paragraph properties share the element name.
+				if inRun {
+					current.WriteByte('\t')
+				}
+			case "br", "cr":
+				if inRun {
+					current.WriteByte('\n')
+				}
+			}
+		case xml.EndElement:
+			if t.Name.Space != wordNamespace {
+				continue
+			}
+			switch t.Name.Local {
+			case "r":
+				inRun = false
+			case "t":
+				inText = false
+			case "p":
+				if inParagraph {
+					paragraphs = append(paragraphs, current.String())
+				}
+				inParagraph = false
+			}
+		case xml.CharData:
+			if inText {
+				current.Write(t)
+			}
+		}
+	}
+}
@@ -0,0 +1,407 @@
+package office
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// markupCompatibilityNamespace holds mc:AlternateContent, which offers the
+// same content in several forms.
+const markupCompatibilityNamespace = "http://schemas.openxmlformats.org/markup-compatibility/2006"
+
+// DocumentHTML renders the body of a DOCX archive as an HTML fragment for
+// preview. The fragment is built from the document's text and structure
+// alone, so it is safe to embed: it holds only headings, paragraphs, lists,
+// tables, line breaks and bold, italic, underlined or struck-out text, and
+// no attributes beyond a fixed set of alignment classes. Images, text boxes
+// and deleted revisions are left out.
+func DocumentHTML(r io.ReaderAt, size int64) (string, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrNotOOXML, err)
+	}
+	parts := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		parts[f.Name] = f
+	}
+	doc, ok := parts["word/document.xml"]
+	if !ok {
+		return "", fmt.Errorf("%w: missing word/document.xml", ErrNotOOXML)
+	}
+
+	hr := &htmlRenderer{}
+	if hr.headings, err = readHeadingStyles(parts); err != nil {
+		return "", err
+	}
+	if hr.ordered, err = readNumbering(parts); err != nil {
+		return "", err
+	}
+
+	rc, err := doc.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	if err := hr.render(xml.NewDecoder(io.LimitReader(rc, maxPartSize))); err != nil {
+		return "", err
+	}
+	return hr.out.String(), nil
+}
+
+// readHeadingStyles maps the IDs of the paragraph styles that are headings
+// to their level. Style IDs are localized, so headings are recognized by
+// their built-in names and outline levels instead.
+func readHeadingStyles(parts map[string]*zip.File) (map[string]int, error) {
+	headings := make(map[string]int)
+	if _, ok := parts["word/styles.xml"]; !ok {
+		return headings, nil
+	}
+	var styles struct {
+		Styles []struct {
+			Type    string `xml:"type,attr"`
+			ID      string `xml:"styleId,attr"`
+			Name    val    `xml:"name"`
+			Outline *val   `xml:"pPr>outlineLvl"`
+		} `xml:"style"`
+	}
+	if err := decodePart(parts, "word/styles.xml", &styles); err != nil {
+		return nil, err
+	}
+	for _, s := range styles.Styles {
+		if s.Type != "paragraph" {
+			continue
+		}
+		name := strings.ToLower(s.Name.Val)
+		level := 0
+		switch {
+		case name == "title":
+			level = 1
+		case strings.HasPrefix(name, "heading "):
+			level, _ = strconv.Atoi(strings.TrimPrefix(name, "heading "))
+		case s.Outline != nil:
+			if n, err := strconv.Atoi(s.Outline.Val); err == nil && n < 9 {
+				level = n + 1
+			}
+		}
+		if level > 0 {
+			headings[s.ID] = min(level, 6)
+		}
+	}
+	return headings, nil
+}
+
+// listLevel identifies one level of a numbering definition.
+type listLevel struct {
+	numID string
+	level int
+}
+
+// readNumbering reports, for each list level, whether it is numbered rather
+// than bulleted.
+func readNumbering(parts map[string]*zip.File) (map[listLevel]bool, error) {
+	ordered := make(map[listLevel]bool)
+	if _, ok := parts["word/numbering.xml"]; !ok {
+		return ordered, nil
+	}
+	var numbering struct {
+		Abstract []struct {
+			ID     string `xml:"abstractNumId,attr"`
+			Levels []struct {
+				Level  int `xml:"ilvl,attr"`
+				Format val `xml:"numFmt"`
+			} `xml:"lvl"`
+		} `xml:"abstractNum"`
+		Nums []struct {
+			ID       string `xml:"numId,attr"`
+			Abstract val    `xml:"abstractNumId"`
+		} `xml:"num"`
+	}
+	if err := decodePart(parts, "word/numbering.xml", &numbering); err != nil {
+		return nil, err
+	}
+	formats := make(map[string]map[int]string)
+	for _, a := range numbering.Abstract {
+		formats[a.ID] = make(map[int]string)
+		for _, l := range a.Levels {
+			formats[a.ID][l.Level] = l.Format.Val
+		}
+	}
+	for _, n := range numbering.Nums {
+		for level, format := range formats[n.Abstract.Val] {
+			ordered[listLevel{n.ID, level}] = format != "bullet" && format != "none"
+		}
+	}
+	return ordered, nil
+}
+
+// val is an element whose w:val attribute is its value.
+type val struct {
+	Val string `xml:"val,attr"`
+}
+
+// htmlRun is a stretch of text with the same formatting. Tabs and line
+// breaks are kept as \t and \n.
+type htmlRun struct {
+	text                            strings.Builder
+	bold, italic, underline, strike bool
+}
+
+func (r *htmlRun) sameFormat(o *htmlRun) bool {
+	return r.bold == o.bold && r.italic == o.italic && r.underline == o.underline && r.strike == o.strike
+}
+
+type htmlParagraph struct {
+	style   string
+	numID   string
+	level   int
+	align   string
+	runs    []*htmlRun
+	current *htmlRun // the run being read, until it is appended to runs
+}
+
+// openList is a list being written. Nested lists go inside the item before
+// them, so the last item is left open until the next one starts.
+type openList struct {
+	ordered  bool
+	openItem bool
+}
+
+// htmlRenderer turns the WordprocessingML of a document body into HTML.
+type htmlRenderer struct {
+	out      strings.Builder
+	headings map[string]int
+	ordered  map[listLevel]bool
+	lists    []openList // innermost last
+	para     *htmlParagraph
+	inPPr    bool
+	inRPr    bool
+	inText   bool
+}
+
+func (hr *htmlRenderer) render(dec *xml.Decoder) error {
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			hr.closeLists(0)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Space == markupCompatibilityNamespace && t.Name.Local == "AlternateContent" {
+				if err := dec.Skip(); err != nil {
+					return err
+				}
+				continue
+			}
+			if t.Name.Space != wordNamespace {
+				continue
+			}
+			if err := hr.start(dec, t); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			if t.Name.Space == wordNamespace {
+				hr.end(t)
+			}
+		case xml.CharData:
+			if hr.inText && hr.para != nil && hr.para.current != nil {
+				hr.para.current.text.Write(t)
+			}
+		}
+	}
+}
+
+func (hr *htmlRenderer) start(dec *xml.Decoder, t xml.StartElement) error {
+	p := hr.para
+	switch t.Name.Local {
+	case "drawing", "pict", "object", "del", "moveFrom":
+		return dec.Skip()
+	case "tbl":
+		hr.closeLists(0)
+		hr.out.WriteString("<table>\n")
+	case "tr":
+		hr.out.WriteString("<tr>")
+	case "tc":
+		hr.out.WriteString("<td>")
+	case "p":
+		hr.para = &htmlParagraph{}
+	case "pPr":
+		hr.inPPr = true
+	case "rPr":
+		hr.inRPr = true
+	case "r":
+		if p != nil {
+			p.current = &htmlRun{}
+		}
+	case "t":
+		hr.inText = true
+	case "tab":
+		// Tab stops in paragraph properties share the element name.
+		if p != nil && p.current != nil && !hr.inPPr {
+			p.current.text.WriteByte('\t')
+		}
+	case "br", "cr":
+		if p != nil && p.current != nil {
+			p.current.text.WriteByte('\n')
+		}
+	}
+
+	if p == nil {
+		return nil
+	}
+	if hr.inPPr && !hr.inRPr {
+		switch t.Name.Local {
+		case "pStyle":
+			p.style = attr(t, "val")
+		case "numId":
+			p.numID = attr(t, "val")
+		case "ilvl":
+			p.level, _ = strconv.Atoi(attr(t, "val"))
+		case "jc":
+			p.align = attr(t, "val")
+		}
+	}
+	if hr.inRPr && !hr.inPPr && p.current != nil {
+		on := toggleOn(attr(t, "val"))
+		switch t.Name.Local {
+		case "b":
+			p.current.bold = on
+		case "i":
+			p.current.italic = on
+		case "u":
+			p.current.underline = attr(t, "val") != "none"
+		case "strike", "dstrike":
+			p.current.strike = on
+		}
+	}
+	return nil
+}
+
+func (hr *htmlRenderer) end(t xml.EndElement) {
+	switch t.Name.Local {
+	case "tbl":
+		hr.out.WriteString("</table>\n")
+	case "tr":
+		hr.out.WriteString("</tr>\n")
+	case "tc":
+		hr.closeLists(0)
+		hr.out.WriteString("</td>")
+	case "pPr":
+		hr.inPPr = false
+	case "rPr":
+		hr.inRPr = false
+	case "t":
+		hr.inText = false
+	case "r":
+		if p := hr.para; p != nil && p.current != nil {
+			if n := len(p.runs); n > 0 && p.runs[n-1].sameFormat(p.current) {
+				p.runs[n-1].text.WriteString(p.current.text.String())
+			} else if p.current.text.Len() > 0 {
+				p.runs = append(p.runs, p.current)
+			}
+			p.current = nil
+		}
+	case "p":
+		if hr.para != nil {
+			hr.writeParagraph(hr.para)
+		}
+		hr.para = nil
+	}
+}
+
+// toggleOn reads the w:val of a formatting toggle such as <w:b/>, which is
+// on unless turned off explicitly.
+func toggleOn(v string) bool {
+	return v != "0" && v != "false" && v != "off"
+}
+
+// alignClasses are the classes paragraphs are aligned with.
+var alignClasses = map[string]string{
+	"center": "center", "right": "right", "end": "right", "both": "justify", "distribute": "justify",
+}
+
+func (hr *htmlRenderer) writeParagraph(p *htmlParagraph) {
+	var content strings.Builder
+	for _, r := range p.runs {
+		writeRun(&content, r)
+	}
+
+	if p.numID != "" && p.numID != "0" {
+		level := max(0, min(p.level, 8))
+		ordered := hr.ordered[listLevel{p.numID, level}]
+		if len(hr.lists) > level && hr.lists[level].ordered != ordered {
+			hr.closeLists(level)
+		}
+		hr.closeLists(level + 1)
+		for len(hr.lists) <= level {
+			if n := len(hr.lists); n > 0 && !hr.lists[n-1].openItem {
+				hr.out.WriteString("<li>")
+				hr.lists[n-1].openItem = true
+			}
+			hr.lists = append(hr.lists, openList{ordered: ordered})
+			if ordered {
+				hr.out.WriteString("<ol>\n")
+			} else {
+				hr.out.WriteString("<ul>\n")
+			}
+		}
+		list := &hr.lists[level]
+		if list.openItem {
+			hr.out.WriteString("</li>\n")
+		}
+		fmt.Fprintf(&hr.out, "<li>%s", content.String())
+		list.openItem = true
+		return
+	}
+
+	hr.closeLists(0)
+	if level := hr.headings[p.style]; level > 0 {
+		fmt.Fprintf(&hr.out, "<h%d>%s</h%d>\n", level, content.String(), level)
+		return
+	}
+	if class, ok := alignClasses[p.align]; ok {
+		fmt.Fprintf(&hr.out, "<p class=\"%s\">%s</p>\n", class, content.String())
+		return
+	}
+	fmt.Fprintf(&hr.out, "<p>%s</p>\n", content.String())
+}
+
+// closeLists closes the open lists nested deeper than depth.
+func (hr *htmlRenderer) closeLists(depth int) {
+	for len(hr.lists) > depth {
+		list := hr.lists[len(hr.lists)-1]
+		if list.openItem {
+			hr.out.WriteString("</li>\n")
+		}
+		if list.ordered {
+			hr.out.WriteString("</ol>\n")
+		} else {
+			hr.out.WriteString("</ul>\n")
+		}
+		hr.lists = hr.lists[:len(hr.lists)-1]
+	}
+}
+
+func writeRun(b *strings.Builder, r *htmlRun) {
+	var open, closing []string
+	for _, f := range []struct {
+		on  bool
+		tag string
+	}{{r.bold, "strong"}, {r.italic, "em"}, {r.underline, "u"}, {r.strike, "s"}} {
+		if f.on {
+			open = append(open, "<"+f.tag+">")
+			closing = append([]string{"</" + f.tag + ">"}, closing...)
+		}
+	}
+	b.WriteString(strings.Join(open, ""))
+	b.WriteString(strings.ReplaceAll(html.EscapeString(r.text.String()), "\n", "<br>"))
+	b.WriteString(strings.Join(closing, ""))
+}
@@ -0,0 +1,86 @@
+package office
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const testDocument = `<?xml version="1.0" encoding="UTF-8"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"
+            xmlns:mc="http://schemas.openxmlformats.org/markup-compatibility/2006">
+<w:body>
+  <w:p><w:pPr><w:pStyle w:val="Title1"/></w:pPr><w:r><w:t>Report</w:t></w:r></w:p>
+  <w:p><w:pPr><w:jc w:val="center"/><w:tabs><w:tab w:val="left" w:pos="720"/></w:tabs></w:pPr>
+    <w:r><w:rPr><w:b/></w:rPr><w:t xml:space="preserve">Bold </w:t></w:r>
+    <w:r><w:rPr><w:b w:val="0"/><w:i/></w:rPr><w:t>&lt;script&gt;</w:t></w:r>
+    <w:r><w:tab/><w:t>after tab</w:t><w:br/><w:t>next line</w:t></w:r>
+  </w:p>
+  <w:p><w:pPr><w:numPr><w:ilvl w:val="0"/><w:numId w:val="1"/></w:numPr></w:pPr><w:r><w:t>first</w:t></w:r></w:p>
+  <w:p><w:pPr><w:numPr><w:ilvl w:val="0"/><w:numId w:val="1"/></w:numPr></w:pPr><w:r><w:t>second</w:t></w:r></w:p>
+  <w:tbl><w:tr><w:tc><w:p><w:r><w:t>cell</w:t></w:r></w:p></w:tc></w:tr></w:tbl>
+  <w:p><w:r><w:drawing><w:t>hidden</w:t></w:drawing></w:r><w:del><w:r><w:t>deleted</w:t></w:r></w:del></w:p>
+  <w:p><mc:AlternateContent><mc:Choice><w:r><w:t>alternate</w:t></w:r></mc:Choice></mc:AlternateContent></w:p>
+</w:body>
+</w:document>`
+
+const testStyles = `<?xml version="1.0" encoding="UTF-8"?>
+<w:styles xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+  <w:style w:type="paragraph" w:styleId="Title1"><w:name w:val="heading 1"/></w:style>
+</w:styles>`
+
+const testNumbering = `<?xml version="1.0" encoding="UTF-8"?>
+<w:numbering xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+  <w:abstractNum w:abstractNumId="0"><w:lvl w:ilvl="0"><w:numFmt w:val="decimal"/></w:lvl></w:abstractNum>
+  <w:num w:numId="1"><w:abstractNumId w:val="0"/></w:num>
+</w:numbering>`
+
+func TestDocumentParagraphs(t *testing.T) {
+	r := zipParts(t, map[string]string{"word/document.xml": testDocument})
+	paragraphs, err := DocumentParagraphs(r, r.Size())
+	if err != nil {
+		t.Fatalf("DocumentParagraphs: %v", err)
+	}
+	want := []string{"Report", "Bold <script>\tafter tab\nnext line", "first", "second", "cell", "hiddendeleted", "alternate"}
+	if fmt.Sprint(paragraphs) != fmt.Sprint(want) {
+		t.Errorf("paragraphs = %q\nwant %q", paragraphs, want)
+	}
+}
+
+func TestDocumentHTML(t *testing.T) {
+	r := zipParts(t, map[string]string{
+		"word/document.xml":  testDocument,
+		"word/styles.xml":    testStyles,
+		"word/numbering.xml": testNumbering,
+	})
+	got, err := DocumentHTML(r, r.Size())
+	if err != nil {
+		t.Fatalf("DocumentHTML: %v", err)
+	}
+	for _, want := range []string{
+		"<h1>Report</h1>",
+		`<p class="center"><strong>Bold </strong><em>&lt;script&gt;</em>` + "\tafter tab<br>next line</p>",
+		"<ol>\n<li>first</li>\n<li>second</li>\n</ol>",
+		"<table>\n<tr><td><p>cell</p>\n</td></tr>\n</table>",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("HTML lacks %q:\n%s", want, got)
+		}
+	}
+	for _, unwanted := range []string{"<script>", "hidden", "deleted", "alternate"} {
+		if strings.Contains(got, unwanted) {
+			t.Errorf("HTML holds %q:\n%s", unwanted, got)
+		}
+	}
+}
+
+func TestDocumentNotOOXML(t *testing.T) {
+	r := zipParts(t, map[string]string{"word/other.xml": "<x/>"})
+	if _, err := DocumentParagraphs(r, r.Size()); !errors.Is(err, ErrNotOOXML) {
+		t.Errorf("DocumentParagraphs without a document part: got %v, want ErrNotOOXML", err)
+	}
+	if _, err := DocumentHTML(r, r.Size()); !errors.Is(err, ErrNotOOXML) {
+		t.Errorf("DocumentHTML without a document part: got %v, want ErrNotOOXML", err)
+	}
+}
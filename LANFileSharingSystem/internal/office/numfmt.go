@@ -0,0 +1,137 @@
+package office
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// numberFormat is the number format of a cell style, as far as telling dates
+// and times from plain numbers is concerned.
+type numberFormat struct {
+	date    bool // the format shows a calendar date
+	time    bool // the format shows a time of day
+	elapsed bool // the format shows a duration, such as [h]:mm
+}
+
+// builtinDateFormats maps the built-in format IDs that show dates or times,
+// which workbooks reference without spelling out their codes.
+var builtinDateFormats = map[int]numberFormat{
+	14: {date: true}, 15: {date: true}, 16: {date: true}, 17: {date: true},
+	18: {time: true}, 19: {time: true}, 20: {time: true}, 21: {time: true},
+	22: {date: true, time: true},
+	45: {time: true}, 46: {time: true}, 47: {time: true},
+}
+
+// parseNumberFormat classifies a number format by its format code, or by its
+// ID for the built-in formats.
+func parseNumberFormat(id int, code string) numberFormat {
+	if f, ok := builtinDateFormats[id]; ok {
+		return f
+	}
+	var f numberFormat
+	// Only the first section applies to positive numbers.
+	inQuote, inBracket := false, false
+	for i := 0; i < len(code); i++ {
+		c := code[i]
+		switch {
+		case inQuote:
+			inQuote = c != '"'
+			continue
+		case inBracket:
+			// Elapsed-time codes such as [h] are durations; colours and
+			// conditions are not.
+			switch c {
+			case ']':
+				inBracket = false
+			case 'h', 'H', 'm', 'M', 's', 'S':
+				if code[i-1] == '[' {
+					f.elapsed = true
+				}
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inQuote = true
+		case '[':
+			inBracket = true
+		case '\\', '_', '*':
+			i++ // the next character is literal or padding
+		case ';':
+			return f
+		case 'd', 'D', 'y', 'Y':
+			f.date = true
+		case 'm', 'M':
+			// Minutes and months share the letter; a format with hours
+			// or seconds is a time either way.
+			if !f.time {
+				f.date = true
+			}
+		case 'h', 'H', 's', 'S':
+			f.time = true
+		case 'e', 'E':
+			// Part of an exponent in a number format such as 0.00E+00.
+			if f.date || f.time || f.elapsed {
+				continue
+			}
+			return numberFormat{}
+		}
+	}
+	return f
+}
+
+// format renders a numeric cell value. Dates and times are shown in ISO
+// form, since the locale-specific codes of the workbook are not reproduced;
+// other numbers are shown with the 15 significant digits spreadsheets keep.
+func (f numberFormat) format(value float64, date1904 bool) string {
+	if f.elapsed && value >= 0 {
+		seconds := int64(math.Round(value * 86400))
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	if (f.date || f.time) && value >= 0 && value < 2958466 {
+		t := serialTime(value, date1904)
+		switch {
+		case f.date && f.time:
+			return t.Format("2006-01-02 15:04:05")
+		case f.time && value < 1:
+			return t.Format("15:04:05")
+		case f.time:
+			return t.Format("2006-01-02 15:04:05")
+		default:
+			return t.Format("2006-01-02")
+		}
+	}
+	return formatNumber(value)
+}
+
+// serialTime converts a spreadsheet date serial number to a time. In the
+// 1900 system serial 60 is the non-existent 29 February 1900, kept for
+// compatibility with Lotus 1-2-3, so earlier serials are a day off.
+func serialTime(value float64, date1904 bool) time.Time {
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if date1904 {
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	} else if value < 60 {
+		value++
+	}
+	days := math.Floor(value)
+	seconds := math.Round((value - days) * 86400)
+	return epoch.AddDate(0, 0, int(days)).Add(time.Duration(seconds) * time.Second)
+}
+
+// formatNumber renders a number with at most 15 significant digits, which
+// hides binary rounding noise such as 0.30000000000000004.
+func formatNumber(value float64) string {
+	rounded, err := strconv.ParseFloat(strconv.FormatFloat(value, 'g', 15, 64), 64)
+	if err != nil {
+		rounded = value
+	}
+	s := strconv.FormatFloat(rounded, 'f', -1, 64)
+	if strings.Contains(s, ".") && len(s) > 20 {
+		return strconv.FormatFloat(rounded, 'g', -1, 64)
+	}
+	return s
+}
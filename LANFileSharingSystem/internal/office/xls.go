@@ -0,0 +1,506 @@
+package office
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/richardlehane/mscfb"
+)
+
+// ErrNotXLS is returned for files that are not Excel 97-2003 (BIFF8) workbooks.
+var ErrNotXLS = errors.New("office: not a valid Excel 97-2003 workbook")
+
+// BIFF8 record types read from the workbook stream.
+const (
+	recFormula    = 0x0006
+	recEOF        = 0x000A
+	recDateMode   = 0x0022
+	recFilePass   = 0x002F
+	recContinue   = 0x003C
+	recBoundSheet = 0x0085
+	recMulRK      = 0x00BD
+	recXF         = 0x00E0
+	recSST        = 0x00FC
+	recLabelSST   = 0x00FD
+	recNumber     = 0x0203
+	recLabel      = 0x0204
+	recBoolErr    = 0x0205
+	recString     = 0x0207
+	recRK         = 0x027E
+	recFormat     = 0x041E
+	recBOF        = 0x0809
+)
+
+// cellErrors are the texts of the error values a cell can hold.
+var cellErrors = map[byte]string{
+	0x00: "#NULL!", 0x07: "#DIV/0!", 0x0F: "#VALUE!", 0x17: "#REF!",
+	0x1D: "#NAME?", 0x24: "#NUM!", 0x2A: "#N/A",
+}
+
+// ReadXLS parses the worksheets of a legacy Excel 97-2003 workbook, the
+// BIFF8 records of the "Workbook" stream in an OLE compound file. Cell
+// content is formatted as text, and bounded, as by ReadWorkbook; charts and
+// macro sheets are left out.
+func ReadXLS(r io.ReaderAt, size int64) (wb *Workbook, err error) {
+	// Malformed compound files can make the container reader panic.
+	defer func() {
+		if p := recover(); p != nil {
+			wb, err = nil, fmt.Errorf("%w: %v", ErrNotXLS, p)
+		}
+	}()
+
+	stream, err := workbookStream(r)
+	if err != nil {
+		return nil, err
+	}
+	globals, err := readGlobals(stream)
+	if err != nil {
+		return nil, err
+	}
+
+	wb = &Workbook{}
+	limit := newCellLimit()
+	for _, s := range globals.sheets {
+		rows, err := readXLSSheet(stream, s.offset, globals, limit)
+		if err != nil {
+			return nil, fmt.Errorf("sheet %q: %w", s.name, err)
+		}
+		wb.Sheets = append(wb.Sheets, Sheet{Name: s.name, Rows: rows})
+	}
+	return wb, nil
+}
+
+// workbookStream reads the "Workbook" stream out of the compound file.
+func workbookStream(r io.ReaderAt) ([]byte, error) {
+	doc, err := mscfb.New(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotXLS, err)
+	}
+	for {
+		f, err := doc.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: no Workbook stream (Excel 5.0 and older files are not supported)", ErrNotXLS)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrNotXLS, err)
+		}
+		if f.Name != "Workbook" || len(f.Path) > 0 {
+			continue
+		}
+		if f.Size > maxPartSize {
+			return nil, fmt.Errorf("%w: workbook stream is larger than %d MB", ErrNotXLS, maxPartSize>>20)
+		}
+		data := make([]byte, f.Size)
+		if _, err := io.ReadFull(f, data); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrNotXLS, err)
+		}
+		return data, nil
+	}
+}
+
+// biffRecord is one record of a BIFF8 stream.
+type biffRecord struct {
+	kind uint16
+	data []byte
+}
+
+// readRecord returns the record at offset and the offset of the next one.
+func readRecord(stream []byte, offset int) (biffRecord, int, error) {
+	if offset < 0 || offset+4 > len(stream) {
+		return biffRecord{}, 0, io.ErrUnexpectedEOF
+	}
+	kind := binary.LittleEndian.Uint16(stream[offset:])
+	length := int(binary.LittleEndian.Uint16(stream[offset+2:]))
+	end := offset + 4 + length
+	if end > len(stream) {
+		return biffRecord{}, 0, io.ErrUnexpectedEOF
+	}
+	return biffRecord{kind: kind, data: stream[offset+4 : end]}, end, nil
+}
+
+type xlsSheetRef struct {
+	name   string
+	offset int // stream offset of the sheet's BOF record
+}
+
+// xlsGlobals is what the workbook globals substream says about the cells.
+type xlsGlobals struct {
+	sheets   []xlsSheetRef
+	shared   []string
+	formats  []numberFormat // indexed by the XF index of a cell
+	date1904 bool
+}
+
+// readGlobals reads the workbook globals substream at the start of the stream.
+func readGlobals(stream []byte) (*xlsGlobals, error) {
+	rec, next, err := readRecord(stream, 0)
+	if err != nil || rec.kind != recBOF || len(rec.data) < 2 {
+		return nil, fmt.Errorf("%w: missing BOF record", ErrNotXLS)
+	}
+	if binary.LittleEndian.Uint16(rec.data) != 0x0600 {
+		return nil, fmt.Errorf("%w: only BIFF8 workbooks are supported", ErrNotXLS)
+	}
+
+	g := &xlsGlobals{}
+	codes := make(map[int]string)
+	var formatIDs []int
+	for {
+		rec, next, err = readRecord(stream, next)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrNotXLS, err)
+		}
+		switch rec.kind {
+		case recEOF:
+			for _, id := range formatIDs {
+				g.formats = append(g.formats, parseNumberFormat(id, codes[id]))
+			}
+			return g, nil
+		case recFilePass:
+			return nil, fmt.Errorf("%w: the workbook is password protected", ErrNotXLS)
+		case recDateMode:
+			g.date1904 = len(rec.data) >= 2 && binary.LittleEndian.Uint16(rec.data) == 1
+		case recXF:
+			if len(rec.data) >= 4 {
+				formatIDs = append(formatIDs, int(binary.LittleEndian.Uint16(rec.data[2:])))
+			}
+		case recFormat:
+			if len(rec.data) >= 2 {
+				if code, _, err := readXLString(rec.data[2:], 2); err == nil {
+					codes[int(binary.LittleEndian.Uint16(rec.data))] = code
+				}
+			}
+		case recBoundSheet:
+			// Only worksheets (type 0) hold cells.
+			if len(rec.data) < 8 || rec.data[5] != 0 {
+				continue
+			}
+			name, _, err := readXLString(rec.data[6:], 1)
+			if err != nil {
+				return nil, fmt.Errorf("%w: sheet name: %v", ErrNotXLS, err)
+			}
+			g.sheets = append(g.sheets, xlsSheetRef{name: name, offset: int(binary.LittleEndian.Uint32(rec.data))})
+		case recSST:
+			segments := [][]byte{rec.data}
+			for {
+				cont, after, err := readRecord(stream, next)
+				if err != nil || cont.kind != recContinue {
+					break
+				}
+				segments = append(segments, cont.data)
+				next = after
+			}
+			if g.shared, err = readSST(segments); err != nil {
+				return nil, fmt.Errorf("%w: shared strings: %v", ErrNotXLS, err)
+			}
+		}
+	}
+}
+
+// readXLSSheet extracts the formatted cell text of the worksheet substream
+// starting at offset, taking the cells from limit.
+func readXLSSheet(stream []byte, offset int, g *xlsGlobals, limit *cellLimit) ([]Row, error) {
+	rec, next, err := readRecord(stream, offset)
+	if err != nil || rec.kind != recBOF {
+		return nil, fmt.Errorf("%w: sheet does not start with a BOF record", ErrNotXLS)
+	}
+
+	cells := make(map[int]map[int]string)
+	var setErr error // the first cell that could not be stored
+	set := func(row, col int, value string) {
+		if value == "" || setErr != nil {
+			return
+		}
+		if col >= maxColumns {
+			setErr = fmt.Errorf("%w: more than %d columns", ErrTooLarge, maxColumns)
+			return
+		}
+		if cells[row] == nil {
+			cells[row] = make(map[int]string)
+		}
+		if _, ok := cells[row][col]; !ok {
+			if setErr = limit.take(1); setErr != nil {
+				return
+			}
+		}
+		cells[row][col] = value
+	}
+	number := func(xf int, value float64) string {
+		var f numberFormat
+		if xf >= 0 && xf < len(g.formats) {
+			f = g.formats[xf]
+		}
+		return f.format(value, g.date1904)
+	}
+
+	// Embedded charts bring substreams of their own.
+	depth := 1
+	pendingRow, pendingCol := -1, -1 // formula awaiting its STRING record
+	for depth > 0 && setErr == nil {
+		rec, next, err = readRecord(stream, next)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrNotXLS, err)
+		}
+		switch rec.kind {
+		case recBOF:
+			depth++
+			continue
+		case recEOF:
+			depth--
+			continue
+		}
+		if depth > 1 || len(rec.data) < 6 {
+			continue
+		}
+		d := rec.data
+		row := int(binary.LittleEndian.Uint16(d))
+		col := int(binary.LittleEndian.Uint16(d[2:]))
+		xf := int(binary.LittleEndian.Uint16(d[4:]))
+		switch rec.kind {
+		case recLabelSST:
+			if len(d) >= 10 {
+				if i := int(binary.LittleEndian.Uint32(d[6:])); i < len(g.shared) {
+					set(row, col, g.shared[i])
+				}
+			}
+		case recLabel:
+			if s, _, err := readXLString(d[6:], 2); err == nil {
+				set(row, col, s)
+			}
+		case recNumber:
+			if len(d) >= 14 {
+				set(row, col, number(xf, math.Float64frombits(binary.LittleEndian.Uint64(d[6:]))))
+			}
+		case recRK:
+			if len(d) >= 10 {
+				set(row, col, number(xf, rkValue(binary.LittleEndian.Uint32(d[6:]))))
+			}
+		case recMulRK:
+			// Row, first column, then an (XF, RK) pair per column.
+			for i, c := 4, col; i+6 <= len(d)-2; i, c = i+6, c+1 {
+				set(row, c, number(int(binary.LittleEndian.Uint16(d[i:])), rkValue(binary.LittleEndian.Uint32(d[i+2:]))))
+			}
+		case recBoolErr:
+			if len(d) >= 8 {
+				set(row, col, boolErrText(d[6], d[7] != 0))
+			}
+		case recFormula:
+			if len(d) < 14 {
+				continue
+			}
+			if d[12] != 0xFF || d[13] != 0xFF {
+				set(row, col, number(xf, math.Float64frombits(binary.LittleEndian.Uint64(d[6:]))))
+				continue
+			}
+			switch d[6] {
+			case 0: // string, in the STRING record that follows
+				pendingRow, pendingCol = row, col
+			case 1:
+				set(row, col, boolErrText(d[8], false))
+			case 2:
+				set(row, col, boolErrText(d[8], true))
+			}
+		}
+		if rec.kind == recString && pendingRow >= 0 {
+			if s, _, err := readXLString(rec.data, 2); err == nil {
+				set(pendingRow, pendingCol, s)
+			}
+			pendingRow, pendingCol = -1, -1
+		}
+	}
+	if setErr != nil {
+		return nil, setErr
+	}
+
+	numbers := make([]int, 0, len(cells))
+	for n := range cells {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	rows := make([]Row, 0, len(numbers))
+	for _, n := range numbers {
+		last := 0
+		for c := range cells[n] {
+			last = max(last, c)
+		}
+		// The cells set were taken already; padding the row takes the rest.
+		if err := limit.take(last + 1 - len(cells[n])); err != nil {
+			return nil, err
+		}
+		row := Row{Number: n + 1, Cells: make([]string, last+1)}
+		for c, v := range cells[n] {
+			row.Cells[c] = v
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// rkValue decodes an RK number, a compressed form of a double or an integer.
+func rkValue(rk uint32) float64 {
+	var v float64
+	if rk&0x02 != 0 {
+		v = float64(int32(rk) >> 2)
+	} else {
+		v = math.Float64frombits(uint64(rk&0xFFFFFFFC) << 32)
+	}
+	if rk&0x01 != 0 {
+		v /= 100
+	}
+	return v
+}
+
+func boolErrText(value byte, isError bool) string {
+	if isError {
+		if text, ok := cellErrors[value]; ok {
+			return text
+		}
+		return "#ERROR"
+	}
+	if value != 0 {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+// readXLString reads an unformatted string whose character count takes
+// lengthSize bytes, returning it and the bytes it took up.
+func readXLString(b []byte, lengthSize int) (string, int, error) {
+	if len(b) < lengthSize+1 {
+		return "", 0, io.ErrUnexpectedEOF
+	}
+	cch := int(b[0])
+	if lengthSize == 2 {
+		cch = int(binary.LittleEndian.Uint16(b))
+	}
+	high := b[lengthSize]&0x01 != 0
+	pos := lengthSize + 1
+	width := 1
+	if high {
+		width = 2
+	}
+	if pos+cch*width > len(b) {
+		return "", 0, io.ErrUnexpectedEOF
+	}
+	return decodeChars(b[pos:pos+cch*width], high), pos + cch*width, nil
+}
+
+func decodeChars(b []byte, high bool) string {
+	if !high {
+		// Compressed strings hold the low bytes of UTF-16 code units.
+		u := make([]rune, len(b))
+		for i, c := range b {
+			u[i] = rune(c)
+		}
+		return string(u)
+	}
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(u))
+}
+
+// readSST parses the shared string table, which the SST record continues in
+// CONTINUE records. A string split across records restarts its characters
+// with a fresh flags byte.
+func readSST(segments [][]byte) ([]string, error) {
+	r := &segmentReader{segments: segments}
+	header, err := r.bytes(8)
+	if err != nil {
+		return nil, err
+	}
+	count := int(binary.LittleEndian.Uint32(header[4:]))
+
+	var shared []string
+	for i := 0; i < count; i++ {
+		head, err := r.bytes(3)
+		if err != nil {
+			return nil, err
+		}
+		cch := int(binary.LittleEndian.Uint16(head))
+		flags := head[2]
+		runs, extSize := 0, 0
+		if flags&0x08 != 0 {
+			b, err := r.bytes(2)
+			if err != nil {
+				return nil, err
+			}
+			runs = int(binary.LittleEndian.Uint16(b))
+		}
+		if flags&0x04 != 0 {
+			b, err := r.bytes(4)
+			if err != nil {
+				return nil, err
+			}
+			extSize = int(int32(binary.LittleEndian.Uint32(b)))
+		}
+		s, err := r.chars(cch, flags&0x01 != 0)
+		if err != nil {
+			return nil, err
+		}
+		// Formatting runs and phonetic data are not needed.
+		if _, err := r.bytes(4*runs + max(extSize, 0)); err != nil {
+			return nil, err
+		}
+		shared = append(shared, s)
+	}
+	return shared, nil
+}
+
+// segmentReader reads across the data of a record and its CONTINUE records.
+type segmentReader struct {
+	segments [][]byte
+	seg, pos int
+}
+
+// bytes returns the next n bytes, joining segments as needed.
+func (r *segmentReader) bytes(n int) ([]byte, error) {
+	out := make([]byte, 0, n)
+	for len(out) < n {
+		if r.seg >= len(r.segments) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		seg := r.segments[r.seg]
+		take := min(n-len(out), len(seg)-r.pos)
+		out = append(out, seg[r.pos:r.pos+take]...)
+		r.pos += take
+		if r.pos == len(seg) {
+			r.seg, r.pos = r.seg+1, 0
+		}
+	}
+	return out, nil
+}
+
+// chars reads cch characters. Each segment a string continues into starts
+// with a flags byte giving the width of the characters that follow, even
+// when the split falls right after the string's header.
+func (r *segmentReader) chars(cch int, high bool) (string, error) {
+	var s strings.Builder
+	var b []byte
+	for n := 0; n < cch; n++ {
+		// The header or the previous character ended a segment.
+		if r.pos == 0 && r.seg < len(r.segments) {
+			s.WriteString(decodeChars(b, high))
+			b = b[:0]
+			high = r.segments[r.seg][0]&0x01 != 0
+			r.pos = 1
+		}
+		width := 1
+		if high {
+			width = 2
+		}
+		c, err := r.bytes(width)
+		if err != nil {
+			return "", err
+		}
+		b = append(b, c...)
+	}
+	s.WriteString(decodeChars(b, high))
+	return s.String(), nil
+}
@@ -0,0 +1,119 @@
+package office
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// biffStream assembles BIFF8 records into a worksheet substream, from its
+// BOF record to its EOF record.
+type biffStream []byte
+
+func (s *biffStream) record(kind uint16, data []byte) {
+	var head [4]byte
+	binary.LittleEndian.PutUint16(head[:], kind)
+	binary.LittleEndian.PutUint16(head[2:], uint16(len(data)))
+	*s = append(append(*s, head[:]...), data...)
+}
+
+// cell returns the row, column and XF index that start every cell record.
+func cell(row, col, xf int) []byte {
+	b := make([]byte, 6)
+	binary.LittleEndian.PutUint16(b, uint16(row))
+	binary.LittleEndian.PutUint16(b[2:], uint16(col))
+	binary.LittleEndian.PutUint16(b[4:], uint16(xf))
+	return b
+}
+
+func (s *biffStream) number(row, col, xf int, v float64) {
+	s.record(recNumber, binary.LittleEndian.AppendUint64(cell(row, col, xf), math.Float64bits(v)))
+}
+
+func (s *biffStream) sst(row, col, index int) {
+	s.record(recLabelSST, binary.LittleEndian.AppendUint32(cell(row, col, 0), uint32(index)))
+}
+
+func (s *biffStream) boolErr(row, col int, value byte, isError bool) {
+	var flag byte
+	if isError {
+		flag = 1
+	}
+	s.record(recBoolErr, append(cell(row, col, 0), value, flag))
+}
+
+func sheetStream(cells func(s *biffStream)) []byte {
+	var s biffStream
+	s.record(recBOF, []byte{0x00, 0x06, 0x10, 0x00}) // BIFF8 worksheet
+	cells(&s)
+	s.record(recEOF, nil)
+	return s
+}
+
+func TestReadXLSSheet(t *testing.T) {
+	g := &xlsGlobals{
+		shared:  []string{"Name", "Total"},
+		formats: []numberFormat{{}, {date: true}},
+	}
+	stream := sheetStream(func(s *biffStream) {
+		s.sst(0, 0, 0)
+		s.sst(0, 2, 1)
+		s.number(2, 0, 0, 0.1+0.2)
+		s.number(2, 1, 1, 45658)
+		s.boolErr(2, 2, 1, false)
+		s.boolErr(2, 3, 0x07, true)
+		s.sst(4, 0, 99) // out of range, left empty
+	})
+
+	rows, err := readXLSSheet(stream, 0, g, newCellLimit())
+	if err != nil {
+		t.Fatalf("readXLSSheet: %v", err)
+	}
+	want := []Row{
+		{Number: 1, Cells: []string{"Name", "", "Total"}},
+		{Number: 3, Cells: []string{"0.3", "2025-01-01", "TRUE", "#DIV/0!"}},
+	}
+	if fmt.Sprint(rows) != fmt.Sprint(want) {
+		t.Errorf("rows = %q\nwant %q", rows, want)
+	}
+}
+
+func TestReadXLSSheetLimits(t *testing.T) {
+	g := &xlsGlobals{}
+
+	// A column beyond what Excel allows.
+	stream := sheetStream(func(s *biffStream) { s.number(0, maxColumns, 0, 1) })
+	if _, err := readXLSSheet(stream, 0, g, newCellLimit()); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("cell in column %d: got %v, want ErrTooLarge", maxColumns, err)
+	}
+
+	// One far-right value per row, which padding would expand.
+	rows := maxCells/maxColumns + 1
+	stream = sheetStream(func(s *biffStream) {
+		for r := 0; r < rows; r++ {
+			s.number(r, maxColumns-1, 0, 1)
+		}
+	})
+	if _, err := readXLSSheet(stream, 0, g, newCellLimit()); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("%d padded rows: got %v, want ErrTooLarge", rows, err)
+	}
+
+	// The limit is shared by the sheets of a workbook.
+	stream = sheetStream(func(s *biffStream) {
+		for r := 0; r < rows/2; r++ {
+			s.number(r, maxColumns-1, 0, 1)
+		}
+	})
+	limit := newCellLimit()
+	if _, err := readXLSSheet(stream, 0, g, limit); err != nil {
+		t.Fatalf("first half-full sheet: %v", err)
+	}
+	if _, err := readXLSSheet(stream, 0, g, limit); err != nil {
+		t.Fatalf("second half-full sheet: %v", err)
+	}
+	if _, err := readXLSSheet(stream, 0, g, limit); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("third half-full sheet: got %v, want ErrTooLarge", err)
+	}
+}
@@ -0,0 +1,441 @@
+// Package office reads the office formats used by the file repository, the
+// OOXML formats (DOCX, XLSX) and legacy Excel 97-2003 workbooks (XLS),
+// without shelling out to an office suite.
+package office
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrNotOOXML is returned when an archive lacks the parts of the expected format.
+	ErrNotOOXML = errors.New("office: not a valid OOXML document")
+	// ErrTooLarge is returned for workbooks with more cells than are read.
+	ErrTooLarge = errors.New("office: workbook is too large")
+)
+
+// maxPartSize bounds how much of a single archive part is read, so a
+// crafted zip cannot expand into an arbitrarily large allocation.
+const maxPartSize = 64 << 20
+
+// maxColumns is the widest sheet read, the last column of Excel (XFD).
+// maxCells bounds the cells of a workbook. Rows are dense, so a single value
+// far to the right costs a cell for every column before it; without the
+// bound a small part could expand into millions of empty strings.
+const (
+	maxColumns = 16384
+	maxCells   = 2 << 20
+)
+
+// cellLimit counts the cells a workbook has been read into.
+type cellLimit struct {
+	left int
+}
+
+func newCellLimit() *cellLimit {
+	return &cellLimit{left: maxCells}
+}
+
+// take reserves n more cells, failing once the workbook holds too many.
+func (l *cellLimit) take(n int) error {
+	if n > l.left {
+		return fmt.Errorf("%w: more than %d cells", ErrTooLarge, maxCells)
+	}
+	l.left -= n
+	return nil
+}
+
+// Sheet is one worksheet of a workbook. Rows are dense: missing cells are
+// empty strings and trailing empty cells are dropped.
+type Sheet struct {
+	Name string
+	Rows []Row
+}
+
+// Row is one non-empty worksheet row.
+type Row struct {
+	Number int // 1-based row number as shown in the spreadsheet
+	Cells  []string
+}
+
+// Workbook is the cell content of a spreadsheet, formatted as text. Dates
+// are shown in ISO form.
+type Workbook struct {
+	Sheets []Sheet
+}
+
+// ReadWorkbook parses the worksheets of an XLSX archive. Workbooks wider
+// than Excel allows, or with more cells than maxCells once their rows are
+// padded, fail with ErrTooLarge.
+func ReadWorkbook(r io.ReaderAt, size int64) (*Workbook, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotOOXML, err)
+	}
+	parts := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		parts[f.Name] = f
+	}
+
+	sheets, date1904, err := readSheetList(parts)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := readSharedStrings(parts)
+	if err != nil {
+		return nil, err
+	}
+	styles, err := readCellStyles(parts, date1904)
+	if err != nil {
+		return nil, err
+	}
+
+	wb := &Workbook{}
+	limit := newCellLimit()
+	for _, s := range sheets {
+		f, ok := parts[s.target]
+		if !ok {
+			continue
+		}
+		rows, err := readSheetRows(f, shared, styles, limit)
+		if err != nil {
+			return nil, fmt.Errorf("sheet %q: %w", s.name, err)
+		}
+		wb.Sheets = append(wb.Sheets, Sheet{Name: s.name, Rows: rows})
+	}
+	return wb, nil
+}
+
+type sheetRef struct {
+	name   string
+	target string // archive path of the worksheet part
+}
+
+// readSheetList resolves the workbook's sheets, in tab order, to their parts.
+// It also reports whether the workbook counts dates from 1904.
+func readSheetList(parts map[string]*zip.File) ([]sheetRef, bool, error) {
+	var workbook struct {
+		Properties struct {
+			Date1904 string `xml:"date1904,attr"`
+		} `xml:"workbookPr"`
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := decodePart(parts, "xl/workbook.xml", &workbook); err != nil {
+		return nil, false, err
+	}
+	date1904 := workbook.Properties.Date1904 == "1" || workbook.Properties.Date1904 == "true"
+
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := decodePart(parts, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, false, err
+	}
+	targets := make(map[string]string, len(rels.Relationships))
+	for _, rel := range rels.Relationships {
+		target := rel.Target
+		if strings.HasPrefix(target, "/") {
+			target = strings.TrimPrefix(target, "/")
+		} else {
+			target = path.Join("xl", target)
+		}
+		targets[rel.ID] = target
+	}
+
+	var refs []sheetRef
+	for _, s := range workbook.Sheets {
+		refs = append(refs, sheetRef{name: s.Name, target: targets[s.RID]})
+	}
+	return refs, date1904, nil
+}
+
+// cellStyles holds the number format of each cell style, so that dates,
+// which are stored as serial numbers, can be shown as dates.
+type cellStyles struct {
+	formats  []numberFormat // indexed by the s attribute of a cell
+	date1904 bool
+}
+
+// readCellStyles loads the number formats of the cell styles; workbooks
+// without a styles part are valid.
+func readCellStyles(parts map[string]*zip.File, date1904 bool) (*cellStyles, error) {
+	styles := &cellStyles{date1904: date1904}
+	if _, ok := parts["xl/styles.xml"]; !ok {
+		return styles, nil
+	}
+	var sheet struct {
+		NumFmts []struct {
+			ID   int    `xml:"numFmtId,attr"`
+			Code string `xml:"formatCode,attr"`
+		} `xml:"numFmts>numFmt"`
+		CellXfs []struct {
+			NumFmtID int `xml:"numFmtId,attr"`
+		} `xml:"cellXfs>xf"`
+	}
+	if err := decodePart(parts, "xl/styles.xml", &sheet); err != nil {
+		return nil, err
+	}
+	codes := make(map[int]string, len(sheet.NumFmts))
+	for _, f := range sheet.NumFmts {
+		codes[f.ID] = f.Code
+	}
+	for _, xf := range sheet.CellXfs {
+		styles.formats = append(styles.formats, parseNumberFormat(xf.NumFmtID, codes[xf.NumFmtID]))
+	}
+	return styles, nil
+}
+
+// formatNumber renders a numeric cell value in the given style.
+func (s *cellStyles) formatNumber(value, style string) string {
+	v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return value
+	}
+	var f numberFormat
+	if i, err := strconv.Atoi(style); err == nil && i >= 0 && i < len(s.formats) {
+		f = s.formats[i]
+	}
+	return f.format(v, s.date1904)
+}
+
+// readSharedStrings loads the shared string table; workbooks without one are valid.
+func readSharedStrings(parts map[string]*zip.File) ([]string, error) {
+	f, ok := parts["xl/sharedStrings.xml"]
+	if !ok {
+		return nil, nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var shared []string
+	dec := xml.NewDecoder(io.LimitReader(rc, maxPartSize))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return shared, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if se, ok := tok.(xml.StartElement); ok && se.Name.Local == "si" {
+			text, err := readRichText(dec, se.Name)
+			if err != nil {
+				return nil, err
+			}
+			shared = append(shared, text)
+		}
+	}
+}
+
+// readRichText concatenates every <t> below the element that was just opened.
+// Phonetic runs (<rPh>) are skipped because they duplicate the text.
+func readRichText(dec *xml.Decoder, end xml.Name) (string, error) {
+	var b strings.Builder
+	depth, skip := 0, 0
+	inText := false
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if t.Name.Local == "rPh" {
+				skip = depth
+			}
+			if t.Name.Local == "t" && skip == 0 {
+				inText = true
+			}
+		case xml.EndElement:
+			if depth == 0 && t.Name == end {
+				return b.String(), nil
+			}
+			if t.Name.Local == "t" {
+				inText = false
+			}
+			if skip == depth {
+				skip = 0
+			}
+			depth--
+		case xml.CharData:
+			if inText {
+				b.Write(t)
+			}
+		}
+	}
+}
+
+// readSheetRows extracts the formatted cell text of a worksheet, taking the
+// cells from limit.
+func readSheetRows(f *zip.File, shared []string, styles *cellStyles, limit *cellLimit) ([]Row, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var rows []Row
+	dec := xml.NewDecoder(io.LimitReader(rc, maxPartSize))
+	var current *Row
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "row":
+				n, _ := strconv.Atoi(attr(t, "r"))
+				if n == 0 {
+					n = len(rows) + 1
+				}
+				current = &Row{Number: n}
+			case "c":
+				if current == nil {
+					continue
+				}
+				col := columnIndex(attr(t, "r"))
+				if col < 0 {
+					col = len(current.Cells)
+				}
+				value, err := readCell(dec, t, shared, styles)
+				if err != nil {
+					return nil, err
+				}
+				if value == "" {
+					continue
+				}
+				if col >= maxColumns {
+					return nil, fmt.Errorf("%w: more than %d columns", ErrTooLarge, maxColumns)
+				}
+				if grow := col + 1 - len(current.Cells); grow > 0 {
+					if err := limit.take(grow); err != nil {
+						return nil, err
+					}
+					current.Cells = append(current.Cells, make([]string, grow)...)
+				}
+				current.Cells[col] = value
+			}
+		case xml.EndElement:
+			if t.Name.Local == "row" && current != nil {
+				if len(current.Cells) > 0 {
+					rows = append(rows, *current)
+				}
+				current = nil
+			}
+		}
+	}
+}
+
+// readCell returns the display text of the <c> element that was just opened.
+func readCell(dec *xml.Decoder, start xml.StartElement, shared []string, styles *cellStyles) (string, error) {
+	cellType := attr(start, "t")
+	var value, inline string
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "v":
+				if err := dec.DecodeElement(&value, &t); err != nil {
+					return "", err
+				}
+			case "is":
+				if inline, err = readRichText(dec, t.Name); err != nil {
+					return "", err
+				}
+			default:
+				if err := dec.Skip(); err != nil {
+					return "", err
+				}
+			}
+		case xml.EndElement:
+			switch cellType {
+			case "s":
+				if i, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && i >= 0 && i < len(shared) {
+					return shared[i], nil
+				}
+				return "", nil
+			case "inlineStr":
+				return inline, nil
+			case "b":
+				if strings.TrimSpace(value) == "1" {
+					return "TRUE", nil
+				}
+				return "FALSE", nil
+			case "", "n":
+				if value == "" {
+					return "", nil
+				}
+				return styles.formatNumber(value, attr(start, "s")), nil
+			default:
+				return value, nil
+			}
+		}
+	}
+}
+
+// columnIndex converts the column letters of a cell reference such as "AB12"
+// to a zero-based index, or -1 if the reference has none.
+func columnIndex(ref string) int {
+	col := 0
+	n := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		col = col*26 + int(r-'A'+1)
+		n++
+	}
+	if n == 0 || col > maxColumns {
+		return -1
+	}
+	return col - 1
+}
+
+func attr(se xml.StartElement, local string) string {
+	for _, a := range se.Attr {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// decodePart unmarshals a required XML part of the archive into v.
+func decodePart(parts map[string]*zip.File, name string, v interface{}) error {
+	f, ok := parts[name]
+	if !ok {
+		return fmt.Errorf("%w: missing %s", ErrNotOOXML, name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return xml.NewDecoder(io.LimitReader(rc, maxPartSize)).Decode(v)
+}
@@ -0,0 +1,178 @@
+package office
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// zipParts builds an archive holding the given parts.
+func zipParts(t *testing.T, parts map[string]string) *bytes.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range parts {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("zip: %v", err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatalf("zip: %v", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+// workbookParts returns the parts of an XLSX workbook with one sheet per
+// entry of sheets, each given as the content of its <sheetData>.
+func workbookParts(sheets ...string) map[string]string {
+	var list, rels strings.Builder
+	parts := make(map[string]string)
+	for i, data := range sheets {
+		fmt.Fprintf(&list, `<sheet name="Sheet%d" sheetId="%d" r:id="rId%d"/>`, i+1, i+1, i+1)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i+1, i+1)
+		parts[fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1)] = `<?xml version="1.0" encoding="UTF-8"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>` + data + `</sheetData></worksheet>`
+	}
+	parts["xl/workbook.xml"] = `<?xml version="1.0" encoding="UTF-8"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+          xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets>` + list.String() + `</sheets></workbook>`
+	parts["xl/_rels/workbook.xml.rels"] = `<?xml version="1.0" encoding="UTF-8"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` + rels.String() + `</Relationships>`
+	parts["xl/sharedStrings.xml"] = `<?xml version="1.0" encoding="UTF-8"?>
+<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<si><t>Name</t></si>
+<si><r><t>Rich </t></r><r><t>text</t></r><rPh><t>ruby</t></rPh></si>
+</sst>`
+	parts["xl/styles.xml"] = `<?xml version="1.0" encoding="UTF-8"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<numFmts><numFmt numFmtId="164" formatCode="[h]:mm:ss"/></numFmts>
+<cellXfs><xf numFmtId="0"/><xf numFmtId="14"/><xf numFmtId="164"/></cellXfs>
+</styleSheet>`
+	return parts
+}
+
+func readTestWorkbook(t *testing.T, sheets ...string) (*Workbook, error) {
+	t.Helper()
+	r := zipParts(t, workbookParts(sheets...))
+	return ReadWorkbook(r, r.Size())
+}
+
+func TestReadWorkbook(t *testing.T) {
+	wb, err := readTestWorkbook(t, `
+<row r="1"><c r="A1" t="s"><v>0</v></c><c r="C1" t="s"><v>1</v></c></row>
+<row r="3">
+  <c r="A3"><v>0.1</v></c>
+  <c r="B3" s="1"><v>45658</v></c>
+  <c r="C3" s="2"><v>1.5</v></c>
+  <c r="D3" t="b"><v>1</v></c>
+  <c r="E3" t="inlineStr"><is><t>inline</t></is></c>
+  <c r="F3" t="str"><f>A1</f><v>formula</v></c>
+</row>
+<row r="4"><c r="A4"/></row>`)
+	if err != nil {
+		t.Fatalf("ReadWorkbook: %v", err)
+	}
+	if len(wb.Sheets) != 1 || wb.Sheets[0].Name != "Sheet1" {
+		t.Fatalf("sheets = %+v", wb.Sheets)
+	}
+	rows := wb.Sheets[0].Rows
+	want := []Row{
+		{Number: 1, Cells: []string{"Name", "", "Rich text"}},
+		{Number: 3, Cells: []string{"0.1", "2025-01-01", "36:00:00", "TRUE", "inline", "formula"}},
+	}
+	if fmt.Sprint(rows) != fmt.Sprint(want) {
+		t.Errorf("rows = %q\nwant %q", rows, want)
+	}
+}
+
+func TestReadWorkbookLimits(t *testing.T) {
+	// A value in the last column is allowed, and costs a cell per column.
+	wb, err := readTestWorkbook(t, `<row r="1"><c r="XFD1" t="inlineStr"><is><t>x</t></is></c></row>`)
+	if err != nil {
+		t.Fatalf("ReadWorkbook with a value in XFD: %v", err)
+	}
+	if cells := wb.Sheets[0].Rows[0].Cells; len(cells) != maxColumns || cells[maxColumns-1] != "x" {
+		t.Errorf("row holds %d cells, want %d", len(cells), maxColumns)
+	}
+
+	// One far-right value per row must not expand without bound.
+	var data strings.Builder
+	for i := 1; i <= maxCells/maxColumns+1; i++ {
+		fmt.Fprintf(&data, `<row r="%d"><c r="XFD%d"><v>1</v></c></row>`, i, i)
+	}
+	if _, err := readTestWorkbook(t, data.String()); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("ReadWorkbook with %d padded rows: got %v, want ErrTooLarge", maxCells/maxColumns+1, err)
+	}
+
+	// The bound covers the whole workbook, not each sheet.
+	half := data.String()[:strings.Index(data.String(), fmt.Sprintf(`<row r="%d">`, maxCells/maxColumns/2+1))]
+	if _, err := readTestWorkbook(t, half, half, half); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("ReadWorkbook with three half-full sheets: got %v, want ErrTooLarge", err)
+	}
+
+	// Cells without a reference continue the row, and are bounded as well.
+	if _, err := readTestWorkbook(t, `<row r="1"><c r="XFD1"><v>1</v></c><c><v>2</v></c></row>`); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("ReadWorkbook with a cell past XFD: got %v, want ErrTooLarge", err)
+	}
+}
+
+func TestReadWorkbookNotOOXML(t *testing.T) {
+	r := bytes.NewReader([]byte("not a zip"))
+	if _, err := ReadWorkbook(r, r.Size()); !errors.Is(err, ErrNotOOXML) {
+		t.Errorf("ReadWorkbook of garbage: got %v, want ErrNotOOXML", err)
+	}
+	parts := workbookParts(`<row r="1"/>`)
+	delete(parts, "xl/workbook.xml")
+	r = zipParts(t, parts)
+	if _, err := ReadWorkbook(r, r.Size()); !errors.Is(err, ErrNotOOXML) {
+		t.Errorf("ReadWorkbook without a workbook part: got %v, want ErrNotOOXML", err)
+	}
+}
+
+func TestColumnIndex(t *testing.T) {
+	for ref, want := range map[string]int{
+		"A1": 0, "Z9": 25, "AA10": 26, "AZ1": 51, "XFD1048576": 16383,
+		"XFE1": -1, "1": -1, "": -1,
+	} {
+		if got := columnIndex(ref); got != want {
+			t.Errorf("columnIndex(%q) = %d, want %d", ref, got, want)
+		}
+	}
+}
+
+func TestNumberFormat(t *testing.T) {
+	for _, c := range []struct {
+		id       int
+		code     string
+		value    float64
+		date1904 bool
+		want     string
+	}{
+		{0, "", 0.1 + 0.2, false, "0.3"},
+		{0, "", 1234567.5, false, "1234567.5"},
+		{14, "", 45658, false, "2025-01-01"},
+		{14, "", 45658, true, "2029-01-02"},
+		{14, "", 59, false, "1900-02-28"},
+		{14, "", 61, false, "1900-03-01"},
+		{22, "", 45658.75, false, "2025-01-01 18:00:00"},
+		{164, "hh:mm", 0.5, false, "12:00:00"},
+		{164, "yyyy-mm-dd", 45658, false, "2025-01-01"},
+		{164, "[h]:mm", 1.25, false, "30:00:00"},
+		{164, `"Day" 0`, 3, false, "3"},
+		{164, "0.00E+00", 12345, false, "12345"},
+		{164, "[Red]0.00", 2.5, false, "2.5"},
+	} {
+		f := parseNumberFormat(c.id, c.code)
+		if got := f.format(c.value, c.date1904); got != c.want {
+			t.Errorf("format %d %q of %v = %q, want %q", c.id, c.code, c.value, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// internal/services/diff_service.go
+package services
+
+import "errors"
+
+// ErrDiffTooLarge is returned when two texts differ in too many lines to diff.
+var ErrDiffTooLarge = errors.New("the versions differ too much to compare line by line")
+
+// maxDiffEdits caps the edit distance DiffLines will search for; the search
+// costs time and memory quadratic in it.
+const maxDiffEdits = 5000
+
+// Line operations in a diff.
+const (
+	DiffEqual  = "equal"
+	DiffInsert = "insert"
+	DiffDelete = "delete"
+)
+
+// DiffLine is one line of a line-level diff. OldLine and NewLine are 1-based
+// line numbers in the old and new text; zero when the line is absent there.
+type DiffLine struct {
+	Op      string `json:"op"`
+	Text    string `json:"text"`
+	OldLine int    `json:"old_line,omitempty"`
+	NewLine int    `json:"new_line,omitempty"`
+}
+
+// DiffHunk is a run of changes with the unchanged lines around it.
+type DiffHunk struct {
+	OldStart int        `json:"old_start"`
+	OldLines int        `json:"old_lines"`
+	NewStart int        `json:"new_start"`
+	NewLines int        `json:"new_lines"`
+	Lines    []DiffLine `json:"lines"`
+}
+
+// DiffLines computes a shortest line-level edit script turning a into b
+// using Myers' algorithm.
+func DiffLines(a, b []string) ([]DiffLine, error) {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+
+	// trace[d] holds v[-d-1 .. d+1] as it was before round d.
+	var trace [][]int
+	found := false
+	for d := 0; d <= n+m && !found; d++ {
+		if d > maxDiffEdits {
+			return nil, ErrDiffTooLarge
+		}
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+
+	// Walk the trace backwards to recover the edit script.
+	var reversed []DiffLine
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		snap := trace[d]
+		at := func(k int) int { return snap[k+d+1] }
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			reversed = append(reversed, DiffLine{Op: DiffEqual, Text: a[x-1], OldLine: x, NewLine: y})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				reversed = append(reversed, DiffLine{Op: DiffInsert, Text: b[y-1], NewLine: y})
+			} else {
+				reversed = append(reversed, DiffLine{Op: DiffDelete, Text: a[x-1], OldLine: x})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	lines := make([]DiffLine, len(reversed))
+	for i, l := range reversed {
+		lines[len(reversed)-1-i] = l
+	}
+	return lines, nil
+}
+
+// GroupHunks collects the changed lines of a diff into hunks with up to
+// context unchanged lines on either side, like a unified diff.
+func GroupHunks(lines []DiffLine, context int) []DiffHunk {
+	var hunks []DiffHunk
+	for i := 0; i < len(lines); {
+		if lines[i].Op == DiffEqual {
+			i++
+			continue
+		}
+
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		// Extend the hunk while the next change is within 2*context lines.
+		end := i
+		for j := i; j < len(lines); j++ {
+			if lines[j].Op != DiffEqual {
+				end = j
+				continue
+			}
+			if j-end > 2*context {
+				break
+			}
+		}
+		stop := end + context + 1
+		if stop > len(lines) {
+			stop = len(lines)
+		}
+
+		h := DiffHunk{Lines: lines[start:stop]}
+		for _, l := range h.Lines {
+			if l.Op != DiffInsert {
+				if h.OldStart == 0 {
+					h.OldStart = l.OldLine
+				}
+				h.OldLines++
+			}
+			if l.Op != DiffDelete {
+				if h.NewStart == 0 {
+					h.NewStart = l.NewLine
+				}
+				h.NewLines++
+			}
+		}
+		hunks = append(hunks, h)
+		i = stop
+	}
+	return hunks
+}
@@ -0,0 +1,207 @@
+// internal/services/integrity_service.go
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"LANFileSharingSystem/internal/models"
+	"LANFileSharingSystem/internal/storage"
+)
+
+// ScrubReport summarizes one pass of the integrity scrubber.
+type ScrubReport struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Checked    int       `json:"checked"`
+	Backfilled int       `json:"backfilled"`
+	Problems   int       `json:"problems"`
+	NewIssues  int       `json:"new_issues"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// IntegrityScrubber periodically reads every stored blob back, decrypts it and
+// compares its digests with the ones recorded at upload time.
+type IntegrityScrubber struct {
+	App      *models.App
+	Interval time.Duration
+
+	mu      sync.Mutex
+	running bool
+	last    *ScrubReport
+}
+
+// NewIntegrityScrubber returns a scrubber that runs every interval once started.
+func NewIntegrityScrubber(app *models.App, interval time.Duration) *IntegrityScrubber {
+	return &IntegrityScrubber{App: app, Interval: interval}
+}
+
+// Start runs the scrubber on its interval until ctx is cancelled. A
+// non-positive interval disables scheduled runs; Trigger still works.
+func (s *IntegrityScrubber) Start(ctx context.Context) {
+	if s.Interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(s.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if s.begin() {
+					s.run(ctx)
+				}
+			}
+		}
+	}()
+}
+
+// Trigger starts a scrub in the background. It returns false if one is
+// already running.
+func (s *IntegrityScrubber) Trigger() bool {
+	if !s.begin() {
+		return false
+	}
+	go s.run(context.Background())
+	return true
+}
+
+// Status reports whether a scrub is running and the result of the last one.
+func (s *IntegrityScrubber) Status() (running bool, last *ScrubReport) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.running, s.last
+}
+
+func (s *IntegrityScrubber) begin() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return false
+	}
+	s.running = true
+	return true
+}
+
+func (s *IntegrityScrubber) run(ctx context.Context) {
+	report := ScrubReport{StartedAt: time.Now()}
+	if err := s.scrub(ctx, &report); err != nil {
+		log.Printf("Integrity scrub failed: %v", err)
+		report.Error = err.Error()
+	}
+	report.FinishedAt = time.Now()
+	log.Printf("Integrity scrub finished: %d blobs checked, %d problems (%d new)",
+		report.Checked, report.Problems, report.NewIssues)
+
+	s.mu.Lock()
+	s.running = false
+	s.last = &report
+	s.mu.Unlock()
+}
+
+func (s *IntegrityScrubber) scrub(ctx context.Context, report *ScrubReport) error {
+	blobs, err := s.App.ListStoredBlobs()
+	if err != nil {
+		return fmt.Errorf("list blobs: %w", err)
+	}
+	for _, b := range blobs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		report.Checked++
+
+		info, err := s.App.HashStoredBlob(ctx, b.Key)
+		problem, details := classify(b, info, err)
+		if problem == "" {
+			if b.Checksum == "" || b.CiphertextChecksum == "" {
+				if err := s.App.BackfillChecksums(b, info); err != nil {
+					log.Printf("Integrity scrub: backfill %s: %v", b.Key, err)
+				} else {
+					report.Backfilled++
+				}
+			}
+			if err := s.App.ResolveIntegrityIssues(b.Key); err != nil {
+				log.Printf("Integrity scrub: resolve %s: %v", b.Key, err)
+			}
+			continue
+		}
+
+		// The file may have been overwritten, moved or deleted while it was
+		// being read; only report blobs the database still expects as read.
+		current, ok, err := s.App.RefreshStoredBlob(b)
+		if err != nil {
+			log.Printf("Integrity scrub: refresh %s: %v", b.Key, err)
+			continue
+		}
+		if !ok || current.Checksum != b.Checksum || current.CiphertextChecksum != b.CiphertextChecksum {
+			continue
+		}
+
+		report.Problems++
+		isNew, err := s.App.RecordIntegrityIssue(b, problem, details)
+		if err != nil {
+			log.Printf("Integrity scrub: record issue for %s: %v", b.Key, err)
+			continue
+		}
+		if isNew {
+			report.NewIssues++
+			s.alert(b, problem, details)
+		}
+	}
+	return nil
+}
+
+// classify turns the outcome of reading a blob into a problem and details.
+// It returns an empty problem for a blob that matches its recorded digests.
+func classify(b models.StoredBlob, info models.BlobInfo, err error) (string, string) {
+	switch {
+	case errors.Is(err, storage.ErrNotExist):
+		return models.IntegrityMissing, "blob not found in storage"
+	case err != nil:
+		return models.IntegrityUnreadable, truncate(err.Error(), 500)
+	case b.CiphertextChecksum != "" && b.CiphertextChecksum != info.CiphertextSHA256:
+		return models.IntegrityChecksumMismatch,
+			fmt.Sprintf("ciphertext SHA-256 %s, expected %s", info.CiphertextSHA256, b.CiphertextChecksum)
+	case b.Checksum != "" && b.Checksum != info.SHA256:
+		return models.IntegrityChecksumMismatch,
+			fmt.Sprintf("plaintext SHA-256 %s, expected %s", info.SHA256, b.Checksum)
+	}
+	return "", ""
+}
+
+// alert records a newly found problem in the audit log and pushes it to admins.
+func (s *IntegrityScrubber) alert(b models.StoredBlob, problem, details string) {
+	what := b.FileName
+	if b.Version > 0 {
+		what = fmt.Sprintf("%s (version %d)", b.FileName, b.Version)
+	}
+	s.App.LogAudit("", b.FileID, "INTEGRITY_FAIL", fmt.Sprintf("%s: %s (%s)", what, problem, details))
+
+	msg, err := json.Marshal(map[string]interface{}{
+		"event":     "integrity_alert",
+		"file_id":   b.FileID,
+		"file_name": b.FileName,
+		"version":   b.Version,
+		"problem":   problem,
+		"details":   details,
+		"message":   fmt.Sprintf("Integrity check failed for %s: %s", what, problem),
+	})
+	if err != nil {
+		return
+	}
+	s.App.NotifyAdmins(msg)
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
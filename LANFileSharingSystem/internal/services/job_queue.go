@@ -0,0 +1,287 @@
+// internal/services/job_queue.go
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"LANFileSharingSystem/internal/models"
+)
+
+const (
+	defaultJobAttempts = 5
+	defaultJobTimeout  = 10 * time.Minute
+	// jobLeaseGrace is added to the longest job timeout to get the lease a
+	// worker holds on a job, so only dead or hung workers lose theirs.
+	jobLeaseGrace = time.Minute
+	// maxJobBackoff caps the delay between retries.
+	maxJobBackoff = time.Hour
+)
+
+// JobHandler runs one job. Returning an error schedules a retry, unless the
+// error is wrapped with Permanent.
+type JobHandler func(ctx context.Context, job models.Job) error
+
+// JobType describes how jobs of one type are run.
+type JobType struct {
+	Handler JobHandler
+	// MaxAttempts is how many times a job is tried before it is
+	// dead-lettered; zero means 5.
+	MaxAttempts int
+	// Timeout bounds a single attempt; zero means 10 minutes.
+	Timeout time.Duration
+}
+
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks a job error as one that retrying cannot fix, so the job is
+// dead-lettered straight away.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// JobQueue runs the jobs in the jobs table on a pool of workers inside the
+// server. Any number of servers can share the table.
+type JobQueue struct {
+	App          *models.App
+	Workers      int
+	PollInterval time.Duration
+
+	workerID string
+
+	mu      sync.Mutex
+	types   map[string]JobType
+	running map[int64]context.CancelFunc
+	wake    chan struct{}
+}
+
+// NewJobQueue returns a queue that runs jobs on the given number of workers
+// once started, looking for due jobs every pollInterval.
+func NewJobQueue(app *models.App, workers int, pollInterval time.Duration) *JobQueue {
+	host, _ := os.Hostname()
+	return &JobQueue{
+		App:          app,
+		Workers:      workers,
+		PollInterval: pollInterval,
+		workerID:     fmt.Sprintf("%s:%d", host, os.Getpid()),
+		types:        make(map[string]JobType),
+		running:      make(map[int64]context.CancelFunc),
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Register sets the handler for jobs of the given type. Jobs of types that
+// are not registered stay queued.
+func (q *JobQueue) Register(jobType string, t JobType) {
+	if t.MaxAttempts <= 0 {
+		t.MaxAttempts = defaultJobAttempts
+	}
+	if t.Timeout <= 0 {
+		t.Timeout = defaultJobTimeout
+	}
+	q.mu.Lock()
+	q.types[jobType] = t
+	q.mu.Unlock()
+}
+
+// Types returns the registered job types.
+func (q *JobQueue) Types() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	names := make([]string, 0, len(q.types))
+	for name := range q.types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Enqueue adds a job and wakes an idle worker.
+func (q *JobQueue) Enqueue(jobType string, payload interface{}) (models.Job, error) {
+	job, err := q.App.EnqueueJob(jobType, payload)
+	if err == nil {
+		q.notify()
+	}
+	return job, err
+}
+
+// Retry queues a dead or cancelled job again.
+func (q *JobQueue) Retry(id int64) (models.Job, error) {
+	job, err := q.App.RetryJob(id)
+	if err == nil {
+		q.notify()
+	}
+	return job, err
+}
+
+// Cancel cancels a queued or running job. A job running on this server has
+// its context cancelled; elsewhere its result is discarded when it finishes.
+func (q *JobQueue) Cancel(id int64) (models.Job, error) {
+	job, err := q.App.CancelJob(id)
+	if err != nil {
+		return job, err
+	}
+	q.mu.Lock()
+	if cancel, ok := q.running[id]; ok {
+		cancel()
+	}
+	q.mu.Unlock()
+	return job, nil
+}
+
+// Start runs the workers until ctx is cancelled.
+func (q *JobQueue) Start(ctx context.Context) {
+	workers := q.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	for n := 1; n <= workers; n++ {
+		go q.work(ctx, fmt.Sprintf("%s/%d", q.workerID, n))
+	}
+	go q.reap(ctx)
+}
+
+func (q *JobQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// work claims and runs jobs until ctx is cancelled, sleeping while none are due.
+func (q *JobQueue) work(ctx context.Context, workerID string) {
+	ticker := time.NewTicker(q.PollInterval)
+	defer ticker.Stop()
+	for {
+		types, lease := q.claimSettings()
+		job, ok, err := q.App.ClaimJob(workerID, types, lease)
+		if err != nil {
+			log.Printf("Job queue: claim failed: %v", err)
+		}
+		if ok {
+			q.run(ctx, workerID, job)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-q.wake:
+		}
+	}
+}
+
+// claimSettings returns the registered types and the lease that covers the
+// longest of their timeouts.
+func (q *JobQueue) claimSettings() ([]string, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	types := make([]string, 0, len(q.types))
+	var longest time.Duration
+	for name, t := range q.types {
+		types = append(types, name)
+		if t.Timeout > longest {
+			longest = t.Timeout
+		}
+	}
+	return types, longest + jobLeaseGrace
+}
+
+// reap requeues the jobs of workers that died or hung.
+func (q *JobQueue) reap(ctx context.Context) {
+	ticker := time.NewTicker(jobLeaseGrace)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := q.App.RequeueExpiredJobs()
+			if err != nil {
+				log.Printf("Job queue: requeue expired jobs: %v", err)
+			} else if n > 0 {
+				log.Printf("Job queue: requeued %d jobs whose worker lease expired", n)
+			}
+		}
+	}
+}
+
+func (q *JobQueue) run(ctx context.Context, workerID string, job models.Job) {
+	q.mu.Lock()
+	t := q.types[job.Type]
+	q.mu.Unlock()
+
+	// A job reclaimed after its lease expired may already be out of attempts.
+	if job.Attempts > t.MaxAttempts {
+		q.deadLetter(job, workerID, fmt.Sprintf("gave up after %d attempts: %s", t.MaxAttempts, job.LastError))
+		return
+	}
+
+	jobCtx, cancel := context.WithTimeout(ctx, t.Timeout)
+	q.mu.Lock()
+	q.running[job.ID] = cancel
+	q.mu.Unlock()
+
+	err := callJobHandler(jobCtx, t.Handler, job)
+
+	q.mu.Lock()
+	delete(q.running, job.ID)
+	q.mu.Unlock()
+	cancel()
+
+	var perm *permanentError
+	switch {
+	case err == nil:
+		if err := q.App.CompleteJob(job.ID, workerID); err != nil {
+			log.Printf("Job queue: complete job %d: %v", job.ID, err)
+		}
+	case errors.As(err, &perm) || job.Attempts >= t.MaxAttempts:
+		q.deadLetter(job, workerID, err.Error())
+	default:
+		delay := jobBackoff(job.Attempts)
+		log.Printf("Job queue: %s job %d failed (attempt %d of %d), retrying in %s: %v",
+			job.Type, job.ID, job.Attempts, t.MaxAttempts, delay, err)
+		if err := q.App.RescheduleJob(job.ID, workerID, truncate(err.Error(), 2000), time.Now().Add(delay)); err != nil {
+			log.Printf("Job queue: reschedule job %d: %v", job.ID, err)
+		}
+	}
+}
+
+func (q *JobQueue) deadLetter(job models.Job, workerID, reason string) {
+	log.Printf("Job queue: %s job %d dead-lettered after %d attempts: %s", job.Type, job.ID, job.Attempts, reason)
+	if err := q.App.DeadLetterJob(job.ID, workerID, truncate(reason, 2000)); err != nil {
+		log.Printf("Job queue: dead-letter job %d: %v", job.ID, err)
+	}
+}
+
+// callJobHandler runs h, turning a panic into a job error.
+func callJobHandler(ctx context.Context, h JobHandler, job models.Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return h(ctx, job)
+}
+
+// jobBackoff is the delay before retrying after the given attempt: 30s,
+// doubling each time up to an hour.
+func jobBackoff(attempt int) time.Duration {
+	delay := 30 * time.Second
+	for i := 1; i < attempt && delay < maxJobBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxJobBackoff {
+		delay = maxJobBackoff
+	}
+	return delay
+}
@@ -0,0 +1,78 @@
+// internal/services/office_preview_service.go
+package services
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"LANFileSharingSystem/internal/office"
+)
+
+// officePreviewExtensions lists the file types RenderOfficePreview supports.
+var officePreviewExtensions = []string{".xlsx", ".xls", ".docx"}
+
+// CanPreviewNatively reports whether RenderOfficePreview supports the given
+// file name, so the file can be previewed without converting it to PDF.
+func CanPreviewNatively(fileName string) bool {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	for _, e := range officePreviewExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// OfficePreview is an office file read for preview. Spreadsheets fill in
+// Workbook and Word documents HTML, an HTML fragment that is safe to embed.
+type OfficePreview struct {
+	Workbook *office.Workbook
+	HTML     string
+}
+
+// RenderOfficePreview reads an XLSX, XLS or DOCX file for preview, choosing
+// the format by the extension of fileName. Unlike the PDF previews this needs
+// no office suite, and it keeps the sheets of a workbook apart.
+func RenderOfficePreview(src io.Reader, fileName string) (*OfficePreview, error) {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	if !CanPreviewNatively(fileName) {
+		return nil, ErrUnsupportedFormat
+	}
+
+	// The zip and compound file readers need random access, so spool the
+	// plaintext.
+	spool, err := os.CreateTemp("", "preview-*"+ext)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	size, err := io.Copy(spool, src)
+	if err != nil {
+		return nil, err
+	}
+
+	switch ext {
+	case ".docx":
+		body, err := office.DocumentHTML(spool, size)
+		if err != nil {
+			return nil, err
+		}
+		return &OfficePreview{HTML: body}, nil
+	case ".xls":
+		wb, err := office.ReadXLS(spool, size)
+		if err != nil {
+			return nil, err
+		}
+		return &OfficePreview{Workbook: wb}, nil
+	default: // ".xlsx"
+		wb, err := office.ReadWorkbook(spool, size)
+		if err != nil {
+			return nil, err
+		}
+		return &OfficePreview{Workbook: wb}, nil
+	}
+}
@@ -0,0 +1,235 @@
+// internal/services/preview_service.go
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"LANFileSharingSystem/internal/models"
+	"LANFileSharingSystem/internal/storage"
+)
+
+// ErrContentChanged is returned when a file is replaced while its preview
+// is being converted; the result is discarded rather than cached.
+var ErrContentChanged = errors.New("file content changed during conversion")
+
+// Converter turns documents into PDFs.
+type Converter interface {
+	// ConvertToPDF reads a document whose file extension is ext from src
+	// and writes it to dst as a PDF.
+	ConvertToPDF(ctx context.Context, src io.Reader, ext string, dst io.Writer) error
+}
+
+// SofficeConverter converts documents with LibreOffice in headless mode.
+type SofficeConverter struct {
+	// Binary is the soffice executable, either a path or a name looked up
+	// in PATH.
+	Binary string
+}
+
+// ConvertToPDF implements Converter. The document is written to a private
+// temp dir, which LibreOffice needs, and removed afterwards.
+func (c SofficeConverter) ConvertToPDF(ctx context.Context, src io.Reader, ext string, dst io.Writer) error {
+	tempDir, err := os.MkdirTemp("", "lanfs-convert-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	input := filepath.Join(tempDir, "source"+ext)
+	if err := writeTempFile(input, src); err != nil {
+		return err
+	}
+
+	outDir := filepath.Join(tempDir, "out")
+	// soffice refuses to run twice on one user profile, so each conversion
+	// gets its own and can run alongside the others.
+	profile := "file://" + filepath.ToSlash(filepath.Join(tempDir, "profile"))
+	cmd := exec.CommandContext(ctx, c.Binary,
+		"-env:UserInstallation="+profile,
+		"--headless", "--norestore",
+		"--convert-to", "pdf",
+		"--outdir", outDir,
+		input)
+	cmd.WaitDelay = 5 * time.Second
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return fmt.Errorf("soffice: %w", ctx.Err())
+	}
+	if err != nil {
+		return fmt.Errorf("soffice: %v: %s", err, bytes.TrimSpace(out))
+	}
+
+	pdf, err := os.Open(filepath.Join(outDir, "source.pdf"))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("soffice produced no PDF: %s", bytes.TrimSpace(out))
+	}
+	if err != nil {
+		return err
+	}
+	defer pdf.Close()
+	_, err = io.Copy(dst, pdf)
+	return err
+}
+
+func writeTempFile(path string, src io.Reader) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, src); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// PreviewService serves documents as PDFs for inline preview. Each version
+// is converted once and the PDF cached, encrypted, in storage; new uploads
+// invalidate a file's cache. At most a fixed number of conversions run at
+// once, each bounded by Timeout.
+type PreviewService struct {
+	App       *models.App
+	Converter Converter
+	Timeout   time.Duration
+
+	slots    chan struct{}
+	mu       sync.Mutex
+	inflight map[string]*conversion
+}
+
+// conversion is a conversion in progress that several requests may wait for.
+type conversion struct {
+	done chan struct{}
+	err  error
+}
+
+// NewPreviewService returns a service running at most maxConversions
+// conversions at a time.
+func NewPreviewService(app *models.App, converter Converter, maxConversions int, timeout time.Duration) *PreviewService {
+	if maxConversions <= 0 {
+		maxConversions = 1
+	}
+	return &PreviewService{
+		App:       app,
+		Converter: converter,
+		Timeout:   timeout,
+		slots:     make(chan struct{}, maxConversions),
+		inflight:  make(map[string]*conversion),
+	}
+}
+
+// OpenPDF returns the PDF preview of fr, whose current content is the
+// given version, converting it first if it is not cached.
+func (ps *PreviewService) OpenPDF(ctx context.Context, fr models.FileRecord, version int) (models.SeekableBlob, error) {
+	key := models.PreviewStorageKey(fr.ID, version)
+	pdf, err := ps.App.OpenSeekable(ctx, key)
+	if !errors.Is(err, storage.ErrNotExist) {
+		return pdf, err
+	}
+	if err := ps.convert(ctx, fr, key); err != nil {
+		return nil, err
+	}
+	return ps.App.OpenSeekable(ctx, key)
+}
+
+// Invalidate drops the cached previews of a file.
+func (ps *PreviewService) Invalidate(fileID int) {
+	if err := ps.App.DeletePreviewBlobs(context.Background(), fileID); err != nil {
+		log.Printf("Preview: invalidate cache of file %d: %v", fileID, err)
+	}
+}
+
+// convert waits for the conversion of fr into key, starting one unless it
+// is already running. The conversion carries on if ctx ends first, so its
+// result is cached for the next request.
+func (ps *PreviewService) convert(ctx context.Context, fr models.FileRecord, key string) error {
+	ps.mu.Lock()
+	c, running := ps.inflight[key]
+	if !running {
+		c = &conversion{done: make(chan struct{})}
+		ps.inflight[key] = c
+		go func() {
+			c.err = ps.run(fr, key)
+			ps.mu.Lock()
+			delete(ps.inflight, key)
+			ps.mu.Unlock()
+			close(c.done)
+		}()
+	}
+	ps.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run converts the current content of fr and caches the PDF under key.
+// Waiting for a free slot and the conversion itself are each bounded by
+// the timeout.
+func (ps *PreviewService) run(fr models.FileRecord, key string) error {
+	wait, stopWaiting := context.WithTimeout(context.Background(), ps.Timeout)
+	defer stopWaiting()
+	select {
+	case ps.slots <- struct{}{}:
+	case <-wait.Done():
+		return fmt.Errorf("waiting for a conversion slot: %w", wait.Err())
+	}
+	defer func() { <-ps.slots }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), ps.Timeout)
+	defer cancel()
+
+	src, err := ps.App.OpenDecrypted(ctx, fr.FilePath)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", fr.FilePath, err)
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp("", "lanfs-preview-*.pdf")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	started := time.Now()
+	ext := strings.ToLower(filepath.Ext(fr.FileName))
+	content := io.TeeReader(src, hash)
+	if err := ps.Converter.ConvertToPDF(ctx, content, ext, tmp); err != nil {
+		return fmt.Errorf("convert %s: %w", fr.FilePath, err)
+	}
+	// The version was looked up before the content was read, so make sure
+	// the file was not replaced in between.
+	if _, err := io.Copy(io.Discard, content); err != nil {
+		return err
+	}
+	if fr.Checksum != "" && hex.EncodeToString(hash.Sum(nil)) != fr.Checksum {
+		return ErrContentChanged
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := ps.App.PutEncrypted(ctx, key, tmp); err != nil {
+		return fmt.Errorf("cache preview of %s: %w", fr.FilePath, err)
+	}
+	log.Printf("Preview: converted %s in %s", fr.FilePath, time.Since(started).Round(time.Millisecond))
+	return nil
+}
@@ -0,0 +1,283 @@
+// internal/services/rescan_service.go
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"LANFileSharingSystem/internal/models"
+
+	"golang.org/x/time/rate"
+)
+
+// rescanBurst is the most a rate limited rescan reads at once.
+const rescanBurst = 64 << 10
+
+// JobScanFile scans one stored file, typically an upload that was let
+// through unscanned while clamd was unavailable.
+const JobScanFile = "scan_file"
+
+// ScanFilePayload is the payload of a JobScanFile job.
+type ScanFilePayload struct {
+	FileID int `json:"file_id"`
+}
+
+// RescanReport summarizes one pass of the rescanner.
+type RescanReport struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Scanned    int       `json:"scanned"`
+	Clean      int       `json:"clean"`
+	Infected   int       `json:"infected"`
+	Errors     int       `json:"errors"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Rescanner periodically decrypts stored files one at a time and runs them
+// through the virus scanner again, so content that newer signatures catch
+// is quarantined after the fact.
+type Rescanner struct {
+	App      *models.App
+	Scanner  *Scanner
+	Interval time.Duration
+	// RateLimit caps how many plaintext bytes per second are fed to the
+	// scanner; zero means no limit.
+	RateLimit int64
+
+	mu      sync.Mutex
+	running bool
+	last    *RescanReport
+}
+
+// NewRescanner returns a rescanner that runs every interval once started.
+func NewRescanner(app *models.App, scanner *Scanner, interval time.Duration, rateLimit int64) *Rescanner {
+	return &Rescanner{App: app, Scanner: scanner, Interval: interval, RateLimit: rateLimit}
+}
+
+// Start runs the rescanner on its interval until ctx is cancelled. A
+// non-positive interval disables scheduled runs; Trigger still works.
+func (s *Rescanner) Start(ctx context.Context) {
+	if s.Interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(s.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if s.begin() {
+					s.run(ctx)
+				}
+			}
+		}
+	}()
+}
+
+// Trigger starts a rescan in the background. It returns false if one is
+// already running.
+func (s *Rescanner) Trigger() bool {
+	if !s.begin() {
+		return false
+	}
+	go s.run(context.Background())
+	return true
+}
+
+// Status reports whether a rescan is running and the result of the last one.
+func (s *Rescanner) Status() (running bool, last *RescanReport) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.running, s.last
+}
+
+func (s *Rescanner) begin() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return false
+	}
+	s.running = true
+	return true
+}
+
+func (s *Rescanner) run(ctx context.Context) {
+	report := RescanReport{StartedAt: time.Now()}
+	if err := s.rescan(ctx, &report); err != nil {
+		log.Printf("Virus rescan failed: %v", err)
+		report.Error = err.Error()
+	}
+	report.FinishedAt = time.Now()
+	log.Printf("Virus rescan finished: %d files scanned, %d clean, %d infected, %d errors",
+		report.Scanned, report.Clean, report.Infected, report.Errors)
+
+	s.mu.Lock()
+	s.running = false
+	s.last = &report
+	s.mu.Unlock()
+}
+
+func (s *Rescanner) rescan(ctx context.Context, report *RescanReport) error {
+	files, err := s.App.ListFilesDueForScan(report.StartedAt)
+	if err != nil {
+		return fmt.Errorf("list files: %w", err)
+	}
+	for _, fr := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		status, err := s.checkFile(ctx, fr)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// Stop the pass rather than mark every remaining file as failed;
+			// the files left over are the first in line next time.
+			if errors.Is(err, ErrScannerUnavailable) && s.Scanner.Ping() != nil {
+				return err
+			}
+			report.Scanned++
+			report.Errors++
+			s.record(models.FileScan{FileID: fr.ID, Status: models.ScanError, Details: truncate(err.Error(), 500), Checksum: fr.Checksum})
+			continue
+		}
+		report.Scanned++
+		switch status {
+		case models.ScanClean:
+			report.Clean++
+		case models.ScanInfected:
+			report.Infected++
+		}
+	}
+	return nil
+}
+
+// ScanFileJob is the handler for JobScanFile jobs. Scanner errors are
+// returned so the job is retried.
+func (s *Rescanner) ScanFileJob(ctx context.Context, job models.Job) error {
+	var payload ScanFilePayload
+	if err := job.Decode(&payload); err != nil {
+		return Permanent(fmt.Errorf("invalid payload: %w", err))
+	}
+	fr, err := s.App.GetFileRecordByID(payload.FileID)
+	if err == sql.ErrNoRows {
+		return nil // deleted, trashed or quarantined since
+	}
+	if err != nil {
+		return err
+	}
+	_, err = s.checkFile(ctx, fr)
+	return err
+}
+
+// checkFile scans fr and acts on the verdict: clean content is recorded and
+// infected content quarantined. It returns the verdict, or "" when the file
+// changed while it was being scanned. Scanner errors are left to the caller.
+func (s *Rescanner) checkFile(ctx context.Context, fr models.FileRecord) (string, error) {
+	result, err := s.scanFile(ctx, fr)
+	if err != nil {
+		return "", err
+	}
+	if result.Clean {
+		s.record(models.FileScan{FileID: fr.ID, Status: models.ScanClean, Checksum: fr.Checksum})
+		return models.ScanClean, nil
+	}
+
+	// The file may have been overwritten, moved or deleted while it was
+	// being scanned; only quarantine the content that was scanned.
+	current, err := s.App.GetFileRecordByID(fr.ID)
+	if err != nil || current.FilePath != fr.FilePath || current.Checksum != fr.Checksum {
+		return "", nil
+	}
+	s.quarantine(ctx, current, result.Description)
+	return models.ScanInfected, nil
+}
+
+// scanFile streams the decrypted content of fr to the scanner, no faster
+// than the rate limit allows.
+func (s *Rescanner) scanFile(ctx context.Context, fr models.FileRecord) (ScanResult, error) {
+	blob, err := s.App.OpenDecrypted(ctx, fr.FilePath)
+	if err != nil {
+		return ScanResult{}, fmt.Errorf("open %s: %w", fr.FilePath, err)
+	}
+	defer blob.Close()
+
+	scanner := *s.Scanner
+	var src io.Reader = blob
+	if s.RateLimit > 0 {
+		src = &rateLimitedReader{ctx: ctx, r: blob, limiter: rate.NewLimiter(rate.Limit(s.RateLimit), rescanBurst)}
+		// The scanner's timeout covers the verdict; the throttled transfer
+		// takes as long as it takes on top of that.
+		scanner.Timeout += time.Duration(fr.Size/s.RateLimit+1) * time.Second
+	}
+	return scanner.Scan(src)
+}
+
+func (s *Rescanner) record(scan models.FileScan) {
+	if err := s.App.RecordFileScan(scan); err != nil {
+		log.Printf("Virus rescan: record verdict for file %d: %v", scan.FileID, err)
+	}
+}
+
+// quarantine moves an infected file out of reach, records the verdict and
+// alerts the admins.
+func (s *Rescanner) quarantine(ctx context.Context, fr models.FileRecord, signature string) {
+	scan := models.FileScan{FileID: fr.ID, Status: models.ScanInfected, Signature: signature, Checksum: fr.Checksum}
+	q, err := s.App.QuarantineStoredFile(ctx, fr, signature)
+	if err != nil {
+		// Still record and report the detection so an admin can act on it.
+		log.Printf("Virus rescan: quarantine %s: %v", fr.FilePath, err)
+		scan.Details = truncate("quarantine failed: "+err.Error(), 500)
+		s.App.LogAudit("", fr.ID, "MALWARE_FOUND", fmt.Sprintf("Stored file '%s' found infected by a rescan but not quarantined: %s", fr.FilePath, signature))
+	} else {
+		s.App.LogAudit("", fr.ID, "QUARANTINE", fmt.Sprintf("Stored file '%s' quarantined by a rescan: %s", fr.FilePath, signature))
+		s.App.LogActivity(fmt.Sprintf("Stored file '%s' was quarantined by a virus rescan (%s).", fr.FilePath, signature))
+	}
+	s.record(scan)
+
+	msg, err := json.Marshal(map[string]interface{}{
+		"event":         "malware_detected",
+		"source":        models.QuarantineRescanSource,
+		"file_id":       fr.ID,
+		"file_name":     fr.FileName,
+		"directory":     fr.Directory,
+		"uploader":      fr.Uploader,
+		"signature":     signature,
+		"quarantine_id": q.ID,
+		"message":       fmt.Sprintf("Malware (%s) found in stored file '%s'", signature, fr.FilePath),
+	})
+	if err != nil {
+		return
+	}
+	s.App.NotifyAdmins(msg)
+}
+
+// rateLimitedReader reads from r no faster than limiter allows.
+type rateLimitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+func (rr *rateLimitedReader) Read(p []byte) (int, error) {
+	if len(p) > rr.limiter.Burst() {
+		p = p[:rr.limiter.Burst()]
+	}
+	n, err := rr.r.Read(p)
+	if n > 0 {
+		if werr := rr.limiter.WaitN(rr.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,184 @@
+// internal/services/resumable_upload_service.go
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"LANFileSharingSystem/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// uploadCleanupInterval is how often expired upload sessions are removed.
+const uploadCleanupInterval = 10 * time.Minute
+
+// ErrInvalidUploadID is returned for IDs that were not issued by the stager.
+var ErrInvalidUploadID = errors.New("invalid upload ID")
+
+// UploadStager keeps the bytes of resumable uploads on local disk until they
+// are complete. Staged data is plaintext, so Dir is created readable by the
+// server only and each file is removed as soon as its upload is stored.
+type UploadStager struct {
+	App    *models.App
+	Dir    string
+	Expiry time.Duration
+
+	locks sync.Map // upload ID -> *sync.Mutex
+}
+
+// NewUploadStager creates the staging directory if needed.
+func NewUploadStager(app *models.App, dir string, expiry time.Duration) (*UploadStager, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &UploadStager{App: app, Dir: dir, Expiry: expiry}, nil
+}
+
+// path returns the staging file of an upload. IDs are UUIDs, which also
+// keeps client-supplied IDs from escaping Dir.
+func (s *UploadStager) path(id string) (string, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return "", ErrInvalidUploadID
+	}
+	return filepath.Join(s.Dir, id), nil
+}
+
+// Create starts a new upload session with an empty staging file. The ID and
+// expiry of sess are filled in.
+func (s *UploadStager) Create(sess models.UploadSession) (models.UploadSession, error) {
+	sess.ID = uuid.New().String()
+	sess.ExpiresAt = time.Now().Add(s.Expiry)
+
+	p, _ := s.path(sess.ID)
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return models.UploadSession{}, err
+	}
+	f.Close()
+
+	if err := s.App.CreateUploadSession(sess); err != nil {
+		os.Remove(p)
+		return models.UploadSession{}, err
+	}
+	return sess, nil
+}
+
+// Lock serializes requests for one upload. Call the returned function to
+// unlock. The lock is kept until the upload is discarded, so only lock uploads
+// that exist.
+func (s *UploadStager) Lock(id string) func() {
+	mu, _ := s.locks.LoadOrStore(id, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// Append writes up to the remaining length of sess from src at the current
+// offset and returns the new offset. Bytes that arrived before a connection
+// dropped are kept, so the offset advances even when an error is returned.
+// The caller must hold the upload's lock.
+func (s *UploadStager) Append(sess models.UploadSession, src io.Reader) (int64, error) {
+	p, err := s.path(sess.ID)
+	if err != nil {
+		return sess.Offset, err
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY, 0600)
+	if err != nil {
+		return sess.Offset, err
+	}
+	defer f.Close()
+
+	// Drop anything past the recorded offset left by a request that failed
+	// before its progress could be saved.
+	if err := f.Truncate(sess.Offset); err != nil {
+		return sess.Offset, err
+	}
+	if _, err := f.Seek(sess.Offset, io.SeekStart); err != nil {
+		return sess.Offset, err
+	}
+
+	n, copyErr := io.Copy(f, io.LimitReader(src, sess.Length-sess.Offset))
+	if err := f.Sync(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+	offset := sess.Offset + n
+	if err := s.App.UpdateUploadOffset(sess.ID, offset, time.Now().Add(s.Expiry)); err != nil {
+		return sess.Offset, fmt.Errorf("save offset: %w", err)
+	}
+	return offset, copyErr
+}
+
+// Open returns the staged bytes of an upload.
+func (s *UploadStager) Open(id string) (*os.File, error) {
+	p, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+// RemoveData deletes the staged bytes of an upload but keeps its session.
+func (s *UploadStager) RemoveData(id string) error {
+	p, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Discard deletes an upload session and its staged bytes.
+func (s *UploadStager) Discard(id string) error {
+	if err := s.RemoveData(id); err != nil {
+		return err
+	}
+	if err := s.App.DeleteUploadSession(id); err != nil {
+		return err
+	}
+	s.locks.Delete(id)
+	return nil
+}
+
+// Start removes expired upload sessions periodically until ctx is cancelled.
+func (s *UploadStager) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(uploadCleanupInterval)
+		defer ticker.Stop()
+		for {
+			s.cleanup()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (s *UploadStager) cleanup() {
+	ids, err := s.App.ListExpiredUploadSessions(time.Now())
+	if err != nil {
+		log.Printf("Upload cleanup: could not list expired uploads: %v", err)
+		return
+	}
+	for _, id := range ids {
+		unlock := s.Lock(id)
+		err := s.Discard(id)
+		unlock()
+		if err != nil {
+			log.Printf("Upload cleanup: could not remove upload %s: %v", id, err)
+		}
+	}
+	if len(ids) > 0 {
+		log.Printf("Upload cleanup: removed %d expired upload(s)", len(ids))
+	}
+}
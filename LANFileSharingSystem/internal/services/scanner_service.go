@@ -2,39 +2,133 @@
 package services
 
 import (
-	"os"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/dutchcoders/go-clamd"
 )
 
+// ErrScannerUnavailable is returned when clamd cannot be reached or does not
+// produce a verdict, as opposed to the content being found infected.
+var ErrScannerUnavailable = errors.New("virus scanner unavailable")
+
 // ScanResult represents the result of scanning a file.
 type ScanResult struct {
 	Clean       bool
-	Description string
+	Description string // signature name when infected
+}
+
+// Scanner scans content with clamd over the INSTREAM protocol.
+type Scanner struct {
+	// Address is a clamd URL: "tcp://host:port" or "unix:///path/to/socket".
+	Address string
+	// Timeout bounds a whole scan, from connecting to the verdict.
+	Timeout time.Duration
+	// FailOpen lets uploads through unscanned when clamd is unavailable;
+	// otherwise they are refused.
+	FailOpen bool
+}
+
+// NewScanner creates a Scanner for the clamd at address.
+func NewScanner(address string, timeout time.Duration, failOpen bool) *Scanner {
+	return &Scanner{Address: address, Timeout: timeout, FailOpen: failOpen}
 }
 
-// ScanFile uses ClamAV to scan the file at filePath.
-func ScanFile(filePath string) (ScanResult, error) {
-	clam := clamd.NewClamd("tcp://127.0.0.1:3310")
+// Ping checks that clamd is reachable.
+func (s *Scanner) Ping() error {
+	if err := clamd.NewClamd(s.Address).Ping(); err != nil {
+		return fmt.Errorf("%w: %v", ErrScannerUnavailable, err)
+	}
+	return nil
+}
+
+// Scan streams r to clamd and returns its verdict. Failures to get a verdict
+// wrap ErrScannerUnavailable.
+func (s *Scanner) Scan(r io.Reader) (ScanResult, error) {
+	src := &stoppableReader{r: r}
+	defer src.stop()
 
-	// Open the file for scanning.
-	f, err := os.Open(filePath)
-	if err != nil {
-		return ScanResult{}, err
+	abort := make(chan bool)
+	type verdict struct {
+		result ScanResult
+		err    error
 	}
-	defer f.Close()
+	done := make(chan verdict, 1)
+
+	go func() {
+		response, err := clamd.NewClamd(s.Address).ScanStream(src, abort)
+		if err != nil {
+			done <- verdict{err: err}
+			return
+		}
+		var v verdict
+		got := false
+		for res := range response {
+			if got {
+				continue // drain so the connection is closed
+			}
+			got = true
+			switch res.Status {
+			case clamd.RES_OK:
+				v.result = ScanResult{Clean: true, Description: "File is clean"}
+			case clamd.RES_FOUND:
+				v.result = ScanResult{Clean: false, Description: strings.TrimSpace(res.Description)}
+			default:
+				v.err = fmt.Errorf("clamd replied %q", res.Raw)
+			}
+		}
+		if !got {
+			v.err = errors.New("clamd closed the connection without a verdict")
+		}
+		done <- v
+	}()
 
-	// Scan the file using a stream.
-	response, err := clam.ScanStream(f, make(chan bool))
-	if err != nil {
-		return ScanResult{}, err
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Minute
 	}
-	for s := range response {
-		if s.Status == clamd.RES_OK {
-			return ScanResult{Clean: true, Description: "File is clean"}, nil
-		} else if s.Status == clamd.RES_FOUND {
-			return ScanResult{Clean: false, Description: s.Description}, nil
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case v := <-done:
+		// Closing abort releases the client's watcher goroutine.
+		close(abort)
+		if v.err != nil {
+			return ScanResult{}, fmt.Errorf("%w: %v", ErrScannerUnavailable, v.err)
 		}
+		return v.result, nil
+	case <-timer.C:
+		// Closing abort also closes the connection, which unblocks the scan.
+		close(abort)
+		return ScanResult{}, fmt.Errorf("%w: no verdict after %s", ErrScannerUnavailable, timeout)
+	}
+}
+
+// stoppableReader fails every Read after stop returns. The clamd client
+// keeps reading its input after a scan is abandoned, and the caller must be
+// able to reuse the input as soon as Scan returns.
+type stoppableReader struct {
+	mu      sync.Mutex
+	r       io.Reader
+	stopped bool
+}
+
+func (sr *stoppableReader) Read(p []byte) (int, error) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	if sr.stopped {
+		return 0, errors.New("scan abandoned")
 	}
-	return ScanResult{Clean: true, Description: "Unknown result"}, nil
+	return sr.r.Read(p)
+}
+
+func (sr *stoppableReader) stop() {
+	sr.mu.Lock()
+	sr.stopped = true
+	sr.mu.Unlock()
 }
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eicar is the standard antivirus test string.
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fakeClamd is a minimal clamd that speaks the INSTREAM protocol. It flags
+// streams containing the EICAR string and records what it received.
+type fakeClamd struct {
+	ln       net.Listener
+	maxBytes int           // reply with a size limit error past this many bytes; 0 means no limit
+	stall    bool          // never reply
+	received chan []byte   // the content of each completed stream
+	done     chan struct{} // closed when the listener stops
+}
+
+func startFakeClamd(t *testing.T) *fakeClamd {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	f := &fakeClamd{ln: ln, received: make(chan []byte, 8), done: make(chan struct{})}
+	go f.serve()
+	t.Cleanup(func() {
+		ln.Close()
+		<-f.done
+	})
+	return f
+}
+
+func (f *fakeClamd) address() string {
+	return "tcp://" + f.ln.Addr().String()
+}
+
+func (f *fakeClamd) serve() {
+	defer close(f.done)
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeClamd) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	cmd, err := r.ReadString('\n')
+	if err != nil {
+		return
+	}
+	switch strings.TrimSpace(cmd) {
+	case "nPING":
+		io.WriteString(conn, "PONG\n")
+		return
+	case "nINSTREAM":
+	default:
+		io.WriteString(conn, "UNKNOWN COMMAND\n")
+		return
+	}
+
+	var content bytes.Buffer
+	for {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return
+		}
+		if size == 0 {
+			break
+		}
+		if _, err := io.CopyN(&content, r, int64(size)); err != nil {
+			return
+		}
+		if f.maxBytes > 0 && content.Len() > f.maxBytes {
+			io.WriteString(conn, "INSTREAM size limit exceeded. ERROR\n")
+			return
+		}
+	}
+	f.received <- content.Bytes()
+
+	if f.stall {
+		io.Copy(io.Discard, r) // hold the connection until the client gives up
+		return
+	}
+	if bytes.Contains(content.Bytes(), []byte(eicar)) {
+		io.WriteString(conn, "stream: Eicar-Test-Signature FOUND\n")
+	} else {
+		io.WriteString(conn, "stream: OK\n")
+	}
+}
+
+func TestScanClean(t *testing.T) {
+	clamd := startFakeClamd(t)
+	scanner := NewScanner(clamd.address(), 5*time.Second, false)
+
+	// Larger than one client chunk so the stream spans several INSTREAM chunks.
+	content := bytes.Repeat([]byte("quarterly report "), 500)
+	result, err := scanner.Scan(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if !result.Clean {
+		t.Fatalf("clean content reported infected: %+v", result)
+	}
+	if got := <-clamd.received; !bytes.Equal(got, content) {
+		t.Fatalf("clamd received %d bytes, want %d", len(got), len(content))
+	}
+}
+
+func TestScanInfected(t *testing.T) {
+	clamd := startFakeClamd(t)
+	scanner := NewScanner(clamd.address(), 5*time.Second, false)
+
+	result, err := scanner.Scan(strings.NewReader("prefix " + eicar + " suffix"))
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if result.Clean {
+		t.Fatal("EICAR content reported clean")
+	}
+	if result.Description != "Eicar-Test-Signature" {
+		t.Fatalf("signature = %q, want Eicar-Test-Signature", result.Description)
+	}
+}
+
+func TestScanUnavailable(t *testing.T) {
+	// Reserve a port, then close it so nothing is listening there.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := "tcp://" + ln.Addr().String()
+	ln.Close()
+
+	scanner := NewScanner(addr, 5*time.Second, false)
+	if _, err := scanner.Scan(strings.NewReader("data")); !errors.Is(err, ErrScannerUnavailable) {
+		t.Fatalf("Scan error = %v, want ErrScannerUnavailable", err)
+	}
+	if err := scanner.Ping(); !errors.Is(err, ErrScannerUnavailable) {
+		t.Fatalf("Ping error = %v, want ErrScannerUnavailable", err)
+	}
+}
+
+func TestScanSizeLimitIsNotClean(t *testing.T) {
+	clamd := startFakeClamd(t)
+	clamd.maxBytes = 2048
+	scanner := NewScanner(clamd.address(), 5*time.Second, false)
+
+	_, err := scanner.Scan(bytes.NewReader(make([]byte, 64<<10)))
+	if !errors.Is(err, ErrScannerUnavailable) {
+		t.Fatalf("Scan error = %v, want ErrScannerUnavailable", err)
+	}
+}
+
+func TestScanTimeout(t *testing.T) {
+	clamd := startFakeClamd(t)
+	clamd.stall = true
+	scanner := NewScanner(clamd.address(), 200*time.Millisecond, false)
+
+	src := strings.NewReader("data")
+	start := time.Now()
+	_, err := scanner.Scan(src)
+	if !errors.Is(err, ErrScannerUnavailable) {
+		t.Fatalf("Scan error = %v, want ErrScannerUnavailable", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Scan took %s despite a 200ms timeout", elapsed)
+	}
+}
+
+func TestPing(t *testing.T) {
+	clamd := startFakeClamd(t)
+	if err := NewScanner(clamd.address(), time.Second, false).Ping(); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+// internal/services/text_extraction_service.go
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"LANFileSharingSystem/internal/office"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// ErrUnsupportedFormat is returned for file types text cannot be extracted from.
+var ErrUnsupportedFormat = errors.New("text extraction is not supported for this file type")
+
+// textExtensions lists the extensions ExtractText supports.
+var textExtensions = []string{".docx", ".xlsx", ".pdf", ".txt", ".csv"}
+
+// CanExtractText reports whether ExtractText supports the given file name.
+func CanExtractText(fileName string) bool {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	for _, e := range textExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// ExtractText returns the plain text of a DOCX, XLSX, PDF or text file as
+// lines. The format is chosen by the extension of fileName. Spreadsheet rows
+// become one line each, prefixed with the sheet name and row number, so that
+// diffs and search hits point at a recognizable location.
+func ExtractText(src io.Reader, fileName string) ([]string, error) {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	if !CanExtractText(fileName) {
+		return nil, ErrUnsupportedFormat
+	}
+	if ext == ".txt" || ext == ".csv" {
+		data, err := io.ReadAll(src)
+		if err != nil {
+			return nil, err
+		}
+		return splitLines(string(data)), nil
+	}
+
+	// The zip and PDF readers need random access, so spool the plaintext.
+	spool, err := os.CreateTemp("", "extract-*"+ext)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	size, err := io.Copy(spool, src)
+	if err != nil {
+		return nil, err
+	}
+
+	switch ext {
+	case ".docx":
+		paragraphs, err := office.DocumentParagraphs(spool, size)
+		if err != nil {
+			return nil, err
+		}
+		var lines []string
+		for _, p := range paragraphs {
+			lines = append(lines, splitLines(p)...)
+		}
+		return lines, nil
+	case ".xlsx":
+		wb, err := office.ReadWorkbook(spool, size)
+		if err != nil {
+			return nil, err
+		}
+		var lines []string
+		for _, sheet := range wb.Sheets {
+			for _, row := range sheet.Rows {
+				lines = append(lines, fmt.Sprintf("%s!%d: %s", sheet.Name, row.Number, strings.Join(row.Cells, " | ")))
+			}
+		}
+		return lines, nil
+	default: // ".pdf"
+		return extractPDF(spool, size)
+	}
+}
+
+// extractPDF returns the text of every page. The PDF library panics on some
+// malformed files, so that is turned into an error.
+func extractPDF(r io.ReaderAt, size int64) (lines []string, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("malformed PDF: %v", p)
+		}
+	}()
+
+	doc, err := pdf.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	for i := 1; i <= doc.NumPage(); i++ {
+		page := doc.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+		rows, err := page.GetTextByRow()
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %w", i, err)
+		}
+		for _, row := range rows {
+			var b strings.Builder
+			for _, word := range row.Content {
+				b.WriteString(word.S)
+			}
+			if line := strings.TrimSpace(b.String()); line != "" {
+				lines = append(lines, line)
+			}
+		}
+	}
+	return lines, nil
+}
+
+// splitLines splits text into lines, accepting both Unix and Windows endings.
+func splitLines(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.TrimSuffix(text, "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
@@ -0,0 +1,148 @@
+// internal/services/text_index_service.go
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"LANFileSharingSystem/internal/models"
+)
+
+// JobExtractText extracts the searchable text of one stored file.
+const JobExtractText = "extract_text"
+
+// maxIndexedText caps the text kept per file for search. Postgres cannot
+// index arbitrarily large documents, and the opening pages are what people
+// search for anyway.
+const maxIndexedText = 512 << 10
+
+// ExtractTextPayload is the payload of a JobExtractText job. Checksum is the
+// content the job was queued for.
+type ExtractTextPayload struct {
+	FileID   int    `json:"file_id"`
+	Checksum string `json:"checksum"`
+}
+
+// TextIndexer keeps the full-text search index in step with file contents.
+// Uploads queue their own extraction; a periodic sweep queues whatever else
+// changed, such as copies, restored versions and files stored before search
+// existed.
+type TextIndexer struct {
+	App      *models.App
+	Jobs     *JobQueue
+	Interval time.Duration
+}
+
+// NewTextIndexer returns an indexer that sweeps every interval once started.
+func NewTextIndexer(app *models.App, jobs *JobQueue, interval time.Duration) *TextIndexer {
+	return &TextIndexer{App: app, Jobs: jobs, Interval: interval}
+}
+
+// Start sweeps once right away, then on the interval until ctx is
+// cancelled. A non-positive interval disables the periodic sweeps.
+func (ix *TextIndexer) Start(ctx context.Context) {
+	go func() {
+		ix.sweep()
+		if ix.Interval <= 0 {
+			return
+		}
+		ticker := time.NewTicker(ix.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ix.sweep()
+			}
+		}
+	}()
+}
+
+// Enqueue queues text extraction for fr if its type supports it.
+func (ix *TextIndexer) Enqueue(fr models.FileRecord) {
+	if !CanExtractText(fr.FileName) {
+		return
+	}
+	if _, err := ix.Jobs.Enqueue(JobExtractText, ExtractTextPayload{FileID: fr.ID, Checksum: fr.Checksum}); err != nil {
+		log.Printf("Text index: queue extraction for file %d: %v", fr.ID, err)
+	}
+}
+
+func (ix *TextIndexer) sweep() {
+	files, err := ix.App.ListFilesNeedingText(JobExtractText, textExtensions)
+	if err != nil {
+		log.Printf("Text index sweep failed: %v", err)
+		return
+	}
+	for _, fr := range files {
+		ix.Enqueue(fr)
+	}
+	if len(files) > 0 {
+		log.Printf("Text index sweep: queued %d files for extraction", len(files))
+	}
+}
+
+// ExtractTextJob is the handler for JobExtractText jobs. Content that cannot
+// be parsed is indexed by name only and the job fails permanently.
+func (ix *TextIndexer) ExtractTextJob(ctx context.Context, job models.Job) error {
+	var payload ExtractTextPayload
+	if err := job.Decode(&payload); err != nil {
+		return Permanent(fmt.Errorf("invalid payload: %w", err))
+	}
+	fr, err := ix.App.GetFileRecordByID(payload.FileID)
+	if err == sql.ErrNoRows {
+		return nil // deleted, trashed or quarantined since
+	}
+	if err != nil {
+		return err
+	}
+	if fr.Checksum == "" || !CanExtractText(fr.FileName) {
+		return nil
+	}
+
+	blob, err := ix.App.OpenDecrypted(ctx, fr.FilePath)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", fr.FilePath, err)
+	}
+	defer blob.Close()
+
+	lines, extractErr := ExtractText(blob, fr.FileName)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if _, err := ix.App.UpdateFileText(fr.ID, fr.Checksum, indexableText(lines)); err != nil {
+		return err
+	}
+	if extractErr != nil && !errors.Is(extractErr, ErrUnsupportedFormat) {
+		return Permanent(fmt.Errorf("extract text from %s: %w", fr.FilePath, extractErr))
+	}
+	return nil
+}
+
+// indexableText joins extracted lines, cut to maxIndexedText on a line or
+// character boundary.
+func indexableText(lines []string) string {
+	var b strings.Builder
+	for _, line := range lines {
+		if b.Len()+len(line)+1 > maxIndexedText {
+			rest := maxIndexedText - b.Len()
+			for rest > 0 && rest < len(line) && !utf8.RuneStart(line[rest]) {
+				rest--
+			}
+			if rest > 0 && rest < len(line) {
+				b.WriteString(line[:rest])
+			}
+			break
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
@@ -0,0 +1,283 @@
+// internal/services/thumbnail_service.go
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"LANFileSharingSystem/internal/models"
+
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// JobGenerateThumbnails renders the thumbnails of one stored file.
+const JobGenerateThumbnails = "generate_thumbnails"
+
+const (
+	// maxThumbnailSource caps the images read into memory for thumbnails.
+	maxThumbnailSource = 64 << 20
+	// maxThumbnailPixels caps the decoded size of a source image, so a small
+	// file cannot claim an enormous canvas.
+	maxThumbnailPixels = 80 << 20
+)
+
+// thumbnailExtensions lists the file types thumbnails are rendered for.
+var thumbnailExtensions = []string{".pdf", ".png", ".jpg", ".jpeg", ".gif", ".bmp", ".webp", ".tif", ".tiff"}
+
+// CanThumbnail reports whether thumbnails are rendered for the given file name.
+func CanThumbnail(fileName string) bool {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	for _, e := range thumbnailExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// ThumbnailPayload is the payload of a JobGenerateThumbnails job. Checksum
+// is the content the job was queued for.
+type ThumbnailPayload struct {
+	FileID   int    `json:"file_id"`
+	Checksum string `json:"checksum"`
+}
+
+// Thumbnailer renders PNG thumbnails of images and of the first page of
+// PDFs, at each of models.ThumbnailSizes, and stores them encrypted next to
+// the file. Uploads queue their own rendering; a periodic sweep queues
+// whatever else changed, such as copies and restored versions.
+type Thumbnailer struct {
+	App      *models.App
+	Jobs     *JobQueue
+	Interval time.Duration
+	// Pdftoppm is the Poppler executable that renders PDF pages, either a
+	// path or a name looked up in PATH.
+	Pdftoppm string
+}
+
+// NewThumbnailer returns a thumbnailer that sweeps every interval once started.
+func NewThumbnailer(app *models.App, jobs *JobQueue, pdftoppm string, interval time.Duration) *Thumbnailer {
+	return &Thumbnailer{App: app, Jobs: jobs, Interval: interval, Pdftoppm: pdftoppm}
+}
+
+// Start sweeps once right away, then on the interval until ctx is
+// cancelled. A non-positive interval disables the periodic sweeps.
+func (th *Thumbnailer) Start(ctx context.Context) {
+	go func() {
+		th.sweep()
+		if th.Interval <= 0 {
+			return
+		}
+		ticker := time.NewTicker(th.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				th.sweep()
+			}
+		}
+	}()
+}
+
+// Enqueue queues thumbnail rendering for fr if its type supports it.
+func (th *Thumbnailer) Enqueue(fr models.FileRecord) {
+	if !CanThumbnail(fr.FileName) {
+		return
+	}
+	if _, err := th.Jobs.Enqueue(JobGenerateThumbnails, ThumbnailPayload{FileID: fr.ID, Checksum: fr.Checksum}); err != nil {
+		log.Printf("Thumbnails: queue rendering for file %d: %v", fr.ID, err)
+	}
+}
+
+func (th *Thumbnailer) sweep() {
+	files, err := th.App.ListFilesNeedingThumbnails(JobGenerateThumbnails, thumbnailExtensions)
+	if err != nil {
+		log.Printf("Thumbnail sweep failed: %v", err)
+		return
+	}
+	for _, fr := range files {
+		th.Enqueue(fr)
+	}
+	if len(files) > 0 {
+		log.Printf("Thumbnail sweep: queued %d files for rendering", len(files))
+	}
+}
+
+// GenerateJob is the handler for JobGenerateThumbnails jobs. Content that
+// cannot be decoded fails the job permanently.
+func (th *Thumbnailer) GenerateJob(ctx context.Context, job models.Job) error {
+	var payload ThumbnailPayload
+	if err := job.Decode(&payload); err != nil {
+		return Permanent(fmt.Errorf("invalid payload: %w", err))
+	}
+	fr, err := th.App.GetFileRecordByID(payload.FileID)
+	if err == sql.ErrNoRows {
+		return nil // deleted, trashed or quarantined since
+	}
+	if err != nil {
+		return err
+	}
+	if fr.Checksum == "" || !CanThumbnail(fr.FileName) {
+		return nil
+	}
+
+	blob, err := th.App.OpenDecrypted(ctx, fr.FilePath)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", fr.FilePath, err)
+	}
+	defer blob.Close()
+
+	// The record was read before the content, so make sure the file was not
+	// replaced in between; the job queued for the new content renders it.
+	hash := sha256.New()
+	content := io.TeeReader(blob, hash)
+	var page image.Image
+	if strings.ToLower(filepath.Ext(fr.FileName)) == ".pdf" {
+		page, err = th.renderFirstPage(ctx, content)
+	} else {
+		page, err = decodeThumbnailSource(content)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if _, copyErr := io.Copy(io.Discard, content); copyErr != nil {
+		return copyErr
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != fr.Checksum {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("render %s: %w", fr.FilePath, err)
+	}
+
+	for _, size := range models.ThumbnailSizes {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, scaleToFit(page, size)); err != nil {
+			return err
+		}
+		if _, err := th.App.PutEncrypted(ctx, models.ThumbnailStorageKey(fr.ID, fr.Checksum, size), &buf); err != nil {
+			return fmt.Errorf("store thumbnail of %s: %w", fr.FilePath, err)
+		}
+	}
+
+	current, err := th.App.UpdateFileThumbnail(fr.ID, fr.Checksum)
+	if err != nil || !current {
+		// If the file was replaced meanwhile, the job rendering the new
+		// content clears these out.
+		return err
+	}
+	if err := th.App.DeleteStaleThumbnails(ctx, fr.ID, fr.Checksum); err != nil {
+		log.Printf("Thumbnails: remove stale thumbnails of file %d: %v", fr.ID, err)
+	}
+	return nil
+}
+
+// decodeThumbnailSource decodes an image, refusing ones too large to
+// render safely.
+func decodeThumbnailSource(src io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(io.LimitReader(src, maxThumbnailSource+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxThumbnailSource {
+		return nil, Permanent(fmt.Errorf("image is larger than %d MB", maxThumbnailSource>>20))
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, Permanent(err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxThumbnailPixels {
+		return nil, Permanent(fmt.Errorf("image dimensions %dx%d are out of range", cfg.Width, cfg.Height))
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, Permanent(err)
+	}
+	return img, nil
+}
+
+// renderFirstPage renders the first page of a PDF with pdftoppm, at the
+// largest thumbnail size.
+func (th *Thumbnailer) renderFirstPage(ctx context.Context, src io.Reader) (image.Image, error) {
+	tempDir, err := os.MkdirTemp("", "lanfs-thumbnail-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	input := filepath.Join(tempDir, "source.pdf")
+	if err := writeTempFile(input, src); err != nil {
+		return nil, err
+	}
+
+	largest := models.ThumbnailSizes[len(models.ThumbnailSizes)-1]
+	out := filepath.Join(tempDir, "page")
+	cmd := exec.CommandContext(ctx, th.Pdftoppm,
+		"-png", "-singlefile",
+		"-f", "1", "-l", "1",
+		"-scale-to", strconv.Itoa(largest),
+		input, out)
+	cmd.WaitDelay = 5 * time.Second
+	if output, err := cmd.CombinedOutput(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || ctx.Err() != nil {
+			// Not the document's fault, e.g. pdftoppm is not installed, so
+			// the job is retried.
+			return nil, fmt.Errorf("pdftoppm: %w", err)
+		}
+		return nil, Permanent(fmt.Errorf("pdftoppm: %v: %s", err, bytes.TrimSpace(output)))
+	}
+
+	f, err := os.Open(out + ".png")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, Permanent(err)
+	}
+	return img, nil
+}
+
+// scaleToFit shrinks img to fit a size×size box, keeping its aspect ratio.
+// Images that already fit are returned as they are.
+func scaleToFit(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+	if w >= h {
+		h = max(1, h*size/w)
+		w = size
+	} else {
+		w = max(1, w*size/h)
+		h = size
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
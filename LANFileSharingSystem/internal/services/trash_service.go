@@ -0,0 +1,69 @@
+// internal/services/trash_service.go
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"LANFileSharingSystem/internal/models"
+)
+
+// trashPurgeInterval is how often the purger looks for expired items.
+const trashPurgeInterval = time.Hour
+
+// TrashPurger permanently deletes recycle bin items once they have been
+// there longer than the retention period.
+type TrashPurger struct {
+	App       *models.App
+	Retention time.Duration
+}
+
+// NewTrashPurger returns a purger that keeps trashed items for retention.
+func NewTrashPurger(app *models.App, retention time.Duration) *TrashPurger {
+	return &TrashPurger{App: app, Retention: retention}
+}
+
+// Start purges expired items now and then every trashPurgeInterval until ctx
+// is cancelled. A non-positive retention keeps trashed items forever.
+func (p *TrashPurger) Start(ctx context.Context) {
+	if p.Retention <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(trashPurgeInterval)
+		defer ticker.Stop()
+		for {
+			p.Purge(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Purge permanently deletes every item older than the retention period and
+// returns how many were removed.
+func (p *TrashPurger) Purge(ctx context.Context) int {
+	items, err := p.App.ListTrashItemsDeletedBefore(time.Now().Add(-p.Retention))
+	if err != nil {
+		log.Printf("Trash purge: could not list expired items: %v", err)
+		return 0
+	}
+	purged := 0
+	for _, item := range items {
+		if err := p.App.PurgeTrashItem(ctx, item); err != nil {
+			log.Printf("Trash purge: could not delete '%s' (item %d): %v", item.OriginalPath, item.ID, err)
+			continue
+		}
+		p.App.LogAudit("", 0, "PURGE", fmt.Sprintf("Recycle bin item '%s' expired and was deleted permanently", item.OriginalPath))
+		purged++
+	}
+	if purged > 0 {
+		p.App.LogActivity(fmt.Sprintf("Recycle bin purge removed %d expired item(s).", purged))
+	}
+	return purged
+}
@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// partPrefix marks in-progress writes so List never reports them.
+const partPrefix = ".part-"
+
+// Local stores objects as files below a root directory.
+type Local struct {
+	root string
+}
+
+// NewLocal creates a backend rooted at dir, creating the directory if needed.
+func NewLocal(dir string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Local{root: dir}, nil
+}
+
+func (l *Local) path(key string) (string, error) {
+	cleaned, err := CleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(l.root, filepath.FromSlash(cleaned)), nil
+}
+
+func (l *Local) Put(ctx context.Context, key string, r io.Reader) error {
+	target, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	// Write next to the target and rename, so readers never see a partial file.
+	tmp, err := os.CreateTemp(filepath.Dir(target), partPrefix+"*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotExist
+	}
+	return f, err
+}
+
+func (l *Local) GetFrom(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	rc, err := l.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := rc.(*os.File).Seek(offset, io.SeekStart); err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return rc, nil
+}
+
+func (l *Local) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	info, err := os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+		return ObjectInfo{}, ErrNotExist
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	cleaned, _ := CleanKey(key)
+	return ObjectInfo{Key: cleaned, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	l.pruneEmptyDirs(filepath.Dir(p))
+	return nil
+}
+
+func (l *Local) Move(ctx context.Context, src, dst string) error {
+	from, err := l.path(src)
+	if err != nil {
+		return err
+	}
+	to, err := l.path(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(from); errors.Is(err, fs.ErrNotExist) {
+		return ErrNotExist
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	l.pruneEmptyDirs(filepath.Dir(from))
+	return nil
+}
+
+func (l *Local) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	cleaned, err := cleanPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	start := filepath.Join(l.root, filepath.FromSlash(cleaned))
+
+	var objects []ObjectInfo
+	err = filepath.WalkDir(start, func(p string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			if errors.Is(walkErr, fs.ErrNotExist) {
+				return nil
+			}
+			return walkErr
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), partPrefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(l.root, p)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{
+			Key:     filepath.ToSlash(rel),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		return nil
+	})
+	return objects, err
+}
+
+// pruneEmptyDirs removes now-empty folders between dir and the root, since
+// folders only exist on disk to hold objects.
+func (l *Local) pruneEmptyDirs(dir string) {
+	root := filepath.Clean(l.root)
+	for dir = filepath.Clean(dir); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Config holds the connection settings for an S3-compatible object store.
+type S3Config struct {
+	Endpoint  string // host[:port], e.g. "nas.local:9000"
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+	// Prefix is prepended to every key, so several deployments can share a bucket.
+	Prefix string
+}
+
+// S3 stores objects in a bucket of an S3-compatible service (AWS S3, MinIO, NAS gateways).
+type S3 struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3 connects to the object store and creates the bucket if it does not exist yet.
+func NewS3(ctx context.Context, cfg S3Config) (*S3, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("storage: S3 endpoint and bucket are required")
+	}
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+		// Path-style addressing works with MinIO and most NAS gateways,
+		// which usually have no wildcard DNS for virtual-hosted buckets.
+		BucketLookup: minio.BucketLookupPath,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{Region: cfg.Region}); err != nil {
+			return nil, err
+		}
+	}
+
+	prefix := strings.Trim(cfg.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &S3{client: client, bucket: cfg.Bucket, prefix: prefix}, nil
+}
+
+func (s *S3) objectName(key string) (string, error) {
+	cleaned, err := CleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return s.prefix + cleaned, nil
+}
+
+// mapError translates "not found" responses into ErrNotExist.
+func mapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NotFound":
+		return ErrNotExist
+	}
+	return err
+}
+
+func (s *S3) Put(ctx context.Context, key string, r io.Reader) error {
+	name, err := s.objectName(key)
+	if err != nil {
+		return err
+	}
+	// Size -1 streams the body as a multipart upload, so large files are
+	// never buffered whole. An aborted upload leaves no object behind.
+	_, err = s.client.PutObject(ctx, s.bucket, name, r, -1, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+	return err
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	name, err := s.objectName(key)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := s.client.GetObject(ctx, s.bucket, name, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, mapError(err)
+	}
+	// GetObject is lazy; Stat surfaces a missing key before the caller reads.
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, mapError(err)
+	}
+	return obj, nil
+}
+
+func (s *S3) GetFrom(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	if offset == 0 {
+		return s.Get(ctx, key)
+	}
+	name, err := s.objectName(key)
+	if err != nil {
+		return nil, err
+	}
+	opts := minio.GetObjectOptions{}
+	if err := opts.SetRange(offset, 0); err != nil {
+		return nil, err
+	}
+	obj, err := s.client.GetObject(ctx, s.bucket, name, opts)
+	if err != nil {
+		return nil, mapError(err)
+	}
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, mapError(err)
+	}
+	return obj, nil
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	name, err := s.objectName(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	info, err := s.client.StatObject(ctx, s.bucket, name, minio.StatObjectOptions{})
+	if err != nil {
+		return ObjectInfo{}, mapError(err)
+	}
+	return ObjectInfo{
+		Key:     strings.TrimPrefix(info.Key, s.prefix),
+		Size:    info.Size,
+		ModTime: info.LastModified,
+	}, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	name, err := s.objectName(key)
+	if err != nil {
+		return err
+	}
+	return mapError(s.client.RemoveObject(ctx, s.bucket, name, minio.RemoveObjectOptions{}))
+}
+
+func (s *S3) Move(ctx context.Context, src, dst string) error {
+	from, err := s.objectName(src)
+	if err != nil {
+		return err
+	}
+	to, err := s.objectName(dst)
+	if err != nil {
+		return err
+	}
+	// S3 has no rename: copy server-side, then delete the source.
+	// ComposeObject (unlike CopyObject) also handles objects over 5 GiB.
+	_, err = s.client.ComposeObject(ctx,
+		minio.CopyDestOptions{Bucket: s.bucket, Object: to},
+		minio.CopySrcOptions{Bucket: s.bucket, Object: from},
+	)
+	if err != nil {
+		return mapError(err)
+	}
+	return mapError(s.client.RemoveObject(ctx, s.bucket, from, minio.RemoveObjectOptions{}))
+}
+
+func (s *S3) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	cleaned, err := cleanPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	listPrefix := s.prefix
+	if cleaned != "" {
+		listPrefix += cleaned + "/"
+	}
+
+	var objects []ObjectInfo
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    listPrefix,
+		Recursive: true,
+	}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		objects = append(objects, ObjectInfo{
+			Key:     strings.TrimPrefix(obj.Key, s.prefix),
+			Size:    obj.Size,
+			ModTime: obj.LastModified,
+		})
+	}
+	return objects, nil
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrNotExist is returned when an object is not found in the backend.
+var ErrNotExist = errors.New("storage: object does not exist")
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Backend stores encrypted file blobs under slash-separated keys such as
+// "Operation/Reports/memo.pdf". Folders are not objects: they exist only as
+// key prefixes (and as rows in the directories table).
+type Backend interface {
+	// Put stores the contents of r under key, replacing any existing object.
+	// A failed Put never leaves a partial object behind.
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Get opens the object stored under key.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// GetFrom opens the object stored under key, starting offset bytes in.
+	GetFrom(ctx context.Context, key string, offset int64) (io.ReadCloser, error)
+	// Stat returns information about the object stored under key.
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// Delete removes the object stored under key. Deleting a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+	// Move renames the object stored under src to dst, replacing dst.
+	Move(ctx context.Context, src, dst string) error
+	// List returns every object below the folder prefix, recursively.
+	// An empty prefix lists the whole backend.
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+}
+
+// CleanKey normalizes a key to the slash-separated form used by backends and
+// rejects keys that would escape the storage root.
+func CleanKey(key string) (string, error) {
+	key = strings.ReplaceAll(key, "\\", "/")
+	cleaned := strings.Trim(path.Clean("/"+key), "/")
+	if cleaned == "" || cleaned == "." {
+		return "", fmt.Errorf("storage: invalid key %q", key)
+	}
+	return cleaned, nil
+}
+
+// cleanPrefix is like CleanKey but allows the empty (root) prefix.
+func cleanPrefix(prefix string) (string, error) {
+	if strings.Trim(prefix, "/\\. ") == "" {
+		return "", nil
+	}
+	return CleanKey(prefix)
+}
+
+// Exists reports whether an object is stored under key.
+func Exists(ctx context.Context, b Backend, key string) (bool, error) {
+	_, err := b.Stat(ctx, key)
+	if errors.Is(err, ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Copy duplicates the object stored under src to dst.
+func Copy(ctx context.Context, b Backend, src, dst string) error {
+	rc, err := b.Get(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return b.Put(ctx, dst, rc)
+}
+
+// DeletePrefix removes every object below the folder prefix.
+func DeletePrefix(ctx context.Context, b Backend, prefix string) error {
+	if strings.Trim(prefix, "/\\. ") == "" {
+		return errors.New("storage: refusing to delete the storage root")
+	}
+	objects, err := b.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if err := b.Delete(ctx, obj.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MovePrefix moves every object below the folder src to the same relative key below dst.
+func MovePrefix(ctx context.Context, b Backend, src, dst string) error {
+	src, err := CleanKey(src)
+	if err != nil {
+		return err
+	}
+	dst, err = CleanKey(dst)
+	if err != nil {
+		return err
+	}
+	objects, err := b.List(ctx, src)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		rel := strings.TrimPrefix(obj.Key, src+"/")
+		if err := b.Move(ctx, obj.Key, dst+"/"+rel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CopyPrefix copies every object below the folder src to the same relative key below dst.
+func CopyPrefix(ctx context.Context, b Backend, src, dst string) error {
+	src, err := CleanKey(src)
+	if err != nil {
+		return err
+	}
+	dst, err = CleanKey(dst)
+	if err != nil {
+		return err
+	}
+	objects, err := b.List(ctx, src)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		rel := strings.TrimPrefix(obj.Key, src+"/")
+		if err := Copy(ctx, b, obj.Key, dst+"/"+rel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The same suite runs against every backend, so Local and S3 cannot drift
+// apart. The S3 run needs a reachable store (see README.md) and is skipped
+// unless S3_ENDPOINT is set.
+
+func TestLocal(t *testing.T) {
+	b, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocal: %v", err)
+	}
+	testBackend(t, b)
+}
+
+func TestS3(t *testing.T) {
+	endpoint := os.Getenv("S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("S3_ENDPOINT is not set")
+	}
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket == "" {
+		bucket = "storage-test"
+	}
+	ctx := context.Background()
+	// A fresh prefix per run keeps the test away from other objects in the bucket.
+	b, err := NewS3(ctx, S3Config{
+		Endpoint:  endpoint,
+		Region:    os.Getenv("S3_REGION"),
+		Bucket:    bucket,
+		AccessKey: os.Getenv("S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("S3_SECRET_KEY"),
+		UseSSL:    os.Getenv("S3_USE_SSL") == "true",
+		Prefix:    fmt.Sprintf("storage-test-%d", time.Now().UnixNano()),
+	})
+	if err != nil {
+		t.Fatalf("NewS3: %v", err)
+	}
+	t.Cleanup(func() {
+		objects, err := b.List(ctx, "")
+		if err != nil {
+			t.Logf("cleanup: %v", err)
+			return
+		}
+		for _, obj := range objects {
+			b.Delete(ctx, obj.Key)
+		}
+	})
+	testBackend(t, b)
+}
+
+func testBackend(t *testing.T, b Backend) {
+	ctx := context.Background()
+
+	put := func(t *testing.T, key, content string) {
+		t.Helper()
+		if err := b.Put(ctx, key, strings.NewReader(content)); err != nil {
+			t.Fatalf("Put(%q): %v", key, err)
+		}
+	}
+	get := func(t *testing.T, key string) string {
+		t.Helper()
+		rc, err := b.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Get(%q): %v", key, err)
+		}
+		defer rc.Close()
+		data, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatalf("read %q: %v", key, err)
+		}
+		return string(data)
+	}
+	list := func(t *testing.T, prefix string) []string {
+		t.Helper()
+		objects, err := b.List(ctx, prefix)
+		if err != nil {
+			t.Fatalf("List(%q): %v", prefix, err)
+		}
+		keys := make([]string, len(objects))
+		for i, obj := range objects {
+			keys[i] = obj.Key
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	t.Run("PutGet", func(t *testing.T) {
+		put(t, "docs/a.txt", "first")
+		if got := get(t, "docs/a.txt"); got != "first" {
+			t.Fatalf("Get = %q, want %q", got, "first")
+		}
+		// Put replaces an existing object.
+		put(t, "docs/a.txt", "second")
+		if got := get(t, "docs/a.txt"); got != "second" {
+			t.Fatalf("Get after overwrite = %q, want %q", got, "second")
+		}
+		// Keys are cleaned the same way on every backend.
+		if got := get(t, "/docs//a.txt"); got != "second" {
+			t.Fatalf("Get with an uncleaned key = %q, want %q", got, "second")
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		if _, err := b.Get(ctx, "missing/x"); !errors.Is(err, ErrNotExist) {
+			t.Errorf("Get: got %v, want ErrNotExist", err)
+		}
+		if _, err := b.GetFrom(ctx, "missing/x", 3); !errors.Is(err, ErrNotExist) {
+			t.Errorf("GetFrom: got %v, want ErrNotExist", err)
+		}
+		if _, err := b.Stat(ctx, "missing/x"); !errors.Is(err, ErrNotExist) {
+			t.Errorf("Stat: got %v, want ErrNotExist", err)
+		}
+		if err := b.Move(ctx, "missing/x", "missing/y"); !errors.Is(err, ErrNotExist) {
+			t.Errorf("Move: got %v, want ErrNotExist", err)
+		}
+		if err := b.Delete(ctx, "missing/x"); err != nil {
+			t.Errorf("Delete: %v", err)
+		}
+	})
+
+	t.Run("GetFrom", func(t *testing.T) {
+		content := bytes.Repeat([]byte("0123456789"), 1000)
+		if err := b.Put(ctx, "range.bin", bytes.NewReader(content)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		for _, offset := range []int64{0, 1, 4321, int64(len(content)) - 1} {
+			rc, err := b.GetFrom(ctx, "range.bin", offset)
+			if err != nil {
+				t.Fatalf("GetFrom(%d): %v", offset, err)
+			}
+			got, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatalf("read from %d: %v", offset, err)
+			}
+			if !bytes.Equal(got, content[offset:]) {
+				t.Errorf("GetFrom(%d) returned %d bytes, want the %d after the offset", offset, len(got), len(content)-int(offset))
+			}
+		}
+	})
+
+	t.Run("Stat", func(t *testing.T) {
+		put(t, "stat/file.txt", "12345")
+		info, err := b.Stat(ctx, "stat/file.txt")
+		if err != nil {
+			t.Fatalf("Stat: %v", err)
+		}
+		if info.Key != "stat/file.txt" || info.Size != 5 || info.ModTime.IsZero() {
+			t.Errorf("Stat = %+v, want key stat/file.txt, size 5 and a modification time", info)
+		}
+		// A folder is not an object.
+		if _, err := b.Stat(ctx, "stat"); !errors.Is(err, ErrNotExist) {
+			t.Errorf("Stat of a folder: got %v, want ErrNotExist", err)
+		}
+	})
+
+	t.Run("Move", func(t *testing.T) {
+		put(t, "move/src.txt", "moved")
+		put(t, "move/dst.txt", "replaced")
+		if err := b.Move(ctx, "move/src.txt", "move/dst.txt"); err != nil {
+			t.Fatalf("Move onto an existing object: %v", err)
+		}
+		if got := get(t, "move/dst.txt"); got != "moved" {
+			t.Errorf("destination = %q, want %q", got, "moved")
+		}
+		if ok, err := Exists(ctx, b, "move/src.txt"); err != nil || ok {
+			t.Errorf("source still exists after Move (err %v)", err)
+		}
+		if err := b.Move(ctx, "move/dst.txt", "move/deeper/new.txt"); err != nil {
+			t.Fatalf("Move into a new folder: %v", err)
+		}
+		if got := get(t, "move/deeper/new.txt"); got != "moved" {
+			t.Errorf("destination = %q, want %q", got, "moved")
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		put(t, "list/a/1.txt", "1")
+		put(t, "list/a/sub/2.txt", "2")
+		put(t, "list/a_b/3.txt", "3")
+		put(t, "list/ab.txt", "4")
+
+		want := []string{"list/a/1.txt", "list/a/sub/2.txt"}
+		for _, prefix := range []string{"list/a", "list/a/", "/list/a"} {
+			if got := list(t, prefix); strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("List(%q) = %v, want %v", prefix, got, want)
+			}
+		}
+		if got := list(t, "list"); len(got) != 4 {
+			t.Errorf("List(list) = %v, want 4 objects", got)
+		}
+		if got := list(t, "list/none"); len(got) != 0 {
+			t.Errorf("List of an empty folder = %v, want nothing", got)
+		}
+		all := list(t, "")
+		for _, key := range append(want, "docs/a.txt") {
+			if i := sort.SearchStrings(all, key); i == len(all) || all[i] != key {
+				t.Errorf("List(\"\") = %v, missing %s", all, key)
+			}
+		}
+	})
+
+	t.Run("Prefixes", func(t *testing.T) {
+		put(t, "tree/x/1.txt", "1")
+		put(t, "tree/x/y/2.txt", "2")
+		put(t, "tree/x_z/3.txt", "3")
+
+		if err := CopyPrefix(ctx, b, "tree/x", "copy/x"); err != nil {
+			t.Fatalf("CopyPrefix: %v", err)
+		}
+		if got, want := list(t, "copy"), []string{"copy/x/1.txt", "copy/x/y/2.txt"}; strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("after CopyPrefix: %v, want %v", got, want)
+		}
+		if err := MovePrefix(ctx, b, "tree/x", "moved/x"); err != nil {
+			t.Fatalf("MovePrefix: %v", err)
+		}
+		if got, want := list(t, "tree"), []string{"tree/x_z/3.txt"}; strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("after MovePrefix: %v left, want %v", got, want)
+		}
+		if got := get(t, "moved/x/y/2.txt"); got != "2" {
+			t.Errorf("moved object = %q, want %q", got, "2")
+		}
+		if err := DeletePrefix(ctx, b, "moved"); err != nil {
+			t.Fatalf("DeletePrefix: %v", err)
+		}
+		if got := list(t, "moved"); len(got) != 0 {
+			t.Errorf("after DeletePrefix: %v left", got)
+		}
+		if err := DeletePrefix(ctx, b, "/"); err == nil {
+			t.Error("DeletePrefix of the root succeeded")
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		put(t, "del/file.txt", "x")
+		for i := 0; i < 2; i++ {
+			if err := b.Delete(ctx, "del/file.txt"); err != nil {
+				t.Fatalf("Delete #%d: %v", i+1, err)
+			}
+		}
+		if _, err := b.Stat(ctx, "del/file.txt"); !errors.Is(err, ErrNotExist) {
+			t.Errorf("Stat after Delete: got %v, want ErrNotExist", err)
+		}
+		if got := list(t, "del"); len(got) != 0 {
+			t.Errorf("List after Delete = %v, want nothing", got)
+		}
+	})
+}